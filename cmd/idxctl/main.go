@@ -0,0 +1,263 @@
+// Command idxctl manages an allocation pool stored in a snapshot file, for
+// operators doing manual fixes.
+//
+//	idxctl -f vlan.json create -kind vlan -range 100-199 -reserve 100-109
+//	idxctl -f vlan.json claim -l tenant=a 150
+//	idxctl -f vlan.json claim-range -l tenant=a 160-169
+//	idxctl -f vlan.json claim-free -l tenant=b
+//	idxctl -f vlan.json release 150
+//	idxctl -f vlan.json get 160
+//	idxctl -f vlan.json list -selector tenant=a
+//	idxctl -f vlan.json free
+//	idxctl -f vlan.json stats
+//	idxctl -f labels.json tree
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "idxctl: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+const usage = `usage: idxctl -f <pool file> <command> [flags] [args]
+
+commands:
+  create      -kind <vlan|vni|label|ipv4|ipv6> -range <range> [-reserve <range>] [-name <name>]
+  claim       [-l <labels>] <id>
+  claim-range [-l <labels>] <range>
+  claim-free  [-l <labels>]
+  release     <id>
+  get         <id>
+  list        [-selector <selector>]
+  free
+  stats
+  tree
+`
+
+// stringList is a flag that can be set multiple times
+type stringList []string
+
+func (r *stringList) String() string     { return strings.Join(*r, ",") }
+func (r *stringList) Set(s string) error { *r = append(*r, s); return nil }
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("idxctl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	file := fs.String("f", "", "pool file")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s\n%s", err.Error(), usage)
+	}
+	if *file == "" || fs.NArg() == 0 {
+		return fmt.Errorf("missing pool file or command\n%s", usage)
+	}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	if cmd == "create" {
+		return create(*file, args)
+	}
+
+	s, p, err := load(*file)
+	if err != nil {
+		return err
+	}
+	mutated, err := runCommand(p, cmd, args, stdout)
+	if err != nil {
+		return err
+	}
+	if !mutated {
+		return nil
+	}
+	return save(*file, &s.Pool, p)
+}
+
+// runCommand runs a command on the pool and returns true when the pool is changed
+func runCommand(p pool.Pool, cmd string, args []string, stdout io.Writer) (bool, error) {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	l := fs.String("l", "", "labels, e.g. tenant=a,site=b")
+	sel := fs.String("selector", "", "label selector, e.g. tenant=a,site!=b")
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+	lbls, err := labels.ConvertSelectorToLabelsMap(*l)
+	if err != nil {
+		return false, err
+	}
+	arg := func() (string, error) {
+		if fs.NArg() != 1 {
+			return "", fmt.Errorf("%s needs a single argument", cmd)
+		}
+		return fs.Arg(0), nil
+	}
+
+	switch cmd {
+	case "claim":
+		id, err := arg()
+		if err != nil {
+			return false, err
+		}
+		e, err := p.Claim(id, lbls)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, e)
+		return true, nil
+	case "claim-range":
+		r, err := arg()
+		if err != nil {
+			return false, err
+		}
+		entries, err := p.ClaimRange(r, lbls)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, entries...)
+		return true, nil
+	case "claim-free":
+		e, err := p.ClaimFree(lbls)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, e)
+		return true, nil
+	case "release":
+		id, err := arg()
+		if err != nil {
+			return false, err
+		}
+		e, err := p.Release(id)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, e)
+		return true, nil
+	case "get":
+		id, err := arg()
+		if err != nil {
+			return false, err
+		}
+		e, err := p.Get(id)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, e)
+		return false, nil
+	case "list":
+		selector, err := labels.Parse(*sel)
+		if err != nil {
+			return false, err
+		}
+		printEntries(stdout, p.List(selector)...)
+		return false, nil
+	case "free":
+		for _, r := range p.FreeRanges() {
+			fmt.Fprintln(stdout, r)
+		}
+		return false, nil
+	case "stats":
+		stats := p.Stats()
+		fmt.Fprintf(stdout, "capacity: %d\nclaimed: %d\nfree: %d\n", stats.Capacity, stats.Claimed, stats.Free)
+		return false, nil
+	case "tree":
+		return false, p.Render(stdout)
+	default:
+		return false, fmt.Errorf("unknown command %q\n%s", cmd, usage)
+	}
+}
+
+func create(file string, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	name := fs.String("name", "", "pool name, defaults to the file name")
+	kind := fs.String("kind", "", "pool kind: vlan, vni, label, ipv4 or ipv6")
+	var ranges, reservations stringList
+	fs.Var(&ranges, "range", "pool range, can be repeated")
+	fs.Var(&reservations, "reserve", "reserved range, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("pool file %s already exists", file)
+	}
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	spec := &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: *name},
+		Spec: v1alpha1.PoolSpec{
+			Kind:   v1alpha1.PoolKind(*kind),
+			Ranges: ranges,
+		},
+	}
+	for _, r := range reservations {
+		spec.Spec.Reservations = append(spec.Spec.Reservations, v1alpha1.Reservation{Range: r})
+	}
+	p, err := pool.New(spec)
+	if err != nil {
+		return err
+	}
+	return save(file, spec, p)
+}
+
+func load(file string) (*pool.Snapshot, pool.Pool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	s, err := pool.ReadSnapshot(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read pool file %s: %w", file, err)
+	}
+	p, err := pool.Restore(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, p, nil
+}
+
+// save writes the snapshot of the pool to a temporary file which replaces the
+// pool file, so a failed write does not corrupt the pool file
+func save(file string, spec *v1alpha1.Pool, p pool.Pool) error {
+	s, err := pool.TakeSnapshot(spec, p)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := pool.WriteSnapshot(f, s); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func printEntries(w io.Writer, entries ...pool.Entry) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s %s\n", e.ID, e.Labels.String())
+	}
+}
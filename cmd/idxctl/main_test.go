@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	vlan := filepath.Join(dir, "vlan.json")
+	labels := filepath.Join(dir, "labels.json")
+	ipv4 := filepath.Join(dir, "ipv4.json")
+
+	// every command runs on the state saved by the previous ones
+	cases := []struct {
+		args     string
+		expected string
+		err      bool
+	}{
+		{args: "-f " + vlan + " create -kind vlan -range 100-199 -reserve 100-109"},
+		{args: "-f " + vlan + " create -kind vlan -range 100-199", err: true},
+		{args: "-f " + vlan + " claim -l tenant=a 150", expected: "150 tenant=a\n"},
+		{args: "-f " + vlan + " claim 150", err: true},
+		{args: "-f " + vlan + " claim-range -l tenant=a 160-161", expected: "160 tenant=a\n161 tenant=a\n"},
+		{args: "-f " + vlan + " claim-free -l tenant=b", expected: "110 tenant=b\n"},
+		{args: "-f " + vlan + " release 160", expected: "160 tenant=a\n"},
+		{args: "-f " + vlan + " get 161", expected: "161 tenant=a\n"},
+		{args: "-f " + vlan + " list -selector tenant=a", expected: "150 tenant=a\n161 tenant=a\n"},
+		{args: "-f " + vlan + " free", expected: "111-149\n151-160\n162-199\n"},
+		{args: "-f " + vlan + " stats", expected: "capacity: 100\nclaimed: 13\nfree: 87\n"},
+		{args: "-f " + labels + " create -kind label -range 16-1048575"},
+		{args: "-f " + labels + " claim -l region=a 4096/20", expected: "4096/20 region=a\n"},
+		{args: "-f " + labels + " claim-range -l site=a 4096-4097", err: true},
+		{args: "-f " + labels + " claim -l site=a 8192/24", expected: "8192/24 site=a\n"},
+		{args: "-f " + labels + " release 4100", expected: "4100 region=a\n"},
+		{args: "-f " + labels + " list -selector region=a", expected: "4096/30 region=a\n4101 region=a\n4102/31 region=a\n4104/29 region=a\n4112/28 region=a\n4128/27 region=a\n4160/26 region=a\n4224/25 region=a\n4352/24 region=a\n4608/23 region=a\n5120/22 region=a\n6144/21 region=a\n"},
+		{args: "-f " + ipv4 + " create -kind ipv4 -range 10.0.0.0/29 -reserve 10.0.0.0"},
+		{args: "-f " + ipv4 + " claim-free -l host=a", expected: "10.0.0.1 host=a\n"},
+		{args: "-f " + ipv4 + " free", expected: "10.0.0.2-10.0.0.7\n"},
+		{args: "-f " + ipv4 + " unknown", err: true},
+	}
+	for _, tc := range cases {
+		var stdout bytes.Buffer
+		err := run(strings.Fields(tc.args), &stdout)
+		if tc.err {
+			assert.Error(t, err, tc.args)
+			continue
+		}
+		assert.NoError(t, err, tc.args)
+		assert.Equal(t, tc.expected, stdout.String(), tc.args)
+	}
+
+	var stdout bytes.Buffer
+	assert.NoError(t, run([]string{"-f", labels, "claim", "-l", "device=a", "20"}, &stdout))
+	stdout.Reset()
+	assert.NoError(t, run([]string{"-f", labels, "tree"}, &stdout))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Contains(t, lines, "20 device=a")
+}
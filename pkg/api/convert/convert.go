@@ -0,0 +1,149 @@
+// Package convert builds the allocation tables from the v1alpha1 Pool API
+// and applies Claims to them.
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// ClaimLabelKey is the label key holding the name of the claim that owns a value
+	ClaimLabelKey = "idxtable.henderiw.io/claim"
+	// OwnerKindLabelKey is the label key holding the kind of the owner of the claim
+	OwnerKindLabelKey = "idxtable.henderiw.io/owner-kind"
+	// OwnerNameLabelKey is the label key holding the name of the owner of the claim
+	OwnerNameLabelKey = "idxtable.henderiw.io/owner-name"
+	// ReservedLabelKey is the label key, with the pool name as value, set on the
+	// reservations of a pool and on the values of a label pool that are not
+	// part of the pool ranges
+	ReservedLabelKey = tree.ReservedLabelKey
+)
+
+const (
+	maxVLAN  = 1<<12 - 1
+	maxVNI   = 1<<24 - 1
+	maxLabel = 1<<20 - 1
+	// labelBitLength is the number of bits of an mpls label
+	labelBitLength = 20
+)
+
+// Allocator allocates the values of a pool for claims
+type Allocator interface {
+	// Apply allocates the values requested by the claim and records them in the claim status.
+	// Either all requested values are allocated or none.
+	Apply(claim *v1alpha1.Claim) error
+	// Release releases the values allocated to the claim
+	Release(claim *v1alpha1.Claim) error
+}
+
+// New returns the allocator matching the kind of the pool
+func New(pool *v1alpha1.Pool) (Allocator, error) {
+	switch pool.Spec.Kind {
+	case v1alpha1.PoolKindVLAN, v1alpha1.PoolKindVNI:
+		t, err := NewTable(pool)
+		if err != nil {
+			return nil, err
+		}
+		return &tableAllocator{table: t}, nil
+	case v1alpha1.PoolKindLabel:
+		t, err := NewTree(pool)
+		if err != nil {
+			return nil, err
+		}
+		return &treeAllocator{tree: t}, nil
+	case v1alpha1.PoolKindIPv4, v1alpha1.PoolKindIPv6:
+		t, err := NewIPTable(pool)
+		if err != nil {
+			return nil, err
+		}
+		return &ipTableAllocator{table: t}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pool kind %q", pool.Spec.Kind)
+	}
+}
+
+// claimLabels returns the labels stored with the values of the claim
+func claimLabels(claim *v1alpha1.Claim) labels.Set {
+	l := labels.Set{}
+	for k, v := range claim.Spec.Labels {
+		l[k] = v
+	}
+	if claim.Spec.Owner != nil {
+		l[OwnerKindLabelKey] = claim.Spec.Owner.Kind
+		l[OwnerNameLabelKey] = claim.Spec.Owner.Name
+	}
+	l[ClaimLabelKey] = claim.Name
+	return l
+}
+
+// reservationLabels returns the labels stored with the values of the reservation
+func reservationLabels(pool *v1alpha1.Pool, reservation v1alpha1.Reservation) labels.Set {
+	l := labels.Set{}
+	for k, v := range reservation.Labels {
+		l[k] = v
+	}
+	l[ReservedLabelKey] = pool.Name
+	return l
+}
+
+// claimSelector selects the values owned by the claim
+func claimSelector(claim *v1alpha1.Claim) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{ClaimLabelKey: claim.Name})
+}
+
+// validateClaimSpec checks that at most one of the value requests is set
+func validateClaimSpec(spec v1alpha1.ClaimSpec) error {
+	set := 0
+	for _, b := range []bool{spec.ID != nil, spec.Range != nil, spec.Prefix != nil, spec.Size != nil} {
+		if b {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("claim can only request one of id, range, prefix or size")
+	}
+	if spec.Size != nil && *spec.Size == 0 {
+		return fmt.Errorf("claim size must be bigger than 0")
+	}
+	return nil
+}
+
+// parseIDSet returns the union of the id sets in the list
+func parseIDSet[U genid.Uint](sets []string) (*genid.IDSet[U], error) {
+	var bldr genid.IDSetBuilder[U]
+	for _, s := range sets {
+		idset, err := genid.ParseSet[U](s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddSet(idset)
+	}
+	return bldr.IPSet()
+}
+
+// parseSingleID returns the set holding the single id s
+func parseSingleID[U genid.Uint](s string) (*genid.IDSet[U], error) {
+	idset, err := genid.ParseSet[U](s)
+	if err != nil {
+		return nil, err
+	}
+	if idset.Size() != 1 {
+		return nil, fmt.Errorf("id %q is not a single id", s)
+	}
+	return idset, nil
+}
+
+// formatIDSet returns the ids and ranges of the set as a list
+func formatIDSet[U genid.Uint](idset *genid.IDSet[U]) []string {
+	s := genid.FormatSet(idset)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
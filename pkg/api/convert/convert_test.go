@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"testing"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/tj/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newPool(kind v1alpha1.PoolKind, ranges []string, reservations ...v1alpha1.Reservation) *v1alpha1.Pool {
+	return &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+		Spec: v1alpha1.PoolSpec{
+			Kind:         kind,
+			Ranges:       ranges,
+			Reservations: reservations,
+		},
+	}
+}
+
+func newClaim(name string, mutate func(spec *v1alpha1.ClaimSpec)) *v1alpha1.Claim {
+	claim := &v1alpha1.Claim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.ClaimSpec{
+			Pool:  "pool",
+			Owner: &metav1.OwnerReference{Kind: "Device", Name: "dev1"},
+		},
+	}
+	if mutate != nil {
+		mutate(&claim.Spec)
+	}
+	return claim
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestApply(t *testing.T) {
+	cases := map[string]struct {
+		pool              *v1alpha1.Pool
+		claims            []*v1alpha1.Claim
+		expectedAllocated [][]string
+		expectedErr       []bool
+	}{
+		"VLAN": {
+			pool: newPool(v1alpha1.PoolKindVLAN, []string{"100-199"}, v1alpha1.Reservation{Range: "100-109"}),
+			claims: []*v1alpha1.Claim{
+				newClaim("id", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("150") }),
+				newClaim("range", func(spec *v1alpha1.ClaimSpec) { spec.Range = ptr("148-152") }),
+				newClaim("size", func(spec *v1alpha1.ClaimSpec) { spec.Size = ptr(uint64(3)) }),
+				newClaim("free", nil),
+				newClaim("outofrange", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("200") }),
+			},
+			expectedAllocated: [][]string{{"150"}, nil, {"110-112"}, {"113"}, nil},
+			expectedErr:       []bool{false, true, false, false, true},
+		},
+		"Label": {
+			pool: newPool(v1alpha1.PoolKindLabel, []string{"16-1048575"}),
+			claims: []*v1alpha1.Claim{
+				newClaim("prefix", func(spec *v1alpha1.ClaimSpec) { spec.Prefix = ptr("4096/20") }),
+				newClaim("overlap", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("5000") }),
+				newClaim("reserved", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("15") }),
+				newClaim("free", nil),
+			},
+			expectedAllocated: [][]string{{"4096-8191"}, nil, nil, {"16"}},
+			expectedErr:       []bool{false, true, true, false},
+		},
+		"IPv4": {
+			pool: newPool(v1alpha1.PoolKindIPv4, []string{"10.0.0.0/24"}, v1alpha1.Reservation{Range: "10.0.0.0-10.0.0.1"}),
+			claims: []*v1alpha1.Claim{
+				newClaim("size", func(spec *v1alpha1.ClaimSpec) { spec.Size = ptr(uint64(2)) }),
+				newClaim("prefix", func(spec *v1alpha1.ClaimSpec) { spec.Prefix = ptr("10.0.0.8/30") }),
+				newClaim("id", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("10.0.0.9") }),
+				newClaim("outofrange", func(spec *v1alpha1.ClaimSpec) { spec.ID = ptr("10.0.1.1") }),
+			},
+			expectedAllocated: [][]string{{"10.0.0.2-10.0.0.3"}, {"10.0.0.8-10.0.0.11"}, nil, nil},
+			expectedErr:       []bool{false, false, true, true},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, err := New(tc.pool)
+			assert.NoError(t, err)
+
+			for i, claim := range tc.claims {
+				err := a.Apply(claim)
+				if tc.expectedErr[i] {
+					assert.Error(t, err, claim.Name)
+				} else {
+					assert.NoError(t, err, claim.Name)
+				}
+				assert.Equal(t, tc.expectedAllocated[i], claim.Status.Allocated, claim.Name)
+			}
+			// a released claim frees its values for the next claim
+			claim := tc.claims[0]
+			allocated := claim.Status.Allocated
+			assert.NoError(t, a.Release(claim))
+			assert.Nil(t, claim.Status.Allocated)
+			assert.NoError(t, a.Apply(claim))
+			assert.Equal(t, allocated, claim.Status.Allocated)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := map[string]struct {
+		pool *v1alpha1.Pool
+	}{
+		"NotContiguous": {
+			pool: newPool(v1alpha1.PoolKindVLAN, []string{"1-10", "20-30"}),
+		},
+		"VLANTooBig": {
+			pool: newPool(v1alpha1.PoolKindVLAN, []string{"1-4096"}),
+		},
+		"WrongFamily": {
+			pool: newPool(v1alpha1.PoolKindIPv6, []string{"10.0.0.0/24"}),
+		},
+		"ReservationOutOfRange": {
+			pool: newPool(v1alpha1.PoolKindVNI, []string{"1-100"}, v1alpha1.Reservation{Range: "101"}),
+		},
+		"UnknownKind": {
+			pool: newPool("esi", []string{"1-100"}),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(tc.pool)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestClaimAllOrNone(t *testing.T) {
+	// the quota fails the claim of the last id of the set
+	rules := []quota.Rule{{Name: "max", Selector: labels.Everything(), MaxIDs: 2}}
+	l := labels.Set{"owner": "a"}
+	idset, err := parseIDSet[uint32]([]string{"10-12"})
+	assert.NoError(t, err)
+
+	vlans := newPool(v1alpha1.PoolKindVLAN, []string{"1-4094"})
+	tbl, err := NewTable(vlans)
+	assert.NoError(t, err)
+	assert.NoError(t, tbl.SetQuotas(rules))
+	assert.Error(t, ClaimTableIDs(tbl, idset, l))
+	assert.Equal(t, 0, len(tbl.GetByLabel(labels.SelectorFromSet(l))))
+
+	// 10-12 is claimed as 10/31 and 12/32
+	tr, err := NewTree(newPool(v1alpha1.PoolKindLabel, []string{"0-4095"}))
+	assert.NoError(t, err)
+	assert.NoError(t, tr.SetQuotas(rules))
+	assert.Error(t, ClaimTreeIDs(tr, idset, l))
+	assert.Equal(t, 0, len(tr.GetByLabel(labels.SelectorFromSet(l))))
+
+	ips, err := NewIPTable(newPool(v1alpha1.PoolKindIPv4, []string{"10.0.0.0/24"}))
+	assert.NoError(t, err)
+	assert.NoError(t, ips.SetQuotas(rules))
+	ipset, err := ParseIPSet("10.0.0.10-10.0.0.12")
+	assert.NoError(t, err)
+	assert.Error(t, ClaimIPs(ips, ipset, l))
+	assert.Equal(t, 0, len(ips.GetByLabel(labels.SelectorFromSet(l))))
+}
@@ -0,0 +1,212 @@
+package convert
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/iptable"
+	"go4.org/netipx"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NewIPTable returns the ip table of an ipv4 or ipv6 pool, with the reservations claimed
+func NewIPTable(pool *v1alpha1.Pool) (iptable.IPTable, error) {
+	var bldr netipx.IPSetBuilder
+	for _, s := range pool.Spec.Ranges {
+		ipset, err := ParseIPSet(s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddSet(ipset)
+	}
+	ipset, err := bldr.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	ranges := ipset.Ranges()
+	if len(ranges) != 1 {
+		return nil, fmt.Errorf("pool %s of kind %s needs a single contiguous range, got: %v", pool.Name, pool.Spec.Kind, pool.Spec.Ranges)
+	}
+	ipRange := ranges[0]
+	switch pool.Spec.Kind {
+	case v1alpha1.PoolKindIPv4:
+		if !ipRange.From().Is4() {
+			return nil, fmt.Errorf("pool %s of kind %s has a non ipv4 range %s", pool.Name, pool.Spec.Kind, ipRange.String())
+		}
+	case v1alpha1.PoolKindIPv6:
+		if !ipRange.From().Is6() {
+			return nil, fmt.Errorf("pool %s of kind %s has a non ipv6 range %s", pool.Name, pool.Spec.Kind, ipRange.String())
+		}
+	default:
+		return nil, fmt.Errorf("pool %s of kind %s is not ip based", pool.Name, pool.Spec.Kind)
+	}
+
+	t := iptable.New(ipRange.From(), ipRange.To())
+	for _, reservation := range pool.Spec.Reservations {
+		ipset, err := ParseIPSet(reservation.Range)
+		if err != nil {
+			return nil, err
+		}
+		if err := ClaimIPs(t, ipset, reservationLabels(pool, reservation)); err != nil {
+			return nil, fmt.Errorf("pool %s reservation %q failed: %s", pool.Name, reservation.Range, err.Error())
+		}
+	}
+	return t, nil
+}
+
+type ipTableAllocator struct {
+	table iptable.IPTable
+}
+
+func (r *ipTableAllocator) Apply(claim *v1alpha1.Claim) error {
+	if err := validateClaimSpec(claim.Spec); err != nil {
+		return err
+	}
+	l := claimLabels(claim)
+
+	var ipset *netipx.IPSet
+	var err error
+	switch {
+	case claim.Spec.ID != nil:
+		var addr netip.Addr
+		addr, err = netip.ParseAddr(*claim.Spec.ID)
+		if err == nil {
+			var bldr netipx.IPSetBuilder
+			bldr.Add(addr)
+			ipset, err = bldr.IPSet()
+		}
+	case claim.Spec.Range != nil:
+		ipset, err = ParseIPSet(*claim.Spec.Range)
+	case claim.Spec.Prefix != nil:
+		var pfx netip.Prefix
+		pfx, err = netip.ParsePrefix(*claim.Spec.Prefix)
+		if err == nil {
+			var bldr netipx.IPSetBuilder
+			bldr.AddPrefix(pfx)
+			ipset, err = bldr.IPSet()
+		}
+	default:
+		size := uint64(1)
+		if claim.Spec.Size != nil {
+			size = *claim.Spec.Size
+		}
+		var bldr netipx.IPSetBuilder
+		for i := uint64(0); i < size; i++ {
+			addr, err := r.table.FindFree()
+			if err == nil {
+				err = r.table.Claim(addr.String(), newRoute(addr, l))
+			}
+			if err != nil {
+				_ = r.release(claim)
+				return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+			}
+			bldr.Add(addr)
+		}
+		ipset, err := bldr.IPSet()
+		if err != nil {
+			return err
+		}
+		claim.Status.Allocated = FormatIPSet(ipset)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+	}
+	if err := ClaimIPs(r.table, ipset, l); err != nil {
+		return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+	}
+	claim.Status.Allocated = FormatIPSet(ipset)
+	return nil
+}
+
+func (r *ipTableAllocator) Release(claim *v1alpha1.Claim) error {
+	if err := r.release(claim); err != nil {
+		return err
+	}
+	claim.Status.Allocated = nil
+	return nil
+}
+
+func (r *ipTableAllocator) release(claim *v1alpha1.Claim) error {
+	for _, route := range r.table.GetByLabel(claimSelector(claim)) {
+		if err := r.table.Release(route.Prefix().Addr().String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseIPSet parses an address, a prefix or a from-to address range
+func ParseIPSet(s string) (*netipx.IPSet, error) {
+	s = strings.TrimSpace(s)
+	var bldr netipx.IPSetBuilder
+	switch {
+	case strings.Contains(s, "/"):
+		pfx, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddPrefix(pfx)
+	case strings.Contains(s, "-"):
+		ipRange, err := netipx.ParseIPRange(s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddRange(ipRange)
+	default:
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.Add(addr)
+	}
+	return bldr.IPSet()
+}
+
+// FormatIPSet returns the ranges of the set as a list, single addresses are
+// returned without a range
+func FormatIPSet(ipset *netipx.IPSet) []string {
+	var ranges []string
+	for _, ipRange := range ipset.Ranges() {
+		if ipRange.From() == ipRange.To() {
+			ranges = append(ranges, ipRange.From().String())
+			continue
+		}
+		ranges = append(ranges, ipRange.String())
+	}
+	return ranges
+}
+
+func newRoute(addr netip.Addr, l labels.Set) table.Route {
+	return table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), l, nil)
+}
+
+// ClaimIPs claims all addresses of the set, or none of them when one of the
+// addresses is not free or cannot be claimed
+func ClaimIPs(t iptable.IPTable, ipset *netipx.IPSet, l labels.Set) error {
+	ranges := ipset.Ranges()
+	for _, ipRange := range ranges {
+		for addr := ipRange.From(); addr.IsValid() && addr.Compare(ipRange.To()) <= 0; addr = addr.Next() {
+			if !t.IsFree(addr.String()) {
+				return fmt.Errorf("ip %s is not available", addr.String())
+			}
+		}
+	}
+	claimed := []netip.Addr{}
+	for _, ipRange := range ranges {
+		for addr := ipRange.From(); addr.IsValid() && addr.Compare(ipRange.To()) <= 0; addr = addr.Next() {
+			if err := t.Claim(addr.String(), newRoute(addr, l)); err != nil {
+				// release the addresses claimed so far
+				for _, addr := range claimed {
+					_ = t.Release(addr.String())
+				}
+				return err
+			}
+			claimed = append(claimed, addr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/table"
+	"github.com/henderiw/idxtable/pkg/table/table16"
+	"github.com/henderiw/idxtable/pkg/table/table32"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NewTable returns the table of a vlan or vni pool, with the reservations claimed
+func NewTable(pool *v1alpha1.Pool) (table.Table, error) {
+	idset, err := parseIDSet[uint32](pool.Spec.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	ranges := idset.Ranges()
+	if len(ranges) != 1 {
+		return nil, fmt.Errorf("pool %s of kind %s needs a single contiguous range, got: %q", pool.Name, pool.Spec.Kind, idset.String())
+	}
+	from, to := ranges[0].From().ID(), ranges[0].To().ID()
+
+	var t table.Table
+	switch pool.Spec.Kind {
+	case v1alpha1.PoolKindVLAN:
+		if to > maxVLAN {
+			return nil, fmt.Errorf("pool %s range %q exceeds max vlan id %d", pool.Name, idset.String(), maxVLAN)
+		}
+		t = table16.New(uint16(from), uint16(to))
+	case v1alpha1.PoolKindVNI:
+		if to > maxVNI {
+			return nil, fmt.Errorf("pool %s range %q exceeds max vni %d", pool.Name, idset.String(), maxVNI)
+		}
+		t = table32.New(uint32(from), uint32(to))
+	default:
+		return nil, fmt.Errorf("pool %s of kind %s is not table based", pool.Name, pool.Spec.Kind)
+	}
+
+	for _, reservation := range pool.Spec.Reservations {
+		idset, err := genid.ParseSet[uint32](reservation.Range)
+		if err != nil {
+			return nil, err
+		}
+		if err := ClaimTableIDs(t, idset, reservationLabels(pool, reservation)); err != nil {
+			return nil, fmt.Errorf("pool %s reservation %q failed: %s", pool.Name, reservation.Range, err.Error())
+		}
+	}
+	return t, nil
+}
+
+type tableAllocator struct {
+	table table.Table
+}
+
+func (r *tableAllocator) Apply(claim *v1alpha1.Claim) error {
+	if err := validateClaimSpec(claim.Spec); err != nil {
+		return err
+	}
+	l := claimLabels(claim)
+
+	var bldr genid.IDSetBuilder[uint32]
+	switch {
+	case claim.Spec.ID != nil:
+		idset, err := parseSingleID[uint32](*claim.Spec.ID)
+		if err != nil {
+			return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+		}
+		bldr.AddSet(idset)
+	case claim.Spec.Range != nil:
+		idset, err := genid.ParseSet[uint32](*claim.Spec.Range)
+		if err != nil {
+			return err
+		}
+		bldr.AddSet(idset)
+	case claim.Spec.Prefix != nil:
+		return fmt.Errorf("claim %s: prefix claims are not supported by table based pools", claim.Name)
+	default:
+		size := uint64(1)
+		if claim.Spec.Size != nil {
+			size = *claim.Spec.Size
+		}
+		claimed := []uint64{}
+		for i := uint64(0); i < size; i++ {
+			e, _, err := r.table.ClaimFree(l)
+			if err != nil {
+				for _, id := range claimed {
+					_, _ = r.table.Release(id)
+				}
+				return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+			}
+			claimed = append(claimed, e.ID().ID())
+			bldr.AddRange(genid.RangeFrom(uint32(e.ID().ID()), uint32(e.ID().ID())))
+		}
+		idset, err := bldr.IPSet()
+		if err != nil {
+			return err
+		}
+		claim.Status.Allocated = formatIDSet(idset)
+		return nil
+	}
+
+	idset, err := bldr.IPSet()
+	if err != nil {
+		return err
+	}
+	if err := ClaimTableIDs(r.table, idset, l); err != nil {
+		return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+	}
+	claim.Status.Allocated = formatIDSet(idset)
+	return nil
+}
+
+func (r *tableAllocator) Release(claim *v1alpha1.Claim) error {
+	for _, e := range r.table.GetByLabel(claimSelector(claim)) {
+		if _, err := r.table.Release(e.ID().ID()); err != nil {
+			return err
+		}
+	}
+	claim.Status.Allocated = nil
+	return nil
+}
+
+// ClaimTableIDs claims all ids of the set, or none of them when one of the ids
+// is not free or cannot be claimed
+func ClaimTableIDs(t table.Table, idset *genid.IDSet[uint32], l labels.Set) error {
+	for _, r := range idset.Ranges() {
+		for id := r.From().ID(); id <= r.To().ID(); id++ {
+			if !t.IsFree(id) {
+				return fmt.Errorf("id %d is not available", id)
+			}
+		}
+	}
+	claimed := []uint64{}
+	for _, r := range idset.Ranges() {
+		for id := r.From().ID(); id <= r.To().ID(); id++ {
+			if _, err := t.Claim(id, l); err != nil {
+				// release the ids claimed so far
+				for _, id := range claimed {
+					_, _ = t.Release(id)
+				}
+				return err
+			}
+			claimed = append(claimed, id)
+		}
+	}
+	return nil
+}
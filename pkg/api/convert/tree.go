@@ -0,0 +1,129 @@
+package convert
+
+import (
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
+	"github.com/henderiw/idxtable/pkg/tree/tree32"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NewTree returns the tree of a label pool. The labels outside of the pool
+// ranges are claimed as aggregate prefixes with the reserved label, and the
+// reservations of the pool are claimed.
+func NewTree(pool *v1alpha1.Pool) (gtree.GTree, error) {
+	if pool.Spec.Kind != v1alpha1.PoolKindLabel {
+		return nil, fmt.Errorf("pool %s of kind %s is not tree based", pool.Name, pool.Spec.Kind)
+	}
+	idset, err := parseIDSet[uint32](pool.Spec.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	labelRange := id32.RangeFrom(0, maxLabel)
+	if ranges := idset.Ranges(); len(ranges) > 0 && ranges[len(ranges)-1].To().ID() > maxLabel {
+		return nil, fmt.Errorf("pool %s range %q exceeds max label %d", pool.Name, idset.String(), maxLabel)
+	}
+
+	t, err := tree32.New(pool.Name, labelBitLength)
+	if err != nil {
+		return nil, err
+	}
+	if err := ClaimTreeIDs(t, idset.Complement(labelRange), labels.Set{ReservedLabelKey: pool.Name}); err != nil {
+		return nil, err
+	}
+	for _, reservation := range pool.Spec.Reservations {
+		idset, err := genid.ParseSet[uint32](reservation.Range)
+		if err != nil {
+			return nil, err
+		}
+		if err := ClaimTreeIDs(t, idset, reservationLabels(pool, reservation)); err != nil {
+			return nil, fmt.Errorf("pool %s reservation %q failed: %s", pool.Name, reservation.Range, err.Error())
+		}
+	}
+	return t, nil
+}
+
+type treeAllocator struct {
+	tree gtree.GTree
+}
+
+func (r *treeAllocator) Apply(claim *v1alpha1.Claim) error {
+	if err := validateClaimSpec(claim.Spec); err != nil {
+		return err
+	}
+	l := claimLabels(claim)
+
+	var idset *genid.IDSet[uint32]
+	var err error
+	switch {
+	case claim.Spec.ID != nil:
+		idset, err = parseSingleID[uint32](*claim.Spec.ID)
+	case claim.Spec.Range != nil:
+		idset, err = genid.ParseSet[uint32](*claim.Spec.Range)
+	case claim.Spec.Prefix != nil:
+		idset, err = genid.ParseSet[uint32](*claim.Spec.Prefix)
+		if err == nil && len(idset.IDs()) != 1 {
+			err = fmt.Errorf("prefix %q is not a single prefix", *claim.Spec.Prefix)
+		}
+	default:
+		size := uint64(1)
+		if claim.Spec.Size != nil {
+			size = *claim.Spec.Size
+		}
+		var bldr genid.IDSetBuilder[uint32]
+		for i := uint64(0); i < size; i++ {
+			e, _, err := r.tree.ClaimFree(l)
+			if err != nil {
+				_, _ = r.tree.ReleaseByLabel(claimSelector(claim))
+				return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+			}
+			bldr.AddId(e.ID())
+		}
+		idset, err := bldr.IPSet()
+		if err != nil {
+			return err
+		}
+		claim.Status.Allocated = formatIDSet(idset)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+	}
+	if err := ClaimTreeIDs(r.tree, idset, l); err != nil {
+		return fmt.Errorf("claim %s: %s", claim.Name, err.Error())
+	}
+	claim.Status.Allocated = formatIDSet(idset)
+	return nil
+}
+
+func (r *treeAllocator) Release(claim *v1alpha1.Claim) error {
+	if _, err := r.tree.ReleaseByLabel(claimSelector(claim)); err != nil {
+		return err
+	}
+	claim.Status.Allocated = nil
+	return nil
+}
+
+// ClaimTreeIDs claims the minimal prefixes covering the set, or none of them
+// when one of the prefixes is not free or cannot be claimed
+func ClaimTreeIDs(t gtree.GTree, idset *genid.IDSet[uint32], l labels.Set) error {
+	ids := idset.IDs()
+	for _, id := range ids {
+		if !t.IsFree(id) {
+			return fmt.Errorf("id %s is not available", id.String())
+		}
+	}
+	for i, id := range ids {
+		if _, err := t.ClaimID(id, l); err != nil {
+			// release the prefixes claimed so far
+			for _, id := range ids[:i] {
+				_, _ = t.ReleaseID(id)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClaimSpec defines the desired state of a Claim.
+// At most one of ID, Range, Prefix and Size is set; when none is set a single
+// free value is claimed.
+type ClaimSpec struct {
+	// Pool is the name of the pool the claim allocates from
+	Pool string `json:"pool"`
+	// Owner is the object that owns the claimed values
+	// +optional
+	Owner *metav1.OwnerReference `json:"owner,omitempty"`
+	// ID requests a specific id or address, e.g. "100" or "10.0.0.1"
+	// +optional
+	ID *string `json:"id,omitempty"`
+	// Range requests a range of ids or addresses, e.g. "100-199" or "10.0.0.1-10.0.0.10"
+	// +optional
+	Range *string `json:"range,omitempty"`
+	// Prefix requests a prefix, e.g. "4096/20" for a label pool or "10.0.0.0/28" for an ip pool
+	// +optional
+	Prefix *string `json:"prefix,omitempty"`
+	// Size requests a number of free values
+	// +optional
+	Size *uint64 `json:"size,omitempty"`
+	// Labels stored with the claimed values
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ClaimStatus defines the observed state of a Claim
+type ClaimStatus struct {
+	// Allocated are the values allocated to the claim, as ids, ranges, prefixes or addresses
+	// +optional
+	Allocated []string `json:"allocated,omitempty"`
+	// Conditions of the claim
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Claim allocates values from a Pool
+type Claim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClaimSpec   `json:"spec,omitempty"`
+	Status ClaimStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClaimList contains a list of Claims
+type ClaimList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Claim `json:"items"`
+}
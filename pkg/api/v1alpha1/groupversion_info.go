@@ -0,0 +1,34 @@
+// Package v1alpha1 contains the v1alpha1 API types of the idxtable pools and claims.
+// +kubebuilder:object:generate=true
+// +groupName=idxtable.henderiw.io
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//go:generate controller-gen object paths=.
+
+var (
+	// SchemeGroupVersion is the group version used to register these objects.
+	SchemeGroupVersion = schema.GroupVersion{Group: "idxtable.henderiw.io", Version: "v1alpha1"}
+
+	// SchemeBuilder registers the types of this group version with a scheme.
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+
+	// AddToScheme adds the types of this group version to a scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion,
+		&Pool{},
+		&PoolList{},
+		&Claim{},
+		&ClaimList{},
+	)
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
@@ -0,0 +1,75 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PoolKind defines the kind of values a pool allocates
+// +kubebuilder:validation:Enum=vlan;vni;label;ipv4;ipv6
+type PoolKind string
+
+const (
+	// PoolKindVLAN allocates vlan ids (0-4095) from a table
+	PoolKindVLAN PoolKind = "vlan"
+	// PoolKindVNI allocates vxlan network identifiers (0-16777215) from a table
+	PoolKindVNI PoolKind = "vni"
+	// PoolKindLabel allocates mpls labels (0-1048575) from a tree, which supports prefix claims
+	PoolKindLabel PoolKind = "label"
+	// PoolKindIPv4 allocates ipv4 addresses from an ip table
+	PoolKindIPv4 PoolKind = "ipv4"
+	// PoolKindIPv6 allocates ipv6 addresses from an ip table
+	PoolKindIPv6 PoolKind = "ipv6"
+)
+
+// PoolSpec defines the desired state of a Pool
+type PoolSpec struct {
+	// Kind of the values allocated from the pool
+	Kind PoolKind `json:"kind"`
+	// Ranges of the pool; id sets (e.g. "100-199") for the vlan, vni and label kinds,
+	// address ranges (e.g. "10.0.0.10-10.0.0.20") or prefixes (e.g. "10.0.0.0/24") for the ip kinds.
+	// Table backed kinds (vlan, vni, ipv4 and ipv6) need a single contiguous range.
+	Ranges []string `json:"ranges"`
+	// Reservations are values of the pool that are claimed upfront and cannot be allocated
+	// +optional
+	Reservations []Reservation `json:"reservations,omitempty"`
+}
+
+// Reservation is a set of values of a pool which is not available for claims
+type Reservation struct {
+	// Range of the reservation, in the same syntax as the ranges of the pool
+	Range string `json:"range"`
+	// Labels stored with the reserved values
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// PoolStatus defines the observed state of a Pool
+type PoolStatus struct {
+	// Allocated is the number of values claimed from the pool, reservations included
+	// +optional
+	Allocated int `json:"allocated,omitempty"`
+	// Conditions of the pool
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Pool is a set of ids or addresses values can be claimed from
+type Pool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PoolSpec   `json:"spec,omitempty"`
+	Status PoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PoolList contains a list of Pools
+type PoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Pool `json:"items"`
+}
@@ -0,0 +1,162 @@
+// Package audit keeps a bounded history of the changes of the entries of a
+// table, e.g. to find who held a vlan before it was claimed twice.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DefaultCapacity is the number of records of a log when not set
+const DefaultCapacity = 10000
+
+// Record is a claim, update or release of the entry of an id
+type Record struct {
+	Time time.Time         `json:"time"`
+	Op   metrics.Operation `json:"op"`
+	// ID is the textual id, e.g. "100", "4096/20" or "10.0.0.1"
+	ID string `json:"id"`
+	// Actor is the one that made the change, as set on the table
+	Actor string `json:"actor,omitempty"`
+	// Before are the labels of the entry before the change, nil for a claim;
+	// After the ones after the change, nil for a release
+	Before labels.Set `json:"before,omitempty"`
+	After  labels.Set `json:"after,omitempty"`
+}
+
+// Query selects records; the zero Query selects all of them
+type Query struct {
+	// ID selects the records of the id when set
+	ID string
+	// Selector selects the records with labels before or after the change
+	// that match, e.g. the owner of the entry
+	Selector labels.Selector
+	// From and To select the records from (inclusive) and to (exclusive) a
+	// time when set
+	From time.Time
+	To   time.Time
+}
+
+func (r Query) matches(rec Record) bool {
+	if r.ID != "" && r.ID != rec.ID {
+		return false
+	}
+	if r.Selector != nil && !r.Selector.Matches(rec.Before) && !r.Selector.Matches(rec.After) {
+		return false
+	}
+	if !r.From.IsZero() && rec.Time.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !rec.Time.Before(r.To) {
+		return false
+	}
+	return true
+}
+
+// Log holds the last records up to its capacity, the older ones are dropped.
+// It is safe for concurrent use; a nil Log records nothing. A Log is
+// persisted as json, e.g. next to the snapshot of a pool.
+type Log struct {
+	m        sync.RWMutex
+	capacity int
+	// records is a ring of the records from start
+	records []Record
+	start   int
+}
+
+// New returns a log of capacity records, DefaultCapacity when 0
+func New(capacity int) (*Log, error) {
+	if capacity < 0 {
+		return nil, fmt.Errorf("audit log with capacity %d", capacity)
+	}
+	if capacity == 0 {
+		capacity = DefaultCapacity
+	}
+	return &Log{capacity: capacity}, nil
+}
+
+// Append adds the record, dropping the oldest one when the log is full
+func (r *Log) Append(rec Record) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.append(rec)
+}
+
+func (r *Log) append(rec Record) {
+	if len(r.records) < r.capacity {
+		r.records = append(r.records, rec)
+		return
+	}
+	r.records[r.start] = rec
+	r.start = (r.start + 1) % r.capacity
+}
+
+// Records returns the records from the oldest one
+func (r *Log) Records() []Record {
+	return r.Query(Query{})
+}
+
+// Query returns the records selected by q from the oldest one
+func (r *Log) Query(q Query) []Record {
+	records := []Record{}
+	if r == nil {
+		return records
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for i := range r.records {
+		rec := r.records[(r.start+i)%len(r.records)]
+		if q.matches(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// Len returns the number of records in the log
+func (r *Log) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return len(r.records)
+}
+
+type log struct {
+	Capacity int      `json:"capacity"`
+	Records  []Record `json:"records,omitempty"`
+}
+
+func (r *Log) MarshalJSON() ([]byte, error) {
+	return json.Marshal(log{Capacity: r.capacity, Records: r.Records()})
+}
+
+// UnmarshalJSON replaces the records of the log; the last records are kept
+// when there are more than its capacity
+func (r *Log) UnmarshalJSON(b []byte) error {
+	l := log{}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	if l.Capacity <= 0 {
+		return fmt.Errorf("audit log with capacity %d", l.Capacity)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.capacity, r.records, r.start = l.Capacity, nil, 0
+	for _, rec := range l.Records {
+		r.append(rec)
+	}
+	return nil
+}
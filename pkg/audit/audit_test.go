@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/tj/assert"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func at(sec int64) time.Time { return time.Unix(sec, 0).UTC() }
+
+func newLog(t *testing.T) *Log {
+	l, err := New(3)
+	assert.NoError(t, err)
+	l.Append(Record{Time: at(1), Op: metrics.OperationClaim, ID: "100", After: labels.Set{"owner": "a"}})
+	l.Append(Record{Time: at(2), Op: metrics.OperationRelease, ID: "100", Before: labels.Set{"owner": "a"}})
+	l.Append(Record{Time: at(3), Op: metrics.OperationClaim, ID: "100", After: labels.Set{"owner": "b"}})
+	l.Append(Record{Time: at(4), Op: metrics.OperationClaim, ID: "101", After: labels.Set{"owner": "a"}})
+	return l
+}
+
+func TestQuery(t *testing.T) {
+	cases := map[string]struct {
+		query Query
+		times []time.Time
+	}{
+		"All": {
+			query: Query{},
+			times: []time.Time{at(2), at(3), at(4)},
+		},
+		"ID": {
+			query: Query{ID: "100"},
+			times: []time.Time{at(2), at(3)},
+		},
+		"Owner": {
+			query: Query{Selector: labels.SelectorFromSet(labels.Set{"owner": "a"})},
+			times: []time.Time{at(2), at(4)},
+		},
+		"Window": {
+			query: Query{From: at(3), To: at(4)},
+			times: []time.Time{at(3)},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			// the oldest record is dropped
+			times := []time.Time{}
+			for _, rec := range newLog(t).Query(tc.query) {
+				times = append(times, rec.Time)
+			}
+			assert.Equal(t, tc.times, times)
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l := newLog(t)
+	b, err := json.Marshal(l)
+	assert.NoError(t, err)
+	restored := &Log{}
+	assert.NoError(t, json.Unmarshal(b, restored))
+	assert.Equal(t, l.Records(), restored.Records())
+
+	// the restored log keeps its capacity
+	restored.Append(Record{Time: at(5), Op: metrics.OperationRelease, ID: "101"})
+	assert.Equal(t, 3, restored.Len())
+
+	var nilLog *Log
+	nilLog.Append(Record{})
+	assert.Equal(t, 0, nilLog.Len())
+	assert.Equal(t, []Record{}, nilLog.Records())
+}
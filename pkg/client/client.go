@@ -0,0 +1,148 @@
+// Package client is the Go client of the gRPC allocation service.
+package client
+
+import (
+	"context"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/henderiw/idxtable/pkg/server/allocpb"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Client struct {
+	c allocpb.AllocationClient
+}
+
+func New(conn grpc.ClientConnInterface) *Client {
+	return &Client{c: allocpb.NewAllocationClient(conn)}
+}
+
+func (r *Client) CreatePool(ctx context.Context, spec *v1alpha1.Pool) error {
+	req := &allocpb.CreatePoolRequest{
+		Name:   spec.Name,
+		Kind:   string(spec.Spec.Kind),
+		Ranges: spec.Spec.Ranges,
+	}
+	for _, reservation := range spec.Spec.Reservations {
+		req.Reservations = append(req.Reservations, &allocpb.Reservation{
+			Range:  reservation.Range,
+			Labels: reservation.Labels,
+		})
+	}
+	_, err := r.c.CreatePool(ctx, req)
+	return err
+}
+
+func (r *Client) DeletePool(ctx context.Context, name string) error {
+	_, err := r.c.DeletePool(ctx, &allocpb.DeletePoolRequest{Name: name})
+	return err
+}
+
+func (r *Client) ListPools(ctx context.Context) ([]string, error) {
+	resp, err := r.c.ListPools(ctx, &allocpb.ListPoolsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetNames(), nil
+}
+
+func (r *Client) Claim(ctx context.Context, poolName, id string, labels labels.Set) (pool.Entry, error) {
+	resp, err := r.c.Claim(ctx, &allocpb.ClaimRequest{Pool: poolName, Id: id, Labels: labels})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return fromEntry(resp.GetEntry()), nil
+}
+
+func (r *Client) ClaimFree(ctx context.Context, poolName string, labels labels.Set) (pool.Entry, error) {
+	resp, err := r.c.ClaimFree(ctx, &allocpb.ClaimFreeRequest{Pool: poolName, Labels: labels})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return fromEntry(resp.GetEntry()), nil
+}
+
+func (r *Client) ClaimRange(ctx context.Context, poolName, idRange string, labels labels.Set) ([]pool.Entry, error) {
+	resp, err := r.c.ClaimRange(ctx, &allocpb.ClaimRangeRequest{Pool: poolName, Range: idRange, Labels: labels})
+	if err != nil {
+		return nil, err
+	}
+	return fromEntries(resp.GetEntries()), nil
+}
+
+func (r *Client) Release(ctx context.Context, poolName, id string) (pool.Entry, error) {
+	resp, err := r.c.Release(ctx, &allocpb.ReleaseRequest{Pool: poolName, Id: id})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return fromEntry(resp.GetEntry()), nil
+}
+
+func (r *Client) Get(ctx context.Context, poolName, id string) (pool.Entry, error) {
+	resp, err := r.c.Get(ctx, &allocpb.GetRequest{Pool: poolName, Id: id})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return fromEntry(resp.GetEntry()), nil
+}
+
+func (r *Client) List(ctx context.Context, poolName string, selector labels.Selector) ([]pool.Entry, error) {
+	resp, err := r.c.List(ctx, &allocpb.ListRequest{Pool: poolName, Selector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	return fromEntries(resp.GetEntries()), nil
+}
+
+// Watch returns the events of the entries of the pool that match the
+// selector. Watch returns once the watch is established, so the events of all
+// later changes are received. The channel is closed when the context is done
+// or the stream ends.
+func (r *Client) Watch(ctx context.Context, poolName string, selector labels.Selector) (<-chan pool.Event, error) {
+	stream, err := r.c.Watch(ctx, &allocpb.WatchRequest{Pool: poolName, Selector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stream.Header(); err != nil {
+		return nil, err
+	}
+	ch := make(chan pool.Event)
+	go func() {
+		defer close(ch)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- pool.Event{Type: fromEventType(event.GetType()), Pool: poolName, Entry: fromEntry(event.GetEntry())}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func fromEntry(e *allocpb.Entry) pool.Entry {
+	return pool.Entry{ID: e.GetId(), Labels: e.GetLabels()}
+}
+
+func fromEntries(pbEntries []*allocpb.Entry) []pool.Entry {
+	entries := make([]pool.Entry, 0, len(pbEntries))
+	for _, e := range pbEntries {
+		entries = append(entries, fromEntry(e))
+	}
+	return entries
+}
+
+func fromEventType(t allocpb.WatchEvent_Type) pool.EventType {
+	switch t {
+	case allocpb.WatchEvent_TYPE_RELEASED:
+		return pool.EventReleased
+	default:
+		return pool.EventClaimed
+	}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/henderiw/idxtable/pkg/server"
+	"github.com/tj/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newClient(t *testing.T) *Client {
+	conn, stop, err := server.NewInProcess(pool.NewRegistry())
+	assert.NoError(t, err)
+	t.Cleanup(stop)
+	return New(conn)
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+
+	spec := &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "labels"},
+		Spec: v1alpha1.PoolSpec{
+			Kind:         v1alpha1.PoolKindLabel,
+			Ranges:       []string{"16-1048575"},
+			Reservations: []v1alpha1.Reservation{{Range: "16-31", Labels: map[string]string{"reserved": "true"}}},
+		},
+	}
+	assert.NoError(t, c.CreatePool(ctx, spec))
+	assert.Equal(t, codes.AlreadyExists, status.Code(c.CreatePool(ctx, spec)))
+	names, err := c.ListPools(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"labels"}, names)
+
+	e, err := c.ClaimFree(ctx, "labels", labels.Set{"site": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "32", e.ID)
+
+	entries, err := c.ClaimRange(ctx, "labels", "4096/24", labels.Set{"site": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []pool.Entry{{ID: "4096/24", Labels: labels.Set{"site": "b"}}}, entries)
+
+	_, err = c.Claim(ctx, "labels", "4100", labels.Set{"site": "c"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = c.Claim(ctx, "labels", "abc", nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = c.Claim(ctx, "unknown", "100", nil)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	e, err = c.Get(ctx, "labels", "4100")
+	assert.NoError(t, err)
+	assert.Equal(t, "4096/24", e.ID)
+
+	entries, err = c.List(ctx, "labels", labels.SelectorFromSet(labels.Set{"site": "a"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []pool.Entry{{ID: "32", Labels: labels.Set{"site": "a"}}}, entries)
+
+	e, err = c.Release(ctx, "labels", "32")
+	assert.NoError(t, err)
+	assert.Equal(t, "32", e.ID)
+	_, err = c.Get(ctx, "labels", "32")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.NoError(t, c.DeletePool(ctx, "labels"))
+	assert.Equal(t, codes.NotFound, status.Code(c.DeletePool(ctx, "labels")))
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newClient(t)
+
+	spec := &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "vlan"},
+		Spec:       v1alpha1.PoolSpec{Kind: v1alpha1.PoolKindVLAN, Ranges: []string{"100-199"}},
+	}
+	assert.NoError(t, c.CreatePool(ctx, spec))
+
+	ch, err := c.Watch(ctx, "vlan", labels.SelectorFromSet(labels.Set{"tenant": "a"}))
+	assert.NoError(t, err)
+	_, err = c.Claim(ctx, "vlan", "100", labels.Set{"tenant": "b"})
+	assert.NoError(t, err)
+	_, err = c.Claim(ctx, "vlan", "101", labels.Set{"tenant": "a"})
+	assert.NoError(t, err)
+	_, err = c.Release(ctx, "vlan", "101")
+	assert.NoError(t, err)
+
+	assert.Equal(t, pool.Event{Type: pool.EventClaimed, Pool: "vlan", Entry: pool.Entry{ID: "101", Labels: labels.Set{"tenant": "a"}}}, <-ch)
+	assert.Equal(t, pool.Event{Type: pool.EventReleased, Pool: "vlan", Entry: pool.Entry{ID: "101", Labels: labels.Set{"tenant": "a"}}}, <-ch)
+
+	// deleting the pool ends the watch
+	assert.NoError(t, c.DeletePool(ctx, "vlan"))
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,211 @@
+package idxtable
+
+import (
+	"github.com/henderiw/idxtable/pkg/metrics"
+)
+
+// Move relocates the entry claimed at From to the free id To
+type Move struct {
+	From uint64
+	To   uint64
+	// Owner is the value of the owner label of the entry, see Plan
+	Owner string
+}
+
+// Plan is the set of moves that frees the block of Size ids from Start
+type Plan struct {
+	Start uint64
+	Size  uint64
+	// OwnerKey is the label key of the owner of the moved entries; the moves
+	// have no owner when it is empty or the data has no labels
+	OwnerKey string
+	Moves    []Move
+}
+
+// ByOwner returns the moves of the plan grouped by their owner
+func (r *Plan) ByOwner() map[string][]Move {
+	moves := map[string][]Move{}
+	for _, move := range r.Moves {
+		moves[move.Owner] = append(moves[move.Owner], move)
+	}
+	return moves
+}
+
+// MoveEvent is emitted for every move of an applied plan, with the entry at
+// its new id
+type MoveEvent[T1 any] struct {
+	Move
+	Entry Entry[T1]
+}
+
+// PlanDefrag returns the plan with the least moves that frees a contiguous
+// block of size ids. Reserved entries are never moved and the entries are
+// moved to the lowest free ids outside of the block; every move has the value
+// of the ownerKey label of its entry as owner. The table is not changed.
+func (r *table[T1]) PlanDefrag(size uint64, ownerKey string) (*Plan, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	if size == 0 || size > r.size {
+		return nil, metrics.Errorf(metrics.ReasonOutOfRange, "size %d must be between 1 and %d", size, r.size)
+	}
+	if r.size-uint64(len(r.table)) < size {
+		return nil, metrics.Errorf(metrics.ReasonExhausted, "not enough free entries for a block of size %d, free %d", size, r.size-uint64(len(r.table)))
+	}
+
+	keys := r.iterate().keys
+	start, ok := r.bestBlock(keys, size)
+	if !ok {
+		return nil, metrics.Errorf(metrics.ReasonExhausted, "no block of size %d without reserved entries", size)
+	}
+	end := start + size - 1
+
+	plan := &Plan{Start: start, Size: size, OwnerKey: ownerKey, Moves: []Move{}}
+	var from []uint64
+	for _, key := range keys {
+		if key >= start && key <= end {
+			from = append(from, key)
+		}
+	}
+	// move to the lowest free ids outside of the block
+	for _, free := range r.free.runs {
+		for to := free.from; to <= free.to && len(from) > 0; to++ {
+			if to >= start && to <= end {
+				to = end
+				continue
+			}
+			plan.Moves = append(plan.Moves, Move{From: from[0], To: to, Owner: r.owner(from[0], ownerKey)})
+			from = from[1:]
+		}
+	}
+	return plan, nil
+}
+
+// owner returns the value of the ownerKey label of the entry of id
+func (r *table[T1]) owner(id uint64, ownerKey string) string {
+	if ownerKey == "" {
+		return ""
+	}
+	return labelsOf(r.table[id].Data())[ownerKey]
+}
+
+// bestBlock returns the start of the block of size ids with the least
+// claimed entries and no reserved entries; keys are the sorted claimed ids.
+// The best block either starts at 0 or right after a claimed id.
+func (r *table[T1]) bestBlock(keys []uint64, size uint64) (uint64, bool) {
+	starts := []uint64{0}
+	for _, key := range keys {
+		if key+size <= r.size-1 {
+			starts = append(starts, key+1)
+		}
+	}
+
+	// reserved[i] is the number of reserved entries before keys[i]
+	reserved := make([]int, len(keys)+1)
+	for i, key := range keys {
+		reserved[i+1] = reserved[i]
+		if isReserved(r.table[key].Data()) {
+			reserved[i+1]++
+		}
+	}
+
+	var best, bestCount uint64
+	found := false
+	// first is the index of the first key in the block, last the index after
+	// the last one
+	first, last := 0, 0
+	for _, start := range starts {
+		end := start + size - 1
+		for first < len(keys) && keys[first] < start {
+			first++
+		}
+		for last < len(keys) && keys[last] <= end {
+			last++
+		}
+		if last > first && reserved[last]-reserved[first] > 0 {
+			continue
+		}
+		count := uint64(max(first, last) - first)
+		if !found || count < bestCount {
+			best, bestCount, found = start, count, true
+		}
+	}
+	return best, found
+}
+
+// ApplyPlan applies the moves of the plan as a single transaction: either all
+// entries are moved or the table is not changed. fn, when not nil, is called
+// for every move after the plan is applied.
+func (r *table[T1]) ApplyPlan(plan *Plan, fn func(MoveEvent[T1])) (uint64, error) {
+	r.m.Lock()
+	events, err := r.applyPlan(plan)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	revision := r.revision
+	r.m.Unlock()
+	if err != nil {
+		return revision, err
+	}
+
+	if fn != nil {
+		for _, event := range events {
+			fn(event)
+		}
+	}
+	return revision, nil
+}
+
+func (r *table[T1]) applyPlan(plan *Plan) ([]MoveEvent[T1], error) {
+	from, to := map[uint64]struct{}{}, map[uint64]struct{}{}
+	for _, move := range plan.Moves {
+		if err := r.validate(move.From); err != nil {
+			return nil, err
+		}
+		if _, ok := from[move.From]; ok || r.isFree(move.From) {
+			return nil, metrics.Errorf(metrics.ReasonNotFound, "move from entry %d not claimed", move.From)
+		}
+		from[move.From] = struct{}{}
+		if err := r.validate(move.To); err != nil {
+			return nil, err
+		}
+		if _, ok := to[move.To]; ok || !r.isFree(move.To) {
+			return nil, metrics.Errorf(metrics.ReasonClaimed, "move to entry %d already claimed", move.To)
+		}
+		to[move.To] = struct{}{}
+	}
+
+	events := make([]MoveEvent[T1], 0, len(plan.Moves))
+	for _, move := range plan.Moves {
+		// getting an error is unlikely as the moves are validated with a lock
+		e, err := r.add(relocate(r.table[move.From], move.To))
+		if err != nil {
+			r.rollback(events)
+			return nil, err
+		}
+		if err := r.delete(move.From); err != nil {
+			r.delete(move.To)
+			r.rollback(events)
+			return nil, err
+		}
+		events = append(events, MoveEvent[T1]{Move: move, Entry: e})
+	}
+	return events, nil
+}
+
+// rollback reverts the applied moves
+func (r *table[T1]) rollback(events []MoveEvent[T1]) {
+	for i := len(events) - 1; i >= 0; i-- {
+		r.delete(events[i].To)
+		r.add(relocate(events[i].Entry, events[i].From))
+	}
+}
+
+// relocate returns the entry e at id, with the metadata of e
+func relocate[T1 any](e Entry[T1], id uint64) Entry[T1] {
+	return entry[T1]{
+		id:         id,
+		data:       e.Data(),
+		created:    e.Created(),
+		updated:    e.Updated(),
+		generation: e.Generation(),
+	}
+}
@@ -0,0 +1,110 @@
+package idxtable
+
+import (
+	"fmt"
+)
+
+// ConflictPolicy defines how Merge handles an id that is claimed in both tables
+// with different data
+type ConflictPolicy int
+
+const (
+	// ConflictPolicyKeep keeps the data of the destination table
+	ConflictPolicyKeep ConflictPolicy = iota
+	// ConflictPolicyOverwrite overwrites the data with the one of the source table
+	ConflictPolicyOverwrite
+	// ConflictPolicyFail fails the merge without changing the destination table
+	ConflictPolicyFail
+)
+
+// EqualFunc is called to check if the data of 2 entries is the same
+type EqualFunc[T1 any] func(a, b T1) bool
+
+// Change is an entry that exists in both tables with different data
+type Change[T1 any] struct {
+	Old Entry[T1]
+	New Entry[T1]
+}
+
+type DiffResult[T1 any] struct {
+	// Added are the entries in b that are not in a
+	Added Entries[T1]
+	// Removed are the entries in a that are not in b
+	Removed Entries[T1]
+	// Changed are the entries in a and b with different data
+	Changed []Change[T1]
+}
+
+func (r DiffResult[T1]) IsEmpty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+// Diff returns the entries that are added, removed or changed in b compared to a.
+// Both tables are walked once in id order.
+func Diff[T1 any](a, b Table[T1], equal EqualFunc[T1]) DiffResult[T1] {
+	result := DiffResult[T1]{
+		Added:   Entries[T1]{},
+		Removed: Entries[T1]{},
+		Changed: []Change[T1]{},
+	}
+
+	iterA := a.Iterate()
+	iterB := b.Iterate()
+	okA := iterA.Next()
+	okB := iterB.Next()
+	for okA || okB {
+		switch {
+		case !okB || (okA && iterA.ID() < iterB.ID()):
+			result.Removed = append(result.Removed, iterA.Value())
+			okA = iterA.Next()
+		case !okA || iterB.ID() < iterA.ID():
+			result.Added = append(result.Added, iterB.Value())
+			okB = iterB.Next()
+		default:
+			if !equal(iterA.Value().Data(), iterB.Value().Data()) {
+				result.Changed = append(result.Changed, Change[T1]{Old: iterA.Value(), New: iterB.Value()})
+			}
+			okA = iterA.Next()
+			okB = iterB.Next()
+		}
+	}
+	return result
+}
+
+// Merge claims the entries of src that are not in dst. Entries that exist in both
+// with different data are handled according to the conflict policy; entries
+// that only exist in dst are left untouched. The merge is applied as a whole:
+// when a claim or update fails, the ones already made are reverted.
+func Merge[T1 any](dst, src Table[T1], policy ConflictPolicy, equal EqualFunc[T1]) error {
+	diff := Diff(dst, src, equal)
+	if policy == ConflictPolicyFail && len(diff.Changed) > 0 {
+		return fmt.Errorf("merge conflict on %d entries, first: %d", len(diff.Changed), diff.Changed[0].Old.ID())
+	}
+	claimed := make([]uint64, 0, len(diff.Added))
+	updated := make([]Change[T1], 0, len(diff.Changed))
+	revert := func() {
+		for i := len(updated) - 1; i >= 0; i-- {
+			_, _ = dst.Update(updated[i].Old.ID(), updated[i].Old.Data())
+		}
+		for i := len(claimed) - 1; i >= 0; i-- {
+			_, _ = dst.Release(claimed[i])
+		}
+	}
+	for _, e := range diff.Added {
+		if _, err := dst.Claim(e.ID(), e.Data()); err != nil {
+			revert()
+			return err
+		}
+		claimed = append(claimed, e.ID())
+	}
+	if policy == ConflictPolicyOverwrite {
+		for _, c := range diff.Changed {
+			if _, err := dst.Update(c.New.ID(), c.New.Data()); err != nil {
+				revert()
+				return err
+			}
+			updated = append(updated, c)
+		}
+	}
+	return nil
+}
@@ -1,24 +1,58 @@
 package idxtable
 
+import (
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+)
+
 type Entry[T1 any] interface {
-	ID()  uint64
+	ID() uint64
 	Data() T1
+	// Revision is the revision of the table when the entry was last changed
+	Revision() uint64
+	// Created is the time the entry was claimed
+	Created() time.Time
+	// Updated is the time of the last change of the entry
+	Updated() time.Time
+	// Generation counts the changes of the entry; it is 1 after the claim
+	Generation() uint64
 }
 
 type entry[T1 any] struct {
-	id   uint64
-	data T1
-	// table
+	id         uint64
+	data       T1
+	revision   uint64
+	created    time.Time
+	updated    time.Time
+	generation uint64
 }
 type Entries[T1 any] []Entry[T1]
 
-func (r entry[T1]) ID() uint64        { return r.id }
-func (r entry[T1]) Data() T1         { return r.data }
+func (r entry[T1]) ID() uint64         { return r.id }
+func (r entry[T1]) Data() T1           { return r.data }
+func (r entry[T1]) Revision() uint64   { return r.revision }
+func (r entry[T1]) Created() time.Time { return r.created }
+func (r entry[T1]) Updated() time.Time { return r.updated }
+func (r entry[T1]) Generation() uint64 { return r.generation }
 
 func NewEntry[T1 any](id uint64, d T1) Entry[T1] {
 	return entry[T1]{
-		id:    id,
-		data:  d,
+		id:   id,
+		data: d,
+	}
+}
+
+// WithMeta returns the entry e with the creation time, update time and
+// generation of meta, which a table keeps when the entry is claimed with
+// ClaimEntry, e.g. an entry loaded from a storage
+func WithMeta[T1 any](e Entry[T1], meta tree.Meta) Entry[T1] {
+	return entry[T1]{
+		id:         e.ID(),
+		data:       e.Data(),
+		created:    meta.Created,
+		updated:    meta.Updated,
+		generation: meta.Generation,
 	}
 }
 
@@ -0,0 +1,107 @@
+package idxtable
+
+import (
+	"sort"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+)
+
+// run is a range of consecutive free ids
+type run struct {
+	from uint64
+	to   uint64
+}
+
+func (r run) size() uint64 { return r.to - r.from + 1 }
+
+// freeRuns keeps the runs of free ids sorted, together with the counts by run
+// size, so the free ids and their fragmentation are known without scanning
+// the table.
+type freeRuns struct {
+	runs []run
+	// sizes is the number of runs by size
+	sizes     map[uint64]uint64
+	histogram [64]uint64
+}
+
+func newFreeRuns(size uint64) *freeRuns {
+	r := &freeRuns{sizes: map[uint64]uint64{}}
+	if size > 0 {
+		r.insert(0, run{from: 0, to: size - 1})
+	}
+	return r
+}
+
+// claim removes id from the free runs
+func (r *freeRuns) claim(id uint64) {
+	i := sort.Search(len(r.runs), func(i int) bool { return r.runs[i].to >= id })
+	if i == len(r.runs) || r.runs[i].from > id {
+		return
+	}
+	old := r.runs[i]
+	r.remove(i)
+	if id < old.to {
+		r.insert(i, run{from: id + 1, to: old.to})
+	}
+	if id > old.from {
+		r.insert(i, run{from: old.from, to: id - 1})
+	}
+}
+
+// release adds id to the free runs, merging it with the adjacent runs
+func (r *freeRuns) release(id uint64) {
+	i := sort.Search(len(r.runs), func(i int) bool { return r.runs[i].from > id })
+	if i > 0 && r.runs[i-1].to >= id {
+		// already free
+		return
+	}
+	newRun := run{from: id, to: id}
+	if i < len(r.runs) && r.runs[i].from == id+1 {
+		newRun.to = r.runs[i].to
+		r.remove(i)
+	}
+	if i > 0 && r.runs[i-1].to+1 == id {
+		newRun.from = r.runs[i-1].from
+		r.remove(i - 1)
+		i--
+	}
+	r.insert(i, newRun)
+}
+
+// first returns the lowest free id
+func (r *freeRuns) first() (uint64, bool) {
+	if len(r.runs) == 0 {
+		return 0, false
+	}
+	return r.runs[0].from, true
+}
+
+func (r *freeRuns) insert(i int, newRun run) {
+	r.runs = append(r.runs, run{})
+	copy(r.runs[i+1:], r.runs[i:])
+	r.runs[i] = newRun
+	r.sizes[newRun.size()]++
+	r.histogram[metrics.RunSizeBucket(newRun.size())]++
+}
+
+func (r *freeRuns) remove(i int) {
+	old := r.runs[i]
+	r.runs = append(r.runs[:i], r.runs[i+1:]...)
+	if r.sizes[old.size()]--; r.sizes[old.size()] == 0 {
+		delete(r.sizes, old.size())
+	}
+	r.histogram[metrics.RunSizeBucket(old.size())]--
+}
+
+// stats sets the run statistics
+func (r *freeRuns) stats(s *metrics.Stats) {
+	s.FreeRuns = uint64(len(r.runs))
+	for size := range r.sizes {
+		s.LargestFreeRun = max(s.LargestFreeRun, size)
+	}
+	last := len(r.histogram)
+	for last > 0 && r.histogram[last-1] == 0 {
+		last--
+	}
+	s.RunSizes = append([]uint64{}, r.histogram[:last]...)
+}
@@ -1,19 +1,47 @@
 package idxtable
 
 import (
-	"fmt"
+	"context"
 	"sort"
+	"strconv"
 	"sync"
+
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Table is a table of size ids. Every mutation returns the revision of the
+// table after the mutation, also when it fails.
 type Table[T1 any] interface {
 	Get(id uint64) (Entry[T1], error)
-	Claim(id uint64, d T1) error
-	ClaimDynamic(d T1) (Entry[T1], error)
-	ClaimRange(start, size uint64, d T1) error
-	ClaimSize(size uint64, d T1) (Entries[T1], error)
-	Release(id uint64) error
-	Update(id uint64, d T1) error
+	Claim(id uint64, d T1) (uint64, error)
+	// ClaimEntry claims the entry e; an entry with a creation time, see
+	// WithMeta, keeps its metadata
+	ClaimEntry(e Entry[T1]) (uint64, error)
+	ClaimDynamic(d T1) (Entry[T1], uint64, error)
+	// ClaimFreeWait claims a free id, waiting for a release when the table is
+	// exhausted
+	ClaimFreeWait(ctx context.Context, d T1) (Entry[T1], uint64, error)
+	ClaimFreeWaitFunc(ctx context.Context, fn func(id uint64) T1) (Entry[T1], uint64, error)
+	ClaimRange(start, size uint64, d T1) (uint64, error)
+	ClaimSize(size uint64, d T1) (Entries[T1], uint64, error)
+	// ClaimContiguous claims the best fitting run of size free ids that starts
+	// on a multiple of align
+	ClaimContiguous(size, align uint64, d T1) (Entries[T1], uint64, error)
+	Release(id uint64) (uint64, error)
+	Update(id uint64, d T1) (uint64, error)
+	// UpdateIf updates the entry when it is still at revision
+	UpdateIf(id, revision uint64, d T1) (uint64, error)
+	// ReleaseIf releases the entry when it is still at revision
+	ReleaseIf(id, revision uint64) (uint64, error)
+	// Replace releases the claimed entry of id and claims id for d in one
+	// change, so the id is not served to a waiter of ClaimFreeWait
+	Replace(id uint64, d T1) (uint64, error)
+	// Revision returns the revision of the table, incremented by every change
+	// of an entry
+	Revision() uint64
 
 	Iterate() *Iterator[T1]
 	IterateFree() *Iterator[T1]
@@ -25,8 +53,26 @@ type Table[T1 any] interface {
 	FindFree() (uint64, error)
 	FindFreeRange(min, size uint64) ([]uint64, error)
 	FindFreeSize(size uint64) ([]uint64, error)
+	// FindContiguous returns the start of the best fitting run of size free ids
+	// for which origin+start is a multiple of align
+	FindContiguous(origin, size, align uint64) (uint64, error)
 
 	GetAll() Entries[T1]
+	// Stats returns the utilization and fragmentation of the table
+	Stats() metrics.Stats
+	// PlanDefrag returns the moves that free a contiguous block of size ids,
+	// with the value of the ownerKey label of the moved entries
+	PlanDefrag(size uint64, ownerKey string) (*Plan, error)
+	// ApplyPlan applies all the moves of the plan or none of them
+	ApplyPlan(plan *Plan, fn func(MoveEvent[T1])) (uint64, error)
+	// SetAuditLog records every claim, update and release made by actor in
+	// the log
+	SetAuditLog(log *audit.Log, actor string)
+	// SetClock sets the clock of the metadata and the audit records of the
+	// changes, e.g. to apply a replicated change at the time of the leader
+	SetClock(clock tree.Clock)
+
+	metrics.Instrumented
 }
 
 func NewTable[T1 any](size uint64) Table[T1] {
@@ -34,6 +80,7 @@ func NewTable[T1 any](size uint64) Table[T1] {
 		m:     new(sync.RWMutex),
 		table: map[uint64]Entry[T1]{},
 		size:  size,
+		free:  newFreeRuns(size),
 	}
 
 	return r
@@ -43,11 +90,25 @@ type table[T1 any] struct {
 	m     *sync.RWMutex
 	table map[uint64]Entry[T1]
 	size  uint64
+	hook  metrics.Hook
+	// free and reserved are maintained on every change of the table
+	free     *freeRuns
+	reserved uint64
+	// waiters are the ClaimFreeWait calls waiting for a free id, in the order
+	// they are served
+	waiters []*waiter[T1]
+	// revision is incremented by every change of an entry; the entries carry
+	// the revision of their last change
+	revision uint64
+	// audit is nil when the changes are not recorded
+	audit *audit.Log
+	actor string
+	clock tree.Clock
 }
 
 func (r *table[T1]) validate(id uint64) error {
 	if id > r.size-1 {
-		return fmt.Errorf("id %d is bigger then max allowed entries: %d", id, r.size-1)
+		return metrics.Errorf(metrics.ReasonOutOfRange, "id %d is bigger then max allowed entries: %d", id, r.size-1)
 	}
 	return nil
 }
@@ -62,37 +123,55 @@ func (r *table[T1]) Get(id uint64) (Entry[T1], error) {
 
 	e, ok := r.table[id]
 	if !ok {
-		return nil, fmt.Errorf("no entry found for: %d", id)
+		return nil, metrics.Errorf(metrics.ReasonNotFound, "no entry found for: %d", id)
 	}
 	return e, nil
 }
 
-func (r *table[T1]) Claim(id uint64, d T1) error {
+func (r *table[T1]) Claim(id uint64, d T1) (uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
-	return r.add(NewEntry(id, d))
+	_, err := r.add(NewEntry(id, d))
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.revision, err
 }
 
-func (r *table[T1]) ClaimDynamic(d T1) (Entry[T1], error) {
+func (r *table[T1]) ClaimEntry(e Entry[T1]) (uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
-	free := r.iterateFree()
-	if free.Next() {
-		e := NewEntry(free.ID(), d)
-		if err := r.add(e); err != nil {
-			return nil, err
-		}
-		return e, nil
+	_, err := r.add(e)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.revision, err
+}
+
+func (r *table[T1]) ClaimDynamic(d T1) (Entry[T1], uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e, err := r.claimDynamic(d)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return e, r.revision, err
+}
+
+func (r *table[T1]) claimDynamic(d T1) (Entry[T1], error) {
+	if id, ok := r.free.first(); ok {
+		return r.add(NewEntry(id, d))
 	}
-	return nil, fmt.Errorf("no free entry found")
+	return nil, metrics.Errorf(metrics.ReasonExhausted, "no free entry found")
 }
 
-func (r *table[T1]) ClaimRange(start, size uint64, d T1) error {
+func (r *table[T1]) ClaimRange(start, size uint64, d T1) (uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
+	err := r.claimRange(start, size, d)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.revision, err
+}
+
+func (r *table[T1]) claimRange(start, size uint64, d T1) error {
 	ids, err := r.findFreeRange(start, size)
 	if err != nil {
 		return err
@@ -100,27 +179,58 @@ func (r *table[T1]) ClaimRange(start, size uint64, d T1) error {
 	for _, id := range ids {
 		id := id
 		// getting an error is unlikely as we have a lock
-		if err := r.add(NewEntry(id, d)); err != nil {
+		if _, err := r.add(NewEntry(id, d)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *table[T1]) ClaimSize(size uint64, d T1) (Entries[T1], error) {
+func (r *table[T1]) ClaimSize(size uint64, d T1) (Entries[T1], uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
+	entries, err := r.claimSize(size, d)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return entries, r.revision, err
+}
+
+func (r *table[T1]) claimSize(size uint64, d T1) (Entries[T1], error) {
 	ids, err := r.findFreeSize(size)
 	if err != nil {
 		return nil, err
 	}
 	entries := Entries[T1]{}
 	for _, id := range ids {
-		id := id
-		e := NewEntry(id, d)
 		// getting an error is unlikely as we have a lock
-		if err := r.add(e); err != nil {
+		e, err := r.add(NewEntry(id, d))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (r *table[T1]) ClaimContiguous(size, align uint64, d T1) (Entries[T1], uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entries, err := r.claimContiguous(size, align, d)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return entries, r.revision, err
+}
+
+func (r *table[T1]) claimContiguous(size, align uint64, d T1) (Entries[T1], error) {
+	start, err := r.findContiguous(0, size, align)
+	if err != nil {
+		return nil, err
+	}
+	entries := Entries[T1]{}
+	for id := start; id < start+size; id++ {
+		// getting an error is unlikely as we have a lock
+		e, err := r.add(NewEntry(id, d))
+		if err != nil {
 			return nil, err
 		}
 		entries = append(entries, e)
@@ -128,18 +238,99 @@ func (r *table[T1]) ClaimSize(size uint64, d T1) (Entries[T1], error) {
 	return entries, nil
 }
 
-func (r *table[T1]) Release(id uint64) error {
+func (r *table[T1]) Release(id uint64) (uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
-	return r.delete(id)
+	err := r.delete(id)
+	if err == nil {
+		r.serve()
+	}
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return r.revision, err
 }
 
-func (r *table[T1]) Update(id uint64, d T1) error {
+func (r *table[T1]) Update(id uint64, d T1) (uint64, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
-	return r.update(NewEntry(id, d))
+	_, err := r.update(NewEntry(id, d))
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return r.revision, err
+}
+
+func (r *table[T1]) UpdateIf(id, revision uint64, d T1) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.checkRevision(id, revision)
+	if err == nil {
+		_, err = r.update(NewEntry(id, d))
+	}
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return r.revision, err
+}
+
+func (r *table[T1]) ReleaseIf(id, revision uint64) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.checkRevision(id, revision)
+	if err == nil {
+		err = r.delete(id)
+	}
+	if err == nil {
+		r.serve()
+	}
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return r.revision, err
+}
+
+func (r *table[T1]) Replace(id uint64, d T1) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.replace(NewEntry(id, d))
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.revision, err
+}
+
+// replace releases the claimed entry of the id of e and claims e, which is
+// stamped as a new entry
+func (r *table[T1]) replace(e Entry[T1]) error {
+	if err := r.validate(e.ID()); err != nil {
+		return err
+	}
+	if r.isFree(e.ID()) {
+		return metrics.Errorf(metrics.ReasonNotFound, "entry %d not created", e.ID())
+	}
+	if err := r.delete(e.ID()); err != nil {
+		return err
+	}
+	_, err := r.add(e)
+	return err
+}
+
+func (r *table[T1]) Revision() uint64 {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.revision
+}
+
+// checkRevision returns an error when the entry is not claimed or was changed
+// after revision
+func (r *table[T1]) checkRevision(id, revision uint64) error {
+	if err := r.validate(id); err != nil {
+		return err
+	}
+	e, ok := r.table[id]
+	if !ok {
+		return metrics.Errorf(metrics.ReasonNotFound, "no entry found for: %d", id)
+	}
+	if e.Revision() != revision {
+		return metrics.Errorf(metrics.ReasonRevisionMismatch, "entry %d is at revision %d, expected %d", id, e.Revision(), revision)
+	}
+	return nil
 }
 
 func (r *table[T1]) Iterate() *Iterator[T1] {
@@ -214,12 +405,13 @@ func (r *table[T1]) isFree(id uint64) bool {
 }
 
 func (r *table[T1]) FindFree() (uint64, error) {
-	free := r.IterateFree()
+	r.m.RLock()
+	defer r.m.RUnlock()
 
-	if free.Next() {
-		return free.ID(), nil
+	if id, ok := r.free.first(); ok {
+		return id, nil
 	}
-	return 0, fmt.Errorf("no free entry found")
+	return 0, metrics.Errorf(metrics.ReasonExhausted, "no free entry found")
 }
 
 func (r *table[T1]) FindFreeRange(start, size uint64) ([]uint64, error) {
@@ -232,10 +424,10 @@ func (r *table[T1]) findFreeRange(start, size uint64) ([]uint64, error) {
 	end := start + size - 1
 
 	if start > r.size-1 {
-		return nil, fmt.Errorf("start %d is bigger then max allowed entries: %d", start, r.size)
+		return nil, metrics.Errorf(metrics.ReasonOutOfRange, "start %d is bigger then max allowed entries: %d", start, r.size)
 	}
 	if end > r.size-1 {
-		return nil, fmt.Errorf("end %d is bigger then max allowed entries: %d", end, r.size)
+		return nil, metrics.Errorf(metrics.ReasonOutOfRange, "end %d is bigger then max allowed entries: %d", end, r.size)
 	}
 
 	entries := []uint64{}
@@ -249,7 +441,7 @@ func (r *table[T1]) findFreeRange(start, size uint64) ([]uint64, error) {
 			entries = append(entries, free.ID())
 		case free.ID() > start && free.ID() < end:
 			if !free.IsConsecutive() {
-				return nil, fmt.Errorf("entry %d in use in range: start: %d, end %d", free.ID(), start, end)
+				return nil, metrics.Errorf(metrics.ReasonClaimed, "entry %d in use in range: start: %d, end %d", free.ID(), start, end)
 			}
 			entries = append(entries, free.ID())
 		default:
@@ -257,7 +449,7 @@ func (r *table[T1]) findFreeRange(start, size uint64) ([]uint64, error) {
 			return entries, nil
 		}
 	}
-	return nil, fmt.Errorf("could not find free range that fit in start %d, size %d", start, size)
+	return nil, metrics.Errorf(metrics.ReasonExhausted, "could not find free range that fit in start %d, size %d", start, size)
 }
 
 func (r *table[T1]) FindFreeSize(size uint64) ([]uint64, error) {
@@ -268,7 +460,7 @@ func (r *table[T1]) FindFreeSize(size uint64) ([]uint64, error) {
 
 func (r *table[T1]) findFreeSize(size uint64) ([]uint64, error) {
 	if size > r.size {
-		return nil, fmt.Errorf("size %d is bigger then max allowed entries: %d", size, r.size)
+		return nil, metrics.Errorf(metrics.ReasonExhausted, "size %d is bigger then max allowed entries: %d", size, r.size)
 	}
 	entries := []uint64{}
 	free := r.iterateFree()
@@ -280,39 +472,151 @@ func (r *table[T1]) findFreeSize(size uint64) ([]uint64, error) {
 			return entries, nil
 		}
 	}
-	return nil, fmt.Errorf("could not find free entries that fit in size %d", size)
+	return nil, metrics.Errorf(metrics.ReasonExhausted, "could not find free entries that fit in size %d", size)
+}
+
+func (r *table[T1]) FindContiguous(origin, size, align uint64) (uint64, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.findContiguous(origin, size, align)
+}
+
+// findContiguous returns the start of the smallest free run that fits size ids
+// from a multiple of align, and the lowest one when several runs fit. An
+// align of 0 or 1 does not constrain the start.
+func (r *table[T1]) findContiguous(origin, size, align uint64) (uint64, error) {
+	if size == 0 || size > r.size {
+		return 0, metrics.Errorf(metrics.ReasonOutOfRange, "size %d must be between 1 and %d", size, r.size)
+	}
+	if align == 0 {
+		align = 1
+	}
+
+	var bestStart, bestSize uint64
+	found := false
+	for _, free := range r.free.runs {
+		if found && free.size() >= bestSize {
+			continue
+		}
+		start := free.from
+		if rem := (origin%align + start%align) % align; rem != 0 {
+			if start += align - rem; start < free.from {
+				// overflow
+				continue
+			}
+		}
+		if start > free.to || free.to-start+1 < size {
+			continue
+		}
+		bestStart, bestSize, found = start, free.size(), true
+	}
+	if !found {
+		return 0, metrics.Errorf(metrics.ReasonExhausted, "could not find %d contiguous free entries aligned to %d", size, align)
+	}
+	return bestStart, nil
 }
 
-func (r *table[T1]) add(e Entry[T1]) error {
+// add claims the entry and returns it with the new revision of the table
+func (r *table[T1]) add(e Entry[T1]) (Entry[T1], error) {
 	if err := r.validate(e.ID()); err != nil {
-		return err
+		return nil, err
 	}
 	if !r.isFree(e.ID()) {
-		return fmt.Errorf("entry %d already exists", e.ID())
+		return nil, metrics.Errorf(metrics.ReasonClaimed, "entry %d already exists", e.ID())
 	}
+	e = r.stamp(e, nil)
 	r.table[e.ID()] = e
-	return nil
+	r.free.claim(e.ID())
+	if isReserved(e.Data()) {
+		r.reserved++
+	}
+	r.record(metrics.OperationClaim, e.ID(), nil, e)
+	return e, nil
 }
 
-func (r *table[T1]) update(e Entry[T1]) error {
+// update replaces the entry and returns it with the new revision of the table
+func (r *table[T1]) update(e Entry[T1]) (Entry[T1], error) {
 	if err := r.validate(e.ID()); err != nil {
-		return err
+		return nil, err
 	}
 	if r.isFree(e.ID()) {
-		return fmt.Errorf("entry %d not created", e.ID())
+		return nil, metrics.Errorf(metrics.ReasonNotFound, "entry %d not created", e.ID())
+	}
+	old := r.table[e.ID()]
+	if isReserved(old.Data()) {
+		r.reserved--
 	}
+	if isReserved(e.Data()) {
+		r.reserved++
+	}
+	e = r.stamp(e, old)
 	r.table[e.ID()] = e
-	return nil
+	r.record(metrics.OperationUpdate, e.ID(), old, e)
+	return e, nil
 }
 
 func (r *table[T1]) delete(id uint64) error {
 	if err := r.validate(id); err != nil {
 		return err
 	}
+	old, ok := r.table[id]
+	if !ok {
+		return nil
+	}
+	if isReserved(old.Data()) {
+		r.reserved--
+	}
 	delete(r.table, id)
+	r.free.release(id)
+	r.revision++
+	r.record(metrics.OperationRelease, id, old, nil)
 	return nil
 }
 
+// record adds the change of the entry of id from before to after to the
+// audit log, with the labels of the data of the entries, if any
+func (r *table[T1]) record(op metrics.Operation, id uint64, before, after Entry[T1]) {
+	if r.audit == nil {
+		return
+	}
+	rec := audit.Record{Time: r.clock.Now(), Op: op, ID: strconv.FormatUint(id, 10), Actor: r.actor}
+	if before != nil {
+		rec.Before = labelsOf(before.Data())
+	}
+	if after != nil {
+		rec.After = labelsOf(after.Data())
+	}
+	r.audit.Append(rec)
+}
+
+// stamp returns the entry with the next revision of the table, changed now;
+// old is the entry it replaces, if any. An entry with a creation time, e.g. a
+// moved entry, keeps it; a claimed entry takes the metadata of its data when
+// set, e.g. an entry restored with tree.WithMeta.
+func (r *table[T1]) stamp(e Entry[T1], old Entry[T1]) Entry[T1] {
+	r.revision++
+	now := r.clock.Now()
+	stamped := entry[T1]{
+		id:         e.ID(),
+		data:       e.Data(),
+		revision:   r.revision,
+		created:    e.Created(),
+		updated:    e.Updated(),
+		generation: e.Generation(),
+	}
+	meta := metaOf(e.Data())
+	switch {
+	case !stamped.created.IsZero():
+	case old != nil:
+		stamped.created, stamped.updated, stamped.generation = old.Created(), now, old.Generation()+1
+	case !meta.Created.IsZero():
+		stamped.created, stamped.updated, stamped.generation = meta.Created, meta.Updated, meta.Generation
+	default:
+		stamped.created, stamped.updated, stamped.generation = now, now, 1
+	}
+	return stamped
+}
+
 func (r *table[T1]) GetAll() Entries[T1] {
 	r.m.RLock()
 	defer r.m.RUnlock()
@@ -325,3 +629,70 @@ func (r *table[T1]) GetAll() Entries[T1] {
 	}
 	return entries
 }
+
+func (r *table[T1]) SetMetricsHook(hook metrics.Hook) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.hook = hook
+}
+
+func (r *table[T1]) SetAuditLog(log *audit.Log, actor string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.audit, r.actor = log, actor
+}
+
+func (r *table[T1]) SetClock(clock tree.Clock) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.clock = clock
+}
+
+// Usage returns the utilization of the table. Entries whose data has labels
+// with the tree.ReservedLabelKey are counted as reserved.
+func (r *table[T1]) Usage() metrics.Usage {
+	stats := r.Stats()
+
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return metrics.Usage{
+		Capacity:         stats.Capacity,
+		Claimed:          stats.Claimed,
+		Free:             stats.Free,
+		Reserved:         r.reserved,
+		LargestFreeBlock: stats.LargestFreeRun,
+	}
+}
+
+func (r *table[T1]) Stats() metrics.Stats {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	stats := metrics.Stats{
+		Capacity: r.size,
+		Claimed:  uint64(len(r.table)),
+		Free:     r.size - uint64(len(r.table)),
+	}
+	r.free.stats(&stats)
+	return stats
+}
+
+func isReserved(d any) bool {
+	return labelsOf(d).Has(tree.ReservedLabelKey)
+}
+
+// metaOf returns the metadata of the data d, the zero Meta when d has none
+func metaOf(d any) tree.Meta {
+	if m, ok := d.(interface{ Meta() tree.Meta }); ok {
+		return m.Meta()
+	}
+	return tree.Meta{}
+}
+
+// labelsOf returns the labels of the data d, nil when d has none
+func labelsOf(d any) labels.Set {
+	if l, ok := d.(interface{ Labels() labels.Set }); ok {
+		return l.Labels()
+	}
+	return nil
+}
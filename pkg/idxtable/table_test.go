@@ -1,10 +1,17 @@
 package idxtable
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func TestNewTable(t *testing.T) {
@@ -54,12 +61,12 @@ func TestClaim(t *testing.T) {
 			r := NewTable[string](tc.size)
 
 			for id, d := range tc.newSuccessEntries {
-				err := r.Claim(id, d)
+				_, err := r.Claim(id, d)
 				assert.NoError(t, err)
 
 			}
 			for id, d := range tc.newFailedEntries {
-				err := r.Claim(id, d)
+				_, err := r.Claim(id, d)
 				assert.Error(t, err)
 			}
 			// check table
@@ -106,17 +113,17 @@ func TestRelease(t *testing.T) {
 			r := NewTable[string](tc.size)
 
 			for id, d := range tc.newSuccessEntries {
-				err := r.Claim(id, d)
+				_, err := r.Claim(id, d)
 				assert.NoError(t, err)
 
 			}
 			// delete entries
 			for _, id := range tc.deleteSuccessEntries {
-				err := r.Release(id)
+				_, err := r.Release(id)
 				assert.NoError(t, err)
 			}
 			for _, id := range tc.deleteFailedEntries {
-				err := r.Release(id)
+				_, err := r.Release(id)
 				assert.NoError(t, err)
 			}
 			for id := range tc.newSuccessEntries {
@@ -176,7 +183,7 @@ func TestIterate(t *testing.T) {
 			r := NewTable[string](tc.size)
 
 			for id, d := range tc.newSuccessEntries {
-				err := r.Claim(id, d)
+				_, err := r.Claim(id, d)
 				assert.NoError(t, err)
 			}
 
@@ -229,11 +236,11 @@ func TestClaimRange(t *testing.T) {
 			r := NewTable[string](tc.total)
 
 			for id, d := range tc.newSuccessEntries {
-				err := r.Claim(id, d)
+				_, err := r.Claim(id, d)
 				assert.NoError(t, err)
 			}
 
-			err := r.ClaimRange(tc.start, tc.size, "a")
+			_, err := r.ClaimRange(tc.start, tc.size, "a")
 			if tc.expectedErr {
 				assert.Error(t, err)
 				return
@@ -277,7 +284,7 @@ func TestClaimSize(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			r := NewTable[string](tc.size)
 
-			_, err := r.ClaimSize(tc.total, "a")
+			_, _, err := r.ClaimSize(tc.total, "a")
 			if tc.expectedErr {
 				assert.Error(t, err)
 				return
@@ -290,3 +297,470 @@ func TestClaimSize(t *testing.T) {
 		})
 	}
 }
+
+func TestDiffMerge(t *testing.T) {
+	cases := map[string]struct {
+		a               map[uint64]string
+		b               map[uint64]string
+		sizeA           uint64
+		policy          ConflictPolicy
+		expectedAdded   []uint64
+		expectedRemoved []uint64
+		expectedChanged []uint64
+		expectedMerged  map[uint64]string
+		expectedErr     bool
+	}{
+		"Keep": {
+			a:               map[uint64]string{1: "a", 2: "b", 5: "c"},
+			b:               map[uint64]string{2: "x", 3: "d", 5: "c"},
+			policy:          ConflictPolicyKeep,
+			expectedAdded:   []uint64{3},
+			expectedRemoved: []uint64{1},
+			expectedChanged: []uint64{2},
+			expectedMerged:  map[uint64]string{1: "a", 2: "b", 3: "d", 5: "c"},
+		},
+		"Overwrite": {
+			a:               map[uint64]string{1: "a", 2: "b"},
+			b:               map[uint64]string{2: "x", 999: "d"},
+			policy:          ConflictPolicyOverwrite,
+			expectedAdded:   []uint64{999},
+			expectedRemoved: []uint64{1},
+			expectedChanged: []uint64{2},
+			expectedMerged:  map[uint64]string{1: "a", 2: "x", 999: "d"},
+		},
+		"Fail": {
+			a:               map[uint64]string{2: "b"},
+			b:               map[uint64]string{2: "x", 3: "d"},
+			policy:          ConflictPolicyFail,
+			expectedAdded:   []uint64{3},
+			expectedRemoved: []uint64{},
+			expectedChanged: []uint64{2},
+			expectedMerged:  map[uint64]string{2: "b"},
+			expectedErr:     true,
+		},
+		"Reverted": {
+			a:               map[uint64]string{1: "a"},
+			b:               map[uint64]string{2: "x", 50: "d"},
+			sizeA:           10,
+			policy:          ConflictPolicyOverwrite,
+			expectedAdded:   []uint64{2, 50},
+			expectedRemoved: []uint64{1},
+			expectedChanged: []uint64{},
+			expectedMerged:  map[uint64]string{1: "a"},
+			expectedErr:     true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if tc.sizeA == 0 {
+				tc.sizeA = 1000
+			}
+			a := NewTable[string](tc.sizeA)
+			for id, d := range tc.a {
+				assert.NoError(t, errOf(a.Claim(id, d)))
+			}
+			b := NewTable[string](1000)
+			for id, d := range tc.b {
+				assert.NoError(t, errOf(b.Claim(id, d)))
+			}
+			equal := func(x, y string) bool { return x == y }
+
+			diff := Diff(a, b, equal)
+			ids := func(entries Entries[string]) []uint64 {
+				out := []uint64{}
+				for _, e := range entries {
+					out = append(out, e.ID())
+				}
+				return out
+			}
+			changed := []uint64{}
+			for _, c := range diff.Changed {
+				changed = append(changed, c.Old.ID())
+			}
+			assert.Equal(t, tc.expectedAdded, ids(diff.Added))
+			assert.Equal(t, tc.expectedRemoved, ids(diff.Removed))
+			assert.Equal(t, tc.expectedChanged, changed)
+
+			err := Merge(a, b, tc.policy, equal)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			merged := map[uint64]string{}
+			for _, e := range a.GetAll() {
+				merged[e.ID()] = e.Data()
+			}
+			if diff := cmp.Diff(tc.expectedMerged, merged); diff != "" {
+				t.Errorf("%s: -want, +got:\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	cases := map[string]struct {
+		total         uint64
+		claim         []uint64
+		release       []uint64
+		expectedStats metrics.Stats
+	}{
+		"Empty": {
+			total: 16,
+			expectedStats: metrics.Stats{
+				Capacity: 16, Free: 16,
+				FreeRuns: 1, LargestFreeRun: 16,
+				RunSizes: []uint64{0, 0, 0, 0, 1},
+			},
+		},
+		"Fragmented": {
+			total:   16,
+			claim:   []uint64{3, 4, 10},
+			release: []uint64{4},
+			expectedStats: metrics.Stats{
+				Capacity: 16, Claimed: 2, Free: 14,
+				FreeRuns: 3, LargestFreeRun: 6,
+				RunSizes: []uint64{0, 1, 2},
+			},
+		},
+		"Full": {
+			total:   2,
+			claim:   []uint64{1, 0},
+			release: []uint64{5},
+			expectedStats: metrics.Stats{
+				Capacity: 2, Claimed: 2,
+				RunSizes: []uint64{},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := NewTable[string](tc.total)
+			for _, id := range tc.claim {
+				assert.NoError(t, errOf(r.Claim(id, "a")))
+			}
+			for _, id := range tc.release {
+				r.Release(id)
+			}
+			assert.Equal(t, tc.expectedStats, r.Stats())
+
+			id, err := r.FindFree()
+			if tc.expectedStats.Free == 0 {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, r.IsFree(id))
+		})
+	}
+}
+
+func TestDefrag(t *testing.T) {
+	cases := map[string]struct {
+		claim          map[uint64]string
+		size           uint64
+		expectedPlan   *Plan
+		expectedErr    bool
+		claimAfterPlan uint64
+	}{
+		"Normal": {
+			claim: map[uint64]string{2: "a", 5: "b", 9: "c", 13: "d"},
+			size:  8,
+			expectedPlan: &Plan{Start: 0, Size: 8, Moves: []Move{
+				{From: 2, To: 8},
+				{From: 5, To: 10},
+			}},
+		},
+		"NoMoves": {
+			claim:        map[uint64]string{15: "a"},
+			size:         8,
+			expectedPlan: &Plan{Start: 0, Size: 8, Moves: []Move{}},
+		},
+		"NotEnoughFree": {
+			claim:       map[uint64]string{2: "a", 5: "b", 9: "c", 13: "d"},
+			size:        13,
+			expectedErr: true,
+		},
+		"StalePlan": {
+			claim: map[uint64]string{2: "a", 5: "b", 9: "c", 13: "d"},
+			size:  8,
+			expectedPlan: &Plan{Start: 0, Size: 8, Moves: []Move{
+				{From: 2, To: 8},
+				{From: 5, To: 10},
+			}},
+			claimAfterPlan: 10,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := NewTable[string](16)
+			for id, d := range tc.claim {
+				assert.NoError(t, errOf(r.Claim(id, d)))
+			}
+
+			plan, err := r.PlanDefrag(tc.size, "")
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPlan, plan)
+
+			if tc.claimAfterPlan != 0 {
+				assert.NoError(t, errOf(r.Claim(tc.claimAfterPlan, "x")))
+				assert.Error(t, errOf(r.ApplyPlan(plan, nil)))
+				for id, d := range tc.claim {
+					e, err := r.Get(id)
+					assert.NoError(t, err)
+					assert.Equal(t, d, e.Data())
+				}
+				return
+			}
+
+			events := []MoveEvent[string]{}
+			assert.NoError(t, errOf(r.ApplyPlan(plan, func(e MoveEvent[string]) {
+				events = append(events, e)
+			})))
+			assert.Equal(t, len(plan.Moves), len(events))
+			for _, e := range events {
+				assert.True(t, r.IsFree(e.From))
+				assert.Equal(t, tc.claim[e.From], e.Entry.Data())
+			}
+			assert.Equal(t, len(tc.claim), r.Size())
+			_, err = r.FindFreeRange(plan.Start, plan.Size)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestDefragOwner(t *testing.T) {
+	r := NewTable[tree.Entry](8)
+	for id, owner := range map[uint64]string{1: "a", 3: "b", 5: "a", 6: "b"} {
+		assert.NoError(t, errOf(r.Claim(id, tree.NewEntry(id32.NewID(uint32(id), 32), labels.Set{"owner": owner}))))
+	}
+
+	plan, err := r.PlanDefrag(4, "owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "owner", plan.OwnerKey)
+	assert.Equal(t, map[string][]Move{
+		"a": {{From: 1, To: 4, Owner: "a"}},
+		"b": {{From: 3, To: 7, Owner: "b"}},
+	}, plan.ByOwner())
+}
+
+func TestClaimContiguous(t *testing.T) {
+	cases := map[string]struct {
+		size          uint64
+		align         uint64
+		expectedStart uint64
+		expectedErr   bool
+	}{
+		"SmallestRun": {
+			size:          8,
+			expectedStart: 21,
+		},
+		"SmallestAlignedRun": {
+			size:          8,
+			align:         8,
+			expectedStart: 24,
+		},
+		"Aligned": {
+			size:          12,
+			align:         4,
+			expectedStart: 8,
+		},
+		"Exact": {
+			size:          5,
+			expectedStart: 0,
+		},
+		"NoFit": {
+			size:        13,
+			align:       4,
+			expectedErr: true,
+		},
+		"Zero": {
+			size:        0,
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			// free runs are 0-4, 6-19 and 21-31
+			r := NewTable[string](32)
+			assert.NoError(t, errOf(r.Claim(5, "a")))
+			assert.NoError(t, errOf(r.Claim(20, "a")))
+
+			entries, _, err := r.ClaimContiguous(tc.size, tc.align, "b")
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.Equal(t, 2, r.Size())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, int(tc.size), len(entries))
+			for i, e := range entries {
+				assert.Equal(t, tc.expectedStart+uint64(i), e.ID())
+				assert.Equal(t, "b", e.Data())
+			}
+			assert.Equal(t, int(tc.size)+2, r.Size())
+		})
+	}
+}
+
+func TestClaimFreeWait(t *testing.T) {
+	r := NewTable[string](1)
+	e, _, err := r.ClaimFreeWait(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), e.ID())
+
+	// waiting stops with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = r.ClaimFreeWait(ctx, "b")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the waiters are served by priority, and in order for the same priority
+	claimed := make(chan string, 3)
+	wait := func(ctx context.Context, d string) {
+		waiters := len(r.(*table[string]).waiters)
+		go func() {
+			e, _, err := r.ClaimFreeWait(ctx, d)
+			assert.NoError(t, err)
+			claimed <- e.Data()
+		}()
+		assert.Eventually(t, func() bool {
+			r.(*table[string]).m.RLock()
+			defer r.(*table[string]).m.RUnlock()
+			return len(r.(*table[string]).waiters) == waiters+1
+		}, time.Second, time.Millisecond)
+	}
+	wait(context.Background(), "b")
+	wait(context.Background(), "c")
+	wait(WithPriority(context.Background(), 10), "d")
+
+	for _, expected := range []string{"d", "b", "c"} {
+		assert.NoError(t, errOf(r.Release(0)))
+		assert.Equal(t, expected, <-claimed)
+		e, err := r.Get(0)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, e.Data())
+	}
+}
+
+// errOf returns the error of a mutation without the revision of the table
+func errOf(_ uint64, err error) error {
+	return err
+}
+
+func TestRevision(t *testing.T) {
+	table := NewTable[int](10)
+	rev, err := table.Claim(1, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), rev)
+	e, rev, err := table.ClaimDynamic(200)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), e.Revision())
+	assert.Equal(t, uint64(2), rev)
+	e1, err := table.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), e1.Revision())
+
+	rev, err = table.UpdateIf(1, 2, 101)
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	assert.Equal(t, uint64(2), rev)
+	rev, err = table.UpdateIf(1, 1, 101)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), rev)
+	e1, err = table.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 101, e1.Data())
+	assert.Equal(t, uint64(3), e1.Revision())
+
+	_, err = table.ReleaseIf(1, 1)
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	rev, err = table.ReleaseIf(1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), rev)
+	assert.True(t, table.IsFree(1))
+	_, err = table.ReleaseIf(1, 3)
+	assert.Equal(t, metrics.ReasonNotFound, metrics.ReasonOf(err))
+
+	rev, err = table.Release(e.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), rev)
+	assert.Equal(t, uint64(5), table.Revision())
+
+	// a failed mutation returns the unchanged revision
+	rev, err = table.Update(1, 102)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(5), rev)
+	rev, err = table.ClaimRange(0, 3, 300)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), rev)
+	entries, rev, err := table.ClaimSize(2, 400)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), rev)
+	assert.Equal(t, rev, entries[len(entries)-1].Revision())
+	entries, rev, err = table.ClaimContiguous(2, 2, 500)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12), rev)
+	assert.Equal(t, rev, entries[len(entries)-1].Revision())
+	e, rev, err = table.ClaimFreeWait(context.Background(), 600)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(13), rev)
+	assert.Equal(t, rev, e.Revision())
+	rev, err = table.ApplyPlan(&Plan{Start: 9, Size: 1, Moves: []Move{{From: e.ID(), To: 9}}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(15), rev)
+}
+
+func TestMeta(t *testing.T) {
+	table := NewTable[int](10)
+	before := time.Now()
+	assert.NoError(t, errOf(table.Claim(1, 100)))
+	claimed, err := table.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), claimed.Generation())
+	assert.False(t, claimed.Created().Before(before))
+	assert.Equal(t, claimed.Created(), claimed.Updated())
+
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, errOf(table.Update(1, 101)))
+	updated, err := table.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), updated.Generation())
+	assert.Equal(t, claimed.Created(), updated.Created())
+	assert.True(t, updated.Updated().After(claimed.Updated()))
+
+	// a moved entry keeps its metadata
+	assert.NoError(t, errOf(table.ApplyPlan(&Plan{Start: 1, Size: 1, Moves: []Move{{From: 1, To: 5}}}, nil)))
+	moved, err := table.Get(5)
+	assert.NoError(t, err)
+	assert.Equal(t, updated.Created(), moved.Created())
+	assert.Equal(t, updated.Updated(), moved.Updated())
+	assert.Equal(t, uint64(2), moved.Generation())
+}
+
+func TestAudit(t *testing.T) {
+	log, err := audit.New(2)
+	assert.NoError(t, err)
+	table := NewTable[tree.Entry](10)
+	table.SetAuditLog(log, "controller-a")
+	assert.NoError(t, errOf(table.Claim(1, tree.NewEntry(id32.NewID(1, 32), labels.Set{"owner": "a"}))))
+	assert.NoError(t, errOf(table.Update(1, tree.NewEntry(id32.NewID(1, 32), labels.Set{"owner": "b"}))))
+	assert.NoError(t, errOf(table.Release(1)))
+
+	// the log holds the last records
+	records := log.Records()
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, metrics.OperationUpdate, records[0].Op)
+	assert.Equal(t, labels.Set{"owner": "a"}, records[0].Before)
+	assert.Equal(t, labels.Set{"owner": "b"}, records[0].After)
+	assert.Equal(t, metrics.OperationRelease, records[1].Op)
+	assert.Equal(t, "1", records[1].ID)
+	assert.Equal(t, "controller-a", records[1].Actor)
+}
@@ -0,0 +1,131 @@
+package idxtable
+
+import (
+	"context"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context for ClaimFreeWait with the priority of the
+// waiter. Waiters with a higher priority are served first, waiters with the
+// same priority in the order they started to wait; the default priority is 0.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityOf(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// waiter is a ClaimFreeWait call that waits for a free id
+type waiter[T1 any] struct {
+	priority int
+	data     func(id uint64) T1
+	// claimed receives the entry claimed for the waiter
+	claimed chan Entry[T1]
+}
+
+// ClaimFreeWait claims the first free id like ClaimDynamic. When the table is
+// exhausted it waits until an id is released, which is claimed directly by
+// Release for the first waiter, or until ctx is done.
+func (r *table[T1]) ClaimFreeWait(ctx context.Context, d T1) (Entry[T1], uint64, error) {
+	return r.ClaimFreeWaitFunc(ctx, func(uint64) T1 { return d })
+}
+
+// ClaimFreeWaitFunc is ClaimFreeWait for the entries with data that depends on
+// the claimed id; fn is called with the lock of the table held.
+func (r *table[T1]) ClaimFreeWaitFunc(ctx context.Context, fn func(id uint64) T1) (Entry[T1], uint64, error) {
+	e, err := r.claimFreeWait(ctx, fn)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	if err != nil {
+		return nil, r.Revision(), err
+	}
+	// the entry is claimed at the revision of the table after the claim
+	return e, e.Revision(), nil
+}
+
+func (r *table[T1]) claimFreeWait(ctx context.Context, fn func(id uint64) T1) (Entry[T1], error) {
+	r.m.Lock()
+	// the free ids go to the waiters first
+	if len(r.waiters) == 0 {
+		if id, ok := r.free.first(); ok {
+			e, err := r.add(NewEntry(id, fn(id)))
+			r.m.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return e, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		r.m.Unlock()
+		return nil, err
+	}
+	w := &waiter[T1]{
+		priority: priorityOf(ctx),
+		data:     fn,
+		claimed:  make(chan Entry[T1], 1),
+	}
+	r.enqueue(w)
+	r.m.Unlock()
+
+	select {
+	case e := <-w.claimed:
+		return e, nil
+	case <-ctx.Done():
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.dequeue(w) {
+		return nil, ctx.Err()
+	}
+	// the waiter was served while ctx was done; the id goes to the next waiter
+	e := <-w.claimed
+	r.delete(e.ID())
+	r.serve()
+	return nil, ctx.Err()
+}
+
+// enqueue adds w after the waiters with the same or a higher priority
+func (r *table[T1]) enqueue(w *waiter[T1]) {
+	i := len(r.waiters)
+	for i > 0 && r.waiters[i-1].priority < w.priority {
+		i--
+	}
+	r.waiters = append(r.waiters, nil)
+	copy(r.waiters[i+1:], r.waiters[i:])
+	r.waiters[i] = w
+}
+
+// dequeue removes w from the waiters; it returns false when w was served
+func (r *table[T1]) dequeue(w *waiter[T1]) bool {
+	for i := range r.waiters {
+		if r.waiters[i] == w {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// serve claims the free ids for the waiters in order
+func (r *table[T1]) serve() {
+	for len(r.waiters) > 0 {
+		id, ok := r.free.first()
+		if !ok {
+			return
+		}
+		w := r.waiters[0]
+		// getting an error is unlikely as we have a lock
+		e, err := r.add(NewEntry(id, w.data(id)))
+		if err != nil {
+			return
+		}
+		r.waiters = r.waiters[1:]
+		w.claimed <- e
+	}
+}
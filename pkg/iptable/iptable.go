@@ -1,19 +1,32 @@
 package iptable
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"net/netip"
+	"sync"
 
 	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/audit"
 	"github.com/henderiw/idxtable/pkg/idxtable"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
 	"go4.org/netipx"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
 type IPTable interface {
 	Get(addr string) (table.Route, error)
+	// Meta returns the metadata of the route claimed for addr
+	Meta(addr string) (tree.Meta, error)
 	Claim(addr string, d table.Route) error
+	// ClaimWithMeta claims addr with the metadata of a route that is
+	// restored, e.g. from a snapshot
+	ClaimWithMeta(addr string, d table.Route, meta tree.Meta) error
 	Release(addr string) error
 	Update(addr string, d table.Route) error
 
@@ -22,13 +35,47 @@ type IPTable interface {
 
 	IsFree(addr string) bool
 	FindFree() (netip.Addr, error)
+	// ClaimFreeWait claims a free address, waiting for a release when the
+	// table is exhausted
+	ClaimFreeWait(ctx context.Context, labels labels.Set) (table.Route, error)
 
 	GetAll() table.Routes
 	GetByLabel(selector labels.Selector) table.Routes
+	// Reconcile loads the desired claims in the table. When claims hold the same
+	// address the oldest one wins; routes that are not claimed are reported as
+	// stale and left in the table.
+	Reconcile(desired []ClaimSpec) (*ReconcileReport, error)
+	// Stats returns the utilization and fragmentation of the table
+	Stats() metrics.Stats
+	// SetQuotas replaces the quota rules, which are enforced on every claim
+	SetQuotas(rules []quota.Rule) error
+	// QuotaUsage returns the usage versus the limit of every quota rule
+	QuotaUsage() []quota.Usage
+	// SetStorage loads the records of the storage in the table and writes
+	// every following change through to it
+	SetStorage(s storage.Storage) error
+	// SetAuditLog records every claim, update and release made by actor in
+	// the log
+	SetAuditLog(log *audit.Log, actor string)
+	// SetClock sets the clock of the metadata and the audit records of the
+	// changes, e.g. to apply a replicated change at the time of the leader
+	SetClock(clock tree.Clock)
+
+	metrics.Instrumented
+}
+
+// ClaimSpec is a claim of a single address loaded by Reconcile
+type ClaimSpec struct {
+	reconcile.Meta
+	Addr   string
+	Labels labels.Set
 }
 
+type ReconcileReport = reconcile.Report[ClaimSpec, table.Route]
+
 func New(from, to netip.Addr) IPTable {
 	return &ipTable{
+		m: new(sync.RWMutex),
 		table: idxtable.NewTable[table.Route](
 			uint64(numIPs(from, to)),
 		),
@@ -37,11 +84,30 @@ func New(from, to netip.Addr) IPTable {
 }
 
 type ipTable struct {
+	// m serializes the changes, which check the table and the quotas and
+	// write the table, the storage and the audit log
+	m       *sync.RWMutex
 	table   idxtable.Table[table.Route]
 	ipRange netipx.IPRange
+	hook    metrics.Hook
+	// quotas is nil when no quota rules are set
+	quotas *quota.Quotas
+	// storage is nil when the table is not persisted
+	storage storage.Storage
+	// audit is nil when the changes are not recorded
+	audit *audit.Log
+	actor string
+	clock tree.Clock
 }
 
 func (r *ipTable) Get(addr string) (table.Route, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.get(addr)
+}
+
+func (r *ipTable) get(addr string) (table.Route, error) {
 	var route table.Route
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
@@ -56,7 +122,46 @@ func (r *ipTable) Get(addr string) (table.Route, error) {
 	return e.Data(), nil
 }
 
+func (r *ipTable) Meta(addr string) (tree.Meta, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	claimIP, err := r.validateIP(addr)
+	if err != nil {
+		return tree.Meta{}, err
+	}
+	id := calculateIndex(claimIP, r.ipRange.From())
+	if !r.table.Has(id) {
+		return tree.Meta{}, metrics.Errorf(metrics.ReasonNotFound, "ip %s is not claimed", addr)
+	}
+	e, err := r.table.Get(id)
+	if err != nil {
+		return tree.Meta{}, err
+	}
+	return metaOf(e), nil
+}
+
 func (r *ipTable) Claim(addr string, d table.Route) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.claim(addr, d, tree.Meta{})
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return err
+}
+
+func (r *ipTable) ClaimWithMeta(addr string, d table.Route, meta tree.Meta) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.claim(addr, d, meta)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return err
+}
+
+// claim claims addr for the route d; the route keeps meta when it has a
+// creation time
+func (r *ipTable) claim(addr string, d table.Route, meta tree.Meta) error {
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
 	if err != nil {
@@ -64,39 +169,109 @@ func (r *ipTable) Claim(addr string, d table.Route) error {
 	}
 	id := calculateIndex(claimIP, r.ipRange.From())
 	if !r.table.IsFree(id) {
-		return fmt.Errorf("claim failed ip %s already claimed", addr)
+		return metrics.Errorf(metrics.ReasonClaimed, "claim failed ip %s already claimed", addr)
+	}
+	if err := r.quotas.Claim(d.Labels(), 1); err != nil {
+		return err
 	}
-	return r.table.Claim(id, d)
+	if _, err := r.table.ClaimEntry(idxtable.WithMeta(idxtable.NewEntry(id, d), meta)); err != nil {
+		r.quotas.Release(d.Labels(), 1)
+		return err
+	}
+	if err := r.write(id); err != nil {
+		r.table.Release(id)
+		r.quotas.Release(d.Labels(), 1)
+		return err
+	}
+	r.record(metrics.OperationClaim, claimIP, nil, d.Labels())
+	return nil
 }
 
 func (r *ipTable) Release(addr string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.release(addr)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return err
+}
+
+func (r *ipTable) release(addr string) error {
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
 	if err != nil {
 		return err
 	}
 	id := calculateIndex(claimIP, r.ipRange.From())
-	return r.table.Release(id)
+	e, err := r.table.Get(id)
+	if err != nil {
+		// releasing a free address is not an error
+		_, err := r.table.Release(id)
+		return err
+	}
+	if err := r.erase(claimIP); err != nil {
+		return err
+	}
+	if _, err := r.table.Release(id); err != nil {
+		r.write(id)
+		return err
+	}
+	r.quotas.Release(e.Data().Labels(), 1)
+	r.record(metrics.OperationRelease, claimIP, e.Data().Labels(), nil)
+	return nil
 }
 
 func (r *ipTable) Update(addr string, d table.Route) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.update(addr, d)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return err
+}
+
+func (r *ipTable) update(addr string, d table.Route) error {
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
 	if err != nil {
 		return err
 	}
 	id := calculateIndex(claimIP, r.ipRange.From())
-	if r.table.IsFree(id) {
+	old, err := r.table.Get(id)
+	if err != nil {
 		return fmt.Errorf("update failed ip %s not claimed", addr)
 	}
-	return r.table.Update(id, d)
+	if err := r.quotas.Update(old.Data().Labels(), 1, d.Labels(), 1); err != nil {
+		return err
+	}
+	if _, err := r.table.Update(id, d); err != nil {
+		r.quotas.Update(d.Labels(), 1, old.Data().Labels(), 1)
+		return err
+	}
+	if err := r.write(id); err != nil {
+		r.table.Update(id, old.Data())
+		r.quotas.Update(d.Labels(), 1, old.Data().Labels(), 1)
+		return err
+	}
+	r.record(metrics.OperationUpdate, claimIP, old.Data().Labels(), d.Labels())
+	return nil
 }
 
 func (r *ipTable) Size() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
 	return r.table.Size()
 }
 
 func (r *ipTable) Has(addr string) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.has(addr)
+}
+
+func (r *ipTable) has(addr string) bool {
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
 	if err != nil {
@@ -107,6 +282,9 @@ func (r *ipTable) Has(addr string) bool {
 }
 
 func (r *ipTable) IsFree(addr string) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
 	// Validate IP address
 	claimIP, err := r.validateIP(addr)
 	if err != nil {
@@ -117,6 +295,9 @@ func (r *ipTable) IsFree(addr string) bool {
 }
 
 func (r *ipTable) FindFree() (netip.Addr, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
 	var addr netip.Addr
 
 	id, err := r.table.FindFree()
@@ -126,7 +307,57 @@ func (r *ipTable) FindFree() (netip.Addr, error) {
 	return calculateIPFromIndex(r.ipRange.From(), id), nil
 }
 
+// ClaimFreeWait claims the first free address with a host route. When the
+// table is exhausted it waits until an address is released or ctx is done;
+// the priority of the waiter is set on ctx with idxtable.WithPriority. The
+// quota of the claim is held while waiting; the table is not locked while
+// waiting, so the releases can proceed.
+func (r *ipTable) ClaimFreeWait(ctx context.Context, labels labels.Set) (table.Route, error) {
+	route, err := r.claimFreeWait(ctx, labels)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return route, err
+}
+
+func (r *ipTable) claimFreeWait(ctx context.Context, labels labels.Set) (table.Route, error) {
+	r.m.Lock()
+	quotas := r.quotas
+	err := quotas.Claim(labels, 1)
+	r.m.Unlock()
+	if err != nil {
+		return table.Route{}, err
+	}
+	e, _, err := r.table.ClaimFreeWaitFunc(ctx, func(id uint64) table.Route {
+		addr := calculateIPFromIndex(r.ipRange.From(), id)
+		return table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), labels, nil)
+	})
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err != nil {
+		quotas.Release(labels, 1)
+		return table.Route{}, err
+	}
+	addr := calculateIPFromIndex(r.ipRange.From(), e.ID())
+	if err := r.write(e.ID()); err != nil {
+		r.table.Release(e.ID())
+		quotas.Release(labels, 1)
+		return table.Route{}, err
+	}
+	r.record(metrics.OperationClaim, addr, nil, labels)
+	return e.Data(), nil
+}
+
 func (r *ipTable) GetAll() table.Routes {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.getAll()
+}
+
+func (r *ipTable) getAll() table.Routes {
 	var routes table.Routes
 	for _, entry := range r.table.GetAll() {
 		routes = append(routes, entry.Data())
@@ -135,6 +366,9 @@ func (r *ipTable) GetAll() table.Routes {
 }
 
 func (r *ipTable) GetByLabel(selector labels.Selector) table.Routes {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
 	var routes table.Routes
 
 	iter := r.table.Iterate()
@@ -149,6 +383,85 @@ func (r *ipTable) GetByLabel(selector labels.Selector) table.Routes {
 	return routes
 }
 
+// SetMetricsHook sets the hook called after every claim, release and update
+func (r *ipTable) SetMetricsHook(hook metrics.Hook) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.hook = hook
+}
+
+// SetAuditLog records every claim, update and release made by actor in the
+// log
+func (r *ipTable) SetAuditLog(log *audit.Log, actor string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.audit, r.actor = log, actor
+}
+
+// SetClock sets the clock of the metadata and the audit records of the
+// changes
+func (r *ipTable) SetClock(clock tree.Clock) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.clock = clock
+	r.table.SetClock(clock)
+}
+
+// record adds the change of the route of addr from the labels before to
+// after to the audit log; the caller must hold the lock
+func (r *ipTable) record(op metrics.Operation, addr netip.Addr, before, after labels.Set) {
+	if r.audit == nil {
+		return
+	}
+	r.audit.Append(audit.Record{
+		Time:   r.clock.Now(),
+		Op:     op,
+		ID:     addr.String(),
+		Actor:  r.actor,
+		Before: before,
+		After:  after,
+	})
+}
+
+func (r *ipTable) Usage() metrics.Usage {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Usage()
+}
+
+func (r *ipTable) Stats() metrics.Stats {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Stats()
+}
+
+// SetQuotas replaces the quota rules of the table. The routes in the table
+// count in the usage, even when they exceed a rule.
+func (r *ipTable) SetQuotas(rules []quota.Rule) error {
+	quotas, err := quota.New(rules)
+	if err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, route := range r.getAll() {
+		quotas.Add(route.Labels(), 1)
+	}
+	r.quotas = quotas
+	return nil
+}
+
+func (r *ipTable) QuotaUsage() []quota.Usage {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.quotas.Usage()
+}
+
 func (r *ipTable) validateIP(addr string) (netip.Addr, error) {
 	// Parse IP address
 	claimIP, err := netip.ParseAddr(addr)
@@ -156,7 +469,7 @@ func (r *ipTable) validateIP(addr string) (netip.Addr, error) {
 		return netip.Addr{}, fmt.Errorf("ip address %s is invalid", addr)
 	}
 	if !r.ipRange.Contains(claimIP) {
-		return netip.Addr{}, fmt.Errorf("ip address %s, does not fit in the range from %s to %s", addr, r.ipRange.From().String(), r.ipRange.To().String())
+		return netip.Addr{}, metrics.Errorf(metrics.ReasonOutOfRange, "ip address %s, does not fit in the range from %s to %s", addr, r.ipRange.From().String(), r.ipRange.To().String())
 	}
 	return claimIP, nil
 }
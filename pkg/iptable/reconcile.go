@@ -0,0 +1,70 @@
+package iptable
+
+import (
+	"net/netip"
+
+	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reconcile loads the desired claims in the table. The claims are applied from
+// oldest to newest, so the oldest claim of an address wins and the newer ones
+// are reported as conflicts. Routes that already hold a desired address get the
+// labels of the claim, unless they hold them already, the other routes are
+// reported as stale.
+func (r *ipTable) Reconcile(desired []ClaimSpec) (*ReconcileReport, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	report := &ReconcileReport{}
+
+	owners := map[netip.Addr]ClaimSpec{}
+	for _, claim := range reconcile.Sort(desired) {
+		addr, err := r.validateIP(claim.Addr)
+		if err != nil {
+			report.OutOfRange = append(report.OutOfRange, claim)
+			continue
+		}
+		if winner, ok := owners[addr]; ok {
+			// the same claim listed twice is not a conflict
+			if winner.Name != claim.Name {
+				report.Conflicts = append(report.Conflicts, reconcile.Conflict[ClaimSpec]{Claim: claim, Winner: winner})
+			}
+			continue
+		}
+		owners[addr] = claim
+		report.Loaded = append(report.Loaded, claim)
+	}
+
+	for _, route := range r.getAll() {
+		if _, ok := owners[route.Prefix().Addr()]; !ok {
+			report.Stale = append(report.Stale, route)
+		}
+	}
+
+	for _, claim := range report.Loaded {
+		addr, _ := r.validateIP(claim.Addr)
+		route := table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), claim.Labels, nil)
+		if r.has(claim.Addr) {
+			// a route that holds the labels of the claim is left as it is
+			if old, err := r.get(claim.Addr); err == nil && labels.Equals(old.Labels(), claim.Labels) {
+				continue
+			}
+			err := r.update(claim.Addr, route)
+			metrics.Observe(r.hook, metrics.OperationUpdate, err)
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		err := r.claim(claim.Addr, route, tree.Meta{})
+		metrics.Observe(r.hook, metrics.OperationClaim, err)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,94 @@
+package iptable
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/idxtable"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SetStorage loads the records of s in the table and writes every following
+// change through to s, one record per address with the metadata of the
+// route. The records are loaded as host routes; a record replaces the route
+// of the same address and counts in the quotas, even when it exceeds a rule,
+// and a record of a free address is claimed with its metadata. The other
+// routes of the table are written to s.
+func (r *ipTable) SetStorage(s storage.Storage) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	loaded := map[uint64]struct{}{}
+	if err := s.Range(func(rec storage.Record) error {
+		addr, err := r.validateIP(rec.Key)
+		if err != nil {
+			return fmt.Errorf("invalid record %q, err: %s", rec.Key, err.Error())
+		}
+		id := calculateIndex(addr, r.ipRange.From())
+		loaded[id] = struct{}{}
+		route := table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), rec.Labels, nil)
+		old, err := r.table.Get(id)
+		if err != nil {
+			r.quotas.Add(rec.Labels, 1)
+			_, err := r.table.ClaimEntry(idxtable.WithMeta(idxtable.NewEntry(id, route), rec.Meta))
+			return err
+		}
+		if labels.Equals(old.Data().Labels(), rec.Labels) {
+			return nil
+		}
+		r.quotas.Release(old.Data().Labels(), 1)
+		r.quotas.Add(rec.Labels, 1)
+		_, err = r.table.Update(id, route)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range r.table.GetAll() {
+		if _, ok := loaded[e.ID()]; ok {
+			continue
+		}
+		if err := s.Put(record(calculateIPFromIndex(r.ipRange.From(), e.ID()), e)); err != nil {
+			return err
+		}
+	}
+	r.storage = s
+	return nil
+}
+
+// record returns the record of the route e of addr with its metadata
+func record(addr netip.Addr, e idxtable.Entry[table.Route]) storage.Record {
+	return storage.Record{
+		Key:    addr.String(),
+		Labels: e.Data().Labels(),
+		Meta:   metaOf(e),
+	}
+}
+
+// metaOf returns the metadata of the entry of a route
+func metaOf(e idxtable.Entry[table.Route]) tree.Meta {
+	return tree.Meta{Created: e.Created(), Updated: e.Updated(), Generation: e.Generation()}
+}
+
+// write stores the route at index id of the table with its metadata
+func (r *ipTable) write(id uint64) error {
+	if r.storage == nil {
+		return nil
+	}
+	e, err := r.table.Get(id)
+	if err != nil {
+		return err
+	}
+	return r.storage.Put(record(calculateIPFromIndex(r.ipRange.From(), id), e))
+}
+
+// erase removes the record of addr
+func (r *ipTable) erase(addr netip.Addr) error {
+	if r.storage == nil {
+		return nil
+	}
+	return r.storage.Delete(addr.String())
+}
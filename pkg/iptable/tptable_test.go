@@ -1,8 +1,20 @@
 package iptable
 
 import (
+	"context"
 	"fmt"
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+	"net/netip"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hansthienpondt/nipam/pkg/table"
 	"github.com/tj/assert"
@@ -66,3 +78,125 @@ func TestClaim(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcile(t *testing.T) {
+	claim := func(name string, created int64, addr string) ClaimSpec {
+		return ClaimSpec{
+			Meta:   reconcile.Meta{Name: name, CreationTimestamp: time.Unix(created, 0)},
+			Addr:   addr,
+			Labels: labels.Set{"owner": name},
+		}
+	}
+
+	ipRange, err := netipx.ParseIPRange("10.0.0.10-10.0.0.20")
+	assert.NoError(t, err)
+	r := New(ipRange.From(), ipRange.To())
+	assert.NoError(t, r.Claim("10.0.0.15", table.Route{}))
+
+	report, err := r.Reconcile([]ClaimSpec{
+		claim("b", 1, "10.0.0.10"),
+		claim("a", 1, "10.0.0.10"),
+		claim("c", 1, "10.0.0.30"),
+		claim("d", 1, "invalid"),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, report.Loaded, 1)
+	assert.Equal(t, "a", report.Loaded[0].Name)
+	assert.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "b", report.Conflicts[0].Claim.Name)
+	assert.Len(t, report.OutOfRange, 2)
+	assert.Len(t, report.Stale, 1)
+
+	route, err := r.Get("10.0.0.10")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", route.Labels()["owner"])
+
+	// a route that holds the labels of its claim is not changed
+	meta, err := r.Meta("10.0.0.10")
+	assert.NoError(t, err)
+	report, err = r.Reconcile([]ClaimSpec{claim("a", 1, "10.0.0.10")})
+	assert.NoError(t, err)
+	assert.Len(t, report.Loaded, 1)
+	got, err := r.Meta("10.0.0.10")
+	assert.NoError(t, err)
+	assert.Equal(t, meta, got)
+}
+
+func TestAudit(t *testing.T) {
+	log, err := audit.New(0)
+	assert.NoError(t, err)
+	ipRange, err := netipx.ParseIPRange("10.0.0.10-10.0.0.20")
+	assert.NoError(t, err)
+	r := New(ipRange.From(), ipRange.To())
+	r.SetAuditLog(log, "controller-a")
+	assert.NoError(t, r.Claim("10.0.0.10", table.Route{}))
+	assert.NoError(t, r.Update("10.0.0.10", table.NewRoute(netip.MustParsePrefix("10.0.0.10/32"), labels.Set{"owner": "a"}, nil)))
+	assert.NoError(t, r.Release("10.0.0.10"))
+	_, err = r.ClaimFreeWait(context.Background(), labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+
+	records := log.Query(audit.Query{ID: "10.0.0.10"})
+	ops := []metrics.Operation{}
+	for _, rec := range records {
+		ops = append(ops, rec.Op)
+		assert.Equal(t, "controller-a", rec.Actor)
+	}
+	assert.Equal(t, []metrics.Operation{metrics.OperationClaim, metrics.OperationUpdate, metrics.OperationRelease, metrics.OperationClaim}, ops)
+	assert.Equal(t, labels.Set{"owner": "a"}, records[2].Before)
+	assert.Equal(t, labels.Set{"owner": "b"}, records[3].After)
+}
+
+func TestMeta(t *testing.T) {
+	ipRange, err := netipx.ParseIPRange("10.0.0.10-10.0.0.20")
+	assert.NoError(t, err)
+	r := New(ipRange.From(), ipRange.To())
+	created := time.Unix(100, 0).UTC()
+	meta := tree.Meta{Created: created, Updated: created, Generation: 3}
+	route := table.NewRoute(netip.MustParsePrefix("10.0.0.10/32"), labels.Set{"owner": "a"}, nil)
+
+	// a restored route keeps its metadata
+	assert.NoError(t, r.ClaimWithMeta("10.0.0.10", route, meta))
+	got, err := r.Meta("10.0.0.10")
+	assert.NoError(t, err)
+	assert.Equal(t, meta, got)
+	_, err = r.Meta("10.0.0.11")
+	assert.Error(t, err)
+}
+
+func TestConcurrent(t *testing.T) {
+	ipRange, err := netipx.ParseIPRange("10.0.0.10-10.0.0.20")
+	assert.NoError(t, err)
+	r := New(ipRange.From(), ipRange.To())
+	s := storage.NewMemory()
+	assert.NoError(t, r.SetStorage(s))
+	assert.NoError(t, r.SetQuotas([]quota.Rule{
+		{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 50},
+	}))
+
+	log, err := audit.New(10)
+	assert.NoError(t, err)
+
+	// every address is claimed by exactly one of the workers
+	var claimed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for addr := ipRange.From(); addr.Compare(ipRange.To()) <= 0; addr = addr.Next() {
+				route := table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), labels.Set{"tenant": "a"}, nil)
+				if err := r.Claim(addr.String(), route); err == nil {
+					claimed.Add(1)
+				}
+			}
+			r.SetAuditLog(log, fmt.Sprintf("worker-%d", i))
+			r.SetMetricsHook(metrics.NewRecorder())
+		}(i)
+	}
+	wg.Wait()
+
+	// the table, the storage and the quotas agree
+	assert.Equal(t, int64(11), claimed.Load())
+	assert.Equal(t, r.Size(), s.Len())
+	assert.Equal(t, uint64(r.Size()), r.QuotaUsage()[0].IDs)
+}
@@ -0,0 +1,168 @@
+// Package metrics defines the hook the tables report their operations to and
+// the usage they expose, so a metrics system like prometheus can be adapted
+// without the tables depending on it.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Operation is a mutation of a table
+type Operation string
+
+const (
+	OperationClaim   Operation = "claim"
+	OperationRelease Operation = "release"
+	OperationUpdate  Operation = "update"
+)
+
+// Reason is the outcome of an operation
+type Reason string
+
+const (
+	// ReasonSuccess is the reason of a successful operation
+	ReasonSuccess Reason = "success"
+	// ReasonExhausted is reported when no free id is available
+	ReasonExhausted Reason = "exhausted"
+	// ReasonClaimed is reported when an id is already claimed
+	ReasonClaimed Reason = "claimed"
+	// ReasonNotFound is reported when an id is not claimed
+	ReasonNotFound Reason = "not_found"
+	// ReasonOutOfRange is reported when an id does not fit in the table
+	ReasonOutOfRange Reason = "out_of_range"
+	// ReasonQuotaExceeded is reported when a claim exceeds a quota
+	ReasonQuotaExceeded Reason = "quota_exceeded"
+	// ReasonRevisionMismatch is reported when a conditional update or release
+	// expects another revision of the entry
+	ReasonRevisionMismatch Reason = "revision_mismatch"
+	// ReasonOther is reported for failures without a reason
+	ReasonOther Reason = "other"
+)
+
+// Hook is called by a table after every claim, release and update
+type Hook interface {
+	Observe(op Operation, reason Reason)
+}
+
+// Usage is the utilization of a table
+type Usage struct {
+	// Capacity is the number of ids of the table
+	Capacity uint64
+	// Claimed is the number of claimed ids, reserved ids included
+	Claimed uint64
+	Free    uint64
+	// Reserved is the number of claimed ids labeled as reserved
+	Reserved uint64
+	// LargestFreeBlock is the size of the largest run of consecutive free ids
+	LargestFreeBlock uint64
+}
+
+// Instrumented is implemented by the tables
+type Instrumented interface {
+	Usage() Usage
+	SetMetricsHook(hook Hook)
+}
+
+// Error is an error annotated with the reason of the failure
+type Error struct {
+	Reason Reason
+	Err    error
+}
+
+// Errorf returns an error with the reason and a message formatted like fmt.Errorf
+func Errorf(reason Reason, format string, a ...any) error {
+	return &Error{Reason: reason, Err: fmt.Errorf(format, a...)}
+}
+
+func (r *Error) Error() string { return r.Err.Error() }
+func (r *Error) Unwrap() error { return r.Err }
+
+// ReasonOf returns the reason of the outcome of an operation
+func ReasonOf(err error) Reason {
+	if err == nil {
+		return ReasonSuccess
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return ReasonOther
+}
+
+// Observe calls the hook, when set, with the reason of err
+func Observe(hook Hook, op Operation, err error) {
+	if hook != nil {
+		hook.Observe(op, ReasonOf(err))
+	}
+}
+
+// Count is the number of operations with the same outcome
+type Count struct {
+	Operation Operation
+	Reason    Reason
+	Value     uint64
+}
+
+type countKey struct {
+	op     Operation
+	reason Reason
+}
+
+// Recorder is a Hook that counts the operations by outcome
+type Recorder struct {
+	m      sync.Mutex
+	counts map[countKey]uint64
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{counts: map[countKey]uint64{}}
+}
+
+func (r *Recorder) Observe(op Operation, reason Reason) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.counts[countKey{op: op, reason: reason}]++
+}
+
+// Counts returns the counts ordered by operation and reason
+func (r *Recorder) Counts() []Count {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	counts := make([]Count, 0, len(r.counts))
+	for k, v := range r.counts {
+		counts = append(counts, Count{Operation: k.op, Reason: k.reason, Value: v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Operation != counts[j].Operation {
+			return counts[i].Operation < counts[j].Operation
+		}
+		return counts[i].Reason < counts[j].Reason
+	})
+	return counts
+}
+
+// Stats is the utilization and fragmentation of a table
+type Stats struct {
+	Capacity uint64
+	Claimed  uint64
+	Free     uint64
+	// FreeRuns is the number of runs of consecutive free ids
+	FreeRuns       uint64
+	LargestFreeRun uint64
+	// FreePrefixes is the number of free prefixes by prefix length, with the
+	// free ids split in the minimal set of prefixes. It is only set for trees.
+	FreePrefixes map[uint8]uint64
+	// RunSizes is the histogram of the free run sizes; RunSizes[i] is the
+	// number of runs with a size from 2^i to 2^(i+1)-1.
+	RunSizes []uint64
+}
+
+// RunSizeBucket returns the index in Stats.RunSizes of a run of size ids
+func RunSizeBucket(size uint64) int {
+	return bits.Len64(size) - 1
+}
@@ -0,0 +1,94 @@
+// Package prom exposes the metrics of the tables as a prometheus.Collector.
+package prom
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	capacityDesc = prometheus.NewDesc(
+		"idxtable_pool_capacity", "Number of ids of the pool.", []string{"pool"}, nil)
+	claimedDesc = prometheus.NewDesc(
+		"idxtable_pool_claimed", "Number of claimed ids of the pool, reserved ids included.", []string{"pool"}, nil)
+	freeDesc = prometheus.NewDesc(
+		"idxtable_pool_free", "Number of free ids of the pool.", []string{"pool"}, nil)
+	reservedDesc = prometheus.NewDesc(
+		"idxtable_pool_reserved", "Number of reserved ids of the pool.", []string{"pool"}, nil)
+	largestFreeBlockDesc = prometheus.NewDesc(
+		"idxtable_pool_largest_free_block", "Size of the largest run of consecutive free ids of the pool.", []string{"pool"}, nil)
+	operationsDesc = prometheus.NewDesc(
+		"idxtable_pool_operations_total", "Number of claims, releases and updates of the pool by result.", []string{"pool", "operation", "result"}, nil)
+)
+
+// Collector collects the usage and the operation counts of the registered
+// tables, labeled with the pool name. The usage is read from the tables on
+// every scrape.
+type Collector struct {
+	m     sync.RWMutex
+	pools map[string]*pool
+}
+
+type pool struct {
+	table    metrics.Instrumented
+	recorder *metrics.Recorder
+}
+
+func NewCollector() *Collector {
+	return &Collector{pools: map[string]*pool{}}
+}
+
+// Register adds the table as the pool name and sets a metrics.Recorder as its
+// metrics hook; operations are counted from then on.
+func (r *Collector) Register(name string, table metrics.Instrumented) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.pools[name]; ok {
+		return fmt.Errorf("pool %s is already registered", name)
+	}
+	recorder := metrics.NewRecorder()
+	table.SetMetricsHook(recorder)
+	r.pools[name] = &pool{table: table, recorder: recorder}
+	return nil
+}
+
+// Unregister removes the pool and the metrics hook of its table
+func (r *Collector) Unregister(name string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if p, ok := r.pools[name]; ok {
+		p.table.SetMetricsHook(nil)
+		delete(r.pools, name)
+	}
+}
+
+func (r *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- capacityDesc
+	ch <- claimedDesc
+	ch <- freeDesc
+	ch <- reservedDesc
+	ch <- largestFreeBlockDesc
+	ch <- operationsDesc
+}
+
+func (r *Collector) Collect(ch chan<- prometheus.Metric) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	for name, p := range r.pools {
+		usage := p.table.Usage()
+		ch <- prometheus.MustNewConstMetric(capacityDesc, prometheus.GaugeValue, float64(usage.Capacity), name)
+		ch <- prometheus.MustNewConstMetric(claimedDesc, prometheus.GaugeValue, float64(usage.Claimed), name)
+		ch <- prometheus.MustNewConstMetric(freeDesc, prometheus.GaugeValue, float64(usage.Free), name)
+		ch <- prometheus.MustNewConstMetric(reservedDesc, prometheus.GaugeValue, float64(usage.Reserved), name)
+		ch <- prometheus.MustNewConstMetric(largestFreeBlockDesc, prometheus.GaugeValue, float64(usage.LargestFreeBlock), name)
+		for _, c := range p.recorder.Counts() {
+			ch <- prometheus.MustNewConstMetric(operationsDesc, prometheus.CounterValue, float64(c.Value), name, string(c.Operation), string(c.Reason))
+		}
+	}
+}
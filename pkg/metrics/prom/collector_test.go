@@ -0,0 +1,85 @@
+package prom
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/iptable"
+	"github.com/henderiw/idxtable/pkg/table/table16"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
+	"github.com/henderiw/idxtable/pkg/tree/tree32"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tj/assert"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+
+	vlan := table16.New(100, 199)
+	assert.NoError(t, c.Register("vlan", vlan))
+	assert.Error(t, c.Register("vlan", vlan))
+	assert.NoError(t, errOf(vlan.Claim(100, labels.Set{tree.ReservedLabelKey: "vlan"})))
+	assert.NoError(t, errOf(vlan.Claim(150, nil)))
+	assert.Error(t, errOf(vlan.Claim(150, nil)))
+	assert.Error(t, errOf(vlan.Claim(200, nil)))
+	assert.NoError(t, errOf(vlan.Release(150)))
+
+	label, err := tree32.New("label", 20)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Register("label", label))
+	assert.NoError(t, errOf(label.ClaimID(id32.NewID(0, 22), labels.Set{tree.ReservedLabelKey: "label"})))
+	assert.NoError(t, errOf(label.ClaimID(id32.NewID(1<<19, 31), nil)))
+	_, _, err = label.ClaimFree(nil)
+	assert.NoError(t, err)
+
+	ip := iptable.New(netip.MustParseAddr("10.0.0.0"), netip.MustParseAddr("10.0.0.3"))
+	assert.NoError(t, c.Register("ip", ip))
+	assert.NoError(t, ip.Claim("10.0.0.1", table.NewRoute(netip.MustParsePrefix("10.0.0.1/32"), nil, nil)))
+	assert.NoError(t, ip.Claim("10.0.0.2", table.NewRoute(netip.MustParsePrefix("10.0.0.2/32"), nil, nil)))
+
+	expected := `
+# HELP idxtable_pool_capacity Number of ids of the pool.
+# TYPE idxtable_pool_capacity gauge
+idxtable_pool_capacity{pool="ip"} 4
+idxtable_pool_capacity{pool="label"} 1.048576e+06
+idxtable_pool_capacity{pool="vlan"} 100
+# HELP idxtable_pool_claimed Number of claimed ids of the pool, reserved ids included.
+# TYPE idxtable_pool_claimed gauge
+idxtable_pool_claimed{pool="ip"} 2
+idxtable_pool_claimed{pool="label"} 1027
+idxtable_pool_claimed{pool="vlan"} 1
+# HELP idxtable_pool_largest_free_block Size of the largest run of consecutive free ids of the pool.
+# TYPE idxtable_pool_largest_free_block gauge
+idxtable_pool_largest_free_block{pool="ip"} 1
+idxtable_pool_largest_free_block{pool="label"} 524285
+idxtable_pool_largest_free_block{pool="vlan"} 99
+# HELP idxtable_pool_operations_total Number of claims, releases and updates of the pool by result.
+# TYPE idxtable_pool_operations_total counter
+idxtable_pool_operations_total{operation="claim",pool="ip",result="success"} 2
+idxtable_pool_operations_total{operation="claim",pool="label",result="success"} 3
+idxtable_pool_operations_total{operation="claim",pool="vlan",result="claimed"} 1
+idxtable_pool_operations_total{operation="claim",pool="vlan",result="out_of_range"} 1
+idxtable_pool_operations_total{operation="claim",pool="vlan",result="success"} 2
+idxtable_pool_operations_total{operation="release",pool="vlan",result="success"} 1
+# HELP idxtable_pool_reserved Number of reserved ids of the pool.
+# TYPE idxtable_pool_reserved gauge
+idxtable_pool_reserved{pool="ip"} 0
+idxtable_pool_reserved{pool="label"} 1024
+idxtable_pool_reserved{pool="vlan"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"idxtable_pool_capacity", "idxtable_pool_claimed", "idxtable_pool_reserved",
+		"idxtable_pool_largest_free_block", "idxtable_pool_operations_total"))
+
+	c.Unregister("vlan")
+	assert.Equal(t, 2*5+2, testutil.CollectAndCount(c))
+}
+
+// errOf returns the error of a mutation without the revision of the table
+func errOf(_ uint64, err error) error {
+	return err
+}
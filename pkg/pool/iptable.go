@@ -0,0 +1,191 @@
+package pool
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+
+	"github.com/hansthienpondt/nipam/pkg/table"
+	"github.com/henderiw/idxtable/pkg/api/convert"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/iptable"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"go4.org/netipx"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newIPPool(pool *v1alpha1.Pool) (Pool, error) {
+	var bldr netipx.IPSetBuilder
+	for _, s := range pool.Spec.Ranges {
+		ipset, err := convert.ParseIPSet(s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddSet(ipset)
+	}
+	ipset, err := bldr.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	t, err := convert.NewIPTable(pool)
+	if err != nil {
+		return nil, err
+	}
+	return &ipPool{kind: pool.Spec.Kind, table: t, ips: ipset}, nil
+}
+
+type ipPool struct {
+	kind  v1alpha1.PoolKind
+	table iptable.IPTable
+	// ips are the addresses of the pool
+	ips *netipx.IPSet
+}
+
+func (r *ipPool) Kind() v1alpha1.PoolKind { return r.kind }
+
+func (r *ipPool) SetClock(clock tree.Clock) { r.table.SetClock(clock) }
+
+func (r *ipPool) Claim(id string, labels labels.Set) (Entry, error) {
+	addr, err := r.parseAddr(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if r.table.Has(addr.String()) {
+		return Entry{}, fmt.Errorf("%w: ip %s is claimed", ErrExists, addr)
+	}
+	if err := r.table.Claim(addr.String(), newRoute(addr, labels)); err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: addr.String(), Labels: labels}, nil
+}
+
+func (r *ipPool) ClaimFree(labels labels.Set) (Entry, error) {
+	addr, err := r.table.FindFree()
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %s", ErrExhausted, err.Error())
+	}
+	return r.Claim(addr.String(), labels)
+}
+
+func (r *ipPool) ClaimRange(s string, labels labels.Set) ([]Entry, error) {
+	ipset, err := convert.ParseIPSet(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	for _, ipRange := range ipset.Ranges() {
+		if !r.ips.ContainsRange(ipRange) {
+			return nil, fmt.Errorf("%w: range %q is not part of the pool", ErrInvalid, s)
+		}
+	}
+	if err := convert.ClaimIPs(r.table, ipset, labels); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, err.Error())
+	}
+	entries := []Entry{}
+	for _, ipRange := range ipset.Ranges() {
+		for addr := ipRange.From(); addr.IsValid() && addr.Compare(ipRange.To()) <= 0; addr = addr.Next() {
+			entries = append(entries, Entry{ID: addr.String(), Labels: labels})
+		}
+	}
+	return entries, nil
+}
+
+func (r *ipPool) Release(id string) (Entry, error) {
+	e, err := r.Get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if err := r.table.Release(e.ID); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func (r *ipPool) meta(id string) (tree.Meta, error) {
+	addr, err := r.parseAddr(id)
+	if err != nil {
+		return tree.Meta{}, err
+	}
+	meta, err := r.table.Meta(addr.String())
+	if err != nil {
+		return tree.Meta{}, fmt.Errorf("%w: ip %s is not claimed", ErrNotFound, addr)
+	}
+	return meta, nil
+}
+
+func (r *ipPool) claimWithMeta(id string, labels labels.Set, meta tree.Meta) error {
+	addr, err := r.parseAddr(id)
+	if err != nil {
+		return err
+	}
+	if r.table.Has(addr.String()) {
+		return fmt.Errorf("%w: ip %s is claimed", ErrExists, addr)
+	}
+	return r.table.ClaimWithMeta(addr.String(), newRoute(addr, labels), meta)
+}
+
+func (r *ipPool) Get(id string) (Entry, error) {
+	addr, err := r.parseAddr(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	route, err := r.table.Get(addr.String())
+	if err != nil || !r.table.Has(addr.String()) {
+		return Entry{}, fmt.Errorf("%w: ip %s is not claimed", ErrNotFound, addr)
+	}
+	return Entry{ID: addr.String(), Labels: route.Labels()}, nil
+}
+
+func (r *ipPool) List(selector labels.Selector) []Entry {
+	entries := []Entry{}
+	for _, route := range r.table.GetByLabel(selector) {
+		entries = append(entries, Entry{ID: route.Prefix().Addr().String(), Labels: route.Labels()})
+	}
+	return entries
+}
+
+func (r *ipPool) Stats() Stats {
+	var capacity uint64
+	for _, ipRange := range r.ips.Ranges() {
+		size := new(big.Int).Sub(addrToInt(ipRange.To()), addrToInt(ipRange.From()))
+		capacity += size.Uint64() + 1
+	}
+	return newStats(capacity, uint64(r.table.Size()))
+}
+
+func (r *ipPool) FreeRanges() []string {
+	var bldr netipx.IPSetBuilder
+	bldr.AddSet(r.ips)
+	for _, route := range r.table.GetAll() {
+		bldr.Remove(route.Prefix().Addr())
+	}
+	ipset, err := bldr.IPSet()
+	if err != nil {
+		return nil
+	}
+	return convert.FormatIPSet(ipset)
+}
+
+func (r *ipPool) Render(w io.Writer) error {
+	return renderEntries(w, r.List(labels.Everything()))
+}
+
+func addrToInt(addr netip.Addr) *big.Int {
+	b := addr.As16()
+	return new(big.Int).SetBytes(b[:])
+}
+
+func (r *ipPool) parseAddr(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	if !r.ips.Contains(addr) {
+		return netip.Addr{}, fmt.Errorf("%w: ip %s is not part of the pool", ErrInvalid, addr)
+	}
+	return addr, nil
+}
+
+func newRoute(addr netip.Addr, l labels.Set) table.Route {
+	return table.NewRoute(netip.PrefixFrom(addr, addr.BitLen()), l, nil)
+}
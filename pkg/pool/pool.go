@@ -0,0 +1,88 @@
+// Package pool exposes the tables built from a v1alpha1 Pool behind a single
+// interface that addresses values by their textual id, so front-ends like the
+// gRPC and HTTP servers do not need to know the kind of table backing a pool.
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	// ErrNotFound is returned when a pool or a claimed value does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when a pool or a value is already created or claimed
+	ErrExists = errors.New("already exists")
+	// ErrInvalid is returned when an id cannot be parsed or is outside of the pool
+	ErrInvalid = errors.New("invalid")
+	// ErrExhausted is returned when a pool has no free values left
+	ErrExhausted = errors.New("exhausted")
+)
+
+// Entry is a claimed value of a pool. The id is an id (e.g. "100"), a prefix
+// of a label pool (e.g. "4096/20") or an address (e.g. "10.0.0.1").
+type Entry struct {
+	ID     string     `json:"id"`
+	Labels labels.Set `json:"labels,omitempty"`
+}
+
+// Pool allocates the values of a pool
+type Pool interface {
+	Kind() v1alpha1.PoolKind
+	// Claim claims a single id, or a prefix for a label pool
+	Claim(id string, labels labels.Set) (Entry, error)
+	// ClaimFree claims the first free value
+	ClaimFree(labels labels.Set) (Entry, error)
+	// ClaimRange claims all values of the range, or none of them when one of
+	// the values is not free
+	ClaimRange(r string, labels labels.Set) ([]Entry, error)
+	// Release releases the claimed id and returns the entry it was claimed with
+	Release(id string) (Entry, error)
+	Get(id string) (Entry, error)
+	List(selector labels.Selector) []Entry
+	Stats() Stats
+	// FreeRanges returns the free values of the pool as ranges, e.g. "103-199"
+	FreeRanges() []string
+	// Render writes the entries of the pool; label pools are rendered as a
+	// tree of prefixes.
+	Render(w io.Writer) error
+	// SetClock sets the clock of the changes of the pool, e.g. to apply a
+	// replicated change at the time it was made on the leader
+	SetClock(clock tree.Clock)
+}
+
+// Stats is the utilization of a pool; reserved values count as claimed
+type Stats struct {
+	Capacity uint64 `json:"capacity"`
+	Claimed  uint64 `json:"claimed"`
+	Free     uint64 `json:"free"`
+}
+
+func newStats(capacity, claimed uint64) Stats {
+	return Stats{Capacity: capacity, Claimed: claimed, Free: capacity - claimed}
+}
+
+// New returns the pool matching the kind of the pool spec
+func New(pool *v1alpha1.Pool) (Pool, error) {
+	var p Pool
+	var err error
+	switch pool.Spec.Kind {
+	case v1alpha1.PoolKindVLAN, v1alpha1.PoolKindVNI:
+		p, err = newTablePool(pool)
+	case v1alpha1.PoolKindLabel:
+		p, err = newTreePool(pool)
+	case v1alpha1.PoolKindIPv4, v1alpha1.PoolKindIPv6:
+		p, err = newIPPool(pool)
+	default:
+		return nil, fmt.Errorf("%w: unsupported pool kind %q", ErrInvalid, pool.Spec.Kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	return p, nil
+}
@@ -0,0 +1,186 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/tj/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newSpec(name string, kind v1alpha1.PoolKind, ranges ...string) *v1alpha1.Pool {
+	return &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.PoolSpec{Kind: kind, Ranges: ranges},
+	}
+}
+
+func TestPool(t *testing.T) {
+	cases := map[string]struct {
+		spec          *v1alpha1.Pool
+		claim         string
+		claimRange    string
+		expectedFree  string
+		expectedRange []string
+		outOfPool     string
+		released      string
+	}{
+		"VLAN": {
+			spec:          newSpec("vlan", v1alpha1.PoolKindVLAN, "100-199"),
+			claim:         "100",
+			claimRange:    "101-102",
+			expectedFree:  "103",
+			expectedRange: []string{"101", "102"},
+			outOfPool:     "200",
+			released:      "101",
+		},
+		"Label": {
+			spec:          newSpec("label", v1alpha1.PoolKindLabel, "16-1048575"),
+			claim:         "16",
+			claimRange:    "4096/20",
+			expectedFree:  "17",
+			expectedRange: []string{"4096/20"},
+			outOfPool:     "15",
+			released:      "5000",
+		},
+		"IPv4": {
+			spec:          newSpec("ipv4", v1alpha1.PoolKindIPv4, "10.0.0.0/24"),
+			claim:         "10.0.0.0",
+			claimRange:    "10.0.0.1-10.0.0.2",
+			expectedFree:  "10.0.0.3",
+			expectedRange: []string{"10.0.0.1", "10.0.0.2"},
+			outOfPool:     "10.0.1.0",
+			released:      "10.0.0.2",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			p, err := New(tc.spec)
+			assert.NoError(t, err)
+			l := labels.Set{"tenant": "a"}
+
+			e, err := p.Claim(tc.claim, l)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.claim, e.ID)
+			_, err = p.Claim(tc.claim, l)
+			assert.True(t, errors.Is(err, ErrExists))
+			_, err = p.Claim(tc.outOfPool, l)
+			assert.True(t, errors.Is(err, ErrInvalid))
+
+			entries, err := p.ClaimRange(tc.claimRange, l)
+			assert.NoError(t, err)
+			ids := []string{}
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, tc.expectedRange, ids)
+
+			e, err = p.ClaimFree(labels.Set{"tenant": "b"})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedFree, e.ID)
+
+			selector := labels.SelectorFromSet(labels.Set{"tenant": "a"})
+			assert.Len(t, p.List(selector), 1+len(tc.expectedRange))
+
+			e, err = p.Release(tc.released)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.released, e.ID)
+			assert.Equal(t, "a", e.Labels["tenant"])
+			_, err = p.Get(tc.released)
+			assert.True(t, errors.Is(err, ErrNotFound))
+		})
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	r := NewRegistry()
+	p, err := r.Create(newSpec("vlan", v1alpha1.PoolKindVLAN, "1-10"))
+	assert.NoError(t, err)
+	_, err = r.Create(newSpec("vlan", v1alpha1.PoolKindVLAN, "1-10"))
+	assert.True(t, errors.Is(err, ErrExists))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.Watch(ctx, "vlan", labels.SelectorFromSet(labels.Set{"tenant": "a"}))
+	assert.NoError(t, err)
+
+	_, err = p.Claim("1", labels.Set{"tenant": "a"})
+	assert.NoError(t, err)
+	_, err = p.Claim("2", labels.Set{"tenant": "b"})
+	assert.NoError(t, err)
+	_, err = p.Release("1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, Event{Type: EventClaimed, Pool: "vlan", Entry: Entry{ID: "1", Labels: labels.Set{"tenant": "a"}}}, <-ch)
+	assert.Equal(t, Event{Type: EventReleased, Pool: "vlan", Entry: Entry{ID: "1", Labels: labels.Set{"tenant": "a"}}}, <-ch)
+
+	// deleting the pool closes the watchers
+	assert.NoError(t, r.Delete("vlan"))
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, err = r.Get("vlan")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// the watchers of a deleted pool do not wait for their context
+	goroutines := runtime.NumGoroutine()
+	_, err = r.Create(newSpec("vlan", v1alpha1.PoolKindVLAN, "1-10"))
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = r.Watch(context.Background(), "vlan", labels.Everything())
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, r.Delete("vlan"))
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+}
+
+func TestSnapshot(t *testing.T) {
+	spec := newSpec("vlan", v1alpha1.PoolKindVLAN, "100-199")
+	spec.Spec.Reservations = []v1alpha1.Reservation{{Range: "100-109"}}
+	p, err := New(spec)
+	assert.NoError(t, err)
+	created := time.Unix(100, 0).UTC()
+	p.SetClock(func() time.Time { return created })
+	_, err = p.ClaimRange("150-151", labels.Set{"tenant": "a"})
+	assert.NoError(t, err)
+
+	s, err := TakeSnapshot(spec, p)
+	assert.NoError(t, err)
+	// the reservations are part of the spec
+	meta := tree.Meta{Created: created, Updated: created, Generation: 1}
+	assert.Equal(t, []SnapshotEntry{
+		{Entry: Entry{ID: "150", Labels: labels.Set{"tenant": "a"}}, Meta: meta},
+		{Entry: Entry{ID: "151", Labels: labels.Set{"tenant": "a"}}, Meta: meta},
+	}, s.Entries)
+
+	// the audit log is written with the snapshot
+	log, err := audit.New(0)
+	assert.NoError(t, err)
+	log.Append(audit.Record{Time: time.Unix(1, 0).UTC(), Op: metrics.OperationClaim, ID: "150", After: labels.Set{"tenant": "a"}})
+	s.Audit = log
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteSnapshot(&buf, s))
+	s, err = ReadSnapshot(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, log.Records(), s.Audit.Records())
+	restored, err := Restore(s)
+	assert.NoError(t, err)
+	assert.Equal(t, p.List(labels.Everything()), restored.List(labels.Everything()))
+	assert.Equal(t, []string{"110-149", "152-199"}, restored.FreeRanges())
+	// the restored entries keep their metadata
+	m, err := restored.(metaPool).meta("150")
+	assert.NoError(t, err)
+	assert.Equal(t, meta, m)
+}
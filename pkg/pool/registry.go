@@ -0,0 +1,277 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// EventType is the type of change of an entry
+type EventType string
+
+const (
+	EventClaimed  EventType = "claimed"
+	EventReleased EventType = "released"
+)
+
+// Event is a change of an entry of a pool
+type Event struct {
+	Type  EventType `json:"type"`
+	Pool  string    `json:"pool"`
+	Entry Entry     `json:"entry"`
+}
+
+// watchBufferSize is the number of events buffered per watcher; a watcher
+// that falls further behind is closed and has to watch again.
+const watchBufferSize = 128
+
+// Registry holds the pools by name. The pools returned by the registry
+// serialize their mutations and send an event to the watchers of the pool for
+// every claimed and released entry.
+type Registry struct {
+	m     sync.RWMutex
+	pools map[string]*registeredPool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		pools: map[string]*registeredPool{},
+	}
+}
+
+// Create builds the pool from the spec and adds it to the registry
+func (r *Registry) Create(spec *v1alpha1.Pool) (Pool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, ok := r.pools[spec.Name]; ok {
+		return nil, fmt.Errorf("%w: pool %s", ErrExists, spec.Name)
+	}
+	p, err := New(spec)
+	if err != nil {
+		return nil, err
+	}
+	rp := &registeredPool{
+		name:     spec.Name,
+		pool:     p,
+		watchers: map[*watcher]struct{}{},
+	}
+	r.pools[spec.Name] = rp
+	return rp, nil
+}
+
+// Delete removes the pool from the registry and closes its watchers
+func (r *Registry) Delete(name string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rp, ok := r.pools[name]
+	if !ok {
+		return fmt.Errorf("%w: pool %s", ErrNotFound, name)
+	}
+	delete(r.pools, name)
+	rp.closeWatchers()
+	return nil
+}
+
+func (r *Registry) Get(name string) (Pool, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	rp, ok := r.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: pool %s", ErrNotFound, name)
+	}
+	return rp, nil
+}
+
+// List returns the names of the pools in alphabetical order
+func (r *Registry) List() []string {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	names := make([]string, 0, len(r.pools))
+	for name := range r.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Watch returns the events of the entries of the pool that match the
+// selector. The channel is closed when the context is done, when the pool is
+// deleted or when the watcher does not keep up with the events.
+func (r *Registry) Watch(ctx context.Context, name string, selector labels.Selector) (<-chan Event, error) {
+	r.m.RLock()
+	rp, ok := r.pools[name]
+	r.m.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: pool %s", ErrNotFound, name)
+	}
+
+	w := &watcher{
+		selector: selector,
+		ch:       make(chan Event, watchBufferSize),
+		done:     make(chan struct{}),
+	}
+	rp.addWatcher(w)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rp.removeWatcher(w)
+		case <-w.done:
+		}
+	}()
+	return w.ch, nil
+}
+
+type watcher struct {
+	selector labels.Selector
+	ch       chan Event
+	// done is closed together with ch, which ends the goroutine of the watcher
+	done chan struct{}
+}
+
+// close closes the channel of the watcher, the caller holds the lock of the pool
+func (r *watcher) close() {
+	close(r.ch)
+	close(r.done)
+}
+
+type registeredPool struct {
+	name string
+	pool Pool
+
+	// m serializes the mutations of the pool and protects the watchers
+	m        sync.Mutex
+	watchers map[*watcher]struct{}
+	closed   bool
+}
+
+func (r *registeredPool) Kind() v1alpha1.PoolKind { return r.pool.Kind() }
+
+func (r *registeredPool) SetClock(clock tree.Clock) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.pool.SetClock(clock)
+}
+
+func (r *registeredPool) Claim(id string, labels labels.Set) (Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e, err := r.pool.Claim(id, labels)
+	if err != nil {
+		return e, err
+	}
+	r.notify(EventClaimed, e)
+	return e, nil
+}
+
+func (r *registeredPool) ClaimFree(labels labels.Set) (Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e, err := r.pool.ClaimFree(labels)
+	if err != nil {
+		return e, err
+	}
+	r.notify(EventClaimed, e)
+	return e, nil
+}
+
+func (r *registeredPool) ClaimRange(s string, labels labels.Set) ([]Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entries, err := r.pool.ClaimRange(s, labels)
+	if err != nil {
+		return entries, err
+	}
+	for _, e := range entries {
+		r.notify(EventClaimed, e)
+	}
+	return entries, nil
+}
+
+func (r *registeredPool) Release(id string) (Entry, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e, err := r.pool.Release(id)
+	if err != nil {
+		return e, err
+	}
+	r.notify(EventReleased, e)
+	return e, nil
+}
+
+func (r *registeredPool) Get(id string) (Entry, error) {
+	return r.pool.Get(id)
+}
+
+func (r *registeredPool) List(selector labels.Selector) []Entry {
+	return r.pool.List(selector)
+}
+
+func (r *registeredPool) Stats() Stats {
+	return r.pool.Stats()
+}
+
+func (r *registeredPool) FreeRanges() []string {
+	return r.pool.FreeRanges()
+}
+
+func (r *registeredPool) Render(w io.Writer) error {
+	return r.pool.Render(w)
+}
+
+// notify sends the event to the matching watchers, the caller holds the lock
+func (r *registeredPool) notify(t EventType, e Entry) {
+	for w := range r.watchers {
+		if !w.selector.Matches(e.Labels) {
+			continue
+		}
+		select {
+		case w.ch <- Event{Type: t, Pool: r.name, Entry: e}:
+		default:
+			delete(r.watchers, w)
+			w.close()
+		}
+	}
+}
+
+func (r *registeredPool) addWatcher(w *watcher) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.closed {
+		w.close()
+		return
+	}
+	r.watchers[w] = struct{}{}
+}
+
+func (r *registeredPool) removeWatcher(w *watcher) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.watchers[w]; ok {
+		delete(r.watchers, w)
+		w.close()
+	}
+}
+
+func (r *registeredPool) closeWatchers() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.closed = true
+	for w := range r.watchers {
+		delete(r.watchers, w)
+		w.close()
+	}
+}
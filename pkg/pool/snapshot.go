@@ -0,0 +1,96 @@
+package pool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Snapshot is the serializable state of a pool: the spec it is built from and
+// the entries claimed after it was built, so reservations are not stored twice.
+// A reservation that was released is claimed again by Restore.
+type Snapshot struct {
+	Pool    v1alpha1.Pool   `json:"pool"`
+	Entries []SnapshotEntry `json:"entries,omitempty"`
+	// Audit is the audit log of the tables of the pool, when it is recorded;
+	// it is set by the caller and not restored by Restore
+	Audit *audit.Log `json:"audit,omitempty"`
+}
+
+// SnapshotEntry is an entry of a snapshot with its metadata, so a restored
+// entry keeps its creation time and generation
+type SnapshotEntry struct {
+	Entry
+	Meta tree.Meta `json:"meta"`
+}
+
+// metaPool is implemented by the pools of New, which keep the metadata of
+// their entries
+type metaPool interface {
+	// meta returns the metadata of the claimed id
+	meta(id string) (tree.Meta, error)
+	// claimWithMeta claims id with the metadata of a restored entry
+	claimWithMeta(id string, labels labels.Set, meta tree.Meta) error
+}
+
+// TakeSnapshot returns the snapshot of the pool built from spec
+func TakeSnapshot(spec *v1alpha1.Pool, p Pool) (*Snapshot, error) {
+	initial, err := New(spec)
+	if err != nil {
+		return nil, err
+	}
+	initialEntries := map[string]labels.Set{}
+	for _, e := range initial.List(labels.Everything()) {
+		initialEntries[e.ID] = e.Labels
+	}
+
+	s := &Snapshot{Pool: *spec.DeepCopy()}
+	for _, e := range p.List(labels.Everything()) {
+		if l, ok := initialEntries[e.ID]; ok && labels.Equals(l, e.Labels) {
+			continue
+		}
+		se := SnapshotEntry{Entry: e}
+		if mp, ok := p.(metaPool); ok {
+			if se.Meta, err = mp.meta(e.ID); err != nil {
+				return nil, err
+			}
+		}
+		s.Entries = append(s.Entries, se)
+	}
+	return s, nil
+}
+
+// Restore builds the pool of the snapshot and claims its entries
+func Restore(s *Snapshot) (Pool, error) {
+	p, err := New(&s.Pool)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range s.Entries {
+		if err := p.(metaPool).claimWithMeta(e.ID, e.Labels, e.Meta); err != nil {
+			return nil, fmt.Errorf("restore pool %s entry %s failed: %w", s.Pool.Name, e.ID, err)
+		}
+	}
+	return p, nil
+}
+
+// WriteSnapshot writes the snapshot as json
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
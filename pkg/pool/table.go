@@ -0,0 +1,209 @@
+package pool
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/henderiw/idxtable/pkg/api/convert"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/table"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newTablePool(pool *v1alpha1.Pool) (Pool, error) {
+	idset, err := parseIDSet(pool.Spec.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	t, err := convert.NewTable(pool)
+	if err != nil {
+		return nil, err
+	}
+	return &tablePool{kind: pool.Spec.Kind, table: t, ids: idset}, nil
+}
+
+type tablePool struct {
+	kind  v1alpha1.PoolKind
+	table table.Table
+	// ids are the ids of the pool
+	ids *id32.IDSet
+}
+
+func (r *tablePool) Kind() v1alpha1.PoolKind { return r.kind }
+
+func (r *tablePool) SetClock(clock tree.Clock) { r.table.SetClock(clock) }
+
+func (r *tablePool) Claim(id string, labels labels.Set) (Entry, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if r.table.Has(i) {
+		return Entry{}, fmt.Errorf("%w: id %d is claimed", ErrExists, i)
+	}
+	if _, err := r.table.Claim(i, labels); err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: formatTableID(i), Labels: labels}, nil
+}
+
+func (r *tablePool) ClaimFree(labels labels.Set) (Entry, error) {
+	e, _, err := r.table.ClaimFree(labels)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %s", ErrExhausted, err.Error())
+	}
+	return tableEntry(e), nil
+}
+
+func (r *tablePool) ClaimRange(s string, labels labels.Set) ([]Entry, error) {
+	idset, err := id32.ParseSet(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	if idset.Difference(r.ids).Size() != 0 {
+		return nil, fmt.Errorf("%w: range %q is not part of the pool", ErrInvalid, s)
+	}
+	if err := convert.ClaimTableIDs(r.table, idset, labels); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, err.Error())
+	}
+	entries := []Entry{}
+	for _, idRange := range idset.Ranges() {
+		for i := idRange.From().ID(); i <= idRange.To().ID(); i++ {
+			entries = append(entries, Entry{ID: formatTableID(i), Labels: labels})
+		}
+	}
+	return entries, nil
+}
+
+func (r *tablePool) Release(id string) (Entry, error) {
+	e, err := r.Get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	i, _ := r.parseID(id)
+	if _, err := r.table.Release(i); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func (r *tablePool) meta(id string) (tree.Meta, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return tree.Meta{}, err
+	}
+	e, err := r.table.Get(i)
+	if err != nil {
+		return tree.Meta{}, fmt.Errorf("%w: id %d is not claimed", ErrNotFound, i)
+	}
+	return e.Meta(), nil
+}
+
+func (r *tablePool) claimWithMeta(id string, labels labels.Set, meta tree.Meta) error {
+	i, err := r.parseID(id)
+	if err != nil {
+		return err
+	}
+	if r.table.Has(i) {
+		return fmt.Errorf("%w: id %d is claimed", ErrExists, i)
+	}
+	_, err = r.table.ClaimWithMeta(i, labels, meta)
+	return err
+}
+
+func (r *tablePool) Get(id string) (Entry, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	e, err := r.table.Get(i)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: id %d is not claimed", ErrNotFound, i)
+	}
+	return tableEntry(e), nil
+}
+
+func (r *tablePool) List(selector labels.Selector) []Entry {
+	entries := []Entry{}
+	for _, e := range r.table.GetByLabel(selector) {
+		entries = append(entries, tableEntry(e))
+	}
+	return entries
+}
+
+func (r *tablePool) Stats() Stats {
+	return newStats(r.ids.Size(), uint64(r.table.Size()))
+}
+
+func (r *tablePool) FreeRanges() []string {
+	var bldr id32.IDSetBuilder
+	bldr.AddSet(r.ids)
+	for _, e := range r.table.GetAll() {
+		// vlan tables hold 16 bit ids
+		bldr.RemoveId(id32.NewID(uint32(e.ID().ID()), id32.IDBitSize))
+	}
+	return formatIDSet(&bldr)
+}
+
+func (r *tablePool) Render(w io.Writer) error {
+	return renderEntries(w, r.List(labels.Everything()))
+}
+
+// parseID parses a single id of the pool
+func (r *tablePool) parseID(s string) (uint64, error) {
+	idset, err := id32.ParseSet(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	if idset.Size() != 1 {
+		return 0, fmt.Errorf("%w: %q is not a single id", ErrInvalid, s)
+	}
+	i := idset.Ranges()[0].From()
+	if !r.ids.Contains(i) {
+		return 0, fmt.Errorf("%w: id %d is not part of the pool", ErrInvalid, i.ID())
+	}
+	return i.ID(), nil
+}
+
+func formatTableID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+func tableEntry(e tree.Entry) Entry {
+	return Entry{ID: formatTableID(e.ID().ID()), Labels: e.Labels()}
+}
+
+func formatIDSet(bldr *id32.IDSetBuilder) []string {
+	idset, err := bldr.IPSet()
+	if err != nil || len(idset.Ranges()) == 0 {
+		return nil
+	}
+	return strings.Split(id32.FormatSet(idset), ",")
+}
+
+func renderEntries(w io.Writer, entries []Entry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s %s\n", e.ID, e.Labels.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseIDSet returns the union of the id sets of the pool ranges
+func parseIDSet(ranges []string) (*id32.IDSet, error) {
+	var bldr id32.IDSetBuilder
+	for _, s := range ranges {
+		idset, err := genid.ParseSet[uint32](s)
+		if err != nil {
+			return nil, err
+		}
+		bldr.AddSet(idset)
+	}
+	return bldr.IPSet()
+}
@@ -0,0 +1,207 @@
+package pool
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/henderiw/idxtable/pkg/api/convert"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newTreePool(pool *v1alpha1.Pool) (Pool, error) {
+	idset, err := parseIDSet(pool.Spec.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	t, err := convert.NewTree(pool)
+	if err != nil {
+		return nil, err
+	}
+	return &treePool{kind: pool.Spec.Kind, tree: t, ids: idset}, nil
+}
+
+type treePool struct {
+	kind v1alpha1.PoolKind
+	tree gtree.GTree
+	// ids are the ids of the pool, the other ids of the tree are reserved
+	ids *id32.IDSet
+}
+
+func (r *treePool) Kind() v1alpha1.PoolKind { return r.kind }
+
+func (r *treePool) SetClock(clock tree.Clock) { r.tree.SetClock(clock) }
+
+func (r *treePool) Claim(id string, labels labels.Set) (Entry, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if !r.tree.IsFree(i) {
+		return Entry{}, fmt.Errorf("%w: id %s is claimed", ErrExists, formatTreeID(i))
+	}
+	if _, err := r.tree.ClaimID(i, labels); err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: formatTreeID(i), Labels: labels}, nil
+}
+
+func (r *treePool) ClaimFree(labels labels.Set) (Entry, error) {
+	e, _, err := r.tree.ClaimFree(labels)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %s", ErrExhausted, err.Error())
+	}
+	return treeEntry(e), nil
+}
+
+func (r *treePool) ClaimRange(s string, labels labels.Set) ([]Entry, error) {
+	idset, err := id32.ParseSet(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	if idset.Difference(r.ids).Size() != 0 {
+		return nil, fmt.Errorf("%w: range %q is not part of the pool", ErrInvalid, s)
+	}
+	if err := convert.ClaimTreeIDs(r.tree, idset, labels); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, err.Error())
+	}
+	entries := []Entry{}
+	for _, i := range idset.IDs() {
+		entries = append(entries, Entry{ID: formatTreeID(i), Labels: labels})
+	}
+	return entries, nil
+}
+
+// Release releases the id; when the id is part of a claimed prefix the
+// remainder of the prefix stays claimed.
+func (r *treePool) Release(id string) (Entry, error) {
+	e, err := r.Get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	i, _ := r.parseID(id)
+	if _, err := r.tree.ReleaseID(i); err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: formatTreeID(i), Labels: e.Labels}, nil
+}
+
+func (r *treePool) meta(id string) (tree.Meta, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return tree.Meta{}, err
+	}
+	e, err := r.tree.Get(i)
+	if err != nil {
+		return tree.Meta{}, fmt.Errorf("%w: id %s is not claimed", ErrNotFound, formatTreeID(i))
+	}
+	return e.Meta(), nil
+}
+
+func (r *treePool) claimWithMeta(id string, labels labels.Set, meta tree.Meta) error {
+	i, err := r.parseID(id)
+	if err != nil {
+		return err
+	}
+	if !r.tree.IsFree(i) {
+		return fmt.Errorf("%w: id %s is claimed", ErrExists, formatTreeID(i))
+	}
+	_, err = r.tree.ClaimWithMeta(i, labels, meta)
+	return err
+}
+
+func (r *treePool) Get(id string) (Entry, error) {
+	i, err := r.parseID(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	e, err := r.tree.Get(i)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: id %s is not claimed", ErrNotFound, formatTreeID(i))
+	}
+	return treeEntry(e), nil
+}
+
+func (r *treePool) List(selector labels.Selector) []Entry {
+	entries := []Entry{}
+	for _, e := range r.tree.GetByLabel(selector) {
+		entries = append(entries, treeEntry(e))
+	}
+	return entries
+}
+
+// Stats counts the ids of the claimed prefixes; the ids outside of the pool
+// ranges are not part of the capacity.
+func (r *treePool) Stats() Stats {
+	var bldr id32.IDSetBuilder
+	iter := r.tree.Iterate()
+	for iter.Next() {
+		bldr.AddId(iter.Entry().ID())
+	}
+	claimed, err := bldr.IPSet()
+	if err != nil {
+		return newStats(r.ids.Size(), 0)
+	}
+	return newStats(r.ids.Size(), claimed.Intersect(r.ids).Size())
+}
+
+func (r *treePool) FreeRanges() []string {
+	var bldr id32.IDSetBuilder
+	bldr.AddSet(r.ids)
+	iter := r.tree.Iterate()
+	for iter.Next() {
+		bldr.RemoveId(iter.Entry().ID())
+	}
+	return formatIDSet(&bldr)
+}
+
+// Render writes the entries in trie order, indented below the prefixes that
+// cover them.
+func (r *treePool) Render(w io.Writer) error {
+	parents := []tree.ID{}
+	iter := r.tree.Iterate()
+	for iter.Next() {
+		e := iter.Entry()
+		for len(parents) > 0 && !parents[len(parents)-1].Overlaps(e.ID()) {
+			parents = parents[:len(parents)-1]
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("  ", len(parents)), formatTreeID(e.ID()), e.Labels().String()); err != nil {
+			return err
+		}
+		parents = append(parents, e.ID())
+	}
+	return nil
+}
+
+// parseID parses a single id or prefix of the pool, e.g. "100" or "4096/20"
+func (r *treePool) parseID(s string) (tree.ID, error) {
+	idset, err := id32.ParseSet(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err.Error())
+	}
+	ids := idset.IDs()
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("%w: %q is not a single id or prefix", ErrInvalid, s)
+	}
+	if !r.ids.ContainsRange(id32.RangeOfID(ids[0])) {
+		return nil, fmt.Errorf("%w: id %s is not part of the pool", ErrInvalid, formatTreeID(ids[0]))
+	}
+	return ids[0], nil
+}
+
+// formatTreeID returns the id without a length for single ids, e.g. "100",
+// and with the length for prefixes, e.g. "4096/20"
+func formatTreeID(id tree.ID) string {
+	if id.Length() == id32.IDBitSize {
+		return fmt.Sprintf("%d", id.ID())
+	}
+	return id.String()
+}
+
+func treeEntry(e tree.Entry) Entry {
+	return Entry{ID: formatTreeID(e.ID()), Labels: e.Labels()}
+}
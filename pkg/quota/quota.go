@@ -0,0 +1,189 @@
+// Package quota limits the ids that the entries of a table matching a label
+// selector may hold, e.g. tenant=a holds at most 50 vlans.
+package quota
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Rule limits the entries that match the selector
+type Rule struct {
+	// Name identifies the rule in the usage and the errors
+	Name     string
+	Selector labels.Selector
+	// MaxIDs is the max number of ids held by the entries; 0 is no limit
+	MaxIDs uint64
+	// MaxEntries is the max number of entries, e.g. at most one /28 is
+	// MaxEntries 1 with MaxIDs 16; 0 is no limit
+	MaxEntries uint64
+}
+
+// Usage is the amount held by the entries that match a rule
+type Usage struct {
+	Rule    Rule
+	IDs     uint64
+	Entries uint64
+}
+
+// Unit is what a limit counts
+type Unit string
+
+const (
+	UnitIDs     Unit = "ids"
+	UnitEntries Unit = "entries"
+)
+
+// ExceededError is returned, wrapped in a metrics.Error with the
+// metrics.ReasonQuotaExceeded reason, when a claim exceeds a rule
+type ExceededError struct {
+	Rule string
+	Unit Unit
+	// Limit is the limit of the rule, Used the amount held before the claim
+	// and Requested the amount of the claim
+	Limit     uint64
+	Used      uint64
+	Requested uint64
+}
+
+func (r *ExceededError) Error() string {
+	return fmt.Sprintf("quota %s exceeded: %d %s used, %d requested, limit %d", r.Rule, r.Used, r.Unit, r.Requested, r.Limit)
+}
+
+// Quotas tracks the usage of a set of rules. It is safe for concurrent use;
+// the methods of a nil Quotas do not limit anything.
+type Quotas struct {
+	m     sync.Mutex
+	usage []Usage
+}
+
+func New(rules []Rule) (*Quotas, error) {
+	names := map[string]struct{}{}
+	usage := make([]Usage, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("quota rule without a name")
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("quota rule %s defined twice", rule.Name)
+		}
+		if rule.Selector == nil {
+			return nil, fmt.Errorf("quota rule %s without a selector", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		usage = append(usage, Usage{Rule: rule})
+	}
+	return &Quotas{usage: usage}, nil
+}
+
+// Claim adds an entry with labels l holding ids to the usage, unless it
+// exceeds a rule
+func (r *Quotas) Claim(l labels.Set, ids uint64) error {
+	return r.replace(nil, &held{labels: l, ids: ids})
+}
+
+// Release removes an entry with labels l holding ids from the usage
+func (r *Quotas) Release(l labels.Set, ids uint64) {
+	// releasing never exceeds a rule
+	r.replace(&held{labels: l, ids: ids}, nil)
+}
+
+// Update replaces an entry with labels prev holding prevIDs by an entry with
+// labels next holding nextIDs, unless it exceeds a rule
+func (r *Quotas) Update(prev labels.Set, prevIDs uint64, next labels.Set, nextIDs uint64) error {
+	return r.replace(&held{labels: prev, ids: prevIDs}, &held{labels: next, ids: nextIDs})
+}
+
+// held are the ids held by an entry
+type held struct {
+	labels labels.Set
+	ids    uint64
+}
+
+// replace replaces prev by next; a nil prev adds an entry and a nil next
+// removes one. A change that does not increase the usage of a rule is always
+// accepted, even when the rule is already exceeded.
+func (r *Quotas) replace(prev, next *held) error {
+	if r == nil {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	after := make([]Usage, len(r.usage))
+	for i, usage := range r.usage {
+		after[i] = usage
+		if prev != nil && usage.Rule.Selector.Matches(prev.labels) {
+			after[i].IDs -= prev.ids
+			after[i].Entries--
+		}
+		if next == nil || !usage.Rule.Selector.Matches(next.labels) {
+			continue
+		}
+		after[i].IDs += next.ids
+		after[i].Entries++
+		if err := exceeded(usage.Rule, UnitIDs, usage.Rule.MaxIDs, usage.IDs, after[i].IDs, next.ids); err != nil {
+			return err
+		}
+		if err := exceeded(usage.Rule, UnitEntries, usage.Rule.MaxEntries, usage.Entries, after[i].Entries, 1); err != nil {
+			return err
+		}
+	}
+	r.usage = after
+	return nil
+}
+
+func exceeded(rule Rule, unit Unit, limit, used, after, requested uint64) error {
+	if limit == 0 || after <= limit || after <= used {
+		return nil
+	}
+	return &metrics.Error{
+		Reason: metrics.ReasonQuotaExceeded,
+		Err: &ExceededError{
+			Rule:      rule.Name,
+			Unit:      unit,
+			Limit:     limit,
+			Used:      used,
+			Requested: requested,
+		},
+	}
+}
+
+// Add adds an entry with labels l holding ids to the usage without checking
+// the rules, e.g. to load the entries that exist when the rules are set
+func (r *Quotas) Add(l labels.Set, ids uint64) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for i, usage := range r.usage {
+		if usage.Rule.Selector.Matches(l) {
+			r.usage[i].IDs += ids
+			r.usage[i].Entries++
+		}
+	}
+}
+
+// Clone returns a copy of the rules and their usage
+func (r *Quotas) Clone() *Quotas {
+	if r == nil {
+		return nil
+	}
+	return &Quotas{usage: r.Usage()}
+}
+
+// Usage returns the usage versus the limit of every rule
+func (r *Quotas) Usage() []Usage {
+	if r == nil {
+		return []Usage{}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]Usage{}, r.usage...)
+}
@@ -0,0 +1,103 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/tj/assert"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestQuotas(t *testing.T) {
+	cases := map[string]struct {
+		claims        []labels.Set
+		ids           uint64
+		expectedErr   *ExceededError
+		expectedUsage []uint64
+	}{
+		"WithinLimits": {
+			claims:        []labels.Set{{"tenant": "a"}, {"tenant": "a"}, {"tenant": "b"}},
+			ids:           1,
+			expectedUsage: []uint64{2, 1},
+		},
+		"MaxIDs": {
+			claims:        []labels.Set{{"tenant": "a"}, {"tenant": "a"}, {"tenant": "a"}, {"tenant": "a"}},
+			ids:           1,
+			expectedErr:   &ExceededError{Rule: "tenant-a", Unit: UnitIDs, Limit: 3, Used: 3, Requested: 1},
+			expectedUsage: []uint64{3, 0},
+		},
+		"MaxEntries": {
+			claims:        []labels.Set{{"tenant": "b"}, {"tenant": "b"}},
+			ids:           16,
+			expectedErr:   &ExceededError{Rule: "tenant-b", Unit: UnitEntries, Limit: 1, Used: 1, Requested: 1},
+			expectedUsage: []uint64{0, 16},
+		},
+		"Unmatched": {
+			claims:        []labels.Set{{"tenant": "c"}, {}, nil},
+			ids:           100,
+			expectedUsage: []uint64{0, 0},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			q, err := New([]Rule{
+				{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 3},
+				{Name: "tenant-b", Selector: labels.SelectorFromSet(labels.Set{"tenant": "b"}), MaxEntries: 1},
+			})
+			assert.NoError(t, err)
+
+			var claimErr error
+			for _, l := range tc.claims {
+				if claimErr = q.Claim(l, tc.ids); claimErr != nil {
+					break
+				}
+			}
+			if tc.expectedErr != nil {
+				var e *ExceededError
+				assert.True(t, errors.As(claimErr, &e))
+				assert.Equal(t, tc.expectedErr, e)
+				assert.Equal(t, metrics.ReasonQuotaExceeded, metrics.ReasonOf(claimErr))
+			} else {
+				assert.NoError(t, claimErr)
+			}
+
+			usage := []uint64{}
+			for _, u := range q.Usage() {
+				usage = append(usage, u.IDs)
+			}
+			assert.Equal(t, tc.expectedUsage, usage)
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	q, err := New([]Rule{
+		{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 1},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.Claim(labels.Set{"tenant": "a"}, 1))
+	assert.NoError(t, q.Claim(labels.Set{"tenant": "b"}, 1))
+	// moving an entry to an exceeded rule fails, updating the same one does not
+	assert.Error(t, q.Update(labels.Set{"tenant": "b"}, 1, labels.Set{"tenant": "a"}, 1))
+	assert.NoError(t, q.Update(labels.Set{"tenant": "a"}, 1, labels.Set{"tenant": "a", "x": "y"}, 1))
+
+	q.Release(labels.Set{"tenant": "a"}, 1)
+	assert.NoError(t, q.Update(labels.Set{"tenant": "b"}, 1, labels.Set{"tenant": "a"}, 1))
+	assert.Equal(t, uint64(1), q.Usage()[0].IDs)
+
+	// the entries loaded with Add may exceed the rules
+	q.Add(labels.Set{"tenant": "a"}, 5)
+	assert.Equal(t, uint64(6), q.Usage()[0].IDs)
+	q.Release(labels.Set{"tenant": "a"}, 5)
+	assert.Error(t, q.Claim(labels.Set{"tenant": "a"}, 1))
+
+	_, err = New([]Rule{{Name: "a", Selector: labels.Everything()}, {Name: "a", Selector: labels.Everything()}})
+	assert.Error(t, err)
+
+	var nilQuotas *Quotas
+	assert.NoError(t, nilQuotas.Claim(labels.Set{"tenant": "a"}, 100))
+	assert.Equal(t, []Usage{}, nilQuotas.Usage())
+}
@@ -0,0 +1,67 @@
+// Package reconcile holds the types shared by the Reconcile implementations of
+// the tables, which rebuild the allocation state from a list of claims.
+package reconcile
+
+import (
+	"sort"
+	"time"
+)
+
+// Meta identifies the owner of a claim and orders claims when they conflict:
+// the oldest claim wins, claims created at the same time are ordered by name.
+type Meta struct {
+	Name              string
+	CreationTimestamp time.Time
+}
+
+func (r Meta) ClaimMeta() Meta { return r }
+
+// Older returns true when r wins over other
+func (r Meta) Older(other Meta) bool {
+	if !r.CreationTimestamp.Equal(other.CreationTimestamp) {
+		return r.CreationTimestamp.Before(other.CreationTimestamp)
+	}
+	return r.Name < other.Name
+}
+
+// Claim is implemented by the claim specs of the tables by embedding Meta
+type Claim interface {
+	ClaimMeta() Meta
+}
+
+// Sort returns a copy of the claims ordered from oldest to newest
+func Sort[C Claim](claims []C) []C {
+	sorted := make([]C, len(claims))
+	copy(sorted, claims)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ClaimMeta().Older(sorted[j].ClaimMeta())
+	})
+	return sorted
+}
+
+// Conflict is a claim that was not loaded as an older claim holds the same id
+type Conflict[C Claim] struct {
+	Claim  C
+	Winner C
+}
+
+// Report is the result of a Reconcile of the claims C on a table with entries E
+type Report[C Claim, E any] struct {
+	// Loaded are the claims that are claimed in the table
+	Loaded []C
+	// Conflicts are the claims that lost against an older claim
+	Conflicts []Conflict[C]
+	// OutOfRange are the claims with an id that does not fit in the table
+	OutOfRange []C
+	// Pending are the claims that overlap a stale entry, they are loaded by a
+	// next Reconcile once the stale entries are released
+	Pending []C
+	// Stale are the entries of the table that are not claimed by any claim.
+	// They are not released by Reconcile.
+	Stale []E
+}
+
+// HasIssues returns true when not all claims are loaded or stale entries exist
+func (r *Report[C, E]) HasIssues() bool {
+	return len(r.Conflicts) != 0 || len(r.OutOfRange) != 0 || len(r.Pending) != 0 || len(r.Stale) != 0
+}
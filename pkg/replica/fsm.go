@@ -0,0 +1,178 @@
+package replica
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Op is the mutation of a command
+type Op string
+
+const (
+	OpClaim      Op = "claim"
+	OpClaimFree  Op = "claimFree"
+	OpClaimRange Op = "claimRange"
+	OpRelease    Op = "release"
+)
+
+// command is a mutation of the pool in the raft log
+type command struct {
+	Op Op `json:"op"`
+	// ID is the id of a claim or a release, or the range of a range claim
+	ID     string     `json:"id,omitempty"`
+	Labels labels.Set `json:"labels,omitempty"`
+	// Time is the time of the mutation on the leader, so every replica
+	// stamps the same metadata
+	Time time.Time `json:"time"`
+}
+
+// result is the response of the fsm to an applied command
+type result struct {
+	entries []pool.Entry
+	err     error
+}
+
+// fsm applies the commands of the raft log to the pool. Every replica applies
+// the same commands in the same order at the time of the command, so e.g. a
+// ClaimFree claims the same id with the same metadata on all of them.
+type fsm struct {
+	spec *v1alpha1.Pool
+
+	// m protects the pool against the reads while a command is applied or a
+	// snapshot is restored
+	m    sync.RWMutex
+	pool pool.Pool
+	// index is the log index of the last applied command
+	index uint64
+	// now is the time of the command being applied, the clock of the pool
+	now time.Time
+}
+
+func newFSM(spec *v1alpha1.Pool) (*fsm, error) {
+	p, err := pool.New(spec)
+	if err != nil {
+		return nil, err
+	}
+	r := &fsm{spec: spec.DeepCopy(), pool: p}
+	p.SetClock(r.clock)
+	return r, nil
+}
+
+// clock returns the time of the command being applied; the caller holds the
+// lock. Commands of a leader that did not stamp them are applied now.
+func (r *fsm) clock() time.Time {
+	if r.now.IsZero() {
+		return time.Now()
+	}
+	return r.now
+}
+
+func (r *fsm) Apply(log *raft.Log) any {
+	c := command{}
+	if err := json.Unmarshal(log.Data, &c); err != nil {
+		return &result{err: fmt.Errorf("invalid command at index %d, err: %s", log.Index, err.Error())}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.index = log.Index
+	r.now = c.Time
+	switch c.Op {
+	case OpClaim:
+		e, err := r.pool.Claim(c.ID, c.Labels)
+		return newResult(err, e)
+	case OpClaimFree:
+		e, err := r.pool.ClaimFree(c.Labels)
+		return newResult(err, e)
+	case OpClaimRange:
+		entries, err := r.pool.ClaimRange(c.ID, c.Labels)
+		return newResult(err, entries...)
+	case OpRelease:
+		e, err := r.pool.Release(c.ID)
+		return newResult(err, e)
+	default:
+		return &result{err: fmt.Errorf("unsupported op %q at index %d", c.Op, log.Index)}
+	}
+}
+
+func newResult(err error, entries ...pool.Entry) *result {
+	if err != nil {
+		return &result{err: err}
+	}
+	return &result{entries: entries}
+}
+
+// Snapshot is never called concurrently with Apply, so the entries are
+// collected here and written by Persist.
+func (r *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	s, err := pool.TakeSnapshot(r.spec, r.pool)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{Index: r.index, Pool: s}, nil
+}
+
+func (r *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	s := &snapshot{}
+	if err := json.NewDecoder(rc).Decode(s); err != nil {
+		return err
+	}
+	if s.Pool == nil {
+		return fmt.Errorf("snapshot at index %d without a pool", s.Index)
+	}
+	p, err := pool.Restore(s.Pool)
+	if err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	p.SetClock(r.clock)
+	r.pool = p
+	r.index = s.Index
+	return nil
+}
+
+// appliedIndex returns the log index of the last applied command
+func (r *fsm) appliedIndex() uint64 {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.index
+}
+
+// read calls fn with the pool, without a command being applied concurrently
+func (r *fsm) read(fn func(p pool.Pool)) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	fn(r.pool)
+}
+
+// snapshot is the persisted state of the fsm
+type snapshot struct {
+	Index uint64         `json:"index"`
+	Pool  *pool.Snapshot `json:"pool"`
+}
+
+func (r *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(r); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (r *snapshot) Release() {}
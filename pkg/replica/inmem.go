@@ -0,0 +1,135 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+)
+
+// InmemCluster is a cluster of replicas in a single process, connected by an
+// in-memory transport, e.g. to test the replication with partitions.
+type InmemCluster struct {
+	Nodes      []*Node
+	transports []*raft.InmemTransport
+	// isolated are the replicas of the partition
+	isolated map[int]bool
+}
+
+// NewInmemCluster starts a cluster of n replicas of the pool built from spec,
+// with raft timeouts suited to an in-memory transport
+func NewInmemCluster(spec *v1alpha1.Pool, n int) (*InmemCluster, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("cluster of %d replicas", n)
+	}
+	c := &InmemCluster{}
+	servers := make([]raft.Server, 0, n)
+	for i := 0; i < n; i++ {
+		addr, trans := raft.NewInmemTransport("")
+		c.transports = append(c.transports, trans)
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(fmt.Sprintf("replica-%d", i)),
+			Address: addr,
+		})
+	}
+	c.Heal()
+
+	for i, server := range servers {
+		node, err := New(spec, Config{
+			Raft:         inmemConfig(server.ID),
+			Transport:    c.transports[i],
+			ApplyTimeout: time.Second,
+		})
+		if err != nil {
+			c.Shutdown()
+			return nil, err
+		}
+		c.Nodes = append(c.Nodes, node)
+		if err := node.Bootstrap(servers); err != nil {
+			c.Shutdown()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func inmemConfig(id raft.ServerID) *raft.Config {
+	cfg := raft.DefaultConfig()
+	cfg.LocalID = id
+	cfg.HeartbeatTimeout = 50 * time.Millisecond
+	cfg.ElectionTimeout = 50 * time.Millisecond
+	cfg.LeaderLeaseTimeout = 50 * time.Millisecond
+	cfg.CommitTimeout = 5 * time.Millisecond
+	cfg.LogOutput = io.Discard
+	return cfg
+}
+
+// Leader waits until one of the connected replicas is the leader; during a
+// partition the replicas of the minority do not count.
+func (r *InmemCluster) Leader(ctx context.Context) (*Node, error) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		for i, node := range r.Nodes {
+			if node.IsLeader() && r.majority(i) {
+				return node, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(errors.New("no leader elected"), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// majority returns true when replica i is connected to a majority of the
+// replicas, itself included
+func (r *InmemCluster) majority(i int) bool {
+	connected := 0
+	for j := range r.transports {
+		if r.isolated[i] == r.isolated[j] {
+			connected++
+		}
+	}
+	return connected > len(r.transports)/2
+}
+
+// Partition disconnects the replicas with the given indexes from the other
+// replicas; the replicas of the partition stay connected to each other.
+func (r *InmemCluster) Partition(indexes ...int) {
+	r.isolated = map[int]bool{}
+	for _, i := range indexes {
+		r.isolated[i] = true
+	}
+	for i := range r.transports {
+		for j := range r.transports {
+			if r.isolated[i] != r.isolated[j] {
+				r.transports[i].Disconnect(r.transports[j].LocalAddr())
+			}
+		}
+	}
+}
+
+// Heal connects all replicas to each other
+func (r *InmemCluster) Heal() {
+	r.isolated = map[int]bool{}
+	for i := range r.transports {
+		for j := range r.transports {
+			if i != j {
+				r.transports[i].Connect(r.transports[j].LocalAddr(), r.transports[j])
+			}
+		}
+	}
+}
+
+// Shutdown stops all replicas
+func (r *InmemCluster) Shutdown() {
+	for _, node := range r.Nodes {
+		node.Shutdown()
+	}
+}
@@ -0,0 +1,231 @@
+// Package replica replicates a pool over several controller replicas with a
+// Raft log. The mutations are applied on the leader once they are committed
+// by a majority of the replicas, so claims are linearizable; the reads are
+// served from the local state of every replica, which may lag behind the
+// leader.
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ErrNotLeader is returned when a mutation or a linearizable read is sent to
+// a replica that is not the leader
+var ErrNotLeader = errors.New("not the leader")
+
+// Config is the raft setup of a replica
+type Config struct {
+	// Raft is the raft config, with at least the LocalID of the replica
+	Raft      *raft.Config
+	Transport raft.Transport
+	// LogStore, StableStore and SnapshotStore default to in-memory stores
+	LogStore      raft.LogStore
+	StableStore   raft.StableStore
+	SnapshotStore raft.SnapshotStore
+	// ApplyTimeout bounds the time to enqueue a mutation; 0 is no limit
+	ApplyTimeout time.Duration
+}
+
+// Node is a replica of a pool. It implements pool.Pool: the mutations are
+// only accepted by the leader and return ErrNotLeader on the followers.
+type Node struct {
+	id      raft.ServerID
+	kind    v1alpha1.PoolKind
+	raft    *raft.Raft
+	fsm     *fsm
+	timeout time.Duration
+	// clock stamps the mutations sent by the leader
+	clock tree.Clock
+}
+
+// New returns a replica of the pool built from spec. A new cluster is started
+// by Bootstrap on one of the replicas.
+func New(spec *v1alpha1.Pool, cfg Config) (*Node, error) {
+	if cfg.Raft == nil || cfg.Transport == nil {
+		return nil, fmt.Errorf("replica of pool %s without a raft config or transport", spec.Name)
+	}
+	if cfg.LogStore == nil || cfg.StableStore == nil {
+		store := raft.NewInmemStore()
+		if cfg.LogStore == nil {
+			cfg.LogStore = store
+		}
+		if cfg.StableStore == nil {
+			cfg.StableStore = store
+		}
+	}
+	if cfg.SnapshotStore == nil {
+		cfg.SnapshotStore = raft.NewInmemSnapshotStore()
+	}
+
+	f, err := newFSM(spec)
+	if err != nil {
+		return nil, err
+	}
+	r, err := raft.NewRaft(cfg.Raft, f, cfg.LogStore, cfg.StableStore, cfg.SnapshotStore, cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{
+		id:      cfg.Raft.LocalID,
+		kind:    spec.Spec.Kind,
+		raft:    r,
+		fsm:     f,
+		timeout: cfg.ApplyTimeout,
+	}, nil
+}
+
+// Bootstrap starts a new cluster with the servers; it fails when the replica
+// already has state.
+func (r *Node) Bootstrap(servers []raft.Server) error {
+	return r.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+}
+
+func (r *Node) ID() raft.ServerID { return r.id }
+
+func (r *Node) IsLeader() bool {
+	return r.raft.State() == raft.Leader
+}
+
+// Leader returns the address of the leader known by the replica; it is empty
+// when there is no leader
+func (r *Node) Leader() raft.ServerAddress {
+	addr, _ := r.raft.LeaderWithID()
+	return addr
+}
+
+// AppliedIndex returns the log index of the last mutation applied to the
+// local state
+func (r *Node) AppliedIndex() uint64 {
+	return r.fsm.appliedIndex()
+}
+
+// WaitApplied waits until the local state includes the mutations up to index,
+// e.g. the AppliedIndex of the leader after a claim, so the reads of a
+// follower see the claim.
+func (r *Node) WaitApplied(ctx context.Context, index uint64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for r.fsm.appliedIndex() < index {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Sync makes the next reads of the leader linearizable: it returns once the
+// replica confirmed it is still the leader and applied all committed
+// mutations.
+func (r *Node) Sync() error {
+	if err := r.raft.VerifyLeader().Error(); err != nil {
+		return r.leaderError(err)
+	}
+	return r.leaderError(r.raft.Barrier(r.timeout).Error())
+}
+
+// Shutdown stops the replica
+func (r *Node) Shutdown() error {
+	return r.raft.Shutdown().Error()
+}
+
+func (r *Node) Kind() v1alpha1.PoolKind { return r.kind }
+
+// SetClock sets the clock the leader stamps the mutations with; the replicas
+// apply a mutation at the time of the leader. It is not safe to call
+// concurrently with the other methods.
+func (r *Node) SetClock(clock tree.Clock) { r.clock = clock }
+
+func (r *Node) Claim(id string, labels labels.Set) (pool.Entry, error) {
+	entries, err := r.apply(command{Op: OpClaim, ID: id, Labels: labels})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return entries[0], nil
+}
+
+func (r *Node) ClaimFree(labels labels.Set) (pool.Entry, error) {
+	entries, err := r.apply(command{Op: OpClaimFree, Labels: labels})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return entries[0], nil
+}
+
+func (r *Node) ClaimRange(s string, labels labels.Set) ([]pool.Entry, error) {
+	return r.apply(command{Op: OpClaimRange, ID: s, Labels: labels})
+}
+
+func (r *Node) Release(id string) (pool.Entry, error) {
+	entries, err := r.apply(command{Op: OpRelease, ID: id})
+	if err != nil {
+		return pool.Entry{}, err
+	}
+	return entries[0], nil
+}
+
+// apply commits the command to the raft log and returns the result of the
+// command on the leader
+func (r *Node) apply(c command) ([]pool.Entry, error) {
+	if !r.IsLeader() {
+		return nil, r.leaderError(raft.ErrNotLeader)
+	}
+	c.Time = r.clock.Now()
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	f := r.raft.Apply(b, r.timeout)
+	if err := f.Error(); err != nil {
+		return nil, r.leaderError(err)
+	}
+	res := f.Response().(*result)
+	return res.entries, res.err
+}
+
+// leaderError returns ErrNotLeader with the known leader for the raft errors
+// of a replica that is not the leader. When the leadership is lost after a
+// mutation is sent, the mutation may still be committed by the next leader.
+func (r *Node) leaderError(err error) error {
+	if errors.Is(err, raft.ErrNotLeader) {
+		return fmt.Errorf("%w: replica %s, leader %q", ErrNotLeader, r.id, r.Leader())
+	}
+	return err
+}
+
+func (r *Node) Get(id string) (e pool.Entry, err error) {
+	r.fsm.read(func(p pool.Pool) { e, err = p.Get(id) })
+	return e, err
+}
+
+func (r *Node) List(selector labels.Selector) (entries []pool.Entry) {
+	r.fsm.read(func(p pool.Pool) { entries = p.List(selector) })
+	return entries
+}
+
+func (r *Node) Stats() (stats pool.Stats) {
+	r.fsm.read(func(p pool.Pool) { stats = p.Stats() })
+	return stats
+}
+
+func (r *Node) FreeRanges() (ranges []string) {
+	r.fsm.read(func(p pool.Pool) { ranges = p.FreeRanges() })
+	return ranges
+}
+
+func (r *Node) Render(w io.Writer) (err error) {
+	r.fsm.read(func(p pool.Pool) { err = p.Render(w) })
+	return err
+}
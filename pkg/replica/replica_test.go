@@ -0,0 +1,173 @@
+package replica
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/tj/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newSpec(name string, kind v1alpha1.PoolKind, ranges ...string) *v1alpha1.Pool {
+	return &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1alpha1.PoolSpec{Kind: kind, Ranges: ranges},
+	}
+}
+
+func newCluster(t *testing.T, spec *v1alpha1.Pool, n int) (*InmemCluster, *Node) {
+	c, err := NewInmemCluster(spec, n)
+	assert.NoError(t, err)
+	t.Cleanup(c.Shutdown)
+	leader := waitLeader(t, c)
+	return c, leader
+}
+
+func waitLeader(t *testing.T, c *InmemCluster) *Node {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	leader, err := c.Leader(ctx)
+	assert.NoError(t, err)
+	return leader
+}
+
+// waitApplied waits until all nodes applied the mutations of the leader
+func waitApplied(t *testing.T, leader *Node, nodes ...*Node) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, node := range nodes {
+		assert.NoError(t, node.WaitApplied(ctx, leader.AppliedIndex()))
+	}
+}
+
+func TestReplication(t *testing.T) {
+	cases := map[string]struct {
+		spec       *v1alpha1.Pool
+		claim      string
+		claimRange string
+		free       string
+	}{
+		"VLAN": {
+			spec:       newSpec("vlan", v1alpha1.PoolKindVLAN, "100-199"),
+			claim:      "100",
+			claimRange: "101-102",
+			free:       "103",
+		},
+		"Label": {
+			spec:       newSpec("label", v1alpha1.PoolKindLabel, "16-1048575"),
+			claim:      "16",
+			claimRange: "4096/20",
+			free:       "17",
+		},
+		"IPv4": {
+			spec:       newSpec("ipv4", v1alpha1.PoolKindIPv4, "10.0.0.0/24"),
+			claim:      "10.0.0.0",
+			claimRange: "10.0.0.1-10.0.0.2",
+			free:       "10.0.0.3",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c, leader := newCluster(t, tc.spec, 3)
+			l := labels.Set{"tenant": "a"}
+
+			_, err := leader.Claim(tc.claim, l)
+			assert.NoError(t, err)
+			_, err = leader.Claim(tc.claim, l)
+			assert.True(t, errors.Is(err, pool.ErrExists))
+			_, err = leader.ClaimRange(tc.claimRange, l)
+			assert.NoError(t, err)
+			e, err := leader.ClaimFree(l)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.free, e.ID)
+
+			// the followers serve the reads and refuse the mutations; they
+			// apply the changes at the time of the leader, so the entries
+			// have the same metadata
+			waitApplied(t, leader, c.Nodes...)
+			want, err := leader.fsm.Snapshot()
+			assert.NoError(t, err)
+			for _, node := range c.Nodes {
+				assert.Equal(t, leader.List(labels.Everything()), node.List(labels.Everything()))
+				assert.Equal(t, leader.Stats(), node.Stats())
+				got, err := node.fsm.Snapshot()
+				assert.NoError(t, err)
+				assert.Equal(t, want.(*snapshot).Pool.Entries, got.(*snapshot).Pool.Entries)
+				if node == leader {
+					continue
+				}
+				_, err = node.ClaimFree(l)
+				assert.True(t, errors.Is(err, ErrNotLeader))
+				assert.Error(t, node.Sync())
+			}
+
+			_, err = leader.Release(tc.claim)
+			assert.NoError(t, err)
+			assert.NoError(t, leader.Sync())
+			_, err = leader.Get(tc.claim)
+			assert.True(t, errors.Is(err, pool.ErrNotFound))
+		})
+	}
+}
+
+func TestPartition(t *testing.T) {
+	c, leader := newCluster(t, newSpec("vlan", v1alpha1.PoolKindVLAN, "100-199"), 3)
+	_, err := leader.Claim("100", labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+
+	// the isolated leader cannot commit, the majority elects a new leader
+	old := 0
+	for i, node := range c.Nodes {
+		if node == leader {
+			old = i
+		}
+	}
+	c.Partition(old)
+	_, err = leader.Claim("101", labels.Set{"owner": "a"})
+	assert.Error(t, err)
+	newLeader := waitLeader(t, c)
+	assert.NotEqual(t, leader.ID(), newLeader.ID())
+	e, err := newLeader.ClaimFree(labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "101", e.ID)
+
+	// the old leader catches up after the partition heals
+	c.Heal()
+	waitApplied(t, newLeader, leader)
+	got, err := leader.Get("101")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got.Labels["owner"])
+	assert.Equal(t, uint64(2), leader.Stats().Claimed)
+}
+
+func TestSnapshot(t *testing.T) {
+	spec := newSpec("label", v1alpha1.PoolKindLabel, "16-1048575")
+	c, leader := newCluster(t, spec, 1)
+	_, err := leader.ClaimRange("4096/20", labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+	assert.NoError(t, c.Nodes[0].raft.Snapshot().Error())
+
+	// a new replica restores the entries claimed after the pool was built
+	f, err := newFSM(spec)
+	assert.NoError(t, err)
+	snapshot, err := leader.fsm.Snapshot()
+	assert.NoError(t, err)
+	snapshots := raft.NewInmemSnapshotStore()
+	sink, err := snapshots.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 1, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, snapshot.Persist(sink))
+	_, rc, err := snapshots.Open(sink.ID())
+	assert.NoError(t, err)
+	assert.NoError(t, f.Restore(rc))
+	assert.Equal(t, leader.AppliedIndex(), f.appliedIndex())
+
+	f.read(func(p pool.Pool) {
+		assert.Equal(t, leader.List(labels.Everything()), p.List(labels.Everything()))
+	})
+}
@@ -0,0 +1,229 @@
+// Package rest implements a REST/JSON front-end for the pools of a
+// pool.Registry.
+//
+//	GET    /pools                      list the pool names
+//	PUT    /pools/{name}               create a pool from a v1alpha1.PoolSpec
+//	DELETE /pools/{name}               delete a pool
+//	GET    /pools/{name}/stats         utilization of the pool
+//	GET    /pools/{name}/entries       list the entries, filtered by ?selector=
+//	POST   /pools/{name}/entries       claim a free value
+//	POST   /pools/{name}/ranges        claim a range
+//	GET    /pools/{name}/entries/{id}  get an entry
+//	PUT    /pools/{name}/entries/{id}  claim an id
+//	DELETE /pools/{name}/entries/{id}  release an id
+//
+// Ids of label pools may be prefixes, e.g. /pools/labels/entries/4096/20.
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ClaimRequest is the body of the claim requests
+type ClaimRequest struct {
+	// Range is only used to claim a range
+	Range  string     `json:"range,omitempty"`
+	Labels labels.Set `json:"labels,omitempty"`
+}
+
+// ErrorResponse is the body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	registry *pool.Registry
+}
+
+// NewHandler returns the handler serving the pools of the registry
+func NewHandler(registry *pool.Registry) http.Handler {
+	r := &handler{registry: registry}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pools", r.listPools)
+	mux.HandleFunc("PUT /pools/{name}", r.createPool)
+	mux.HandleFunc("DELETE /pools/{name}", r.deletePool)
+	mux.HandleFunc("GET /pools/{name}/stats", r.stats)
+	mux.HandleFunc("GET /pools/{name}/entries", r.list)
+	mux.HandleFunc("POST /pools/{name}/entries", r.claimFree)
+	mux.HandleFunc("POST /pools/{name}/ranges", r.claimRange)
+	mux.HandleFunc("GET /pools/{name}/entries/{id...}", r.get)
+	mux.HandleFunc("PUT /pools/{name}/entries/{id...}", r.claim)
+	mux.HandleFunc("DELETE /pools/{name}/entries/{id...}", r.release)
+	return mux
+}
+
+func (r *handler) listPools(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, r.registry.List())
+}
+
+func (r *handler) createPool(w http.ResponseWriter, req *http.Request) {
+	spec := v1alpha1.Pool{ObjectMeta: metav1.ObjectMeta{Name: req.PathValue("name")}}
+	if err := json.NewDecoder(req.Body).Decode(&spec.Spec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := r.registry.Create(&spec); err != nil {
+		writePoolError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *handler) deletePool(w http.ResponseWriter, req *http.Request) {
+	if err := r.registry.Delete(req.PathValue("name")); err != nil {
+		writePoolError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *handler) stats(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Stats())
+}
+
+func (r *handler) list(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	selector, err := labels.Parse(req.URL.Query().Get("selector"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.List(selector))
+}
+
+func (r *handler) claimFree(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	claimReq, ok := decodeClaimRequest(w, req)
+	if !ok {
+		return
+	}
+	e, err := p.ClaimFree(claimReq.Labels)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (r *handler) claimRange(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	claimReq, ok := decodeClaimRequest(w, req)
+	if !ok {
+		return
+	}
+	entries, err := p.ClaimRange(claimReq.Range, claimReq.Labels)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, entries)
+}
+
+func (r *handler) get(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	e, err := p.Get(req.PathValue("id"))
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (r *handler) claim(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	claimReq, ok := decodeClaimRequest(w, req)
+	if !ok {
+		return
+	}
+	e, err := p.Claim(req.PathValue("id"), claimReq.Labels)
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (r *handler) release(w http.ResponseWriter, req *http.Request) {
+	p, ok := r.pool(w, req)
+	if !ok {
+		return
+	}
+	e, err := p.Release(req.PathValue("id"))
+	if err != nil {
+		writePoolError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// pool returns the pool of the request, or writes the error when it does not exist
+func (r *handler) pool(w http.ResponseWriter, req *http.Request) (pool.Pool, bool) {
+	p, err := r.registry.Get(req.PathValue("name"))
+	if err != nil {
+		writePoolError(w, err)
+		return nil, false
+	}
+	return p, true
+}
+
+// decodeClaimRequest decodes the optional body of a claim request
+func decodeClaimRequest(w http.ResponseWriter, req *http.Request) (ClaimRequest, bool) {
+	var claimReq ClaimRequest
+	if err := json.NewDecoder(req.Body).Decode(&claimReq); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return claimReq, false
+	}
+	return claimReq, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
+
+// writePoolError maps the pool errors to http status codes
+func writePoolError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, pool.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, pool.ErrExists), errors.Is(err, pool.ErrExhausted):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, pool.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/tj/assert"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	var v T
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&v))
+	return v
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(pool.NewRegistry())
+
+	rec := do(t, h, http.MethodPut, "/pools/labels", `{"kind":"label","ranges":["16-1048575"]}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = do(t, h, http.MethodPut, "/pools/labels", `{"kind":"label","ranges":["16-1048575"]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = do(t, h, http.MethodPut, "/pools/bad", `{"kind":"esi","ranges":["1-10"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(t, h, http.MethodGet, "/pools", "")
+	assert.Equal(t, []string{"labels"}, decode[[]string](t, rec))
+
+	cases := map[string]struct {
+		method       string
+		target       string
+		body         string
+		expectedCode int
+		expected     any
+	}{
+		"ClaimFree": {
+			method:       http.MethodPost,
+			target:       "/pools/labels/entries",
+			body:         `{"labels":{"site":"a"}}`,
+			expectedCode: http.StatusCreated,
+			expected:     pool.Entry{ID: "16", Labels: labels.Set{"site": "a"}},
+		},
+		"ClaimPrefix": {
+			method:       http.MethodPut,
+			target:       "/pools/labels/entries/4096/24",
+			body:         `{"labels":{"site":"b"}}`,
+			expectedCode: http.StatusCreated,
+			expected:     pool.Entry{ID: "4096/24", Labels: labels.Set{"site": "b"}},
+		},
+		"ClaimRange": {
+			method:       http.MethodPost,
+			target:       "/pools/labels/ranges",
+			body:         `{"range":"100-101","labels":{"site":"a"}}`,
+			expectedCode: http.StatusCreated,
+			expected:     []pool.Entry{{ID: "100/31", Labels: labels.Set{"site": "a"}}},
+		},
+		"ClaimWithoutBody": {
+			method:       http.MethodPut,
+			target:       "/pools/labels/entries/200",
+			expectedCode: http.StatusCreated,
+			expected:     pool.Entry{ID: "200"},
+		},
+		"ClaimClaimed": {
+			method:       http.MethodPut,
+			target:       "/pools/labels/entries/4100",
+			expectedCode: http.StatusConflict,
+		},
+		"ClaimOutOfPool": {
+			method:       http.MethodPut,
+			target:       "/pools/labels/entries/15",
+			expectedCode: http.StatusBadRequest,
+		},
+		"GetCovered": {
+			method:       http.MethodGet,
+			target:       "/pools/labels/entries/4100",
+			expectedCode: http.StatusOK,
+			expected:     pool.Entry{ID: "4096/24", Labels: labels.Set{"site": "b"}},
+		},
+		"List": {
+			method:       http.MethodGet,
+			target:       "/pools/labels/entries?selector=" + url.QueryEscape("site=a"),
+			expectedCode: http.StatusOK,
+			expected: []pool.Entry{
+				{ID: "16", Labels: labels.Set{"site": "a"}},
+				{ID: "100/31", Labels: labels.Set{"site": "a"}},
+			},
+		},
+		"ListBadSelector": {
+			method:       http.MethodGet,
+			target:       "/pools/labels/entries?selector=" + url.QueryEscape("site in (a"),
+			expectedCode: http.StatusBadRequest,
+		},
+		"Release": {
+			method:       http.MethodDelete,
+			target:       "/pools/labels/entries/16",
+			expectedCode: http.StatusOK,
+			expected:     pool.Entry{ID: "16", Labels: labels.Set{"site": "a"}},
+		},
+		"GetReleased": {
+			method:       http.MethodGet,
+			target:       "/pools/labels/entries/16",
+			expectedCode: http.StatusNotFound,
+		},
+		"Stats": {
+			method:       http.MethodGet,
+			target:       "/pools/labels/stats",
+			expectedCode: http.StatusOK,
+			expected:     pool.Stats{Capacity: 1048560, Claimed: 259, Free: 1048301},
+		},
+		"UnknownPool": {
+			method:       http.MethodGet,
+			target:       "/pools/unknown/entries",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	// the cases build on each other
+	for _, name := range []string{
+		"ClaimFree", "ClaimPrefix", "ClaimRange", "ClaimWithoutBody", "ClaimClaimed", "ClaimOutOfPool",
+		"GetCovered", "List", "ListBadSelector", "Release", "GetReleased", "Stats", "UnknownPool",
+	} {
+		tc := cases[name]
+		t.Run(name, func(t *testing.T) {
+			rec := do(t, h, tc.method, tc.target, tc.body)
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+			switch expected := tc.expected.(type) {
+			case pool.Entry:
+				assert.Equal(t, expected, decode[pool.Entry](t, rec))
+			case []pool.Entry:
+				assert.Equal(t, expected, decode[[]pool.Entry](t, rec))
+			case pool.Stats:
+				assert.Equal(t, expected, decode[pool.Stats](t, rec))
+			case nil:
+				assert.NotEmpty(t, decode[ErrorResponse](t, rec).Error)
+			}
+		})
+	}
+
+	rec = do(t, h, http.MethodDelete, "/pools/labels", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = do(t, h, http.MethodDelete, "/pools/labels", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,5 @@
+// Package allocpb holds the protobuf messages and the gRPC service of the
+// allocation server, generated from allocation.proto.
+package allocpb
+
+//go:generate buf generate
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/henderiw/idxtable/pkg/pool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufSize = 1024 * 1024
+
+// NewInProcess serves the registry over an in-memory connection and returns a
+// client connection to it, so tests and embedding processes can use the
+// service without a network. stop closes the connection and the server.
+func NewInProcess(registry *pool.Registry) (conn *grpc.ClientConn, stop func(), err error) {
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	New(registry).Register(s)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	conn, err = grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		s.Stop()
+		return nil, nil, err
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}, nil
+}
@@ -0,0 +1,201 @@
+// Package server implements the gRPC allocation service on top of a
+// pool.Registry.
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/henderiw/idxtable/pkg/api/v1alpha1"
+	"github.com/henderiw/idxtable/pkg/pool"
+	"github.com/henderiw/idxtable/pkg/server/allocpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Server struct {
+	allocpb.UnimplementedAllocationServer
+	registry *pool.Registry
+}
+
+func New(registry *pool.Registry) *Server {
+	return &Server{registry: registry}
+}
+
+// Register registers the allocation service on the grpc server
+func (r *Server) Register(s *grpc.Server) {
+	allocpb.RegisterAllocationServer(s, r)
+}
+
+func (r *Server) CreatePool(ctx context.Context, req *allocpb.CreatePoolRequest) (*allocpb.CreatePoolResponse, error) {
+	spec := &v1alpha1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: req.GetName()},
+		Spec: v1alpha1.PoolSpec{
+			Kind:   v1alpha1.PoolKind(req.GetKind()),
+			Ranges: req.GetRanges(),
+		},
+	}
+	for _, reservation := range req.GetReservations() {
+		spec.Spec.Reservations = append(spec.Spec.Reservations, v1alpha1.Reservation{
+			Range:  reservation.GetRange(),
+			Labels: reservation.GetLabels(),
+		})
+	}
+	if _, err := r.registry.Create(spec); err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.CreatePoolResponse{}, nil
+}
+
+func (r *Server) DeletePool(ctx context.Context, req *allocpb.DeletePoolRequest) (*allocpb.DeletePoolResponse, error) {
+	if err := r.registry.Delete(req.GetName()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.DeletePoolResponse{}, nil
+}
+
+func (r *Server) ListPools(ctx context.Context, req *allocpb.ListPoolsRequest) (*allocpb.ListPoolsResponse, error) {
+	return &allocpb.ListPoolsResponse{Names: r.registry.List()}, nil
+}
+
+func (r *Server) Claim(ctx context.Context, req *allocpb.ClaimRequest) (*allocpb.ClaimResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	e, err := p.Claim(req.GetId(), req.GetLabels())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.ClaimResponse{Entry: toEntry(e)}, nil
+}
+
+func (r *Server) ClaimFree(ctx context.Context, req *allocpb.ClaimFreeRequest) (*allocpb.ClaimFreeResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	e, err := p.ClaimFree(req.GetLabels())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.ClaimFreeResponse{Entry: toEntry(e)}, nil
+}
+
+func (r *Server) ClaimRange(ctx context.Context, req *allocpb.ClaimRangeRequest) (*allocpb.ClaimRangeResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	entries, err := p.ClaimRange(req.GetRange(), req.GetLabels())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.ClaimRangeResponse{Entries: toEntries(entries)}, nil
+}
+
+func (r *Server) Release(ctx context.Context, req *allocpb.ReleaseRequest) (*allocpb.ReleaseResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	e, err := p.Release(req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.ReleaseResponse{Entry: toEntry(e)}, nil
+}
+
+func (r *Server) Get(ctx context.Context, req *allocpb.GetRequest) (*allocpb.GetResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	e, err := p.Get(req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &allocpb.GetResponse{Entry: toEntry(e)}, nil
+}
+
+func (r *Server) List(ctx context.Context, req *allocpb.ListRequest) (*allocpb.ListResponse, error) {
+	p, err := r.registry.Get(req.GetPool())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	selector, err := labels.Parse(req.GetSelector())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &allocpb.ListResponse{Entries: toEntries(p.List(selector))}, nil
+}
+
+func (r *Server) Watch(req *allocpb.WatchRequest, stream allocpb.Allocation_WatchServer) error {
+	selector, err := labels.Parse(req.GetSelector())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	ch, err := r.registry.Watch(stream.Context(), req.GetPool(), selector)
+	if err != nil {
+		return toStatus(err)
+	}
+	// the header tells the client the watch is established
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for event := range ch {
+		if err := stream.Send(&allocpb.WatchEvent{
+			Type:  toEventType(event.Type),
+			Entry: toEntry(event.Entry),
+		}); err != nil {
+			return err
+		}
+	}
+	if err := stream.Context().Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	// the pool was deleted or the watcher fell behind
+	return status.Error(codes.Aborted, "watch closed")
+}
+
+func toEntry(e pool.Entry) *allocpb.Entry {
+	return &allocpb.Entry{Id: e.ID, Labels: e.Labels}
+}
+
+func toEntries(entries []pool.Entry) []*allocpb.Entry {
+	pbEntries := make([]*allocpb.Entry, 0, len(entries))
+	for _, e := range entries {
+		pbEntries = append(pbEntries, toEntry(e))
+	}
+	return pbEntries
+}
+
+func toEventType(t pool.EventType) allocpb.WatchEvent_Type {
+	switch t {
+	case pool.EventClaimed:
+		return allocpb.WatchEvent_TYPE_CLAIMED
+	case pool.EventReleased:
+		return allocpb.WatchEvent_TYPE_RELEASED
+	default:
+		return allocpb.WatchEvent_TYPE_UNSPECIFIED
+	}
+}
+
+// toStatus maps the pool errors to grpc status codes
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, pool.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, pool.ErrExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, pool.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, pool.ErrExhausted):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Bolt is a Storage that keeps the records in a bucket of a bbolt database;
+// the tables of several pools share a database with a bucket per pool.
+type Bolt struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// value is the stored value of a record, the key is the key of the bucket
+type value struct {
+	Labels labels.Set `json:"labels,omitempty"`
+	Meta   tree.Meta  `json:"meta"`
+}
+
+// NewBolt returns the storage of the bucket, which is created when it does
+// not exist. The database is owned by the caller.
+func NewBolt(db *bolt.DB, bucket string) (*Bolt, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bolt storage without a bucket")
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &Bolt{db: db, bucket: []byte(bucket)}, nil
+}
+
+func (r *Bolt) Put(rec Record) error {
+	b, err := json.Marshal(value{Labels: rec.Labels, Meta: rec.Meta})
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).Put([]byte(rec.Key), b)
+	})
+}
+
+func (r *Bolt) Delete(key string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).Delete([]byte(key))
+	})
+}
+
+// Range calls fn for the records in the order of their keys, in a read
+// transaction; fn must not change the storage.
+func (r *Bolt) Range(fn func(rec Record) error) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(k, v []byte) error {
+			val := value{}
+			if err := json.Unmarshal(v, &val); err != nil {
+				return fmt.Errorf("invalid record %s, err: %s", k, err.Error())
+			}
+			return fn(Record{Key: string(k), Labels: val.Labels, Meta: val.Meta})
+		})
+	})
+}
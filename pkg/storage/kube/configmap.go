@@ -0,0 +1,244 @@
+// Package kube stores the records of a pool in Kubernetes objects.
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/storage"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PoolLabelKey is the label key of the pool of a ConfigMap
+const PoolLabelKey = "idxtable.henderiw.io/pool"
+
+const (
+	// DefaultShards is the number of ConfigMaps of a pool when not set; a
+	// ConfigMap holds at most 1MiB, about 10k records.
+	DefaultShards = 16
+	// maxConflicts is the number of conflicting updates of a ConfigMap before
+	// a write fails
+	maxConflicts   = 5
+	requestTimeout = 30 * time.Second
+)
+
+// keyReplacer makes a record key a valid ConfigMap key: the prefixes of a
+// tree have a '/' and the ipv6 addresses a ':'
+var keyReplacer = strings.NewReplacer("/", "_", ":", "-")
+
+// ConfigMaps is a storage.Storage that keeps the records of a pool in the
+// ConfigMaps <pool>-0 to <pool>-<shards-1> of a namespace, a record in the
+// shard of the hash of its key. A write updates the last version of the
+// shard read by the storage with its resourceVersion and retries on a
+// conflict, so several replicas can write to the same pool.
+type ConfigMaps struct {
+	client    kubernetes.Interface
+	namespace string
+	pool      string
+	shards    uint32
+
+	// m serializes the writes and protects the cache
+	m sync.Mutex
+	// cache holds the last version of every shard read or written
+	cache map[string]*corev1.ConfigMap
+}
+
+// NewConfigMaps returns the storage of the pool; shards is the number of
+// ConfigMaps of the pool, DefaultShards when 0. The shards must not change
+// once records are stored.
+func NewConfigMaps(client kubernetes.Interface, namespace, pool string, shards int) (*ConfigMaps, error) {
+	if namespace == "" || pool == "" {
+		return nil, fmt.Errorf("configmap storage without a namespace or pool")
+	}
+	if shards < 0 {
+		return nil, fmt.Errorf("configmap storage of pool %s with %d shards", pool, shards)
+	}
+	if shards == 0 {
+		shards = DefaultShards
+	}
+	return &ConfigMaps{
+		client:    client,
+		namespace: namespace,
+		pool:      pool,
+		shards:    uint32(shards),
+		cache:     map[string]*corev1.ConfigMap{},
+	}, nil
+}
+
+// Put writes the record over the version of the key known to the storage.
+// When another replica changed the record of the key since, the write fails
+// with storage.ErrConflict instead of overwriting it, unless the record is
+// the same.
+func (r *ConfigMaps) Put(rec storage.Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	key := keyReplacer.Replace(rec.Key)
+	read := false
+	var known string
+	var exists bool
+	return r.modify(rec.Key, func(data map[string]string) (bool, error) {
+		v, ok := data[key]
+		if !read {
+			// the first attempt is made on the version known to the storage
+			read, known, exists = true, v, ok
+		}
+		switch {
+		case ok && v == string(b):
+			return false, nil
+		case ok != exists || v != known:
+			return false, fmt.Errorf("%w: record %s in configmap %s/%s was changed by another replica", storage.ErrConflict, rec.Key, r.namespace, r.shard(rec.Key))
+		}
+		data[key] = string(b)
+		return true, nil
+	})
+}
+
+func (r *ConfigMaps) Delete(key string) error {
+	return r.modify(key, func(data map[string]string) (bool, error) {
+		if _, ok := data[keyReplacer.Replace(key)]; !ok {
+			return false, nil
+		}
+		delete(data, keyReplacer.Replace(key))
+		return true, nil
+	})
+}
+
+// Range lists the shards of the pool and calls fn for their records in the
+// order of the keys.
+func (r *ConfigMaps) Range(fn func(rec storage.Record) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	list, err := r.client.CoreV1().ConfigMaps(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{PoolLabelKey: r.pool}).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	records := []storage.Record{}
+	r.m.Lock()
+	for i := range list.Items {
+		cm := &list.Items[i]
+		r.cache[cm.Name] = cm
+		for k, v := range cm.Data {
+			rec := storage.Record{}
+			if err := json.Unmarshal([]byte(v), &rec); err != nil {
+				r.m.Unlock()
+				return fmt.Errorf("invalid record %s in configmap %s/%s, err: %s", k, r.namespace, cm.Name, err.Error())
+			}
+			records = append(records, rec)
+		}
+	}
+	r.m.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shard returns the name of the ConfigMap of key
+func (r *ConfigMaps) shard(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%s-%d", r.pool, h.Sum32()%r.shards)
+}
+
+// modify applies fn to the data of the shard of key; fn returns false when
+// the data is not changed, or an error to fail the write. The shard is
+// created when it does not exist.
+func (r *ConfigMaps) modify(key string, fn func(data map[string]string) (bool, error)) error {
+	name := r.shard(key)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	client := r.client.CoreV1().ConfigMaps(r.namespace)
+	for i := 0; i < maxConflicts; i++ {
+		cm, err := r.get(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if cm == nil {
+			data := map[string]string{}
+			if changed, err := fn(data); !changed || err != nil {
+				return err
+			}
+			created, err := client.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: r.namespace,
+					Labels:    map[string]string{PoolLabelKey: r.pool},
+				},
+				Data: data,
+			}, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// created by another replica
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			r.cache[name] = created
+			return nil
+		}
+
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if changed, err := fn(cm.Data); !changed || err != nil {
+			return err
+		}
+		updated, err := client.Update(ctx, cm, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			// changed by another replica, read it again
+			delete(r.cache, name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.cache[name] = updated
+		return nil
+	}
+	return fmt.Errorf("write of configmap %s/%s failed after %d conflicts", r.namespace, name, maxConflicts)
+}
+
+// get returns the cached shard, or reads it when it is not cached; it returns
+// nil when the shard does not exist. The caller must hold the lock.
+func (r *ConfigMaps) get(ctx context.Context, name string) (*corev1.ConfigMap, error) {
+	if cm, ok := r.cache[name]; ok {
+		return cm, nil
+	}
+	cm, err := r.client.CoreV1().ConfigMaps(r.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.cache[name] = cm
+	return cm, nil
+}
@@ -0,0 +1,169 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/table/table32"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/id32"
+	"github.com/henderiw/idxtable/pkg/tree/tree32"
+	"github.com/tj/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newClientset returns a fake clientset that sets and checks the
+// resourceVersion of the ConfigMaps like the api server
+func newClientset() *fake.Clientset {
+	cs := fake.NewSimpleClientset()
+	var m sync.Mutex
+	version := 0
+	cs.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		m.Lock()
+		defer m.Unlock()
+		version++
+		action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap).ResourceVersion = strconv.Itoa(version)
+		return false, nil, nil
+	})
+	cs.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		m.Lock()
+		defer m.Unlock()
+		cm := action.(k8stesting.UpdateAction).GetObject().(*corev1.ConfigMap)
+		current, err := cs.Tracker().Get(action.GetResource(), cm.Namespace, cm.Name)
+		if err == nil && current.(*corev1.ConfigMap).ResourceVersion != cm.ResourceVersion {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), cm.Name, nil)
+		}
+		version++
+		cm.ResourceVersion = strconv.Itoa(version)
+		return false, nil, nil
+	})
+	return cs
+}
+
+func records(t *testing.T, s storage.Storage) []storage.Record {
+	records := []storage.Record{}
+	assert.NoError(t, s.Range(func(r storage.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	return records
+}
+
+func TestConfigMaps(t *testing.T) {
+	cs := newClientset()
+	s, err := NewConfigMaps(cs, "default", "vlan", 4)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put(storage.Record{Key: "100", Labels: labels.Set{"owner": "a"}}))
+	assert.NoError(t, s.Put(storage.Record{Key: "4096/20"}))
+	assert.NoError(t, s.Put(storage.Record{Key: "2001:db8::1", Labels: labels.Set{"owner": "b"}}))
+	assert.NoError(t, s.Put(storage.Record{Key: "100", Labels: labels.Set{"owner": "c"}}))
+	assert.NoError(t, s.Delete("2001:db8::1"))
+	assert.NoError(t, s.Delete("101"))
+
+	// the records of other pools are not listed
+	other, err := NewConfigMaps(cs, "default", "label", 4)
+	assert.NoError(t, err)
+	assert.NoError(t, other.Put(storage.Record{Key: "1"}))
+
+	assert.Equal(t, []storage.Record{
+		{Key: "100", Labels: labels.Set{"owner": "c"}},
+		{Key: "4096/20"},
+	}, records(t, s))
+	list, err := cs.CoreV1().ConfigMaps("default").List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	for _, cm := range list.Items {
+		assert.Contains(t, []string{"vlan", "label"}, cm.Labels[PoolLabelKey])
+	}
+}
+
+func TestConfigMapsConflict(t *testing.T) {
+	cs := newClientset()
+	// the replicas write to the same shard
+	a, err := NewConfigMaps(cs, "default", "vlan", 1)
+	assert.NoError(t, err)
+	b, err := NewConfigMaps(cs, "default", "vlan", 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Put(storage.Record{Key: "100"}))
+	assert.NoError(t, b.Put(storage.Record{Key: "101"}))
+	// a writes with a stale resourceVersion, reads the shard again and retries
+	assert.NoError(t, a.Put(storage.Record{Key: "102"}))
+	assert.NoError(t, b.Delete("100"))
+	assert.NoError(t, a.Delete("102"))
+
+	assert.Equal(t, []storage.Record{{Key: "101"}}, records(t, a))
+
+	// a record written by another replica is not overwritten
+	assert.NoError(t, a.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "a"}}))
+	err = b.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "b"}})
+	assert.True(t, errors.Is(err, storage.ErrConflict))
+	assert.NoError(t, b.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "a"}}))
+	// the replica updates the records it knows
+	assert.NoError(t, b.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "c"}}))
+	err = a.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "d"}})
+	assert.True(t, errors.Is(err, storage.ErrConflict))
+	assert.Equal(t, []storage.Record{
+		{Key: "101"},
+		{Key: "200", Labels: labels.Set{"owner": "c"}},
+	}, records(t, a))
+	assert.NoError(t, a.Put(storage.Record{Key: "200", Labels: labels.Set{"owner": "d"}}))
+}
+
+func TestReload(t *testing.T) {
+	cs := newClientset()
+	s, err := NewConfigMaps(cs, "default", "vlan", 0)
+	assert.NoError(t, err)
+	vlans := table32.New(1, 4094)
+	assert.NoError(t, vlans.SetStorage(s))
+	assert.NoError(t, errOf(vlans.Claim(100, labels.Set{"owner": "a"})))
+	_, _, err = vlans.ClaimFree(labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(vlans.Release(100)))
+
+	s, err = NewConfigMaps(cs, "default", "labels", 0)
+	assert.NoError(t, err)
+	tree, err := tree32.New("labels", 20)
+	assert.NoError(t, err)
+	assert.NoError(t, tree.SetStorage(s))
+	assert.NoError(t, errOf(tree.ClaimID(id32.NewID(4096, 20), labels.Set{"owner": "c"})))
+
+	// a restarted replica loads the entries at start-up
+	s, err = NewConfigMaps(cs, "default", "vlan", 0)
+	assert.NoError(t, err)
+	restored := table32.New(1, 4094)
+	assert.NoError(t, restored.SetStorage(s))
+	assert.Equal(t, unstored(vlans.GetAll()), unstored(restored.GetAll()))
+
+	s, err = NewConfigMaps(cs, "default", "labels", 0)
+	assert.NoError(t, err)
+	restoredTree, err := tree32.New("labels", 20)
+	assert.NoError(t, err)
+	assert.NoError(t, restoredTree.SetStorage(s))
+	assert.Equal(t, unstored(tree.GetAll()), unstored(restoredTree.GetAll()))
+}
+
+// unstored returns the entries without the revision and metadata of the
+// table or tree that stores them, to compare them with the ones restored in
+// another
+func unstored(entries tree.Entries) tree.Entries {
+	out := make(tree.Entries, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, tree.NewEntry(e.ID(), e.Labels()))
+	}
+	return out
+}
+
+// errOf returns the error of a mutation without the revision of the table
+func errOf(_ uint64, err error) error {
+	return err
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory is a Storage that keeps the records in a map, e.g. for tests
+type Memory struct {
+	m       sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemory() *Memory {
+	return &Memory{records: map[string]Record{}}
+}
+
+func (r *Memory) Put(rec Record) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.records[rec.Key] = rec
+	return nil
+}
+
+func (r *Memory) Delete(key string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.records, key)
+	return nil
+}
+
+// Range calls fn for the records in the order of their keys; fn may change
+// the storage.
+func (r *Memory) Range(fn func(rec Record) error) error {
+	r.m.RLock()
+	keys := make([]string, 0, len(r.records))
+	for key := range r.records {
+		keys = append(keys, key)
+	}
+	r.m.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		r.m.RLock()
+		rec, ok := r.records[key]
+		r.m.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of records
+func (r *Memory) Len() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return len(r.records)
+}
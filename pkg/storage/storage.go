@@ -0,0 +1,37 @@
+// Package storage persists the entries of a table one record per id or
+// prefix, so a change writes a single record instead of a full snapshot.
+package storage
+
+import (
+	"errors"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ErrConflict is returned by a Put of a record that was changed by another
+// writer of a shared storage
+var ErrConflict = errors.New("conflict")
+
+// Record is the stored state of an entry
+type Record struct {
+	// Key is the textual id of the entry: an id of a table (e.g. "100"), a
+	// prefix of a tree (e.g. "4096/20") or an address of an ip table
+	Key    string     `json:"key"`
+	Labels labels.Set `json:"labels,omitempty"`
+	// Meta is the metadata of the entry, restored when the record is loaded
+	Meta tree.Meta `json:"meta"`
+}
+
+// Storage stores the records of a single table. The tables write through on
+// every mutation, so a failing write fails the mutation.
+type Storage interface {
+	// Put creates or replaces the record with the key of r
+	Put(r Record) error
+	// Delete removes the record of key; deleting a missing record is not an
+	// error
+	Delete(key string) error
+	// Range calls fn for every record until fn returns an error, which is
+	// returned by Range
+	Range(fn func(r Record) error) error
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/tj/assert"
+	bolt "go.etcd.io/bbolt"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestStorage(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := tree.Meta{Created: created, Updated: created.Add(time.Hour), Generation: 2, Annotations: map[string]string{"ticket": "1"}}
+	cases := map[string]struct {
+		new func(t *testing.T) Storage
+	}{
+		"Memory": {
+			new: func(t *testing.T) Storage { return NewMemory() },
+		},
+		"Bolt": {
+			new: func(t *testing.T) Storage {
+				db, err := bolt.Open(filepath.Join(t.TempDir(), "idx.db"), 0600, nil)
+				assert.NoError(t, err)
+				t.Cleanup(func() { db.Close() })
+				s, err := NewBolt(db, "vlan")
+				assert.NoError(t, err)
+				// the buckets of other pools are separate
+				other, err := NewBolt(db, "label")
+				assert.NoError(t, err)
+				assert.NoError(t, other.Put(Record{Key: "1"}))
+				return s
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := tc.new(t)
+			assert.NoError(t, s.Put(Record{Key: "100", Labels: labels.Set{"owner": "a"}}))
+			assert.NoError(t, s.Put(Record{Key: "101", Labels: labels.Set{"owner": "b"}}))
+			assert.NoError(t, s.Put(Record{Key: "100", Labels: labels.Set{"owner": "c"}}))
+			assert.NoError(t, s.Put(Record{Key: "4096/20", Meta: meta}))
+			assert.NoError(t, s.Delete("101"))
+			assert.NoError(t, s.Delete("102"))
+
+			records := []Record{}
+			assert.NoError(t, s.Range(func(r Record) error {
+				records = append(records, r)
+				return nil
+			}))
+			assert.Equal(t, []Record{
+				{Key: "100", Labels: labels.Set{"owner": "c"}},
+				{Key: "4096/20", Meta: meta},
+			}, records)
+
+			errStop := errors.New("stop")
+			assert.Equal(t, errStop, s.Range(func(r Record) error { return errStop }))
+		})
+	}
+}
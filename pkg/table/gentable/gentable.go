@@ -0,0 +1,666 @@
+// Package gentable implements a table.Table that is generic over the width of
+// the ids.
+package gentable
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/idxtable"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/table"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// New returns a table for the ids of width U from start to end (inclusive).
+func New[U genid.Uint](start, end U) table.Table {
+	return &gentable[U]{
+		m: new(sync.RWMutex),
+		table: idxtable.NewTable[tree.Entry](
+			uint64(end) - uint64(start) + 1,
+		),
+		start: start,
+		end:   end,
+	}
+}
+
+type gentable[U genid.Uint] struct {
+	// m serializes the changes, which check the table and the quotas and
+	// write the table, the storage and the audit log
+	m     *sync.RWMutex
+	table idxtable.Table[tree.Entry]
+	start U
+	end   U
+	hook  metrics.Hook
+	// quotas is nil when no quota rules are set
+	quotas *quota.Quotas
+	evict  tree.EvictionFunc
+	// storage is nil when the table is not persisted
+	storage storage.Storage
+	// audit is nil when the changes are not recorded
+	audit *audit.Log
+	actor string
+	clock tree.Clock
+}
+
+func (r *gentable[U]) Get(id uint64) (tree.Entry, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.get(id)
+}
+
+func (r *gentable[U]) get(id uint64) (tree.Entry, error) {
+	var entry tree.Entry
+	// Validate input
+	if err := r.validateID(id); err != nil {
+		return entry, err
+	}
+	newid := calculateIndex(U(id), r.start)
+	e, err := r.table.Get(newid)
+	if err != nil {
+		return entry, err
+	}
+	return entryOf(e), nil
+}
+
+// entryOf returns the tree entry of e with the revision and metadata of e;
+// the annotations are stored in the tree entry
+func entryOf(e idxtable.Entry[tree.Entry]) tree.Entry {
+	return tree.WithRevision(tree.WithMeta(e.Data(), tree.Meta{
+		Created:     e.Created(),
+		Updated:     e.Updated(),
+		Generation:  e.Generation(),
+		Annotations: e.Data().Meta().Annotations,
+	}), e.Revision())
+}
+
+func (r *gentable[U]) Claim(id uint64, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, err := r.claim(id, labels)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.table.Revision(), err
+}
+
+func (r *gentable[U]) ClaimWithMeta(id uint64, labels labels.Set, meta tree.Meta) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, err := r.claimWithMeta(id, labels, meta)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return r.table.Revision(), err
+}
+
+func (r *gentable[U]) claim(id uint64, labels labels.Set) (tree.Entry, error) {
+	return r.claimWithMeta(id, labels, tree.Meta{})
+}
+
+// claimWithMeta claims id for the labels; the entry keeps meta when it has a
+// creation time
+func (r *gentable[U]) claimWithMeta(id uint64, labels labels.Set, meta tree.Meta) (tree.Entry, error) {
+	// Validate input
+	if err := r.validateID(id); err != nil {
+		return nil, err
+	}
+	newid := calculateIndex(U(id), r.start)
+	if !r.table.IsFree(newid) {
+		return nil, metrics.Errorf(metrics.ReasonClaimed, "claim failed id %d already claimed", calculateIDFromIndex(r.start, newid))
+	}
+
+	if err := r.quotas.Claim(labels, 1); err != nil {
+		return nil, err
+	}
+	treeId := genid.NewID(U(id), genid.BitSize[U]())
+	treeEntry := tree.WithMeta(tree.NewEntry(treeId.Copy(), labels), meta)
+	if _, err := r.table.Claim(newid, treeEntry); err != nil {
+		r.quotas.Release(labels, 1)
+		return nil, err
+	}
+	if err := r.write(newid); err != nil {
+		r.table.Release(newid)
+		r.quotas.Release(labels, 1)
+		return nil, err
+	}
+	r.record(metrics.OperationClaim, id, nil, labels)
+	return r.get(id)
+}
+
+// ClaimFree claims the first free id. When the table is exhausted and the
+// labels have a tree.PriorityLabelKey, the preemptible entry with the lowest
+// lower priority is evicted and its id is claimed.
+func (r *gentable[U]) ClaimFree(labels labels.Set) (tree.Entry, uint64, error) {
+	r.m.Lock()
+	e, eviction, err := r.claimFree(labels)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	evict := r.evict
+	rev := r.table.Revision()
+	r.m.Unlock()
+
+	// the eviction func is called without the lock, so it can use the table
+	if eviction != nil && evict != nil {
+		evict(*eviction)
+	}
+	return e, rev, err
+}
+
+func (r *gentable[U]) claimFree(labels labels.Set) (tree.Entry, *tree.Eviction, error) {
+	id, err := r.findFree()
+	if err != nil {
+		if metrics.ReasonOf(err) == metrics.ReasonExhausted {
+			return r.preempt(labels, err)
+		}
+		return nil, nil, err
+	}
+	e, err := r.claim(id, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, nil, nil
+}
+
+// preempt evicts the victim for a claim with labels that have a
+// tree.PriorityLabelKey: the entry of the victim is replaced in the table, so
+// its id is not served to a waiter of ClaimFreeWait in between, and the new
+// entry is claimed afresh. When the claim fails the victim is kept. When no
+// entry is evicted err is returned.
+func (r *gentable[U]) preempt(labels labels.Set, err error) (tree.Entry, *tree.Eviction, error) {
+	if !labels.Has(tree.PriorityLabelKey) {
+		return nil, nil, err
+	}
+	priority, perr := tree.Priority(labels)
+	if perr != nil {
+		return nil, nil, perr
+	}
+	victim, ok := tree.Victim(r.getAll(), priority)
+	if !ok {
+		return nil, nil, err
+	}
+	id := victim.ID().ID()
+	index := calculateIndex(U(id), r.start)
+
+	r.quotas.Release(victim.Labels(), 1)
+	if err := r.quotas.Claim(labels, 1); err != nil {
+		r.quotas.Add(victim.Labels(), 1)
+		return nil, nil, err
+	}
+	if _, err := r.table.Replace(index, tree.NewEntry(victim.ID().Copy(), labels)); err != nil {
+		r.quotas.Release(labels, 1)
+		r.quotas.Add(victim.Labels(), 1)
+		return nil, nil, err
+	}
+	if err := r.write(index); err != nil {
+		// the victim is put back with its metadata
+		r.table.Replace(index, tree.WithMeta(tree.NewEntry(victim.ID().Copy(), victim.Labels()), victim.Meta()))
+		r.quotas.Release(labels, 1)
+		r.quotas.Add(victim.Labels(), 1)
+		return nil, nil, err
+	}
+	r.record(metrics.OperationRelease, id, victim.Labels(), nil)
+	r.record(metrics.OperationClaim, id, nil, labels)
+	e, err := r.get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, &tree.Eviction{Evicted: victim, By: e}, nil
+}
+
+// ClaimFreeWait claims a free id like ClaimFree. When the table is exhausted
+// it waits until an id is released or ctx is done; the priority of the waiter
+// is set on ctx with idxtable.WithPriority. The quota of the claim is held
+// while waiting; the table is not locked while waiting, so the releases can
+// proceed.
+func (r *gentable[U]) ClaimFreeWait(ctx context.Context, labels labels.Set) (tree.Entry, uint64, error) {
+	e, err := r.claimFreeWait(ctx, labels)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return e, r.table.Revision(), err
+}
+
+func (r *gentable[U]) claimFreeWait(ctx context.Context, labels labels.Set) (tree.Entry, error) {
+	r.m.Lock()
+	quotas := r.quotas
+	err := quotas.Claim(labels, 1)
+	r.m.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	e, _, err := r.table.ClaimFreeWaitFunc(ctx, func(index uint64) tree.Entry {
+		treeId := genid.NewID(calculateIDFromIndex(r.start, index), genid.BitSize[U]())
+		return tree.NewEntry(treeId, labels)
+	})
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err != nil {
+		quotas.Release(labels, 1)
+		return nil, err
+	}
+	if err := r.write(e.ID()); err != nil {
+		r.table.Release(e.ID())
+		quotas.Release(labels, 1)
+		return nil, err
+	}
+	r.record(metrics.OperationClaim, uint64(calculateIDFromIndex(r.start, e.ID())), nil, labels)
+	return entryOf(e), nil
+}
+
+// ClaimContiguous claims the best fitting run of size free ids that starts on
+// a multiple of align; e.g. a block of 16 labels aligned to 16.
+func (r *gentable[U]) ClaimContiguous(size, align uint64, labels labels.Set) (tree.Entries, uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	entries, err := r.claimContiguous(size, align, labels)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return entries, r.table.Revision(), err
+}
+
+func (r *gentable[U]) claimContiguous(size, align uint64, labels labels.Set) (tree.Entries, error) {
+	index, err := r.table.FindContiguous(uint64(r.start), size, align)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(tree.Entries, 0, size)
+	for i := index; i < index+size; i++ {
+		id := calculateIDFromIndex(r.start, i)
+		e, err := r.claim(uint64(id), labels)
+		if err != nil {
+			// release the ids claimed so far
+			for _, e := range entries {
+				r.release(e.ID().ID())
+			}
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (r *gentable[U]) Release(id uint64) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.release(id)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return r.table.Revision(), err
+}
+
+func (r *gentable[U]) release(id uint64) error {
+	// Validate input
+	if err := r.validateID(id); err != nil {
+		return err
+	}
+	newid := calculateIndex(U(id), r.start)
+	if _, err := r.table.Get(newid); err != nil {
+		// releasing a free id is not an error
+		_, err := r.table.Release(newid)
+		return err
+	}
+	_, err := r.remove(id, nil)
+	return err
+}
+
+func (r *gentable[U]) ReleaseIf(id uint64, revision uint64) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rev, err := r.releaseIf(id, revision)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return rev, err
+}
+
+func (r *gentable[U]) releaseIf(id uint64, revision uint64) (uint64, error) {
+	if err := r.validateID(id); err != nil {
+		return r.table.Revision(), err
+	}
+	rev, err := r.remove(id, func(e idxtable.Entry[tree.Entry]) error {
+		if e.Revision() != revision {
+			return metrics.Errorf(metrics.ReasonRevisionMismatch, "entry %d is at revision %d, expected %d", id, e.Revision(), revision)
+		}
+		return nil
+	})
+	if err != nil {
+		return r.table.Revision(), err
+	}
+	return rev, nil
+}
+
+// remove releases the claimed id from the table and erases its record. check,
+// when set, is called with the entry before anything is changed, e.g. to
+// compare the revision of a conditional release. It returns the revision of
+// the table after the release.
+func (r *gentable[U]) remove(id uint64, check func(e idxtable.Entry[tree.Entry]) error) (uint64, error) {
+	newid := calculateIndex(U(id), r.start)
+	e, err := r.table.Get(newid)
+	if err != nil {
+		return 0, err
+	}
+	if check != nil {
+		if err := check(e); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.erase(id); err != nil {
+		return 0, err
+	}
+	rev, err := r.table.Release(newid)
+	if err != nil {
+		r.write(newid)
+		return 0, err
+	}
+	r.quotas.Release(e.Data().Labels(), 1)
+	r.record(metrics.OperationRelease, id, e.Data().Labels(), nil)
+	return rev, nil
+}
+
+func (r *gentable[U]) Update(id uint64, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, err := r.update(id, labels, r.table.Update)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return r.table.Revision(), err
+}
+
+func (r *gentable[U]) UpdateIf(id uint64, revision uint64, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	rev, err := r.update(id, labels, func(index uint64, e tree.Entry) (uint64, error) {
+		return r.table.UpdateIf(index, revision, e)
+	})
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	if err != nil {
+		return r.table.Revision(), err
+	}
+	return rev, nil
+}
+
+// Annotate replaces the annotations of the entry
+func (r *gentable[U]) Annotate(id uint64, annotations map[string]string) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.annotate(id, annotations)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return r.table.Revision(), err
+}
+
+func (r *gentable[U]) annotate(id uint64, annotations map[string]string) error {
+	if err := r.validateID(id); err != nil {
+		return err
+	}
+	newid := calculateIndex(U(id), r.start)
+	old, err := r.table.Get(newid)
+	if err != nil {
+		return err
+	}
+	e := tree.NewEntry(old.Data().ID(), old.Data().Labels())
+	if _, err := r.table.Update(newid, tree.WithMeta(e, tree.Meta{Annotations: annotations})); err != nil {
+		return err
+	}
+	if err := r.write(newid); err != nil {
+		r.table.Update(newid, old.Data())
+		return err
+	}
+	r.record(metrics.OperationUpdate, id, e.Labels(), e.Labels())
+	return nil
+}
+
+func (r *gentable[U]) Revision() uint64 {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Revision()
+}
+
+// update replaces the labels of the claimed id in the table with fn, which
+// returns the revision of the table after the update
+func (r *gentable[U]) update(id uint64, labels labels.Set, fn func(index uint64, e tree.Entry) (uint64, error)) (uint64, error) {
+	// Validate input
+	if err := r.validateID(id); err != nil {
+		return 0, err
+	}
+	newid := calculateIndex(U(id), r.start)
+	old, err := r.table.Get(newid)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.quotas.Update(old.Data().Labels(), 1, labels, 1); err != nil {
+		return 0, err
+	}
+	treeId := genid.NewID(U(id), genid.BitSize[U]())
+	// the entry keeps its annotations
+	treeEntry := tree.WithMeta(tree.NewEntry(treeId.Copy(), labels), tree.Meta{Annotations: old.Data().Meta().Annotations})
+	rev, err := fn(newid, treeEntry)
+	if err != nil {
+		r.quotas.Update(labels, 1, old.Data().Labels(), 1)
+		return 0, err
+	}
+	if err := r.write(newid); err != nil {
+		r.table.Update(newid, old.Data())
+		r.quotas.Update(labels, 1, old.Data().Labels(), 1)
+		return 0, err
+	}
+	r.record(metrics.OperationUpdate, id, old.Data().Labels(), labels)
+	return rev, nil
+}
+
+func (r *gentable[U]) Size() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Size()
+}
+
+func (r *gentable[U]) Has(id uint64) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.has(id)
+}
+
+func (r *gentable[U]) has(id uint64) bool {
+	// Validate IP address
+	if err := r.validateID(id); err != nil {
+		return false
+	}
+	newid := calculateIndex(U(id), r.start)
+	return r.table.Has(newid)
+}
+
+func (r *gentable[U]) IsFree(id uint64) bool {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	// Validate IP address
+	if err := r.validateID(id); err != nil {
+		return false
+	}
+	newid := calculateIndex(U(id), r.start)
+	return r.table.IsFree(newid)
+}
+
+func (r *gentable[U]) FindFree() (uint64, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.findFree()
+}
+
+func (r *gentable[U]) findFree() (uint64, error) {
+	id, err := r.table.FindFree()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(calculateIDFromIndex(r.start, id)), nil
+}
+
+func (r *gentable[U]) GetAll() tree.Entries {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.getAll()
+}
+
+func (r *gentable[U]) getAll() tree.Entries {
+	entries := make(tree.Entries, 0, r.table.Size())
+	for _, entry := range r.table.GetAll() {
+		entries = append(entries, entryOf(entry))
+	}
+	return entries
+}
+
+func (r *gentable[U]) GetByLabel(selector labels.Selector) tree.Entries {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	entries := make(tree.Entries, 0, r.table.Size())
+
+	iter := r.table.Iterate()
+
+	for iter.Next() {
+		entry := entryOf(iter.Value())
+		if selector.Matches(entry.Labels()) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// GetByMeta returns the entries with metadata matching selector, e.g.
+// tree.OlderThan(24 * time.Hour)
+func (r *gentable[U]) GetByMeta(selector tree.MetaSelector) tree.Entries {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	entries := tree.Entries{}
+
+	iter := r.table.Iterate()
+	for iter.Next() {
+		entry := entryOf(iter.Value())
+		if selector(entry.Meta()) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// SetMetricsHook sets the hook called after every claim, release and update
+func (r *gentable[U]) SetMetricsHook(hook metrics.Hook) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.hook = hook
+}
+
+func (r *gentable[U]) Usage() metrics.Usage {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Usage()
+}
+
+func (r *gentable[U]) Stats() metrics.Stats {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.table.Stats()
+}
+
+// SetEvictionFunc sets the function called for every entry evicted by a claim
+// with a higher priority; it is called without the lock of the table.
+func (r *gentable[U]) SetEvictionFunc(fn tree.EvictionFunc) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.evict = fn
+}
+
+// SetAuditLog records every claim, update and release made by actor in the
+// log
+func (r *gentable[U]) SetAuditLog(log *audit.Log, actor string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.audit, r.actor = log, actor
+}
+
+// SetClock sets the clock of the metadata and the audit records of the
+// changes
+func (r *gentable[U]) SetClock(clock tree.Clock) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.clock = clock
+	r.table.SetClock(clock)
+}
+
+// record adds the change of the entry of id from the labels before to after
+// to the audit log; the caller must hold the lock
+func (r *gentable[U]) record(op metrics.Operation, id uint64, before, after labels.Set) {
+	if r.audit == nil {
+		return
+	}
+	r.audit.Append(audit.Record{
+		Time:   r.clock.Now(),
+		Op:     op,
+		ID:     strconv.FormatUint(id, 10),
+		Actor:  r.actor,
+		Before: before,
+		After:  after,
+	})
+}
+
+// SetQuotas replaces the quota rules of the table. The entries in the table
+// count in the usage, even when they exceed a rule.
+func (r *gentable[U]) SetQuotas(rules []quota.Rule) error {
+	quotas, err := quota.New(rules)
+	if err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, e := range r.getAll() {
+		quotas.Add(e.Labels(), 1)
+	}
+	r.quotas = quotas
+	return nil
+}
+
+func (r *gentable[U]) QuotaUsage() []quota.Usage {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.quotas.Usage()
+}
+
+func (r *gentable[U]) validateID(id uint64) error {
+	if id > uint64(^U(0)) {
+		return metrics.Errorf(metrics.ReasonOutOfRange, "id %d, cannot be bigger than %d", id, uint64(^U(0)))
+	}
+	if U(id) < r.start {
+		return metrics.Errorf(metrics.ReasonOutOfRange, "id %d, does not fit in the range from %d to %d", id, r.start, r.end)
+	}
+	if U(id) > r.end {
+		return metrics.Errorf(metrics.ReasonOutOfRange, "id %d, does not fit in the range from %d to %d", id, r.start, r.end)
+	}
+	return nil
+}
+
+func calculateIndex[U genid.Uint](id, start U) uint64 {
+	// Calculate the index in the bitmap
+	return uint64(id - start)
+}
+
+func calculateIDFromIndex[U genid.Uint](start U, id uint64) U {
+	return start + U(id)
+}
@@ -0,0 +1,64 @@
+package gentable
+
+import (
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/table"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reconcile loads the desired claims in the table. The claims are applied from
+// oldest to newest, so the oldest claim of an id wins and the newer ones are
+// reported as conflicts. Entries that already hold a desired id get the labels
+// of the claim, unless they hold them already, the other entries are reported
+// as stale.
+func (r *gentable[U]) Reconcile(desired []table.ClaimSpec) (*table.ReconcileReport, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	report := &table.ReconcileReport{}
+
+	owners := map[uint64]table.ClaimSpec{}
+	for _, claim := range reconcile.Sort(desired) {
+		if err := r.validateID(claim.ID); err != nil {
+			report.OutOfRange = append(report.OutOfRange, claim)
+			continue
+		}
+		if winner, ok := owners[claim.ID]; ok {
+			// the same claim listed twice is not a conflict
+			if winner.Name != claim.Name {
+				report.Conflicts = append(report.Conflicts, reconcile.Conflict[table.ClaimSpec]{Claim: claim, Winner: winner})
+			}
+			continue
+		}
+		owners[claim.ID] = claim
+		report.Loaded = append(report.Loaded, claim)
+	}
+
+	for _, e := range r.getAll() {
+		if _, ok := owners[e.ID().ID()]; !ok {
+			report.Stale = append(report.Stale, e)
+		}
+	}
+
+	for _, claim := range report.Loaded {
+		if r.has(claim.ID) {
+			// an entry that holds the labels of the claim is left as it is
+			if e, err := r.get(claim.ID); err == nil && labels.Equals(e.Labels(), claim.Labels) {
+				continue
+			}
+			_, err := r.update(claim.ID, claim.Labels, r.table.Update)
+			metrics.Observe(r.hook, metrics.OperationUpdate, err)
+			if err != nil {
+				return report, err
+			}
+			continue
+		}
+		_, err := r.claim(claim.ID, claim.Labels)
+		metrics.Observe(r.hook, metrics.OperationClaim, err)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
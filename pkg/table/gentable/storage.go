@@ -0,0 +1,85 @@
+package gentable
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SetStorage loads the records of s in the table and writes every following
+// change through to s, one record per id with the metadata of the entry. A
+// record replaces the entry of the same id and counts in the quotas, even
+// when it exceeds a rule; a record of a free id is claimed with its metadata.
+// The other entries of the table are written to s.
+func (r *gentable[U]) SetStorage(s storage.Storage) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	loaded := map[uint64]struct{}{}
+	if err := s.Range(func(rec storage.Record) error {
+		id, err := strconv.ParseUint(rec.Key, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid record %q, err: %s", rec.Key, err.Error())
+		}
+		if err := r.validateID(id); err != nil {
+			return err
+		}
+		loaded[id] = struct{}{}
+		index := calculateIndex(U(id), r.start)
+		e := tree.WithMeta(tree.NewEntry(genid.NewID(U(id), genid.BitSize[U]()), rec.Labels), rec.Meta)
+		old, err := r.table.Get(index)
+		if err != nil {
+			r.quotas.Add(rec.Labels, 1)
+			_, err := r.table.Claim(index, e)
+			return err
+		}
+		if labels.Equals(old.Data().Labels(), rec.Labels) {
+			return nil
+		}
+		r.quotas.Release(old.Data().Labels(), 1)
+		r.quotas.Add(rec.Labels, 1)
+		_, err = r.table.Update(index, e)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for _, e := range r.getAll() {
+		if _, ok := loaded[e.ID().ID()]; ok {
+			continue
+		}
+		if err := s.Put(record(e)); err != nil {
+			return err
+		}
+	}
+	r.storage = s
+	return nil
+}
+
+func record(e tree.Entry) storage.Record {
+	return storage.Record{Key: strconv.FormatUint(e.ID().ID(), 10), Labels: e.Labels(), Meta: e.Meta()}
+}
+
+// write stores the entry at index of the table with its metadata
+func (r *gentable[U]) write(index uint64) error {
+	if r.storage == nil {
+		return nil
+	}
+	e, err := r.table.Get(index)
+	if err != nil {
+		return err
+	}
+	return r.storage.Put(record(entryOf(e)))
+}
+
+// erase removes the record of id
+func (r *gentable[U]) erase(id uint64) error {
+	if r.storage == nil {
+		return nil
+	}
+	return r.storage.Delete(strconv.FormatUint(id, 10))
+}
@@ -1,20 +1,82 @@
 package table
 
 import (
+	"context"
+
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/storage"
 	"github.com/henderiw/idxtable/pkg/tree"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Table is a table of labeled ids. Every mutation returns the revision of the
+// table after the mutation, also when it fails.
 type Table interface {
 	Get(id uint64) (tree.Entry, error)
-	Claim(id uint64, labels labels.Set) error
-	ClaimFree(labels labels.Set) (tree.Entry, error)
-	Release(id uint64) error
-	Update(id uint64, labels labels.Set) error
+	Claim(id uint64, labels labels.Set) (uint64, error)
+	// ClaimWithMeta claims id with the metadata of an entry that is restored,
+	// e.g. from a snapshot
+	ClaimWithMeta(id uint64, labels labels.Set, meta tree.Meta) (uint64, error)
+	ClaimFree(labels labels.Set) (tree.Entry, uint64, error)
+	// ClaimFreeWait claims a free id, waiting for a release when the table is
+	// exhausted
+	ClaimFreeWait(ctx context.Context, labels labels.Set) (tree.Entry, uint64, error)
+	// ClaimContiguous claims a run of size free ids that starts on a multiple
+	// of align
+	ClaimContiguous(size, align uint64, labels labels.Set) (tree.Entries, uint64, error)
+	Release(id uint64) (uint64, error)
+	Update(id uint64, labels labels.Set) (uint64, error)
+	// UpdateIf updates the entry when it is still at revision
+	UpdateIf(id uint64, revision uint64, labels labels.Set) (uint64, error)
+	// ReleaseIf releases the entry when it is still at revision
+	ReleaseIf(id uint64, revision uint64) (uint64, error)
+	// Revision returns the revision of the table, incremented by every change
+	// of an entry
+	Revision() uint64
 	Size() int
 	Has(id uint64) bool
 	IsFree(id uint64) bool
 	FindFree() (uint64, error)
 	GetAll() tree.Entries
 	GetByLabel(selector labels.Selector) tree.Entries
+	// GetByMeta returns the entries with metadata matching selector
+	GetByMeta(selector tree.MetaSelector) tree.Entries
+	// Annotate replaces the annotations of the entry
+	Annotate(id uint64, annotations map[string]string) (uint64, error)
+	// Reconcile loads the desired claims in the table. When claims hold the same
+	// id the oldest one wins; entries that are not claimed are reported as stale
+	// and left in the table.
+	Reconcile(desired []ClaimSpec) (*ReconcileReport, error)
+	// Stats returns the utilization and fragmentation of the table
+	Stats() metrics.Stats
+	// SetEvictionFunc sets the function called for every entry evicted by a
+	// ClaimFree with a higher priority
+	SetEvictionFunc(fn tree.EvictionFunc)
+	// SetQuotas replaces the quota rules, which are enforced on every claim
+	SetQuotas(rules []quota.Rule) error
+	// QuotaUsage returns the usage versus the limit of every quota rule
+	QuotaUsage() []quota.Usage
+	// SetStorage loads the records of the storage in the table and writes
+	// every following change through to it
+	SetStorage(s storage.Storage) error
+	// SetAuditLog records every claim, update and release made by actor in
+	// the log
+	SetAuditLog(log *audit.Log, actor string)
+	// SetClock sets the clock of the metadata and the audit records of the
+	// changes, e.g. to apply a replicated change at the time of the leader
+	SetClock(clock tree.Clock)
+
+	metrics.Instrumented
 }
+
+// ClaimSpec is a claim of a single id loaded by Reconcile
+type ClaimSpec struct {
+	reconcile.Meta
+	ID     uint64
+	Labels labels.Set
+}
+
+type ReconcileReport = reconcile.Report[ClaimSpec, tree.Entry]
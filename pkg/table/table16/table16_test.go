@@ -38,12 +38,12 @@ func TestClaim(t *testing.T) {
 			r := New(uint16(trange.From().ID()), uint16(trange.To().ID()))
 
 			for id, labels := range tc.newSuccessEntries {
-				err := r.Claim(uint64(id), labels)
+				_, err := r.Claim(uint64(id), labels)
 				assert.NoError(t, err)
 
 			}
 			for id, labels := range tc.newFailedEntries {
-				err := r.Claim(uint64(id), labels)
+				_, err := r.Claim(uint64(id), labels)
 				assert.Error(t, err)
 			}
 			for id := range tc.newSuccessEntries {
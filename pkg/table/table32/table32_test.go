@@ -1,9 +1,20 @@
 package table32
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/table"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/henderiw/idxtable/pkg/tree"
 	"github.com/henderiw/idxtable/pkg/tree/id32"
 	"github.com/tj/assert"
 	"k8s.io/apimachinery/pkg/labels"
@@ -49,12 +60,12 @@ func TestClaim(t *testing.T) {
 			r := New(uint32(trange.From().ID()), uint32(trange.To().ID()))
 
 			for id, labels := range tc.newSuccessEntries {
-				err := r.Claim(id, labels)
+				_, err := r.Claim(id, labels)
 				assert.NoError(t, err)
 
 			}
 			for id, labels := range tc.newFailedEntries {
-				err := r.Claim(id, labels)
+				_, err := r.Claim(id, labels)
 				assert.Error(t, err)
 			}
 			for id := range tc.newSuccessEntries {
@@ -77,3 +88,455 @@ func TestClaim(t *testing.T) {
 		})
 	}
 }
+
+func TestReconcile(t *testing.T) {
+	claim := func(name string, created int64, id uint64) table.ClaimSpec {
+		return table.ClaimSpec{
+			Meta:   reconcile.Meta{Name: name, CreationTimestamp: time.Unix(created, 0)},
+			ID:     id,
+			Labels: labels.Set{"owner": name},
+		}
+	}
+
+	r := New(100, 199)
+	assert.NoError(t, errOf(r.Claim(110, labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(r.Claim(150, labels.Set{"owner": "gone"})))
+
+	report, err := r.Reconcile([]table.ClaimSpec{
+		claim("b", 2, 120),
+		claim("c", 3, 120),
+		claim("a", 1, 110),
+		claim("a", 1, 110),
+		claim("d", 2, 120),
+		claim("e", 1, 500),
+	})
+	assert.NoError(t, err)
+	assert.True(t, report.HasIssues())
+
+	loaded := []string{}
+	for _, c := range report.Loaded {
+		loaded = append(loaded, fmt.Sprintf("%s:%d", c.Name, c.ID))
+	}
+	assert.Equal(t, []string{"a:110", "b:120"}, loaded)
+
+	conflicts := []string{}
+	for _, c := range report.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%s>%s", c.Winner.Name, c.Claim.Name))
+	}
+	assert.Equal(t, []string{"b>d", "b>c"}, conflicts)
+	assert.Len(t, report.OutOfRange, 1)
+	assert.Equal(t, "e", report.OutOfRange[0].Name)
+	assert.Len(t, report.Stale, 1)
+	assert.Equal(t, uint64(150), report.Stale[0].ID().ID())
+
+	// stale entries are reported, not released
+	assert.True(t, r.Has(150))
+	e, err := r.Get(120)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", e.Labels()["owner"])
+	assert.Equal(t, 3, r.Size())
+
+	// an entry that holds the labels of its claim is not changed
+	report, err = r.Reconcile([]table.ClaimSpec{claim("b", 2, 120)})
+	assert.NoError(t, err)
+	assert.Len(t, report.Loaded, 1)
+	got, err := r.Get(120)
+	assert.NoError(t, err)
+	assert.Equal(t, e.Revision(), got.Revision())
+	assert.Equal(t, e.Meta(), got.Meta())
+}
+
+func TestClaimContiguous(t *testing.T) {
+	// MPLS labels 16-1048575 with 16 to 20 claimed; blocks are aligned on the
+	// label value, not on the index in the table
+	r := New(16, 1048575)
+	for id := uint64(16); id <= 20; id++ {
+		assert.NoError(t, errOf(r.Claim(id, labels.Set{"owner": "static"})))
+	}
+
+	entries, _, err := r.ClaimContiguous(16, 16, labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 16, len(entries))
+	for i, e := range entries {
+		assert.Equal(t, uint64(32+i), e.ID().ID())
+		assert.Equal(t, uint8(id32.IDBitSize), e.ID().Length())
+		got, err := r.Get(e.ID().ID())
+		assert.NoError(t, err)
+		assert.Equal(t, "a", got.Labels()["owner"])
+	}
+
+	// the unaligned run 21-31 still fits a block without alignment
+	entries, _, err = r.ClaimContiguous(11, 1, labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(21), entries[0].ID().ID())
+
+	_, _, err = r.ClaimContiguous(1<<20, 16, labels.Set{"owner": "c"})
+	assert.Error(t, err)
+	assert.Equal(t, 5+16+11, r.Size())
+}
+
+func TestQuota(t *testing.T) {
+	r := New(1, 100)
+	assert.NoError(t, errOf(r.Claim(1, labels.Set{"tenant": "a"})))
+	assert.NoError(t, r.SetQuotas([]quota.Rule{
+		{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 3},
+	}))
+
+	for i := 0; i < 2; i++ {
+		_, _, err := r.ClaimFree(labels.Set{"tenant": "a"})
+		assert.NoError(t, err)
+	}
+	_, _, err := r.ClaimFree(labels.Set{"tenant": "a"})
+	var e *quota.ExceededError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, uint64(3), e.Used)
+	assert.Error(t, errOf(r.Claim(50, labels.Set{"tenant": "a"})))
+	_, _, err = r.ClaimContiguous(2, 1, labels.Set{"tenant": "a"})
+	assert.Error(t, err)
+	assert.Equal(t, 3, r.Size())
+
+	// other tenants are not limited, but cannot be moved to tenant a
+	assert.NoError(t, errOf(r.Claim(50, labels.Set{"tenant": "b"})))
+	assert.Error(t, errOf(r.Update(50, labels.Set{"tenant": "a"})))
+
+	assert.NoError(t, errOf(r.Release(1)))
+	assert.NoError(t, errOf(r.Update(50, labels.Set{"tenant": "a"})))
+	usage := r.QuotaUsage()
+	assert.Equal(t, 1, len(usage))
+	assert.Equal(t, uint64(3), usage[0].IDs)
+	assert.Equal(t, uint64(3), usage[0].Rule.MaxIDs)
+}
+
+func TestClaimFreeWait(t *testing.T) {
+	r := New(10, 11)
+	for id := uint64(10); id <= 11; id++ {
+		assert.NoError(t, errOf(r.Claim(id, labels.Set{"owner": "a"})))
+	}
+
+	claimed := make(chan tree.Entry)
+	go func() {
+		e, _, err := r.ClaimFreeWait(context.Background(), labels.Set{"owner": "b"})
+		assert.NoError(t, err)
+		claimed <- e
+	}()
+	// the waiter gets the id whether it waits before or after the release
+	assert.NoError(t, errOf(r.Release(11)))
+	e := <-claimed
+	assert.Equal(t, uint64(11), e.ID().ID())
+	got, err := r.Get(11)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got.Labels()["owner"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = r.ClaimFreeWait(ctx, labels.Set{"owner": "c"})
+	assert.Error(t, err)
+}
+
+func TestPreempt(t *testing.T) {
+	r := New(1, 3)
+	assert.NoError(t, errOf(r.Claim(1, labels.Set{"owner": "a", tree.PriorityLabelKey: "5", tree.PreemptibleLabelKey: "true"})))
+	assert.NoError(t, errOf(r.Claim(2, labels.Set{"owner": "b", tree.PriorityLabelKey: "1", tree.PreemptibleLabelKey: "true"})))
+	assert.NoError(t, errOf(r.Claim(3, labels.Set{"owner": "c"})))
+	evictions := []tree.Eviction{}
+	r.SetEvictionFunc(func(e tree.Eviction) { evictions = append(evictions, e) })
+
+	// a claim without a higher priority does not evict
+	_, _, err := r.ClaimFree(labels.Set{"owner": "d"})
+	assert.Error(t, err)
+	_, _, err = r.ClaimFree(labels.Set{"owner": "d", tree.PriorityLabelKey: "1"})
+	assert.Error(t, err)
+	_, _, err = r.ClaimFree(labels.Set{"owner": "d", tree.PriorityLabelKey: "high"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(evictions))
+
+	// the lowest priority is evicted first; the id is claimed afresh
+	e, _, err := r.ClaimFree(labels.Set{"owner": "d", tree.PriorityLabelKey: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), e.ID().ID())
+	assert.Equal(t, 1, len(evictions))
+	assert.Equal(t, "b", evictions[0].Evicted.Labels()["owner"])
+	assert.Equal(t, "d", evictions[0].By.Labels()["owner"])
+	got, err := r.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", got.Labels()["owner"])
+	assert.Equal(t, uint64(1), got.Meta().Generation)
+	assert.False(t, got.Meta().Created.Before(evictions[0].Evicted.Meta().Created))
+	victim, err := r.Get(1)
+	assert.NoError(t, err)
+
+	// the quotas apply to the claim that evicts
+	assert.NoError(t, r.SetQuotas([]quota.Rule{
+		{Name: "owner-e", Selector: labels.SelectorFromSet(labels.Set{"owner": "e"}), MaxIDs: 0, MaxEntries: 1},
+	}))
+	assert.NoError(t, errOf(r.Release(3)))
+	_, _, err = r.ClaimFree(labels.Set{"owner": "e"})
+	assert.NoError(t, err)
+	_, _, err = r.ClaimFree(labels.Set{"owner": "e", tree.PriorityLabelKey: "10"})
+	assert.Error(t, err)
+	// the victim is kept with its metadata
+	got, err = r.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", got.Labels()["owner"])
+	assert.Equal(t, victim.Meta().Created, got.Meta().Created)
+	assert.Equal(t, victim.Meta().Generation, got.Meta().Generation)
+	assert.Equal(t, 1, len(evictions))
+}
+
+func TestPreemptWait(t *testing.T) {
+	r := New(1, 2)
+	assert.NoError(t, errOf(r.Claim(1, labels.Set{"owner": "a", tree.PriorityLabelKey: "1", tree.PreemptibleLabelKey: "true"})))
+	assert.NoError(t, errOf(r.Claim(2, labels.Set{"owner": "b"})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		_, _, err := r.ClaimFreeWait(ctx, labels.Set{"owner": "c"})
+		waited <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the id of the victim goes to the claim that evicts, not to the waiter
+	e, _, err := r.ClaimFree(labels.Set{"owner": "d", tree.PriorityLabelKey: "10"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), e.ID().ID())
+	got, err := r.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", got.Labels()["owner"])
+
+	cancel()
+	assert.Error(t, <-waited)
+	assert.Equal(t, 2, r.Size())
+}
+
+// failingStorage fails every write
+type failingStorage struct {
+	*storage.Memory
+}
+
+func (r failingStorage) Put(storage.Record) error {
+	return errors.New("storage unavailable")
+}
+
+func TestStorage(t *testing.T) {
+	s := storage.NewMemory()
+	assert.NoError(t, s.Put(storage.Record{Key: "10", Labels: labels.Set{"owner": "a"}}))
+	r := New(1, 100)
+	assert.NoError(t, errOf(r.Claim(20, labels.Set{"owner": "b"})))
+
+	// the records are loaded and the entries of the table are stored
+	assert.NoError(t, r.SetStorage(s))
+	got, err := r.Get(10)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", got.Labels()["owner"])
+	assert.Equal(t, 2, s.Len())
+
+	assert.NoError(t, errOf(r.Claim(30, labels.Set{"owner": "c"})))
+	assert.NoError(t, errOf(r.Update(20, labels.Set{"owner": "d"})))
+	assert.NoError(t, errOf(r.Release(10)))
+	_, _, err = r.ClaimFree(labels.Set{"owner": "e"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.Len())
+
+	// a new table loads the same entries
+	restored := New(1, 100)
+	assert.NoError(t, restored.SetStorage(s))
+	assert.Equal(t, unstored(r.GetAll()), unstored(restored.GetAll()))
+
+	// a failing write fails the mutation and leaves the table unchanged
+	assert.NoError(t, r.SetStorage(failingStorage{Memory: s}))
+	assert.Error(t, errOf(r.Claim(40, labels.Set{"owner": "f"})))
+	assert.True(t, r.IsFree(40))
+	assert.Error(t, errOf(r.Update(20, labels.Set{"owner": "f"})))
+	got, err = r.Get(20)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", got.Labels()["owner"])
+	assert.Equal(t, 3, r.Size())
+}
+
+func TestRevision(t *testing.T) {
+	r := New(1, 100)
+	e, _, err := r.ClaimFree(labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), e.Revision())
+	rev, err := r.Claim(10, labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), rev)
+	got, err := r.Get(10)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), got.Revision())
+
+	// an update with a stale revision does not overwrite the previous one
+	rev, err = r.UpdateIf(10, 2, labels.Set{"owner": "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), rev)
+	rev, err = r.UpdateIf(10, 2, labels.Set{"owner": "d"})
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	assert.Equal(t, uint64(3), rev)
+	got, err = r.Get(10)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", got.Labels()["owner"])
+
+	_, err = r.ReleaseIf(10, 2)
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	rev, err = r.ReleaseIf(10, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), rev)
+	assert.True(t, r.IsFree(10))
+	_, err = r.ReleaseIf(10, 3)
+	assert.Equal(t, metrics.ReasonNotFound, metrics.ReasonOf(err))
+	assert.Equal(t, uint64(4), r.Revision())
+
+	// every mutation returns the revision of the table, also when it fails
+	e, rev, err = r.ClaimFree(labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), rev)
+	rev, err = r.Update(e.ID().ID(), labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(6), rev)
+	rev, err = r.Release(e.ID().ID())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), rev)
+	rev, err = r.Claim(500, labels.Set{"owner": "a"})
+	assert.Error(t, err)
+	assert.Equal(t, uint64(7), rev)
+
+	// a release at a stale revision leaves the record of the entry alone
+	s := &countingStorage{Memory: storage.NewMemory()}
+	assert.NoError(t, r.SetStorage(s))
+	_, err = r.ReleaseIf(1, 7)
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	assert.Equal(t, 0, s.deletes)
+	assert.Equal(t, 1, s.Len())
+	_, err = r.ReleaseIf(1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.deletes)
+	assert.Equal(t, 0, s.Len())
+}
+
+// countingStorage counts the deleted records
+type countingStorage struct {
+	*storage.Memory
+	deletes int
+}
+
+func (r *countingStorage) Delete(key string) error {
+	r.deletes++
+	return r.Memory.Delete(key)
+}
+
+func TestMeta(t *testing.T) {
+	r := New(1, 100)
+	assert.NoError(t, errOf(r.Claim(10, labels.Set{"owner": "a"})))
+	time.Sleep(time.Millisecond)
+	cutoff := time.Now()
+	assert.NoError(t, errOf(r.Claim(20, labels.Set{"owner": "b"})))
+
+	// the annotations are kept when the labels are updated
+	assert.NoError(t, errOf(r.Annotate(10, map[string]string{"ticket": "1234"})))
+	assert.NoError(t, errOf(r.Update(10, labels.Set{"owner": "c"})))
+	e, err := r.Get(10)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", e.Meta().Annotations["ticket"])
+	assert.Equal(t, uint64(3), e.Meta().Generation)
+	assert.True(t, e.Meta().Created.Before(cutoff))
+	assert.False(t, e.Meta().Updated.Before(cutoff))
+	assert.Error(t, errOf(r.Annotate(30, map[string]string{"ticket": "1234"})))
+
+	// the metadata is stored and restored with the entries
+	s := storage.NewMemory()
+	assert.NoError(t, r.SetStorage(s))
+	assert.NoError(t, errOf(r.Annotate(10, map[string]string{"ticket": "5678"})))
+	e, err = r.Get(10)
+	assert.NoError(t, err)
+	restored := New(1, 100)
+	assert.NoError(t, restored.SetStorage(s))
+	got, err := restored.Get(10)
+	assert.NoError(t, err)
+	assert.Equal(t, e.Meta(), got.Meta())
+	assert.Equal(t, "5678", got.Meta().Annotations["ticket"])
+
+	entries := r.GetByMeta(tree.CreatedBefore(cutoff))
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, uint64(10), entries[0].ID().ID())
+	assert.Equal(t, 0, len(r.GetByMeta(tree.UpdatedBefore(cutoff))))
+	assert.Equal(t, 0, len(r.GetByMeta(tree.OlderThan(time.Hour))))
+}
+
+func TestAudit(t *testing.T) {
+	log, err := audit.New(0)
+	assert.NoError(t, err)
+	r := New(1, 100)
+	r.SetAuditLog(log, "controller-a")
+	assert.NoError(t, errOf(r.Claim(10, labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(r.Update(10, labels.Set{"owner": "b"})))
+	assert.NoError(t, errOf(r.Release(10)))
+	assert.NoError(t, errOf(r.Claim(10, labels.Set{"owner": "c"})))
+	assert.NoError(t, errOf(r.Claim(20, labels.Set{"owner": "a"})))
+	// a failed claim is not recorded
+	assert.Error(t, errOf(r.Claim(20, labels.Set{"owner": "d"})))
+
+	records := log.Query(audit.Query{ID: "10"})
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, metrics.OperationRelease, records[2].Op)
+	assert.Equal(t, labels.Set{"owner": "b"}, records[2].Before)
+	assert.Equal(t, "controller-a", records[2].Actor)
+
+	// the previous holders of an owner
+	records = log.Query(audit.Query{Selector: labels.SelectorFromSet(labels.Set{"owner": "a"})})
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "10", records[1].ID)
+	assert.Equal(t, "20", records[2].ID)
+}
+
+// unstored returns the entries without the revision and metadata of the
+// table or tree that stores them, to compare them with the ones restored in
+// another
+func unstored(entries tree.Entries) tree.Entries {
+	out := make(tree.Entries, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, tree.NewEntry(e.ID(), e.Labels()))
+	}
+	return out
+}
+
+func TestConcurrent(t *testing.T) {
+	r := New(1, 100)
+	s := storage.NewMemory()
+	assert.NoError(t, r.SetStorage(s))
+	assert.NoError(t, r.SetQuotas([]quota.Rule{
+		{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 50},
+	}))
+
+	log, err := audit.New(10)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				e, _, err := r.ClaimFree(labels.Set{"tenant": "a"})
+				if err != nil {
+					continue
+				}
+				if j%2 == 0 {
+					assert.NoError(t, errOf(r.Release(e.ID().ID())))
+				}
+			}
+			r.SetAuditLog(log, fmt.Sprintf("worker-%d", i))
+			r.SetMetricsHook(metrics.NewRecorder())
+		}(i)
+	}
+	wg.Wait()
+
+	// the table, the storage and the quotas agree
+	assert.Equal(t, r.Size(), s.Len())
+	assert.Equal(t, uint64(r.Size()), r.QuotaUsage()[0].IDs)
+}
+
+// errOf returns the error of a mutation without the revision of the table
+func errOf(_ uint64, err error) error {
+	return err
+}
@@ -49,12 +49,12 @@ func TestClaim(t *testing.T) {
 			r := New(uint64(trange.From().ID()), uint64(trange.To().ID()))
 
 			for id, labels := range tc.newSuccessEntries {
-				err := r.Claim(id, labels)
+				_, err := r.Claim(id, labels)
 				assert.NoError(t, err)
 
 			}
 			for id, labels := range tc.newFailedEntries {
-				err := r.Claim(id, labels)
+				_, err := r.Claim(id, labels)
 				assert.Error(t, err)
 			}
 			for id := range tc.newSuccessEntries {
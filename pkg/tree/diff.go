@@ -0,0 +1,127 @@
+package tree
+
+// DiffFunc is called by Diff with the vals of a prefix in both trees; a or b
+// is empty when the prefix only has vals in the other tree
+type DiffFunc[T any] func(a, b []T)
+
+// Diff walks the tries of a and b together and calls fn for every prefix that
+// has vals in either of them, in trie order. The nodes are matched by their
+// prefix, so a subtree that only exists in one of the trees is reported
+// without being compared. A subtree that both trees share, e.g. a subtree of
+// a tree and its snapshot that was not changed since, holds the same vals and
+// is skipped without being walked; a tree and an unchanged snapshot of it are
+// not walked at all.
+func Diff[T any](a, b *Tree[T], fn DiffFunc[T]) {
+	w := &differ[T]{a: a, b: b, fn: fn}
+	w.walk(trieNode[T]{node: a.root}, trieNode[T]{node: b.root})
+}
+
+// trieNode is a node with its absolute prefix; a nil node is no node
+type trieNode[T any] struct {
+	node   *treeNode[T]
+	id     uint64
+	length uint8
+}
+
+type differ[T any] struct {
+	a  *Tree[T]
+	b  *Tree[T]
+	fn DiffFunc[T]
+}
+
+// walk compares the subtrees of the nodes a and b
+func (r *differ[T]) walk(a, b trieNode[T]) {
+	switch {
+	case a.node == nil && b.node == nil:
+		return
+	case b.node == nil:
+		r.only(r.a, a, true)
+		return
+	case a.node == nil:
+		r.only(r.b, b, false)
+		return
+	case a.node == b.node && a.id == b.id && a.length == b.length:
+		// the subtree is shared by both trees
+		return
+	}
+
+	length := min(a.length, b.length)
+	ma := a.id & leftMask(length, r.a.length)
+	mb := b.id & leftMask(length, r.a.length)
+	switch {
+	case ma < mb:
+		// disjoint prefixes, in trie order
+		r.only(r.a, a, true)
+		r.only(r.b, b, false)
+	case ma > mb:
+		r.only(r.b, b, false)
+		r.only(r.a, a, true)
+	case a.length == b.length:
+		va := a.node.valsForNode(nil, nil)
+		vb := b.node.valsForNode(nil, nil)
+		if len(va) > 0 || len(vb) > 0 {
+			r.fn(va, vb)
+		}
+		r.walk(r.a.child(a, true), r.b.child(b, true))
+		r.walk(r.a.child(a, false), r.b.child(b, false))
+	case a.length < b.length:
+		// a covers b, which continues in the child of a on its side
+		if va := a.node.valsForNode(nil, nil); len(va) > 0 {
+			r.fn(va, nil)
+		}
+		if r.a.isLeft(b, a.length) {
+			r.walk(r.a.child(a, true), b)
+			r.walk(r.a.child(a, false), trieNode[T]{})
+		} else {
+			r.walk(r.a.child(a, true), trieNode[T]{})
+			r.walk(r.a.child(a, false), b)
+		}
+	default:
+		// b covers a
+		if vb := b.node.valsForNode(nil, nil); len(vb) > 0 {
+			r.fn(nil, vb)
+		}
+		if r.b.isLeft(a, b.length) {
+			r.walk(a, r.b.child(b, true))
+			r.walk(trieNode[T]{}, r.b.child(b, false))
+		} else {
+			r.walk(trieNode[T]{}, r.b.child(b, true))
+			r.walk(a, r.b.child(b, false))
+		}
+	}
+}
+
+// only reports the vals of the subtree of n, which only exists in t
+func (r *differ[T]) only(t *Tree[T], n trieNode[T], inA bool) {
+	if n.node == nil {
+		return
+	}
+	if vals := n.node.valsForNode(nil, nil); len(vals) > 0 {
+		if inA {
+			r.fn(vals, nil)
+		} else {
+			r.fn(nil, vals)
+		}
+	}
+	r.only(t, t.child(n, true), inA)
+	r.only(t, t.child(n, false), inA)
+}
+
+// child returns the left or right child of n with its absolute prefix
+func (r *Tree[T]) child(n trieNode[T], left bool) trieNode[T] {
+	c := n.node.Right
+	if left {
+		c = n.node.Left
+	}
+	if c == nil {
+		return trieNode[T]{}
+	}
+	id, length := MergeID(n.id, n.length, c.Id, c.Length, r.length)
+	return trieNode[T]{node: c, id: id, length: length}
+}
+
+// isLeft returns whether the bit after the first length bits of the prefix of
+// n is not set, i.e. n is in the left subtree of a node of length bits
+func (r *Tree[T]) isLeft(n trieNode[T], length uint8) bool {
+	return n.id&(uint64(1)<<(r.length-1-length)) == 0
+}
@@ -6,21 +6,40 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 )
 
+// ReservedLabelKey is the label key of the entries that are reserved rather
+// than claimed by an owner
+const ReservedLabelKey = "idxtable.henderiw.io/reserved"
+
+// DelegatedLabelKey is the label key of the claimed prefixes that are child
+// pools; the ids in such a prefix are claimed through a scoped handle
+const DelegatedLabelKey = "idxtable.henderiw.io/delegated"
+
 type Entry interface {
 	ID() ID
 	Labels() labels.Set
 	String() string
+	// Equal compares the ids and labels, not the revisions and metadata
 	Equal(e2 Entry) bool
+	// Revision is the revision of the table or tree when the entry was last
+	// changed; it is 0 for an entry that is not stored
+	Revision() uint64
+	// Meta returns the metadata of the entry, maintained by the table or tree
+	// that stores it
+	Meta() Meta
 }
 
 type entry struct {
-	id     ID
-	labels labels.Set
+	id       ID
+	labels   labels.Set
+	revision uint64
+	meta     Meta
 }
 type Entries []Entry
 
 func (r entry) ID() ID             { return r.id }
 func (r entry) Labels() labels.Set { return r.labels }
+func (r entry) Revision() uint64   { return r.revision }
+func (r entry) Meta() Meta         { return r.meta }
 func (r entry) String() string     { return fmt.Sprintf("id: %d, labels: %s", r.id, r.labels.String()) }
 func (r entry) Equal(e2 Entry) bool {
 	if r.ID().ID() == e2.ID().ID() &&
@@ -38,4 +57,14 @@ func NewEntry(id ID, labels labels.Set) Entry {
 	}
 }
 
+// WithRevision returns the entry e at revision
+func WithRevision(e Entry, revision uint64) Entry {
+	return entry{
+		id:       e.ID(),
+		labels:   e.Labels(),
+		revision: revision,
+		meta:     e.Meta(),
+	}
+}
+
 type Enries[T1 any] []Entry
@@ -0,0 +1,171 @@
+package genid
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+)
+
+// Uint is the set of unsigned integer types an id can be stored in.
+// The width of the id is the bit size of the type.
+type Uint interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// BitSize returns the width of the id type in bits
+func BitSize[U Uint]() uint8 {
+	return uint8(bits.Len64(uint64(^U(0))))
+}
+
+// IsLeftBitSet returns whether the leftmost bit of the id of width U is set
+func IsLeftBitSet[U Uint](id uint64) bool {
+	return U(id)>>(BitSize[U]()-1) == 1
+}
+
+// mask returns the id with the leftmost length bits set
+func mask[U Uint](length uint8) U {
+	return ^U(0) << (BitSize[U]() - length)
+}
+
+// Sub returns the difference of x, y and borrow, diff = x - y - borrow, in
+// the width of U. The borrow input must be 0 or 1; the borrowOut output is 0
+// or 1.
+func Sub[U Uint](x, y, borrow U) (diff, borrowOut U) {
+	diff = x - y - borrow
+	// The difference will underflow if the top bit of x is not set and the top
+	// bit of y is set (^x & y) or if they are the same (^(x ^ y)) and a borrow
+	// from the lower place happens.
+	borrowOut = ((^x & y) | (^(x ^ y) & diff)) >> (BitSize[U]() - 1)
+	return
+}
+
+// Add returns the sum with carry of x, y and carry, sum = x + y + carry, in
+// the width of U. The carry input must be 0 or 1; the carryOut output is 0
+// or 1.
+func Add[U Uint](x, y, carry U) (sum, carryOut U) {
+	sum = x + y + carry
+	carryOut = ((x & y) | ((x | y) &^ sum)) >> (BitSize[U]() - 1)
+	return
+}
+
+type id[U Uint] struct {
+	id     U
+	length uint8
+}
+
+func NewID[U Uint](i U, length uint8) tree.ID {
+	return id[U]{
+		id:     i,
+		length: length,
+	}
+}
+
+func (r id[U]) Copy() tree.ID {
+	return id[U]{
+		id:     r.id,
+		length: r.length,
+	}
+}
+
+func (r id[U]) Length() uint8 {
+	return r.length
+}
+
+func (r id[U]) ID() uint64 {
+	return uint64(r.id)
+}
+
+// ShiftLeft shifts the address to the left
+func (r id[U]) ShiftLeft(shiftCount uint8) tree.ID {
+	r.id <<= shiftCount
+	r.length -= shiftCount
+	return r
+}
+
+// IsLeftBitSet returns whether the leftmost bit is set
+func (r id[U]) IsLeftBitSet() bool {
+	return IsLeftBitSet[U](uint64(r.id))
+}
+
+// String returns a string version of this ID.
+func (r id[U]) String() string {
+	return fmt.Sprintf("%d/%d", r.id, r.length)
+}
+
+func (r id[U]) Matches(i uint64) uint8 {
+	return uint8(bits.LeadingZeros64(uint64(U(i)^r.id))) - (64 - BitSize[U]())
+}
+
+func (r id[U]) Overlaps(b tree.ID) bool {
+	var minbits uint8
+	if r.Length() < b.Length() {
+		minbits = r.Length()
+	} else {
+		minbits = b.Length()
+	}
+	if minbits == 0 {
+		return true
+	}
+	ida := r.id & mask[U](minbits)
+	idb := U(b.ID()) & mask[U](minbits)
+
+	return ida == idb
+}
+
+// Compare returns an integer comparing two IDs.
+// The result will be 0 if id == id2, -1 if id < id2, and +1 if id > id2.
+// IDs sort first by length, then their id.
+func (r id[U]) Compare(id2 tree.ID) int {
+	f1, f2 := r.Length(), id2.Length()
+	if f1 < f2 {
+		return -1
+	}
+	if f1 > f2 {
+		return 1
+	}
+	if r.ID() < id2.ID() {
+		return -1
+	}
+	if r.ID() > id2.ID() {
+		return 1
+	}
+	return 0
+}
+
+// Less reports whether id sorts before id2.
+func (r id[U]) Less(id2 tree.ID) bool { return r.Compare(id2) == -1 }
+
+// Next returns the ID following id.
+// If there is none, it returns the ID zero value.
+func (r id[U]) Next() tree.ID {
+	r.id++
+	if r.id == 0 {
+		// Overflowed.
+		return id[U]{}
+	}
+	return r
+}
+
+// Prev returns the ID before id.
+// If there is none, it returns the ID zero value.
+func (r id[U]) Prev() tree.ID {
+	if r.id == 0 {
+		return id[U]{}
+	}
+	r.id--
+	return r
+}
+
+// Mask returns the id with only the leftmost l bits kept, and length l.
+func (r id[U]) Mask(l uint8) (tree.ID, error) {
+	if l > BitSize[U]() {
+		return nil, fmt.Errorf("length is too large, max %d, got: %d", BitSize[U](), l)
+	}
+	return id[U]{id: r.id & mask[U](l), length: l}, nil
+}
+
+func (r id[U]) Masked() tree.ID {
+	mid, _ := r.Mask(r.length)
+	return mid
+}
@@ -0,0 +1,81 @@
+package genid
+
+import (
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestRangeIDs(t *testing.T) {
+	cases := map[string]struct {
+		from, to    uint8
+		expectedIDs []string
+	}{
+		"Single": {
+			from:        10,
+			to:          10,
+			expectedIDs: []string{"10/8"},
+		},
+		"Prefix": {
+			from:        64,
+			to:          127,
+			expectedIDs: []string{"64/2"},
+		},
+		"Full": {
+			from:        0,
+			to:          255,
+			expectedIDs: []string{"0/0"},
+		},
+		"Split": {
+			from:        1,
+			to:          6,
+			expectedIDs: []string{"1/8", "2/7", "4/7", "6/8"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ids := []string{}
+			for _, id := range RangeFrom(tc.from, tc.to).IDs() {
+				ids = append(ids, id.String())
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
+
+func TestID(t *testing.T) {
+	assert.Equal(t, uint8(8), BitSize[uint8]())
+	assert.Equal(t, uint8(64), BitSize[uint64]())
+	assert.True(t, IsLeftBitSet[uint16](0x8000))
+	assert.False(t, IsLeftBitSet[uint32](0x8000))
+
+	id := NewID(uint16(0x1234), 16)
+	assert.Equal(t, uint8(4), id.Matches(0x1fff))
+	masked, err := id.Mask(8)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x1200), masked.ID())
+	_, err = id.Mask(17)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(0x12ff), LastID[uint16](masked).ID())
+	assert.Equal(t, "0-65535", RangeOfID[uint16](NewID(uint16(0), 0)).String())
+
+	assert.Equal(t, uint8(0), NewID(uint64(1<<64-1), 64).Next().Length())
+	assert.Equal(t, uint8(0), NewID(uint32(0), 32).Prev().Length())
+}
+
+func TestAddSub(t *testing.T) {
+	sum, carry := Add[uint16](0xffff, 1, 0)
+	assert.Equal(t, uint16(0), sum)
+	assert.Equal(t, uint16(1), carry)
+	sum, carry = Add[uint16](1, 2, 1)
+	assert.Equal(t, uint16(4), sum)
+	assert.Equal(t, uint16(0), carry)
+
+	diff, borrow := Sub[uint16](0, 1, 0)
+	assert.Equal(t, uint16(0xffff), diff)
+	assert.Equal(t, uint16(1), borrow)
+	diff, borrow = Sub[uint16](5, 2, 1)
+	assert.Equal(t, uint16(2), diff)
+	assert.Equal(t, uint16(0), borrow)
+}
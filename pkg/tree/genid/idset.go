@@ -1,20 +1,23 @@
-package id16
+package genid
 
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/bits"
+	"sort"
 
 	"github.com/henderiw/idxtable/pkg/tree"
 )
 
-type IDSetBuilder struct {
+type IDSetBuilder[U Uint] struct {
 	in   []tree.Range
 	out  []tree.Range
 	errs error
 }
 
-func (s *IDSetBuilder) AddId(id tree.ID) {
-	if r := RangeOfID(id); r.IsValid() {
+func (s *IDSetBuilder[U]) AddId(id tree.ID) {
+	if r := RangeOfID[U](id); r.IsValid() {
 		s.AddRange(r)
 	} else {
 		s.errs = errors.Join(s.errs, fmt.Errorf("addId(%v-%v)", id.ID(), id.Length()))
@@ -22,15 +25,15 @@ func (s *IDSetBuilder) AddId(id tree.ID) {
 }
 
 // RemoveId removes all Ids in p from s.
-func (s *IDSetBuilder) RemoveId(id tree.ID) {
-	if r := RangeOfID(id); r.IsValid() {
+func (s *IDSetBuilder[U]) RemoveId(id tree.ID) {
+	if r := RangeOfID[U](id); r.IsValid() {
 		s.RemoveRange(r)
 	} else {
 		s.errs = errors.Join(s.errs, fmt.Errorf("removeId(%v-%v)", id.ID(), id.Length()))
 	}
 }
 
-func (s *IDSetBuilder) AddRange(r tree.Range) {
+func (s *IDSetBuilder[U]) AddRange(r tree.Range) {
 	if !r.IsValid() {
 		s.errs = errors.Join(s.errs, fmt.Errorf("addRange(%v-%v)", r.From(), r.To()))
 		return
@@ -42,7 +45,7 @@ func (s *IDSetBuilder) AddRange(r tree.Range) {
 }
 
 // RemoveRange removes all IPs in r from s.
-func (s *IDSetBuilder) RemoveRange(r tree.Range) {
+func (s *IDSetBuilder[U]) RemoveRange(r tree.Range) {
 	if r.IsValid() {
 		s.out = append(s.out, r)
 	} else {
@@ -52,7 +55,7 @@ func (s *IDSetBuilder) RemoveRange(r tree.Range) {
 }
 
 // AddSet adds all IPs in b to s.
-func (s *IDSetBuilder) AddSet(b *IDSet) {
+func (s *IDSetBuilder[U]) AddSet(b *IDSet[U]) {
 	if b == nil {
 		return
 	}
@@ -64,7 +67,7 @@ func (s *IDSetBuilder) AddSet(b *IDSet) {
 // normalize normalizes s: s.in becomes the minimal sorted list of
 // ranges required to describe s, and s.out becomes empty.
 
-func (s *IDSetBuilder) normalize() {
+func (s *IDSetBuilder[U]) normalize() {
 	in, ok := mergeRanges(s.in)
 	if !ok {
 		return
@@ -114,7 +117,7 @@ func (s *IDSetBuilder) normalize() {
 			// f-------------t
 			//    f------t
 			//       out
-			min = append(min, r16{from: rin.From(), to: rout.From().Prev()})
+			min = append(min, idRange[U]{from: rin.From(), to: rout.From().Prev()})
 			// Adjust in[0], not ir, because we want to consider the
 			// mutated range on the next iteration.
 			in[0] = in[0].SetFrom(rout.To().Next())
@@ -126,7 +129,6 @@ func (s *IDSetBuilder) normalize() {
 			// f------t
 			//    f------t
 			//       in
-			
 			in[0] = in[0].SetFrom(rout.To().Next())
 			// Can't move ir onto min yet, another later out might
 			// trim it further. Just discard or and continue.
@@ -138,7 +140,7 @@ func (s *IDSetBuilder) normalize() {
 			//        f------t
 			//    f------t
 			//       in
-			min = append(min, r16{from: rin.From(), to: rout.From().Prev()})
+			min = append(min, idRange[U]{from: rin.From(), to: rout.From().Prev()})
 			in = in[1:]
 		default:
 			// The above should account for all combinations of in and
@@ -156,9 +158,9 @@ func (s *IDSetBuilder) normalize() {
 
 }
 
-func (s *IDSetBuilder) IPSet() (*IDSet, error) {
+func (s *IDSetBuilder[U]) IPSet() (*IDSet[U], error) {
 	s.normalize()
-	idset := &IDSet{
+	idset := &IDSet[U]{
 		rr: append([]tree.Range{}, s.in...),
 	}
 	if s.errs == nil {
@@ -170,7 +172,7 @@ func (s *IDSetBuilder) IPSet() (*IDSet, error) {
 	}
 }
 
-type IDSet struct {
+type IDSet[U Uint] struct {
 	// rr is the set of IPs that belong to this IPSet. The IPRanges
 	// are normalized according to IPSetBuilder.normalize, meaning
 	// they are a sorted, minimal representation (no overlapping
@@ -181,13 +183,13 @@ type IDSet struct {
 
 // Ranges returns the minimum and sorted set of IP
 // ranges that covers s.
-func (s *IDSet) Ranges() []tree.Range {
+func (s *IDSet[U]) Ranges() []tree.Range {
 	return append([]tree.Range{}, s.rr...)
 }
 
 // Prefixes returns the minimum and sorted set of IP prefixes
 // that covers s.
-func (s *IDSet) IDs() []tree.ID {
+func (s *IDSet[U]) IDs() []tree.ID {
 	out := make([]tree.ID, 0, len(s.rr))
 	for _, r := range s.rr {
 		out = append(out, r.IDs()...)
@@ -200,14 +202,13 @@ func (s *IDSet) IDs() []tree.ID {
 //
 // If no contiguous prefix of length bitLen exists in s,
 // RemoveFreePrefix returns ok=false.
-func (s *IDSet) RemoveFreePrefix(bitLen uint8) (tree.ID, *IDSet, bool) {
+func (s *IDSet[U]) RemoveFreePrefix(bitLen uint8) (tree.ID, *IDSet[U], bool) {
 	var bestFit tree.ID
 	for _, r := range s.rr {
 		for _, id := range r.IDs() {
 			if uint8(id.Length()) > bitLen {
 				continue
 			}
-
 			if !(bestFit != nil) || id.Length() > bestFit.Length() {
 				bestFit = id
 				if uint8(bestFit.Length()) == bitLen {
@@ -222,11 +223,147 @@ func (s *IDSet) RemoveFreePrefix(bitLen uint8) (tree.ID, *IDSet, bool) {
 		return nil, s, false
 	}
 
-	id := NewID(uint16(bestFit.ID()), bitLen)
+	id := NewID(U(bestFit.ID()), bitLen)
 
-	var b IDSetBuilder
+	var b IDSetBuilder[U]
 	b.AddSet(s)
 	b.RemoveId(id)
 	newSet, _ := b.IPSet()
 	return id, newSet, true
 }
+
+// Touching returns the ranges of s that overlap r or are adjacent to it, i.e.
+// the ranges that change when the ids of r are added to or removed from s.
+func (s *IDSet[U]) Touching(r tree.Range) []tree.Range {
+	from, to := r.From().ID(), r.To().ID()
+	i := sort.Search(len(s.rr), func(i int) bool {
+		return from == 0 || s.rr[i].To().ID() >= from-1
+	})
+	var out []tree.Range
+	for ; i < len(s.rr); i++ {
+		if to != uint64(^U(0)) && s.rr[i].From().ID() > to+1 {
+			break
+		}
+		out = append(out, s.rr[i])
+	}
+	return out
+}
+
+// Size returns the number of ids in s.
+// The result saturates at the max uint64 value.
+func (s *IDSet[U]) Size() uint64 {
+	var size uint64
+	for _, r := range s.rr {
+		n, carry := bits.Add64(r.To().ID()-r.From().ID(), 1, 0)
+		if carry != 0 {
+			return math.MaxUint64
+		}
+		size, carry = bits.Add64(size, n, 0)
+		if carry != 0 {
+			return math.MaxUint64
+		}
+	}
+	return size
+}
+
+// Contains reports whether all ids covered by id are in s.
+func (s *IDSet[U]) Contains(id tree.ID) bool {
+	return s.ContainsRange(RangeOfID[U](id))
+}
+
+// ContainsRange reports whether all ids in r are in s.
+func (s *IDSet[U]) ContainsRange(r tree.Range) bool {
+	if !r.IsValid() {
+		return false
+	}
+	i := sort.Search(len(s.rr), func(i int) bool {
+		return lessOrEq(r.From(), s.rr[i].To())
+	})
+	return i < len(s.rr) && r.CoveredBy(s.rr[i])
+}
+
+// Overlaps reports whether any id is in both s and b.
+func (s *IDSet[U]) Overlaps(b *IDSet[U]) bool {
+	i, j := 0, 0
+	for i < len(s.rr) && j < len(b.rr) {
+		switch {
+		case s.rr[i].EntirelyBefore(b.rr[j]):
+			i++
+		case b.rr[j].EntirelyBefore(s.rr[i]):
+			j++
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Equal reports whether s and b contain the same ids.
+func (s *IDSet[U]) Equal(b *IDSet[U]) bool {
+	if len(s.rr) != len(b.rr) {
+		return false
+	}
+	for i := range s.rr {
+		if s.rr[i].From().Compare(b.rr[i].From()) != 0 ||
+			s.rr[i].To().Compare(b.rr[i].To()) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns a new IDSet with the ids that are in s or b.
+func (s *IDSet[U]) Union(b *IDSet[U]) *IDSet[U] {
+	var bldr IDSetBuilder[U]
+	bldr.AddSet(s)
+	bldr.AddSet(b)
+	idset, _ := bldr.IPSet()
+	return idset
+}
+
+// Intersect returns a new IDSet with the ids that are in s and b.
+func (s *IDSet[U]) Intersect(b *IDSet[U]) *IDSet[U] {
+	var bldr IDSetBuilder[U]
+	i, j := 0, 0
+	for i < len(s.rr) && j < len(b.rr) {
+		from, to := s.rr[i].From(), s.rr[i].To()
+		if from.Less(b.rr[j].From()) {
+			from = b.rr[j].From()
+		}
+		if b.rr[j].To().Less(to) {
+			to = b.rr[j].To()
+		}
+		if lessOrEq(from, to) {
+			bldr.AddRange(idRange[U]{from: from, to: to})
+		}
+		if s.rr[i].To().Less(b.rr[j].To()) {
+			i++
+		} else {
+			j++
+		}
+	}
+	idset, _ := bldr.IPSet()
+	return idset
+}
+
+// Difference returns a new IDSet with the ids that are in s but not in b.
+func (s *IDSet[U]) Difference(b *IDSet[U]) *IDSet[U] {
+	var bldr IDSetBuilder[U]
+	bldr.AddSet(s)
+	for _, r := range b.rr {
+		bldr.RemoveRange(r)
+	}
+	idset, _ := bldr.IPSet()
+	return idset
+}
+
+// Complement returns a new IDSet with the ids in within that are not in s.
+func (s *IDSet[U]) Complement(within tree.Range) *IDSet[U] {
+	var bldr IDSetBuilder[U]
+	bldr.AddRange(within)
+	for _, r := range s.rr {
+		bldr.RemoveRange(r)
+	}
+	idset, _ := bldr.IPSet()
+	return idset
+}
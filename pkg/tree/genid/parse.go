@@ -0,0 +1,142 @@
+package genid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseError is returned by ParseSet when the input is not a valid id set.
+// Pos is the byte offset in Input where the error was found.
+type ParseError struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid id set %q at position %d: %s", e.Input, e.Pos, e.Msg)
+}
+
+// ParseSet parses a textual id set, e.g. "1,5,100-199,!150,0x1000/20".
+//
+// The set is a comma separated list of items, which are applied in order:
+//   - a single id: 5
+//   - an inclusive range: 100-199
+//   - a prefix of the id width: 0x1000/20 (the bits after the prefix length must be zero)
+//   - an exclusion of any of the above, removing it from the set: !150
+//
+// Ids are decimal, or hexadecimal with a 0x prefix. Whitespace around items is ignored.
+func ParseSet[U Uint](s string) (*IDSet[U], error) {
+	var bldr IDSetBuilder[U]
+	if strings.TrimSpace(s) == "" {
+		return bldr.IPSet()
+	}
+	pos := 0
+	for _, item := range strings.Split(s, ",") {
+		start := pos
+		pos += len(item) + 1
+
+		// skip the whitespace around the item, keeping track of the position
+		trimmed := strings.TrimLeft(item, " \t")
+		start += len(item) - len(trimmed)
+		trimmed = strings.TrimRight(trimmed, " \t")
+		if trimmed == "" {
+			return nil, &ParseError{Input: s, Pos: start, Msg: "empty item"}
+		}
+
+		exclude := false
+		if trimmed[0] == '!' {
+			exclude = true
+			trimmed = trimmed[1:]
+			start++
+		}
+		from, to, err := parseItem[U](s, trimmed, start)
+		if err != nil {
+			return nil, err
+		}
+		if exclude {
+			bldr.RemoveRange(RangeFrom(from, to))
+		} else {
+			bldr.AddRange(RangeFrom(from, to))
+		}
+	}
+	return bldr.IPSet()
+}
+
+// parseItem parses a single id, range or prefix starting at pos in the input s
+func parseItem[U Uint](s, item string, pos int) (U, U, error) {
+	if i := strings.IndexByte(item, '-'); i >= 0 {
+		from, err := parseID[U](s, item[:i], pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		to, err := parseID[U](s, item[i+1:], pos+i+1)
+		if err != nil {
+			return 0, 0, err
+		}
+		if to < from {
+			return 0, 0, &ParseError{Input: s, Pos: pos, Msg: fmt.Sprintf("range %q ends before it starts", item)}
+		}
+		return from, to, nil
+	}
+	if i := strings.IndexByte(item, '/'); i >= 0 {
+		id, err := parseID[U](s, item[:i], pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		length, err := strconv.ParseUint(item[i+1:], 10, 8)
+		if err != nil || length > uint64(BitSize[U]()) {
+			return 0, 0, &ParseError{Input: s, Pos: pos + i + 1, Msg: fmt.Sprintf("invalid prefix length %q, max %d", item[i+1:], BitSize[U]())}
+		}
+		if id&^mask[U](uint8(length)) != 0 {
+			return 0, 0, &ParseError{Input: s, Pos: pos, Msg: fmt.Sprintf("prefix %q has bits set beyond the prefix length", item)}
+		}
+		return id, U(LastID[U](NewID(id, uint8(length))).ID()), nil
+	}
+	id, err := parseID[U](s, item, pos)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, id, nil
+}
+
+// parseID parses a decimal or 0x prefixed hexadecimal id starting at pos in the input s
+func parseID[U Uint](s, id string, pos int) (U, error) {
+	base := 10
+	digits := id
+	if strings.HasPrefix(id, "0x") || strings.HasPrefix(id, "0X") {
+		base = 16
+		digits = id[2:]
+	}
+	v, err := strconv.ParseUint(digits, base, int(BitSize[U]()))
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return 0, &ParseError{Input: s, Pos: pos, Msg: fmt.Sprintf("id %q is bigger than %d", id, uint64(^U(0)))}
+		}
+		return 0, &ParseError{Input: s, Pos: pos, Msg: fmt.Sprintf("invalid id %q", id)}
+	}
+	return U(v), nil
+}
+
+// FormatSet returns the canonical string of the set: the minimal, sorted list of
+// single ids and ranges, e.g. "1,5,100-149,151-199".
+func FormatSet[U Uint](s *IDSet[U]) string {
+	var sb strings.Builder
+	for i, r := range s.rr {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if r.From().ID() == r.To().ID() {
+			sb.WriteString(strconv.FormatUint(r.From().ID(), 10))
+			continue
+		}
+		sb.WriteString(r.String())
+	}
+	return sb.String()
+}
+
+// String returns the canonical string of the set, see FormatSet.
+func (s *IDSet[U]) String() string {
+	return FormatSet(s)
+}
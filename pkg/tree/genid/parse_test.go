@@ -0,0 +1,96 @@
+package genid
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestParseSet(t *testing.T) {
+	cases := map[string]struct {
+		input       string
+		expected    string
+		expectedPos int
+		expectedErr bool
+	}{
+		"Empty": {
+			input:    "",
+			expected: "",
+		},
+		"Pool": {
+			input:    "1,5,100-199,!150,0x1000/20",
+			expected: "1,5,100-149,151-199,4096-8191",
+		},
+		"Whitespace": {
+			input:    " 1 , 2,3 ",
+			expected: "1-3",
+		},
+		"OrderMatters": {
+			input:    "1-10,!5,5",
+			expected: "1-10",
+		},
+		"ExcludeAll": {
+			input:    "1-10,!0x0/16",
+			expected: "",
+		},
+		"Hex": {
+			input:    "0xFFFF",
+			expected: "65535",
+		},
+		"EmptyItem": {
+			input:       "1,,2",
+			expectedPos: 2,
+			expectedErr: true,
+		},
+		"InvalidID": {
+			input:       "1,abc",
+			expectedPos: 2,
+			expectedErr: true,
+		},
+		"InvalidRangeEnd": {
+			input:       "1, 10-x",
+			expectedPos: 6,
+			expectedErr: true,
+		},
+		"ReversedRange": {
+			input:       "10-1",
+			expectedPos: 0,
+			expectedErr: true,
+		},
+		"TooBig": {
+			input:       "1,!4294967296",
+			expectedPos: 3,
+			expectedErr: true,
+		},
+		"PrefixLength": {
+			input:       "0x1000/33",
+			expectedPos: 7,
+			expectedErr: true,
+		},
+		"PrefixNotAligned": {
+			input:       "0x1001/20",
+			expectedPos: 0,
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			idset, err := ParseSet[uint32](tc.input)
+			if tc.expectedErr {
+				var perr *ParseError
+				assert.True(t, errors.As(err, &perr))
+				assert.Equal(t, tc.expectedPos, perr.Pos)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, FormatSet(idset))
+
+			// the canonical form parses to the same set
+			reparsed, err := ParseSet[uint32](FormatSet(idset))
+			assert.NoError(t, err)
+			assert.True(t, idset.Equal(reparsed))
+		})
+	}
+}
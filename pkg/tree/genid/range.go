@@ -1,4 +1,4 @@
-package id64
+package genid
 
 import (
 	"fmt"
@@ -10,155 +10,142 @@ import (
 	"github.com/henderiw/idxtable/pkg/tree"
 )
 
-type r64 struct {
+type idRange[U Uint] struct {
 	from tree.ID
 	to   tree.ID
 }
 
-func RangeFrom(from, to uint64) tree.Range {
-	return r64{
-		from: NewID(from, IDBitSize),
-		to:   NewID(to, IDBitSize),
+func RangeFrom[U Uint](from, to U) tree.Range {
+	return idRange[U]{
+		from: NewID(from, BitSize[U]()),
+		to:   NewID(to, BitSize[U]()),
 	}
 }
 
 // From returns the lower bound of r.
-func (r r64) From() tree.ID { return r.from }
+func (r idRange[U]) From() tree.ID { return r.from }
 
 // To returns the upper bound of r.
-func (r r64) To() tree.ID { return r.to }
+func (r idRange[U]) To() tree.ID { return r.to }
 
-func (r r64) SetTo(id tree.ID) tree.Range{
+func (r idRange[U]) SetTo(id tree.ID) tree.Range {
 	r.to = id
 	return r
 }
 
-func (r r64) SetFrom(id tree.ID) tree.Range {
+func (r idRange[U]) SetFrom(id tree.ID) tree.Range {
 	r.from = id
 	return r
 }
 
-func ParseRange(s string) (tree.Range, error) {
+func ParseRange[U Uint](s string) (tree.Range, error) {
 	var r tree.Range
 	h := strings.IndexByte(s, '-')
 	if h == -1 {
 		return r, fmt.Errorf("no hyphen in range %q", s)
 	}
 	from, to := s[:h], s[h+1:]
-	fromUint64, err := strconv.ParseUint(from, 10, int(IDBitSize))
+	fromUint, err := strconv.ParseUint(from, 10, int(BitSize[U]()))
 	if err != nil {
 		return r, fmt.Errorf("invalid from id %q in range %q", from, s)
 	}
-	toUint64, err := strconv.ParseUint(to, 10, int(IDBitSize))
+	toUint, err := strconv.ParseUint(to, 10, int(BitSize[U]()))
 	if err != nil {
 		return r, fmt.Errorf("invalid to id %q in range %q", to, s)
 	}
-	return r64{
-		from: NewID(fromUint64, IDBitSize),
-		to:   NewID(toUint64, IDBitSize),
-	}, nil
+	return RangeFrom(U(fromUint), U(toUint)), nil
 }
 
-func (r r64) String() string {
+func (r idRange[U]) String() string {
 	return fmt.Sprintf("%d-%d", r.from.ID(), r.to.ID())
 }
 
-func (r r64) IsValid() bool {
-	return r.from.Length() == r.to.Length() &&
+func (r idRange[U]) IsValid() bool {
+	return r.from != nil && r.to != nil &&
+		r.from.Length() == r.to.Length() &&
 		!(r.to.ID() < r.From().ID())
 }
 
-func (r r64) IsZero() bool {
-	return r == r64{}
+func (r idRange[U]) IsZero() bool {
+	return r == idRange[U]{}
 }
 
-func (r r64) Less(other tree.Range) bool {
+func (r idRange[U]) Less(other tree.Range) bool {
 	if cmp := r.from.Compare(other.From()); cmp != 0 {
 		return cmp < 0
 	}
 	return other.To().Less(r.to)
 }
 
-func (r r64) IDs() []tree.ID {
+func (r idRange[U]) IDs() []tree.ID {
 	return r.AppendIDs(nil)
 }
 
-func (r r64) AppendIDs(dst []tree.ID) []tree.ID {
-	return appendRangeIDs(dst, r.makeID, myuint64(r.from.ID()), myuint64(r.to.ID()))
+func (r idRange[U]) AppendIDs(dst []tree.ID) []tree.ID {
+	return appendRangeIDs(dst, U(r.from.ID()), U(r.to.ID()))
 }
 
-func (r r64) makeID(id myuint64, bits uint8) tree.ID {
-	return &myid64{id: uint64(id), length: bits}
-}
-
-// entirelyBefore returns whether r lies entirely before other in IP
+// EntirelyBefore returns whether r lies entirely before other in id
 // space.
-func (r r64) EntirelyBefore(other tree.Range) bool {
+func (r idRange[U]) EntirelyBefore(other tree.Range) bool {
 	return r.to.Less(other.From())
 }
 
 func lessOrEq(id1, id2 tree.ID) bool { return id1.Compare(id2) <= 0 }
 
-// entirelyWithin returns whether r is entirely contained within
+// CoveredBy returns whether r is entirely contained within
 // other.
-func (r r64) CoveredBy(other tree.Range) bool {
+func (r idRange[U]) CoveredBy(other tree.Range) bool {
 	return lessOrEq(other.From(), r.From()) && lessOrEq(r.To(), other.To())
 }
 
-// inMiddleOf returns whether r is inside other, but not touching the
+// InMiddleOf returns whether r is inside other, but not touching the
 // edges of other.
-func (r r64) InMiddleOf(other tree.Range) bool {
+func (r idRange[U]) InMiddleOf(other tree.Range) bool {
 	return other.From().Less(r.from) && r.to.Less(other.To())
 }
 
-// overlapsStartOf returns whether r entirely overlaps the start of
+// OverlapsStartOf returns whether r entirely overlaps the start of
 // other, but not all of other.
-func (r r64) OverlapsStartOf(other tree.Range) bool {
+func (r idRange[U]) OverlapsStartOf(other tree.Range) bool {
 	return lessOrEq(r.from, other.From()) && r.to.Less(other.To())
 }
 
-// overlapsEndOf returns whether r entirely overlaps the end of
+// OverlapsEndOf returns whether r entirely overlaps the end of
 // other, but not all of other.
-func (r r64) OverlapsEndOf(other tree.Range) bool {
+func (r idRange[U]) OverlapsEndOf(other tree.Range) bool {
 	return other.From().Less(r.from) && lessOrEq(other.To(), r.to)
 }
 
-type idMaker func(a myuint64, bits uint8) tree.ID
-
-func u64CommonMask(a, b myuint64) uint8 {
-	return uint8(bits.LeadingZeros64(uint64(a ^ b)))
+func commonMask[U Uint](a, b U) uint8 {
+	return uint8(bits.LeadingZeros64(uint64(a^b))) - (64 - BitSize[U]())
 }
 
-func appendRangeIDs(dst []tree.ID, makePrefix idMaker, a, b myuint64) []tree.ID {
+func appendRangeIDs[U Uint](dst []tree.ID, a, b U) []tree.ID {
 	common, ok := compareIDs(a, b)
 	if ok {
-		return append(dst, makePrefix(a, common))
+		return append(dst, NewID(a, common))
 	}
 	// Otherwise recursively do both halves.
-	dst = appendRangeIDs(dst, makePrefix, a, a.bitsSetFrom(common+1))
-	dst = appendRangeIDs(dst, makePrefix, b.bitsClearedFrom(common+1), b)
+	dst = appendRangeIDs(dst, a, a|^mask[U](common+1))
+	dst = appendRangeIDs(dst, b&mask[U](common+1), b)
 	return dst
 }
 
-// aZeroBSet is whether, after the common bits, a is all zero bits and
-// b is all set (one) bits.
-func compareIDs(a, b myuint64) (common uint8, aZeroBSet bool) {
-	common = u64CommonMask(a, b)
+// compareIDs returns the number of common leftmost bits of a and b, and
+// whether, after the common bits, a is all zero bits and b is all set
+// (one) bits.
+func compareIDs[U Uint](a, b U) (common uint8, aZeroBSet bool) {
+	common = commonMask(a, b)
 
 	// See whether a and b, after their common shared bits, end
 	// in all zero bits or all one bits, respectively.
-	if common == IDBitSize {
+	if common == BitSize[U]() {
 		return common, true
 	}
 
-	m := mask6[common]
-
-	ma := myuint64(a)
-	mb := myuint64(b)
-	mm := myuint64(m)
-
-	return common, (ma.xor(ma.and(mm)).isZero() &&
-		mb.or(mm) == myuint64(uint64(^uint64(0))))
+	m := mask[U](common)
+	return common, (a&^m == 0 && b|m == ^U(0))
 }
 
 // mergeRanges returns the minimum and sorted set of ranges that
@@ -217,26 +204,21 @@ func mergeRanges(rr []tree.Range) (out []tree.Range, valid bool) {
 	return out, true
 }
 
-// Range returns the inclusive range of IPs that p covers.
+// RangeOfID returns the inclusive range of ids that id covers.
 //
-// If p is zero or otherwise invalid, Range returns the zero value.
-func RangeOfID(id tree.ID) tree.Range {
-	id = id.Masked()
+// If id is nil, RangeOfID returns the zero value.
+func RangeOfID[U Uint](id tree.ID) tree.Range {
 	if id == nil {
-		return r64{}
+		return idRange[U]{}
 	}
-	return RangeFrom(id.ID(), LastID(id).ID())
+	id = id.Masked()
+	return RangeFrom(U(id.ID()), U(LastID[U](id).ID()))
 }
 
-func LastID(id tree.ID) tree.ID {
+// LastID returns the last id covered by the prefix id.
+func LastID[U Uint](id tree.ID) tree.ID {
 	if id == nil {
 		return nil
 	}
-	var a8 [8]byte
-	bePutUint64(a8[:], uint64(id.ID()))
-	for b := uint8(id.Length()); b < IDBitSize; b++ {
-		byteNum, bitInByte := b/8, 7-(b%8)
-		a8[byteNum] |= 1 << uint(bitInByte)
-	}
-	return NewID(beUint64(a8[:]), IDBitSize)
+	return NewID(U(id.ID())|^mask[U](id.Length()), BitSize[U]())
 }
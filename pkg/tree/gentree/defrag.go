@@ -0,0 +1,248 @@
+package gentree
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// span is the range of ids claimed by an entry
+type span[U genid.Uint] struct {
+	first U
+	last  U
+	entry tree.Entry
+	// pinned spans are never moved: reserved entries and entries that overlap
+	// other entries
+	pinned bool
+}
+
+// block is a candidate block of ids to free, with the spans in it
+type block[U genid.Uint] struct {
+	start U
+	// spans[first:last] overlap the block
+	first int
+	last  int
+}
+
+// PlanDefrag returns the plan with the least moves that frees a contiguous
+// block of size ids. Reserved entries are never moved; the other entries
+// are moved as a whole to the best fitting free prefix of the same length
+// outside of the block; every move has the value of the ownerKey label of its
+// entry as owner. The tree is not changed.
+func (r *gentree[U]) PlanDefrag(size uint64, ownerKey string) (*gtree.Plan, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	capacity := rangeSize(genid.RangeFrom(U(0), r.size))
+	if size == 0 || size > capacity {
+		return nil, metrics.Errorf(metrics.ReasonOutOfRange, "size %d must be between 1 and %d", size, capacity)
+	}
+	if r.free.Size() < size {
+		return nil, metrics.Errorf(metrics.ReasonExhausted, "not enough free ids for a block of size %d, free %d", size, r.free.Size())
+	}
+
+	spans := r.spans()
+	blocks := r.blocks(spans, U(size-1))
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].moves() < blocks[j].moves()
+	})
+	for _, b := range blocks {
+		if plan, ok := r.plan(b.start, b.start+U(size-1), spans[b.first:max(b.first, b.last)]); ok {
+			plan.OwnerKey = ownerKey
+			for i, move := range plan.Moves {
+				plan.Moves[i].Owner = move.Labels[ownerKey]
+			}
+			return plan, nil
+		}
+	}
+	return nil, metrics.Errorf(metrics.ReasonExhausted, "no block of size %d can be freed", size)
+}
+
+func (r block[U]) moves() int {
+	return max(r.first, r.last) - r.first
+}
+
+// spans returns the spans of the entries sorted by id; entries that overlap
+// are merged in a single pinned span.
+func (r *gentree[U]) spans() []span[U] {
+	spans := []span[U]{}
+	iter := r.iterate()
+	for iter.Next() {
+		e := iter.Entry()
+		idRange := genid.RangeOfID[U](e.ID())
+		s := span[U]{
+			first:  U(idRange.From().ID()),
+			last:   U(idRange.To().ID()),
+			entry:  e,
+			pinned: e.Labels().Has(tree.ReservedLabelKey),
+		}
+		// the trie order sorts parents before their children
+		if n := len(spans); n > 0 && s.first <= spans[n-1].last {
+			spans[n-1].last = max(spans[n-1].last, s.last)
+			spans[n-1].pinned = true
+			continue
+		}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// blocks returns the blocks of ids from start to start+n without pinned
+// spans. A block with the least spans either starts at 0 or right after a
+// span.
+func (r *gentree[U]) blocks(spans []span[U], n U) []block[U] {
+	starts := []U{0}
+	for _, s := range spans {
+		if s.last < r.size && r.size-(s.last+1) >= n {
+			starts = append(starts, s.last+1)
+		}
+	}
+	// pinned[i] is the number of pinned spans before spans[i]
+	pinned := make([]int, len(spans)+1)
+	for i, s := range spans {
+		pinned[i+1] = pinned[i]
+		if s.pinned {
+			pinned[i+1]++
+		}
+	}
+
+	blocks := []block[U]{}
+	first, last := 0, 0
+	for _, start := range starts {
+		end := start + n
+		for first < len(spans) && spans[first].last < start {
+			first++
+		}
+		for last < len(spans) && spans[last].first <= end {
+			last++
+		}
+		if last > first && pinned[last]-pinned[first] > 0 {
+			continue
+		}
+		blocks = append(blocks, block[U]{start: start, first: first, last: last})
+	}
+	return blocks
+}
+
+// plan moves the entries of spans out of the block from start to end; it
+// returns false when they do not fit in the free ids outside of the block.
+func (r *gentree[U]) plan(start, end U, spans []span[U]) (*gtree.Plan, bool) {
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddSet(r.free)
+	bldr.RemoveRange(genid.RangeFrom(start, end))
+	free, err := bldr.IPSet()
+	if err != nil {
+		return nil, false
+	}
+
+	// place the largest entries first
+	moved := append([]span[U]{}, spans...)
+	sort.SliceStable(moved, func(i, j int) bool {
+		return moved[i].entry.ID().Length() < moved[j].entry.ID().Length()
+	})
+	plan := &gtree.Plan{
+		Range: genid.RangeFrom(start, end),
+		Moves: []gtree.Move{},
+	}
+	for _, s := range moved {
+		to, rest, ok := free.RemoveFreePrefix(s.entry.ID().Length())
+		if !ok {
+			return nil, false
+		}
+		free = rest
+		plan.Moves = append(plan.Moves, gtree.Move{
+			From:   s.entry.ID().Copy(),
+			To:     to,
+			Labels: s.entry.Labels(),
+		})
+	}
+	return plan, true
+}
+
+// ApplyPlan applies the moves of the plan as a single transaction: either all
+// entries are moved or the tree is not changed. fn, when not nil, is called
+// for every move after the plan is applied.
+func (r *gentree[U]) ApplyPlan(plan *gtree.Plan, fn func(gtree.Move)) (uint64, error) {
+	r.m.Lock()
+	err := r.applyPlan(plan)
+	rev := r.revision
+	r.m.Unlock()
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	if err != nil {
+		return rev, err
+	}
+
+	if fn != nil {
+		for _, move := range plan.Moves {
+			fn(move)
+		}
+	}
+	return rev, nil
+}
+
+func (r *gentree[U]) applyPlan(plan *gtree.Plan) error {
+	entries := make([]tree.Entry, 0, len(plan.Moves))
+	for i, move := range plan.Moves {
+		if move.From.Length() != move.To.Length() {
+			return fmt.Errorf("move from %s to %s changes the length", move.From, move.To)
+		}
+		if err := r.validate(move.To); err != nil {
+			return err
+		}
+		e, err := r.get(move.From)
+		if err != nil || e.ID().ID() != move.From.ID() || e.ID().Length() != move.From.Length() {
+			return metrics.Errorf(metrics.ReasonNotFound, "move from %s not claimed", move.From)
+		}
+		if !labels.Equals(e.Labels(), move.Labels) {
+			return fmt.Errorf("move from %s has labels %s, got %s", move.From, e.Labels(), move.Labels)
+		}
+		if !r.free.Contains(move.To) {
+			return metrics.Errorf(metrics.ReasonClaimed, "move to %s not free", move.To)
+		}
+		for _, prev := range plan.Moves[:i] {
+			if prev.From.Overlaps(move.From) {
+				return metrics.Errorf(metrics.ReasonNotFound, "move from %s overlaps move from %s", move.From, prev.From)
+			}
+			if prev.To.Overlaps(move.To) {
+				return metrics.Errorf(metrics.ReasonClaimed, "move to %s overlaps move to %s", move.To, prev.To)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	moved := make([]tree.Entry, 0, len(entries))
+	for i, e := range entries {
+		newEntry, err := r.move(e, plan.Moves[i].To)
+		if err != nil {
+			// revert the applied moves
+			for j := len(moved) - 1; j >= 0; j-- {
+				r.move(moved[j], entries[j].ID())
+			}
+			return err
+		}
+		moved = append(moved, newEntry)
+	}
+	return nil
+}
+
+// move releases the entry e and claims it at to with the same labels and
+// metadata. When the claim fails e is claimed again at its id, so a failed
+// move leaves the tree unchanged.
+func (r *gentree[U]) move(e tree.Entry, to tree.ID) (tree.Entry, error) {
+	if err := r.del(e.ID(), e); err != nil {
+		return nil, err
+	}
+	moved, err := r.set(to, tree.WithMeta(tree.NewEntry(to.Copy(), e.Labels()), e.Meta()))
+	if err != nil {
+		if _, rerr := r.put(e.ID(), e, false); rerr != nil {
+			return nil, fmt.Errorf("%w, restore of the moved entry %s failed: %s", err, e.ID(), rerr.Error())
+		}
+		return nil, err
+	}
+	return moved, nil
+}
@@ -0,0 +1,287 @@
+package gentree
+
+import (
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Delegate marks the claimed prefix id as a child pool. The entry keeps its
+// labels and gets the tree.DelegatedLabelKey label.
+func (r *gentree[U]) Delegate(id tree.ID) (gtree.Scope, error) {
+	s, err := r.delegate(id)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return s, err
+}
+
+func (r *gentree[U]) delegate(id tree.ID) (gtree.Scope, error) {
+	if err := r.validate(id); err != nil {
+		return nil, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.markDelegated(id); err != nil {
+		return nil, err
+	}
+	return &scope[U]{tree: r, prefix: id.Copy()}, nil
+}
+
+func (r *gentree[U]) Scope(id tree.ID) (gtree.Scope, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	if err := r.delegated(id); err != nil {
+		return nil, err
+	}
+	return &scope[U]{tree: r, prefix: id.Copy()}, nil
+}
+
+// ReleaseCascade releases the entry of id and all the entries claimed in it,
+// from the most specific one. When id is part of a claimed prefix it is
+// released like ReleaseID.
+func (r *gentree[U]) ReleaseCascade(id tree.ID) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.releaseCascade(id)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return r.revision, err
+}
+
+// releaseCascade releases the entry of id and the entries claimed in it; the
+// caller must hold the lock
+func (r *gentree[U]) releaseCascade(id tree.ID) error {
+	if err := r.validate(id); err != nil {
+		return err
+	}
+
+	e, err := r.get(id)
+	if err != nil {
+		return nil
+	}
+	if e.ID().Length() != id.Length() {
+		return r.release(e, id)
+	}
+	children := r.children(e.ID())
+	for i := len(children) - 1; i >= 0; i-- {
+		if err := r.del(children[i].ID(), children[i]); err != nil {
+			return err
+		}
+	}
+	return r.del(e.ID(), e)
+}
+
+// exact returns the entry claimed for exactly id
+func (r *gentree[U]) exact(id tree.ID) (tree.Entry, error) {
+	e, err := r.get(id)
+	if err != nil || e.ID().ID() != id.ID() || e.ID().Length() != id.Length() {
+		return nil, metrics.Errorf(metrics.ReasonNotFound, "prefix %s not claimed", id)
+	}
+	return e, nil
+}
+
+// delegated returns an error when id is not a delegated prefix
+func (r *gentree[U]) delegated(id tree.ID) error {
+	e, err := r.exact(id)
+	if err != nil {
+		return err
+	}
+	if !e.Labels().Has(tree.DelegatedLabelKey) {
+		return fmt.Errorf("prefix %s is not delegated", id)
+	}
+	return nil
+}
+
+// undelegated returns an error when id overlaps a delegated prefix: the ids
+// in a delegated prefix are only claimed and updated through its scope, and
+// the prefix itself is only released.
+func (r *gentree[U]) undelegated(id tree.ID) error {
+	for _, e := range r.tree.Overlapping(id) {
+		if e.Labels().Has(tree.DelegatedLabelKey) {
+			return metrics.Errorf(metrics.ReasonClaimed, "id %s overlaps the delegated prefix %s", id, e.ID())
+		}
+	}
+	return nil
+}
+
+func (r *gentree[U]) markDelegated(id tree.ID) error {
+	e, err := r.exact(id)
+	if err != nil {
+		return err
+	}
+	if e.Labels().Has(tree.DelegatedLabelKey) {
+		return nil
+	}
+	l := labels.Merge(e.Labels(), labels.Set{tree.DelegatedLabelKey: "true"})
+	_, err = r.set(id, tree.NewEntry(id.Copy(), l))
+	return err
+}
+
+// scope is the handle on a delegated prefix of the tree; it shares the lock
+// and the metrics hook of the tree.
+type scope[U genid.Uint] struct {
+	tree   *gentree[U]
+	prefix tree.ID
+}
+
+func (r *scope[U]) Prefix() tree.ID {
+	return r.prefix.Copy()
+}
+
+// validate returns an error when the prefix is no longer delegated or id is
+// not in the prefix
+func (r *scope[U]) validate(id tree.ID) error {
+	if err := r.tree.delegated(r.prefix); err != nil {
+		return err
+	}
+	if id.Length() <= r.prefix.Length() || !r.prefix.Overlaps(id) {
+		return metrics.Errorf(metrics.ReasonOutOfRange, "id %s is not in the delegated prefix %s", id, r.prefix)
+	}
+	return nil
+}
+
+func (r *scope[U]) Get(id tree.ID) (tree.Entry, error) {
+	r.tree.m.RLock()
+	defer r.tree.m.RUnlock()
+
+	if err := r.validate(id); err != nil {
+		return nil, err
+	}
+	e, err := r.tree.get(id)
+	if err != nil || e.ID().Length() <= r.prefix.Length() {
+		return nil, metrics.Errorf(metrics.ReasonNotFound, "entry %s not found", id)
+	}
+	return e, nil
+}
+
+func (r *scope[U]) IsFree(id tree.ID) bool {
+	r.tree.m.RLock()
+	defer r.tree.m.RUnlock()
+
+	if err := r.validate(id); err != nil {
+		return false
+	}
+	return r.isFree(id)
+}
+
+func (r *scope[U]) isFree(id tree.ID) bool {
+	for _, e := range r.tree.children(r.prefix) {
+		if e.ID().Overlaps(id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *scope[U]) ClaimID(id tree.ID, labels labels.Set) error {
+	err := r.claimID(id, labels)
+	metrics.Observe(r.tree.hook, metrics.OperationClaim, err)
+	return err
+}
+
+func (r *scope[U]) claimID(id tree.ID, labels labels.Set) error {
+	r.tree.m.Lock()
+	defer r.tree.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return err
+	}
+	if !r.isFree(id) {
+		return metrics.Errorf(metrics.ReasonClaimed, "id %s already claimed in %s", id, r.prefix)
+	}
+	_, err := r.tree.set(id, tree.NewEntry(id.Copy(), labels))
+	return err
+}
+
+func (r *scope[U]) ClaimFree(labels labels.Set) (tree.Entry, error) {
+	e, err := r.claimFree(labels)
+	metrics.Observe(r.tree.hook, metrics.OperationClaim, err)
+	return e, err
+}
+
+func (r *scope[U]) claimFree(labels labels.Set) (tree.Entry, error) {
+	r.tree.m.Lock()
+	defer r.tree.m.Unlock()
+
+	if err := r.tree.delegated(r.prefix); err != nil {
+		return nil, err
+	}
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddId(r.prefix)
+	for _, e := range r.tree.children(r.prefix) {
+		bldr.RemoveId(e.ID())
+	}
+	free, err := bldr.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	id, _, ok := free.RemoveFreePrefix(genid.BitSize[U]())
+	if !ok {
+		return nil, metrics.Errorf(metrics.ReasonExhausted, "no free ids available in %s", r.prefix)
+	}
+	return r.tree.set(id, tree.NewEntry(id.Copy(), labels))
+}
+
+func (r *scope[U]) ReleaseID(id tree.ID) error {
+	err := r.releaseID(id)
+	metrics.Observe(r.tree.hook, metrics.OperationRelease, err)
+	return err
+}
+
+func (r *scope[U]) releaseID(id tree.ID) error {
+	r.tree.m.Lock()
+	defer r.tree.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return err
+	}
+	e, err := r.tree.get(id)
+	if err != nil || e.ID().Length() <= r.prefix.Length() {
+		return nil
+	}
+	return r.tree.release(e, id)
+}
+
+func (r *scope[U]) GetAll() tree.Entries {
+	r.tree.m.RLock()
+	defer r.tree.m.RUnlock()
+
+	return r.tree.children(r.prefix)
+}
+
+func (r *scope[U]) Delegate(id tree.ID) (gtree.Scope, error) {
+	s, err := r.delegate(id)
+	metrics.Observe(r.tree.hook, metrics.OperationUpdate, err)
+	return s, err
+}
+
+func (r *scope[U]) delegate(id tree.ID) (gtree.Scope, error) {
+	r.tree.m.Lock()
+	defer r.tree.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return nil, err
+	}
+	if err := r.tree.markDelegated(id); err != nil {
+		return nil, err
+	}
+	return &scope[U]{tree: r.tree, prefix: id.Copy()}, nil
+}
+
+func (r *scope[U]) Scope(id tree.ID) (gtree.Scope, error) {
+	r.tree.m.RLock()
+	defer r.tree.m.RUnlock()
+
+	if err := r.validate(id); err != nil {
+		return nil, err
+	}
+	if err := r.tree.delegated(id); err != nil {
+		return nil, err
+	}
+	return &scope[U]{tree: r.tree, prefix: id.Copy()}, nil
+}
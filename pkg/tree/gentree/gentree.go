@@ -0,0 +1,942 @@
+// Package gentree implements a gtree.GTree that is generic over the width of
+// the ids.
+package gentree
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// New returns a tree for ids of width U, where at most length bits are used;
+// e.g. a tree with U uint32 and length 20 holds ids 0 to 2^20-1.
+func New[U genid.Uint](name string, length uint8) (gtree.GTree, error) {
+	if length > genid.BitSize[U]() {
+		return nil, fmt.Errorf("cannot create a tree which bitlength > %d, got: %d", genid.BitSize[U](), length)
+	}
+	size := U(1)<<length - 1
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddRange(genid.RangeFrom(U(0), size))
+	free, err := bldr.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	return &gentree[U]{
+		m:      new(sync.RWMutex),
+		tree:   tree.NewTree[tree.Entry](name, genid.IsLeftBitSet[U], genid.BitSize[U]()),
+		size:   size,
+		length: length,
+		free:   free,
+		runs:   newFreeRuns(free.Ranges()),
+	}, nil
+}
+
+type gentree[U genid.Uint] struct {
+	m      *sync.RWMutex
+	tree   *tree.Tree[tree.Entry]
+	size   U
+	length uint8
+	hook   metrics.Hook
+	// count, free and runs are maintained on every change of the tree; free
+	// is never modified in place, so it can be shared with clones and
+	// snapshots, which get a copy of runs
+	count int
+	free  *genid.IDSet[U]
+	runs  *freeRuns
+	// quotas is nil when no quota rules are set
+	quotas *quota.Quotas
+	evict  tree.EvictionFunc
+	// storage is nil when the tree is not persisted
+	storage storage.Storage
+	// revision is incremented by every change of an entry; the entries carry
+	// the revision of their last change
+	revision uint64
+	// audit is nil when the changes are not recorded
+	audit *audit.Log
+	actor string
+	clock tree.Clock
+}
+
+// Clone returns a copy of the tree in O(1), which shares the nodes with the
+// tree until either of them changes them; it locks the tree as the shared
+// nodes are handed over to the copy.
+func (r *gentree[U]) Clone() gtree.GTree {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return &gentree[U]{
+		m:        new(sync.RWMutex),
+		tree:     r.tree.Clone(),
+		size:     r.size,
+		length:   r.length,
+		count:    r.count,
+		free:     r.free,
+		runs:     r.runs.clone(),
+		quotas:   r.quotas.Clone(),
+		revision: r.revision,
+		clock:    r.clock,
+	}
+}
+
+// Snapshot returns a consistent read-only view of the tree in O(1). The
+// snapshot is not affected by later mutations of the tree, which copy the
+// nodes on the path from the root to the changed node only.
+func (r *gentree[U]) Snapshot() gtree.Reader {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return &gentree[U]{
+		m:        new(sync.RWMutex),
+		tree:     r.tree.Snapshot(),
+		size:     r.size,
+		length:   r.length,
+		count:    r.count,
+		free:     r.free,
+		runs:     r.runs.clone(),
+		revision: r.revision,
+	}
+}
+
+// Get returns the entry claimed for id. When id itself is not stored, but is
+// part of an aggregate prefix (e.g. claimed through ClaimRange), the most
+// specific covering entry is returned.
+func (r *gentree[U]) Get(id tree.ID) (tree.Entry, error) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.get(id)
+}
+
+func (r *gentree[U]) get(id tree.ID) (tree.Entry, error) {
+	var covering tree.Entry
+
+	iter := r.iterate()
+	for iter.Next() {
+		entry := iter.Entry()
+		if entry.ID().ID() == id.ID() &&
+			entry.ID().Length() == id.Length() {
+			return entry, nil
+		}
+		if entry.ID().Length() < id.Length() && entry.ID().Overlaps(id) {
+			if covering == nil || entry.ID().Length() > covering.ID().Length() {
+				covering = entry
+			}
+		}
+	}
+	if covering != nil {
+		return covering, nil
+	}
+	return nil, fmt.Errorf("entry %d not found", id)
+}
+
+func (r *gentree[U]) Update(id tree.ID, labels labels.Set) (uint64, error) {
+	rev, err := r.update(id, labels)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return rev, err
+}
+
+func (r *gentree[U]) update(id tree.ID, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+	if err := r.undelegated(id); err != nil {
+		return r.revision, err
+	}
+	_, err := r.set(id, tree.NewEntry(id.Copy(), labels))
+	return r.revision, err
+}
+
+func (r *gentree[U]) UpdateIf(id tree.ID, revision uint64, labels labels.Set) (uint64, error) {
+	rev, err := r.updateIf(id, revision, labels)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return rev, err
+}
+
+func (r *gentree[U]) updateIf(id tree.ID, revision uint64, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+
+	e, err := r.exact(id)
+	if err != nil {
+		return r.revision, err
+	}
+	if err := checkRevision(e, revision); err != nil {
+		return r.revision, err
+	}
+	if err := r.undelegated(id); err != nil {
+		return r.revision, err
+	}
+	_, err = r.set(id, tree.NewEntry(id.Copy(), labels))
+	return r.revision, err
+}
+
+func (r *gentree[U]) ReleaseIf(id tree.ID, revision uint64) (uint64, error) {
+	rev, err := r.releaseIf(id, revision)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return rev, err
+}
+
+func (r *gentree[U]) releaseIf(id tree.ID, revision uint64) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+
+	e, err := r.get(id)
+	if err != nil {
+		return r.revision, metrics.Errorf(metrics.ReasonNotFound, "id %s not claimed", id)
+	}
+	if err := checkRevision(e, revision); err != nil {
+		return r.revision, err
+	}
+	return r.revision, r.release(e, id)
+}
+
+func (r *gentree[U]) Revision() uint64 {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	return r.revision
+}
+
+// checkRevision returns an error when the entry e was changed after revision
+func checkRevision(e tree.Entry, revision uint64) error {
+	if e.Revision() != revision {
+		return metrics.Errorf(metrics.ReasonRevisionMismatch, "entry %s is at revision %d, expected %d", e.ID(), e.Revision(), revision)
+	}
+	return nil
+}
+
+// Annotate replaces the annotations of the entry claimed for id
+func (r *gentree[U]) Annotate(id tree.ID, annotations map[string]string) (uint64, error) {
+	rev, err := r.annotate(id, annotations)
+	metrics.Observe(r.hook, metrics.OperationUpdate, err)
+	return rev, err
+}
+
+func (r *gentree[U]) annotate(id tree.ID, annotations map[string]string) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+	e, err := r.exact(id)
+	if err != nil {
+		return r.revision, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	// the labels do not change, so the quotas are not checked
+	_, err = r.put(id, tree.WithMeta(tree.NewEntry(id.Copy(), e.Labels()), tree.Meta{Annotations: annotations}), false)
+	return r.revision, err
+}
+
+func (r *gentree[U]) ClaimID(id tree.ID, labels labels.Set) (uint64, error) {
+	rev, err := r.claimID(id, labels, tree.Meta{})
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return rev, err
+}
+
+func (r *gentree[U]) ClaimWithMeta(id tree.ID, labels labels.Set, meta tree.Meta) (uint64, error) {
+	rev, err := r.claimID(id, labels, meta)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return rev, err
+}
+
+// claimID claims id for the labels; the entry keeps meta when it has a
+// creation time
+func (r *gentree[U]) claimID(id tree.ID, labels labels.Set, meta tree.Meta) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+	if err := r.undelegated(id); err != nil {
+		return r.revision, err
+	}
+	_, err := r.set(id, tree.WithMeta(tree.NewEntry(id.Copy(), labels), meta))
+	return r.revision, err
+}
+
+// ClaimFree claims a free id. When the tree is exhausted and the labels have a
+// tree.PriorityLabelKey, the first id of the smallest preemptible entry with
+// the lowest lower priority is evicted and claimed.
+func (r *gentree[U]) ClaimFree(labels labels.Set) (tree.Entry, uint64, error) {
+	r.m.Lock()
+	e, eviction, err := r.claimFree(labels)
+	rev := r.revision
+	r.m.Unlock()
+
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	if eviction != nil && r.evict != nil {
+		r.evict(*eviction)
+	}
+	return e, rev, err
+}
+
+// claimFree claims a free id for ClaimFree; the caller must hold the lock
+func (r *gentree[U]) claimFree(labels labels.Set) (tree.Entry, *tree.Eviction, error) {
+	id, err := r.findFree()
+	if err != nil {
+		return r.preempt(labels, metrics.Errorf(metrics.ReasonExhausted, "no free ids available, err: %s", err.Error()))
+	}
+
+	treeId := genid.NewID(id, genid.BitSize[U]())
+	e, err := r.set(treeId, tree.NewEntry(treeId.Copy(), labels))
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, nil, nil
+}
+
+// preempt evicts an id for a claim with labels that have a
+// tree.PriorityLabelKey. Only the first id of the victim is evicted: the
+// other ids of an aggregate victim stay claimed with its labels. The claim is
+// checked against the quotas after the eviction; when it fails the victim is
+// restored. When no id is evicted err is returned.
+func (r *gentree[U]) preempt(labels labels.Set, err error) (tree.Entry, *tree.Eviction, error) {
+	if !labels.Has(tree.PriorityLabelKey) {
+		return nil, nil, err
+	}
+	priority, perr := tree.Priority(labels)
+	if perr != nil {
+		return nil, nil, perr
+	}
+	entries := tree.Entries{}
+	iter := r.iterate()
+	for iter.Next() {
+		entries = append(entries, iter.Entry())
+	}
+	victim, ok := tree.Victim(entries, priority)
+	if !ok {
+		return nil, nil, err
+	}
+
+	treeId := genid.RangeOfID[U](victim.ID()).From()
+	if err := r.release(victim, treeId); err != nil {
+		return nil, nil, err
+	}
+	e, err := r.set(treeId, tree.NewEntry(treeId.Copy(), labels))
+	if err != nil {
+		if rerr := r.restore(victim, treeId); rerr != nil {
+			return nil, nil, fmt.Errorf("%w, restore of the evicted entry %s failed: %s", err, victim.ID(), rerr.Error())
+		}
+		return nil, nil, err
+	}
+	evicted := tree.WithMeta(tree.NewEntry(treeId.Copy(), victim.Labels()), victim.Meta())
+	return e, &tree.Eviction{Evicted: evicted, By: e}, nil
+}
+
+// restore claims the victim again after the eviction of its id failed; the
+// prefixes that remained of an aggregate victim are merged back into it.
+func (r *gentree[U]) restore(victim tree.Entry, id tree.ID) error {
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddId(victim.ID())
+	bldr.RemoveId(id)
+	idset, err := bldr.IPSet()
+	if err != nil {
+		return err
+	}
+	for _, treeId := range idset.IDs() {
+		if e, err := r.exact(treeId); err == nil {
+			if err := r.del(treeId, e); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = r.put(victim.ID(), victim, false)
+	return err
+}
+
+// ClaimRange claims the ids in the range s (from-to). The range is stored as
+// the minimal set of aggregate prefixes covering it rather than one entry per
+// id.
+func (r *gentree[U]) ClaimRange(s string, labels labels.Set) (uint64, error) {
+	rev, err := r.claimRange(s, labels)
+	metrics.Observe(r.hook, metrics.OperationClaim, err)
+	return rev, err
+}
+
+func (r *gentree[U]) claimRange(s string, labels labels.Set) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	idRange, err := genid.ParseRange[U](s)
+	if err != nil {
+		return r.revision, err
+	}
+	// TODO check if free
+
+	// get each entry and validate owner
+
+	for _, treeId := range idRange.IDs() {
+		if err := r.undelegated(treeId); err != nil {
+			return r.revision, err
+		}
+	}
+	for _, treeId := range idRange.IDs() {
+		treeEntry := tree.NewEntry(treeId.Copy(), labels)
+		if _, err := r.set(treeId, treeEntry); err != nil {
+			return r.revision, err
+		}
+	}
+	return r.revision, nil
+}
+
+func (r *gentree[U]) set(id tree.ID, e tree.Entry) (tree.Entry, error) {
+	return r.put(id, e, true)
+}
+
+// put sets the entry e for id and returns it with the new revision of the
+// tree; when checkQuotas is false the entry counts for the quota rules
+// without being checked against them, e.g. for the prefixes that remain of a
+// released aggregate. An entry that does not change the claimed entry of id
+// is a no-op and returns the claimed entry.
+func (r *gentree[U]) put(id tree.ID, e tree.Entry, checkQuotas bool) (tree.Entry, error) {
+	if old, err := r.exact(id); err == nil && unchanged(e, old) {
+		return old, nil
+	}
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddSet(r.free)
+	bldr.RemoveId(id)
+	free, err := bldr.IPSet()
+	if err != nil {
+		return nil, err
+	}
+	size := rangeSize(genid.RangeOfID[U](id))
+	var stamped tree.Entry
+	old, replaced := r.tree.ReplaceFunc(id, func(old tree.Entry, replaced bool) tree.Entry {
+		stamped = r.stamp(e, old, replaced)
+		return stamped
+	})
+	e = stamped
+	switch {
+	case !checkQuotas:
+		if replaced {
+			r.quotas.Release(old.Labels(), size)
+		}
+		r.quotas.Add(e.Labels(), size)
+	case replaced:
+		err = r.quotas.Update(old.Labels(), size, e.Labels(), size)
+	default:
+		err = r.quotas.Claim(e.Labels(), size)
+	}
+	// revert the change of the tree
+	revert := func() {
+		if replaced {
+			r.tree.Set(id, old)
+		} else {
+			r.tree.Delete(id, func(e1, e2 tree.Entry) bool { return e1.Equal(e2) }, e)
+		}
+	}
+	if err != nil {
+		revert()
+		return nil, err
+	}
+	if err := r.write(e); err != nil {
+		r.quotas.Release(e.Labels(), size)
+		if replaced {
+			r.quotas.Add(old.Labels(), size)
+		}
+		revert()
+		return nil, err
+	}
+	if !replaced {
+		r.count++
+		r.record(metrics.OperationClaim, id, nil, e.Labels())
+	} else {
+		r.record(metrics.OperationUpdate, id, old.Labels(), e.Labels())
+	}
+	r.setFree(free, id)
+	r.revision++
+	return e, nil
+}
+
+// record adds the change of the entry of id from the labels before to after
+// to the audit log; the caller must hold the lock
+func (r *gentree[U]) record(op metrics.Operation, id tree.ID, before, after labels.Set) {
+	if r.audit == nil {
+		return
+	}
+	r.audit.Append(audit.Record{
+		Time:   r.clock.Now(),
+		Op:     op,
+		ID:     recordID[U](id),
+		Actor:  r.actor,
+		Before: before,
+		After:  after,
+	})
+}
+
+// unchanged returns whether e leaves the entry old as it is: the labels are
+// equal and e has no metadata other than the annotations of old
+func unchanged(e, old tree.Entry) bool {
+	meta := e.Meta()
+	return labels.Equals(e.Labels(), old.Labels()) && meta.Created.IsZero() &&
+		(meta.Annotations == nil || maps.Equal(meta.Annotations, old.Meta().Annotations))
+}
+
+// unrecorded calls fn without recording its changes in the audit log, for
+// the changes that are part of one recorded by the caller; the caller must
+// hold the lock
+func (r *gentree[U]) unrecorded(fn func() error) error {
+	log := r.audit
+	r.audit = nil
+	defer func() { r.audit = log }()
+	return fn()
+}
+
+// recordID returns the id of an audit record of id: the id for a single id,
+// as recorded by the tables, and the prefix for a prefix
+func recordID[U genid.Uint](id tree.ID) string {
+	if id.Length() == genid.BitSize[U]() {
+		return strconv.FormatUint(id.ID(), 10)
+	}
+	return id.String()
+}
+
+// stamp returns e at the next revision of the tree, changed now; old is the
+// entry e replaces, if any. An entry with a creation time, e.g. a moved entry,
+// keeps its metadata. The annotations of old are kept when e has none.
+func (r *gentree[U]) stamp(e, old tree.Entry, replaced bool) tree.Entry {
+	meta := e.Meta()
+	if meta.Created.IsZero() {
+		now := r.clock.Now()
+		meta.Created, meta.Updated, meta.Generation = now, now, 1
+		if replaced {
+			meta.Created = old.Meta().Created
+			meta.Generation = old.Meta().Generation + 1
+			if meta.Annotations == nil {
+				meta.Annotations = old.Meta().Annotations
+			}
+		}
+	}
+	return tree.WithRevision(tree.WithMeta(e, meta), r.revision+1)
+}
+
+// findFree returns the first free id of the best fitting free prefix; the
+// caller must hold the lock
+func (r *gentree[U]) findFree() (U, error) {
+	availableID, _, _ := r.free.RemoveFreePrefix(genid.BitSize[U]())
+	if availableID == nil {
+		return 0, fmt.Errorf("no free id available")
+	}
+	if err := r.validate(availableID); err != nil {
+		return 0, err
+	}
+	return U(availableID.ID()), nil
+}
+
+// ReleaseID releases the entry claimed for id. If id is part of an aggregate
+// prefix, the aggregate is split and the remaining prefixes stay claimed with
+// the labels of the aggregate.
+func (r *gentree[U]) ReleaseID(id tree.ID) (uint64, error) {
+	rev, err := r.releaseID(id)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return rev, err
+}
+
+func (r *gentree[U]) releaseID(id tree.ID) (uint64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.validate(id); err != nil {
+		return r.revision, err
+	}
+	e, err := r.get(id)
+	if err != nil {
+		return r.revision, nil
+	}
+	err = r.release(e, id)
+	return r.revision, err
+}
+
+// release releases id, which is claimed by the entry e. A delegated prefix is
+// only released as a whole and when no ids are claimed in it.
+func (r *gentree[U]) release(e tree.Entry, id tree.ID) error {
+	if e.Labels().Has(tree.DelegatedLabelKey) {
+		if e.ID().Length() != id.Length() {
+			return metrics.Errorf(metrics.ReasonClaimed, "id %s is part of the delegated prefix %s", id, e.ID())
+		}
+		if children := r.children(e.ID()); len(children) > 0 {
+			return metrics.Errorf(metrics.ReasonClaimed, "delegated prefix %s has %d claimed entries", e.ID(), len(children))
+		}
+	}
+	// the release is recorded once for id, the deletion of the aggregate and
+	// the prefixes that remain of it are part of it
+	if err := r.unrecorded(func() error { return r.del(e.ID(), e) }); err != nil {
+		return err
+	}
+	r.record(metrics.OperationRelease, id, e.Labels(), nil)
+	if e.ID().Length() == id.Length() {
+		return nil
+	}
+
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddId(e.ID())
+	bldr.RemoveId(id)
+	idset, err := bldr.IPSet()
+	if err != nil {
+		return err
+	}
+	return r.unrecorded(func() error {
+		for _, treeId := range idset.IDs() {
+			// the remaining prefixes keep the metadata of the aggregate
+			treeEntry := tree.WithMeta(tree.NewEntry(treeId.Copy(), e.Labels()), e.Meta())
+			if _, err := r.put(treeId, treeEntry, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsFree returns true when no claimed entry overlaps id, either as an exact
+// match, a covering aggregate prefix or a more specific child.
+func (r *gentree[U]) IsFree(id tree.ID) bool {
+	if err := r.validate(id); err != nil {
+		return false
+	}
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	iter := r.iterate()
+	for iter.Next() {
+		if iter.Entry().ID().Overlaps(id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *gentree[U]) ReleaseByLabel(selector labels.Selector) (uint64, error) {
+	entries := r.GetByLabel(selector)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	err := r.releaseByLabel(entries)
+	metrics.Observe(r.hook, metrics.OperationRelease, err)
+	return r.revision, err
+}
+
+// releaseByLabel releases the entries, which match the selector of
+// ReleaseByLabel; the caller must hold the lock
+func (r *gentree[U]) releaseByLabel(entries tree.Entries) error {
+
+	// delegated prefixes are only released together with the ids claimed in them
+	released := map[string]struct{}{}
+	for _, e := range entries {
+		released[e.ID().String()] = struct{}{}
+	}
+	for _, e := range entries {
+		if !e.Labels().Has(tree.DelegatedLabelKey) {
+			continue
+		}
+		for _, child := range r.children(e.ID()) {
+			if _, ok := released[child.ID().String()]; !ok {
+				return metrics.Errorf(metrics.ReasonClaimed, "delegated prefix %s has claimed entry %s", e.ID(), child.ID())
+			}
+		}
+	}
+	for _, e := range entries {
+		if err := r.del(e.ID().Copy(), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *gentree[U]) del(id tree.ID, e tree.Entry) error {
+	matchFunc := func(e1, e2 tree.Entry) bool {
+		return e1.Equal(e2)
+	}
+	deleted := r.tree.Delete(id, matchFunc, e)
+	if deleted == 0 {
+		return nil
+	}
+	if err := r.erase(id); err != nil {
+		r.tree.Set(id, e)
+		return err
+	}
+	r.count -= deleted
+	r.revision++
+	r.record(metrics.OperationRelease, id, e.Labels(), nil)
+	r.quotas.Release(e.Labels(), rangeSize(genid.RangeOfID[U](id)))
+
+	// the ids of id become free, except the ones still claimed by other
+	// overlapping entries
+	var bldr genid.IDSetBuilder[U]
+	bldr.AddSet(r.free)
+	bldr.AddId(id)
+	for _, o := range r.tree.Overlapping(id) {
+		bldr.RemoveId(o.ID())
+	}
+	// ids beyond the size of the tree are never free
+	if r.size < ^U(0) {
+		bldr.RemoveRange(genid.RangeFrom(r.size+1, ^U(0)))
+	}
+	free, err := bldr.IPSet()
+	if err != nil {
+		return err
+	}
+	r.setFree(free, id)
+	return nil
+}
+
+// setFree replaces the free ids after a change of the ids of id; only the
+// free runs that touch id change, so only these are counted again
+func (r *gentree[U]) setFree(free *genid.IDSet[U], id tree.ID) {
+	changed := genid.RangeOfID[U](id)
+	r.runs.update(r.free.Touching(changed), free.Touching(changed))
+	r.free = free
+}
+
+// Children returns the entries that are more specific than id, e.g. the ids
+// claimed in a delegated prefix.
+func (r *gentree[U]) Children(id tree.ID) tree.Entries {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.children(id)
+}
+
+func (r *gentree[U]) children(id tree.ID) tree.Entries {
+	entries := tree.Entries{}
+	iter := r.iterate()
+	for iter.Next() {
+		entry := iter.Entry()
+		if entry.ID().Overlaps(id) && entry.ID().Length() > id.Length() {
+			entries = append(entries, iter.Entry())
+		}
+
+	}
+	return entries
+}
+
+// Parents returns the entries that cover id and are less specific, from the
+// least specific one; for an id claimed in a delegated prefix it is the chain
+// of delegated prefixes.
+func (r *gentree[U]) Parents(id tree.ID) tree.Entries {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.parents(id)
+}
+
+func (r *gentree[U]) parents(id tree.ID) tree.Entries {
+	entries := tree.Entries{}
+	iter := r.iterate()
+	for iter.Next() {
+		entry := iter.Entry()
+		if entry.ID().Overlaps(id) && entry.ID().Length() < id.Length() {
+			entries = append(entries, iter.Entry())
+		}
+	}
+	return entries
+}
+
+func (r *gentree[U]) GetByLabel(selector labels.Selector) tree.Entries {
+	entries := tree.Entries{}
+
+	iter := r.Iterate()
+	for iter.Next() {
+		if selector.Matches(iter.Entry().Labels()) {
+			entries = append(entries, iter.Entry())
+		}
+	}
+
+	return entries
+}
+
+// GetByMeta returns the entries with metadata matching selector, e.g.
+// tree.OlderThan(24 * time.Hour)
+func (r *gentree[U]) GetByMeta(selector tree.MetaSelector) tree.Entries {
+	entries := tree.Entries{}
+
+	iter := r.Iterate()
+	for iter.Next() {
+		if selector(iter.Entry().Meta()) {
+			entries = append(entries, iter.Entry())
+		}
+	}
+	return entries
+}
+
+func (r *gentree[U]) GetAll() tree.Entries {
+	entries := tree.Entries{}
+
+	iter := r.Iterate()
+	for iter.Next() {
+		entries = append(entries, iter.Entry())
+	}
+
+	return entries
+}
+
+func (r *gentree[U]) Size() int {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.count
+}
+
+func (r *gentree[U]) Iterate() *gtree.GTreeIterator {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.iterate()
+}
+
+func (r *gentree[U]) iterate() *gtree.GTreeIterator {
+	return &gtree.GTreeIterator{
+		Iter: r.tree.Iterate(),
+	}
+}
+
+func (r *gentree[U]) validate(id tree.ID) error {
+	if id.ID() > uint64(r.size) {
+		return metrics.Errorf(metrics.ReasonOutOfRange, "max id allowed is %d, got %d", r.size, id.ID())
+	}
+	if id.Length() < uint8(0) {
+		return fmt.Errorf("min allowed length is %d, got %d", r.length, id.Length())
+	}
+	return nil
+}
+
+func (r *gentree[U]) PrintNodes() {
+	r.tree.PrintNodes(0)
+}
+
+func (r *gentree[U]) PrintValues() {
+	r.tree.PrintValues()
+}
+
+// SetMetricsHook sets the hook called after every claim, release and update.
+// It is not safe to call concurrently with the other methods.
+func (r *gentree[U]) SetMetricsHook(hook metrics.Hook) {
+	r.hook = hook
+}
+
+// Usage returns the utilization of the tree; the ids of a claimed prefix
+// count as claimed. Counts that do not fit in 64 bits saturate.
+func (r *gentree[U]) Usage() metrics.Usage {
+	stats := r.Stats()
+
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	var reservedBldr genid.IDSetBuilder[U]
+	iter := r.iterate()
+	for iter.Next() {
+		if e := iter.Entry(); e.Labels().Has(tree.ReservedLabelKey) {
+			reservedBldr.AddId(e.ID())
+		}
+	}
+	reserved, _ := reservedBldr.IPSet()
+
+	return metrics.Usage{
+		Capacity:         stats.Capacity,
+		Claimed:          stats.Claimed,
+		Free:             stats.Free,
+		Reserved:         reserved.Size(),
+		LargestFreeBlock: stats.LargestFreeRun,
+	}
+}
+
+// Stats returns the utilization and fragmentation of the tree from the counts
+// of the free runs, which are maintained on every change. Counts that do not
+// fit in 64 bits saturate.
+func (r *gentree[U]) Stats() metrics.Stats {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	stats := metrics.Stats{
+		Capacity: rangeSize(genid.RangeFrom(U(0), r.size)),
+	}
+	r.runs.stats(&stats)
+	stats.Claimed = stats.Capacity - stats.Free
+	return stats
+}
+
+// rangeSize returns the number of ids in the range, saturating at the max uint64
+func rangeSize(r tree.Range) uint64 {
+	size := r.To().ID() - r.From().ID()
+	if size == ^uint64(0) {
+		return size
+	}
+	return size + 1
+}
+
+// SetEvictionFunc sets the function called for every entry evicted by a claim
+// with a higher priority. It is not safe to call concurrently with the other
+// methods.
+func (r *gentree[U]) SetEvictionFunc(fn tree.EvictionFunc) {
+	r.evict = fn
+}
+
+// SetAuditLog records every claim, update and release made by actor in the
+// log; the ids are recorded as prefixes, e.g. "4096/20". Clones and snapshots
+// of the tree do not record their changes.
+func (r *gentree[U]) SetAuditLog(log *audit.Log, actor string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.audit, r.actor = log, actor
+}
+
+// SetClock sets the clock of the metadata and the audit records of the
+// changes; clones of the tree keep the clock.
+func (r *gentree[U]) SetClock(clock tree.Clock) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.clock = clock
+}
+
+// SetQuotas replaces the quota rules of the tree. The ids of a claimed prefix
+// count for the rules; the entries in the tree count in the usage, even when
+// they exceed a rule.
+func (r *gentree[U]) SetQuotas(rules []quota.Rule) error {
+	quotas, err := quota.New(rules)
+	if err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	iter := r.iterate()
+	for iter.Next() {
+		e := iter.Entry()
+		quotas.Add(e.Labels(), rangeSize(genid.RangeOfID[U](e.ID())))
+	}
+	r.quotas = quotas
+	return nil
+}
+
+func (r *gentree[U]) QuotaUsage() []quota.Usage {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	return r.quotas.Usage()
+}
@@ -0,0 +1,78 @@
+package gentree
+
+import (
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
+)
+
+// Reconcile loads the desired claims in the tree. The claims are applied from
+// oldest to newest, so a claim that overlaps an older one is reported as a
+// conflict. Entries that hold exactly the id of a desired claim get the labels
+// of the claim, unless they hold them already, the other entries are reported
+// as stale and the claims overlapping them as pending.
+func (r *gentree[U]) Reconcile(desired []gtree.ClaimSpec) (*gtree.ReconcileReport, error) {
+	report := &gtree.ReconcileReport{}
+
+	accepted := []gtree.ClaimSpec{}
+	for _, claim := range reconcile.Sort(desired) {
+		if err := r.validate(claim.ID); err != nil || genid.LastID[U](claim.ID).ID() > uint64(r.size) {
+			report.OutOfRange = append(report.OutOfRange, claim)
+			continue
+		}
+		if winner, ok := overlappingClaim(accepted, claim.ID); ok {
+			// the same claim listed twice is not a conflict
+			if winner.Name != claim.Name || !sameID(winner.ID, claim.ID) {
+				report.Conflicts = append(report.Conflicts, reconcile.Conflict[gtree.ClaimSpec]{Claim: claim, Winner: winner})
+			}
+			continue
+		}
+		accepted = append(accepted, claim)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	iter := r.iterate()
+	for iter.Next() {
+		e := iter.Entry()
+		if claim, ok := overlappingClaim(accepted, e.ID()); ok && sameID(claim.ID, e.ID()) {
+			continue
+		}
+		report.Stale = append(report.Stale, e)
+	}
+
+	for _, claim := range accepted {
+		pending := false
+		for _, e := range report.Stale {
+			if e.ID().Overlaps(claim.ID) {
+				pending = true
+				break
+			}
+		}
+		if pending {
+			report.Pending = append(report.Pending, claim)
+			continue
+		}
+		if _, err := r.set(claim.ID, tree.NewEntry(claim.ID.Copy(), claim.Labels)); err != nil {
+			return report, err
+		}
+		report.Loaded = append(report.Loaded, claim)
+	}
+	return report, nil
+}
+
+// overlappingClaim returns the first claim that overlaps id
+func overlappingClaim(claims []gtree.ClaimSpec, id tree.ID) (gtree.ClaimSpec, bool) {
+	for _, claim := range claims {
+		if claim.ID.Overlaps(id) {
+			return claim, true
+		}
+	}
+	return gtree.ClaimSpec{}, false
+}
+
+func sameID(a, b tree.ID) bool {
+	return a.ID() == b.ID() && a.Length() == b.Length()
+}
@@ -0,0 +1,106 @@
+package gentree
+
+import (
+	"maps"
+	"math"
+	"math/bits"
+
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/tree"
+)
+
+// freeRuns keeps the counts of the runs of free ids by size and of the free
+// prefixes by length, so the free ids and their fragmentation are known
+// without walking the free ids. A change of the tree only updates the runs
+// that touch the changed id.
+type freeRuns struct {
+	// hi and lo are the number of free ids, which exceeds 64 bits when all
+	// the ids of a 64 bit tree are free
+	hi, lo uint64
+	// sizes is the number of runs by size
+	sizes     map[uint64]uint64
+	runs      uint64
+	histogram [64]uint64
+	// prefixes is the number of free prefixes by length
+	prefixes map[uint8]uint64
+}
+
+func newFreeRuns(free []tree.Range) *freeRuns {
+	r := &freeRuns{sizes: map[uint64]uint64{}, prefixes: map[uint8]uint64{}}
+	for _, run := range free {
+		r.add(run)
+	}
+	return r
+}
+
+// add counts the free run
+func (r *freeRuns) add(run tree.Range) {
+	n, carry := bits.Add64(run.To().ID()-run.From().ID(), 1, 0)
+	var c uint64
+	r.lo, c = bits.Add64(r.lo, n, 0)
+	r.hi += carry + c
+
+	size := rangeSize(run)
+	r.sizes[size]++
+	r.runs++
+	r.histogram[metrics.RunSizeBucket(size)]++
+	for _, id := range run.IDs() {
+		r.prefixes[id.Length()]++
+	}
+}
+
+// remove no longer counts the free run
+func (r *freeRuns) remove(run tree.Range) {
+	n, carry := bits.Add64(run.To().ID()-run.From().ID(), 1, 0)
+	var b uint64
+	r.lo, b = bits.Sub64(r.lo, n, 0)
+	r.hi -= carry + b
+
+	size := rangeSize(run)
+	if r.sizes[size]--; r.sizes[size] == 0 {
+		delete(r.sizes, size)
+	}
+	r.runs--
+	r.histogram[metrics.RunSizeBucket(size)]--
+	for _, id := range run.IDs() {
+		if r.prefixes[id.Length()]--; r.prefixes[id.Length()] == 0 {
+			delete(r.prefixes, id.Length())
+		}
+	}
+}
+
+// update replaces the counts of the runs before with the ones of after
+func (r *freeRuns) update(before, after []tree.Range) {
+	for _, run := range before {
+		r.remove(run)
+	}
+	for _, run := range after {
+		r.add(run)
+	}
+}
+
+func (r *freeRuns) clone() *freeRuns {
+	c := *r
+	c.sizes = maps.Clone(r.sizes)
+	c.prefixes = maps.Clone(r.prefixes)
+	return &c
+}
+
+// stats sets the free ids and the run statistics; the number of free ids
+// saturates
+func (r *freeRuns) stats(s *metrics.Stats) {
+	s.Free = r.lo
+	if r.hi > 0 {
+		s.Free = math.MaxUint64
+	}
+	s.FreeRuns = r.runs
+	for size := range r.sizes {
+		s.LargestFreeRun = max(s.LargestFreeRun, size)
+	}
+	last := len(r.histogram)
+	for last > 0 && r.histogram[last-1] == 0 {
+		last--
+	}
+	s.RunSizes = append([]uint64{}, r.histogram[:last]...)
+	s.FreePrefixes = maps.Clone(r.prefixes)
+}
@@ -0,0 +1,86 @@
+package gentree
+
+import (
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SetStorage loads the records of s in the tree and writes every following
+// change through to s, one record per prefix with the metadata of the entry.
+// A record replaces the entry of the same prefix with its metadata and counts
+// in the quotas, even when it exceeds a rule; the other entries of the tree
+// are written to s. Clones and snapshots of the
+// tree do not write to s.
+func (r *gentree[U]) SetStorage(s storage.Storage) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	loaded := map[string]struct{}{}
+	if err := s.Range(func(rec storage.Record) error {
+		id, err := parseKey[U](rec.Key)
+		if err != nil {
+			return err
+		}
+		if err := r.validate(id); err != nil {
+			return err
+		}
+		loaded[rec.Key] = struct{}{}
+		if e, err := r.exact(id); err == nil && labels.Equals(e.Labels(), rec.Labels) {
+			return nil
+		}
+		_, err = r.put(id, tree.WithMeta(tree.NewEntry(id.Copy(), rec.Labels), rec.Meta), false)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	iter := r.iterate()
+	for iter.Next() {
+		e := iter.Entry()
+		if _, ok := loaded[e.ID().String()]; ok {
+			continue
+		}
+		if err := s.Put(record(e)); err != nil {
+			return err
+		}
+	}
+	r.storage = s
+	return nil
+}
+
+// parseKey parses the key of a record, e.g. "4096/20"
+func parseKey[U genid.Uint](key string) (tree.ID, error) {
+	idset, err := genid.ParseSet[U](key)
+	if err != nil {
+		return nil, err
+	}
+	ids := idset.IDs()
+	if len(ids) != 1 {
+		return nil, fmt.Errorf("invalid record %q, not a single prefix", key)
+	}
+	return ids[0], nil
+}
+
+func record(e tree.Entry) storage.Record {
+	return storage.Record{Key: e.ID().String(), Labels: e.Labels(), Meta: e.Meta()}
+}
+
+// write stores the entry e, the caller must hold the lock
+func (r *gentree[U]) write(e tree.Entry) error {
+	if r.storage == nil {
+		return nil
+	}
+	return r.storage.Put(record(e))
+}
+
+// erase removes the record of id, the caller must hold the lock
+func (r *gentree[U]) erase(id tree.ID) error {
+	if r.storage == nil {
+		return nil
+	}
+	return r.storage.Delete(id.String())
+}
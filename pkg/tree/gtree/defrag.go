@@ -0,0 +1,34 @@
+package gtree
+
+import (
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Move relocates the entry claimed at From, with its labels, to To. Both ids
+// have the same length.
+type Move struct {
+	From   tree.ID
+	To     tree.ID
+	Labels labels.Set
+	// Owner is the value of the owner label of the entry, see Plan
+	Owner string
+}
+
+// Plan is the set of moves that frees the ids in Range
+type Plan struct {
+	Range tree.Range
+	// OwnerKey is the label key of the owner of the moved entries; the moves
+	// have no owner when it is empty
+	OwnerKey string
+	Moves    []Move
+}
+
+// ByOwner returns the moves of the plan grouped by their owner
+func (r *Plan) ByOwner() map[string][]Move {
+	moves := map[string][]Move{}
+	for _, move := range r.Moves {
+		moves[move.Owner] = append(moves[move.Owner], move)
+	}
+	return moves
+}
@@ -0,0 +1,181 @@
+package gtree
+
+import (
+	"fmt"
+
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ConflictPolicy defines how Merge handles an id that is claimed in both trees
+// with different labels
+type ConflictPolicy int
+
+const (
+	// ConflictPolicyKeep keeps the labels of the destination tree
+	ConflictPolicyKeep ConflictPolicy = iota
+	// ConflictPolicyOverwrite overwrites the labels with the ones of the source tree
+	ConflictPolicyOverwrite
+	// ConflictPolicyFail fails the merge without changing the destination tree
+	ConflictPolicyFail
+)
+
+// Change is an entry that exists in both trees with different labels
+type Change struct {
+	Old tree.Entry
+	New tree.Entry
+}
+
+type DiffResult struct {
+	// Added are the entries in b that are not in a
+	Added tree.Entries
+	// Removed are the entries in a that are not in b
+	Removed tree.Entries
+	// Changed are the entries in a and b with different labels
+	Changed []Change
+}
+
+func (r DiffResult) IsEmpty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+// Diff returns the entries that are added, removed or changed in b compared to a.
+// The tries of both trees are compared node by node, matching the nodes by
+// their prefix; the subtrees that both trees share, e.g. the ones of a tree
+// and its snapshot that were not changed since, are skipped.
+func Diff(a, b Reader) DiffResult {
+	result := DiffResult{
+		Added:   tree.Entries{},
+		Removed: tree.Entries{},
+		Changed: []Change{},
+	}
+
+	// we store only 1 entry per node
+	tree.Diff(a.Iterate().Iter.Tree(), b.Iterate().Iter.Tree(), func(ea, eb []tree.Entry) {
+		switch {
+		case len(eb) == 0:
+			result.Removed = append(result.Removed, ea[0])
+		case len(ea) == 0:
+			result.Added = append(result.Added, eb[0])
+		case !labels.Equals(ea[0].Labels(), eb[0].Labels()):
+			result.Changed = append(result.Changed, Change{Old: ea[0], New: eb[0]})
+		}
+	})
+	return result
+}
+
+// Merge claims the entries of src that are not in dst. An entry of src that
+// overlaps entries of dst, e.g. an id in a claimed aggregate, and an entry
+// that exists in both with different labels are conflicts, which are handled
+// according to the conflict policy; ConflictPolicyOverwrite releases the
+// overlap with dst before the entry of src is claimed: an aggregate of dst
+// that covers the entry is split, so its other ids stay claimed, and the
+// entries of dst it covers are released. Entries that only exist in dst are
+// left untouched otherwise.
+// The merge is applied as a whole: either all the changes are made or, when
+// one of them fails, the ones already made are reverted.
+func Merge(dst GTree, src Reader, policy ConflictPolicy) error {
+	diff := Diff(dst, src)
+	if policy == ConflictPolicyFail && len(diff.Changed) > 0 {
+		return fmt.Errorf("merge conflict on %d entries, first: %s", len(diff.Changed), diff.Changed[0].Old.ID().String())
+	}
+
+	claims := tree.Entries{}
+	// released are the ids of dst released for the claims, with the labels
+	// they are claimed with again when the merge is reverted
+	released := tree.Entries{}
+	releasedIDs := map[string]struct{}{}
+	release := func(e tree.Entry) {
+		if _, ok := releasedIDs[e.ID().String()]; !ok {
+			releasedIDs[e.ID().String()] = struct{}{}
+			released = append(released, e)
+		}
+	}
+	for _, e := range diff.Added {
+		if dst.IsFree(e.ID()) {
+			claims = append(claims, e)
+			continue
+		}
+		switch policy {
+		case ConflictPolicyFail:
+			return fmt.Errorf("merge conflict on %s, which overlaps claimed entries", e.ID().String())
+		case ConflictPolicyOverwrite:
+			// releasing the id of e splits the most specific aggregate
+			// covering it
+			if parents := dst.Parents(e.ID()); len(parents) > 0 {
+				release(tree.NewEntry(e.ID(), parents[len(parents)-1].Labels()))
+			}
+			for _, c := range dst.Children(e.ID()) {
+				release(c)
+			}
+			claims = append(claims, e)
+		}
+	}
+
+	undo := []func(){}
+	apply := func(do func() (uint64, error), revert func()) error {
+		if _, err := do(); err != nil {
+			for i := len(undo) - 1; i >= 0; i-- {
+				undo[i]()
+			}
+			return err
+		}
+		undo = append(undo, revert)
+		return nil
+	}
+	// the changed entries are updated first, so the prefixes that remain of a
+	// split aggregate keep the labels of src
+	if policy == ConflictPolicyOverwrite {
+		for _, c := range diff.Changed {
+			if _, ok := releasedIDs[c.Old.ID().String()]; ok {
+				continue
+			}
+			if err := apply(
+				func() (uint64, error) { return dst.Update(c.Old.ID().Copy(), c.New.Labels()) },
+				func() { _, _ = dst.Update(c.Old.ID().Copy(), c.Old.Labels()) },
+			); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range released {
+		if err := apply(
+			func() (uint64, error) { return dst.ReleaseID(e.ID().Copy()) },
+			func() { _, _ = dst.ClaimID(e.ID().Copy(), e.Labels()) },
+		); err != nil {
+			return err
+		}
+	}
+	for _, e := range claims {
+		if err := apply(
+			func() (uint64, error) { return dst.ClaimID(e.ID().Copy(), e.Labels()) },
+			func() { _, _ = dst.ReleaseID(e.ID().Copy()) },
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompareIDs compares 2 ids in trie order: by their common prefix bits first,
+// and a shorter prefix sorts before the longer prefixes it covers.
+// The result will be 0 if a == b, -1 if a < b, and +1 if a > b.
+func CompareIDs(a, b tree.ID) int {
+	length := a.Length()
+	if b.Length() < length {
+		length = b.Length()
+	}
+	ma, _ := a.Mask(length)
+	mb, _ := b.Mask(length)
+	switch {
+	case ma.ID() < mb.ID():
+		return -1
+	case ma.ID() > mb.ID():
+		return 1
+	case a.Length() < b.Length():
+		return -1
+	case a.Length() > b.Length():
+		return 1
+	}
+	return 0
+}
@@ -1,29 +1,94 @@
 package gtree
 
 import (
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"github.com/henderiw/idxtable/pkg/storage"
 	"github.com/henderiw/idxtable/pkg/tree"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
-
-
+// GTree is a tree of labeled ids and prefixes. Every mutation returns the
+// revision of the tree after the mutation, also when it fails.
 type GTree interface {
+	Reader
 	Clone() GTree
+	Snapshot() Reader
+	Update(id tree.ID, labels labels.Set) (uint64, error)
+	ClaimID(id tree.ID, labels labels.Set) (uint64, error)
+	// ClaimWithMeta claims id with the metadata of an entry that is restored,
+	// e.g. from a snapshot
+	ClaimWithMeta(id tree.ID, labels labels.Set, meta tree.Meta) (uint64, error)
+	ClaimFree(labels labels.Set) (tree.Entry, uint64, error)
+	ClaimRange(s string, labels labels.Set) (uint64, error)
+	ReleaseID(id tree.ID) (uint64, error)
+	ReleaseByLabel(selector labels.Selector) (uint64, error)
+	// Annotate replaces the annotations of the entry claimed for id
+	Annotate(id tree.ID, annotations map[string]string) (uint64, error)
+	// UpdateIf updates the entry of id when it is still at revision
+	UpdateIf(id tree.ID, revision uint64, labels labels.Set) (uint64, error)
+	// ReleaseIf releases id like ReleaseID when the entry claiming it is still
+	// at revision
+	ReleaseIf(id tree.ID, revision uint64) (uint64, error)
+	// Revision returns the revision of the tree, incremented by every change
+	// of an entry
+	Revision() uint64
+	// Delegate marks the claimed prefix id as a child pool, whose ids are
+	// claimed through the returned scope; ClaimID, ClaimRange and Update
+	// reject the ids that overlap it. A delegated prefix with claimed ids is
+	// only released by ReleaseCascade.
+	Delegate(id tree.ID) (Scope, error)
+	// Scope returns the handle on the delegated prefix id
+	Scope(id tree.ID) (Scope, error)
+	// ReleaseCascade releases the entry of id and all the ids claimed in it
+	ReleaseCascade(id tree.ID) (uint64, error)
+	// SetEvictionFunc sets the function called for every entry evicted by a
+	// ClaimFree with a higher priority
+	SetEvictionFunc(fn tree.EvictionFunc)
+	// SetQuotas replaces the quota rules, which are enforced on every claim
+	SetQuotas(rules []quota.Rule) error
+	// QuotaUsage returns the usage versus the limit of every quota rule
+	QuotaUsage() []quota.Usage
+	// SetStorage loads the records of the storage in the tree and writes
+	// every following change through to it
+	SetStorage(s storage.Storage) error
+	// SetAuditLog records every claim, update and release made by actor in
+	// the log. A single id is recorded as "10" and a prefix as "4096/20"; the
+	// release of an id of an aggregate is recorded once, for the id.
+	SetAuditLog(log *audit.Log, actor string)
+	// SetClock sets the clock of the metadata and the audit records of the
+	// changes, e.g. to apply a replicated change at the time of the leader
+	SetClock(clock tree.Clock)
+	// Reconcile loads the desired claims in the tree. When claims overlap the
+	// oldest one wins; entries that are not claimed are reported as stale and
+	// left in the tree.
+	Reconcile(desired []ClaimSpec) (*ReconcileReport, error)
+	// PlanDefrag returns the moves that free a contiguous block of size ids,
+	// with the value of the ownerKey label of the moved entries
+	PlanDefrag(size uint64, ownerKey string) (*Plan, error)
+	// ApplyPlan applies all the moves of the plan or none of them
+	ApplyPlan(plan *Plan, fn func(Move)) (uint64, error)
+	metrics.Instrumented
+	PrintNodes()
+	PrintValues()
+}
+
+// Reader is the read-only view of a GTree, as returned by Snapshot
+type Reader interface {
 	Get(id tree.ID) (tree.Entry, error)
-	Update(id tree.ID, labels labels.Set) error
-	ClaimID(id tree.ID, labels labels.Set) error
-	ClaimFree(labels labels.Set) (tree.Entry, error)
-	ClaimRange(s string, labels labels.Set) error
-	ReleaseID(id tree.ID) error
-	ReleaseByLabel(selector labels.Selector) error
+	IsFree(id tree.ID) bool
 	Children(id tree.ID) tree.Entries
 	Parents(id tree.ID) tree.Entries
 	GetByLabel(selector labels.Selector) tree.Entries
+	// GetByMeta returns the entries with metadata matching selector
+	GetByMeta(selector tree.MetaSelector) tree.Entries
 	GetAll() tree.Entries
-	Size() int 
+	Size() int
 	Iterate() *GTreeIterator
-	PrintNodes()
-	PrintValues()
+	// Stats returns the utilization and fragmentation of the tree
+	Stats() metrics.Stats
 }
 
 type GTreeIterator struct {
@@ -38,4 +103,13 @@ func (i *GTreeIterator) Entry() tree.Entry {
 	l := i.Iter.Vals()
 	// we store only 1 entry
 	return l[0]
-}
\ No newline at end of file
+}
+
+// ClaimSpec is a claim of an id or prefix loaded by Reconcile
+type ClaimSpec struct {
+	reconcile.Meta
+	ID     tree.ID
+	Labels labels.Set
+}
+
+type ReconcileReport = reconcile.Report[ClaimSpec, tree.Entry]
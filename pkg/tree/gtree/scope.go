@@ -0,0 +1,27 @@
+package gtree
+
+import (
+	"github.com/henderiw/idxtable/pkg/tree"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Scope is a handle on a delegated prefix of a GTree, which is a child pool
+// of the tree. The ids are claimed and released within the prefix only; a
+// claimed prefix in the scope can be delegated again, e.g. a /24 of a site
+// in the /20 of a region.
+type Scope interface {
+	// Prefix returns the delegated prefix of the scope
+	Prefix() tree.ID
+	Get(id tree.ID) (tree.Entry, error)
+	IsFree(id tree.ID) bool
+	ClaimID(id tree.ID, labels labels.Set) error
+	ClaimFree(labels labels.Set) (tree.Entry, error)
+	ReleaseID(id tree.ID) error
+	// GetAll returns the entries claimed in the scope, including the ones in
+	// the delegated prefixes of the scope
+	GetAll() tree.Entries
+	// Delegate marks the claimed prefix id in the scope as a child pool
+	Delegate(id tree.ID) (Scope, error)
+	// Scope returns the handle on the delegated prefix id in the scope
+	Scope(id tree.ID) (Scope, error)
+}
@@ -1,142 +1,63 @@
+// Package id16 provides 16 bit wide ids, ranges and id sets. It is a thin
+// wrapper around the width independent implementation in genid.
 package id16
 
 import (
-	"fmt"
-	"math/bits"
-
 	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/genid"
 )
 
-const IDBitSize =  uint8(16)
+const IDBitSize = uint8(16)
 
-const _leftmostBit = uint16(1 << (IDBitSize - 1))
+type IDSetBuilder = genid.IDSetBuilder[uint16]
 
-func IsLeftBitSet(id uint64) bool {
-	return uint16(id) >= _leftmostBit
-}
+type IDSet = genid.IDSet[uint16]
 
-type myid16 struct {
-	id     uint16
-	length uint8
+func IsLeftBitSet(id uint64) bool {
+	return genid.IsLeftBitSet[uint16](id)
 }
 
 func NewID(id uint16, length uint8) tree.ID {
-	return myid16{
-		id:     id,
-		length: length,
-	}
+	return genid.NewID(id, length)
 }
 
-func (r myid16) Copy() tree.ID {
-	return myid16{
-		id:     r.id,
-		length: r.length,
-	}
+func RangeFrom(from, to uint16) tree.Range {
+	return genid.RangeFrom(from, to)
 }
 
-func (r myid16) Length() uint8 {
-	return r.length
+func ParseRange(s string) (tree.Range, error) {
+	return genid.ParseRange[uint16](s)
 }
 
-func (r myid16) ID() uint64 {
-	return uint64(r.id)
+// RangeOfID returns the inclusive range of ids that id covers.
+func RangeOfID(id tree.ID) tree.Range {
+	return genid.RangeOfID[uint16](id)
 }
 
-// ShiftLeft shifts the address to the left
-func (r myid16) ShiftLeft(shiftCount uint8) tree.ID {
-	r.id <<= shiftCount
-	r.length -= shiftCount
-	return r
+// LastID returns the last id covered by the prefix id.
+func LastID(id tree.ID) tree.ID {
+	return genid.LastID[uint16](id)
 }
 
-// IsLeftBitSet returns whether the leftmost bit is set
-func (r myid16) IsLeftBitSet() bool {
-	return r.id >= _leftmostBit
-}
-
-// String returns a string version of this ID.
-func (r myid16) String() string {
-	return fmt.Sprintf("%d/%d", r.id, r.length)
-}
-
-func (r myid16) Matches(id uint64) uint8 {
-	return uint8(bits.LeadingZeros16(uint16(id) ^ r.id))
-}
-
-func (r myid16) Overlaps(b tree.ID) bool {
-	var minbits uint8
-	if r.Length() < b.Length() {
-		minbits = r.Length()
-	} else {
-		minbits = b.Length()
-	}
-	if minbits == 0 {
-		return true
-	}
-	ida := r.id & mask6[minbits]
-	idb := uint16(b.ID()) & mask6[minbits]
-
-	return ida == idb
-}
-
-// Compare returns an integer comparing two IDs.
-// The result will be 0 if id == id2, -1 if id < id2, and +1 if id > id2.
-// The definition of "less than" is the same as the [Addr.Less] method.
-func (r myid16) Compare(id2 tree.ID) int {
-	f1, f2 := r.Length(), id2.Length()
-	if f1 < f2 {
-		return -1
-	}
-	if f1 > f2 {
-		return 1
-	}
-	if r.ID() < id2.ID() {
-		return -1
-	}
-	if r.ID() > id2.ID() {
-		return 1
-	}
-	return 0
-}
-
-// Less reports whether ip sorts before ip2.
-// IP addresses sort first by length, then their address.
-// IPv6 addresses with zones sort just after the same address without a zone.
-func (r myid16) Less(id2 tree.ID) bool { return r.Compare(id2) == -1 }
-
-// Next returns the address following ip.
-// If there is none, it returns the zero [Addr].
-func (id myid16) Next() tree.ID {
-	id.id = uint16(myuint16(id.id).addOne())
-	if id.id == 0 {
-		// Overflowed.
-		return myid16{}
-	}
-
-	return id
+// ParseSet parses a textual id set, e.g. "1,5,100-199,!150,0x1000/20".
+// See genid.ParseSet for the syntax.
+func ParseSet(s string) (*IDSet, error) {
+	return genid.ParseSet[uint16](s)
 }
 
-// Prev returns the ID before id.
-// If there is none, it returns the ID zero value.
-func (id myid16) Prev() tree.ID {
-	if id.id == 0 {
-		return myid16{}
-	}
-	id.id = uint16(myuint16(id.id).subOne())
-	return id
+// FormatSet returns the canonical string of the set, e.g. "1,5,100-149,151-199".
+func FormatSet(s *IDSet) string {
+	return genid.FormatSet(s)
 }
 
-// Prev returns the ID before id.
-// If there is none, it returns the ID zero value.
-func (id myid16) Mask(l uint8) (tree.ID, error) {
-	if l > IDBitSize {
-		return nil, fmt.Errorf("length is too large, max %d, got: %d", IDBitSize, l)
-	}
-	newid := uint16(myuint16(id.id).and(myuint16(mask6[uint16(l)])))
-	return myid16{id: newid, length: l}, nil
+// Sub16 returns the difference of x, y and borrow, diff = x - y - borrow.
+// The borrow input must be 0 or 1; the borrowOut output is 0 or 1.
+func Sub16(x, y, borrow uint16) (diff, borrowOut uint16) {
+	return genid.Sub(x, y, borrow)
 }
 
-func (id myid16) Masked() tree.ID {
-	mid, _ := id.Mask(id.length)
-	return mid
+// Add16 returns the sum with carry of x, y and carry: sum = x + y + carry.
+// The carry input must be 0 or 1; the carryOut output is 0 or 1.
+func Add16(x, y, carry uint16) (sum, carryOut uint16) {
+	return genid.Add(x, y, carry)
 }
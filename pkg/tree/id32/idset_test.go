@@ -0,0 +1,109 @@
+package id32
+
+import (
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func newSet(t *testing.T, ranges ...[2]uint32) *IDSet {
+	var bldr IDSetBuilder
+	for _, r := range ranges {
+		bldr.AddRange(RangeFrom(r[0], r[1]))
+	}
+	idset, err := bldr.IPSet()
+	assert.NoError(t, err)
+	return idset
+}
+
+func rangeStrings(s *IDSet) []string {
+	out := []string{}
+	for _, r := range s.Ranges() {
+		out = append(out, r.String())
+	}
+	return out
+}
+
+func TestIDSetAlgebra(t *testing.T) {
+	cases := map[string]struct {
+		a                  [][2]uint32
+		b                  [][2]uint32
+		expectedUnion      []string
+		expectedIntersect  []string
+		expectedDifference []string
+		expectedOverlaps   bool
+	}{
+		"Overlapping": {
+			a:                  [][2]uint32{{1, 10}, {20, 30}},
+			b:                  [][2]uint32{{5, 25}},
+			expectedUnion:      []string{"1-30"},
+			expectedIntersect:  []string{"5-10", "20-25"},
+			expectedDifference: []string{"1-4", "26-30"},
+			expectedOverlaps:   true,
+		},
+		"Disjoint": {
+			a:                  [][2]uint32{{1, 10}},
+			b:                  [][2]uint32{{12, 20}},
+			expectedUnion:      []string{"1-10", "12-20"},
+			expectedIntersect:  []string{},
+			expectedDifference: []string{"1-10"},
+			expectedOverlaps:   false,
+		},
+		"Adjacent": {
+			a:                  [][2]uint32{{0, 10}},
+			b:                  [][2]uint32{{11, 4294967295}},
+			expectedUnion:      []string{"0-4294967295"},
+			expectedIntersect:  []string{},
+			expectedDifference: []string{"0-10"},
+			expectedOverlaps:   false,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			a := newSet(t, tc.a...)
+			b := newSet(t, tc.b...)
+
+			assert.Equal(t, tc.expectedUnion, rangeStrings(a.Union(b)))
+			assert.Equal(t, tc.expectedIntersect, rangeStrings(a.Intersect(b)))
+			assert.Equal(t, tc.expectedIntersect, rangeStrings(b.Intersect(a)))
+			assert.Equal(t, tc.expectedDifference, rangeStrings(a.Difference(b)))
+			assert.Equal(t, tc.expectedOverlaps, a.Overlaps(b))
+			assert.Equal(t, tc.expectedOverlaps, b.Overlaps(a))
+			assert.True(t, a.Union(b).Equal(b.Union(a)))
+		})
+	}
+}
+
+func TestIDSetQueries(t *testing.T) {
+	s := newSet(t, [2]uint32{100, 199}, [2]uint32{300, 300})
+
+	assert.Equal(t, uint64(101), s.Size())
+	assert.True(t, s.Contains(NewID(150, IDBitSize)))
+	assert.True(t, s.Contains(NewID(128, 27)))
+	assert.False(t, s.Contains(NewID(128, 24)))
+	assert.False(t, s.Contains(NewID(200, IDBitSize)))
+	assert.True(t, s.ContainsRange(RangeFrom(100, 199)))
+	assert.False(t, s.ContainsRange(RangeFrom(199, 300)))
+
+	// free = pool - claimed
+	free := newSet(t, [2]uint32{1, 4094}).Difference(s)
+	assert.Equal(t, uint64(4094-101), free.Size())
+	assert.Equal(t, []string{"1-99", "200-299", "301-4094"}, rangeStrings(free))
+	assert.True(t, free.Equal(s.Complement(RangeFrom(1, 4094))))
+	assert.Equal(t, uint64(1<<32), newSet(t, [2]uint32{0, 4294967295}).Size())
+
+	// the ranges that overlap a range or are adjacent to it
+	touching := func(s *IDSet, from, to uint32) []string {
+		out := []string{}
+		for _, r := range s.Touching(RangeFrom(from, to)) {
+			out = append(out, r.String())
+		}
+		return out
+	}
+	assert.Equal(t, []string{"100-199"}, touching(s, 200, 298))
+	assert.Equal(t, []string{"100-199", "300-300"}, touching(s, 200, 299))
+	assert.Equal(t, []string{}, touching(s, 201, 298))
+	assert.Equal(t, []string{"100-199"}, touching(s, 0, 99))
+	assert.Equal(t, []string{"0-4294967295"}, touching(newSet(t, [2]uint32{0, 4294967295}), 0, 4294967295))
+}
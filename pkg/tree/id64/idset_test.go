@@ -0,0 +1,31 @@
+package id64
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tj/assert"
+)
+
+func TestIDSetAlgebra(t *testing.T) {
+	var bldr IDSetBuilder
+	bldr.AddRange(RangeFrom(0, math.MaxUint64))
+	all, err := bldr.IPSet()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), all.Size())
+
+	bldr = IDSetBuilder{}
+	bldr.AddRange(RangeFrom(1<<40, 1<<41))
+	claimed, err := bldr.IPSet()
+	assert.NoError(t, err)
+
+	free := all.Difference(claimed)
+	assert.Equal(t, 2, len(free.Ranges()))
+	assert.Equal(t, uint64(1<<40-1), free.Ranges()[0].To().ID())
+	assert.Equal(t, uint64(1<<41+1), free.Ranges()[1].From().ID())
+	assert.False(t, free.Overlaps(claimed))
+	assert.True(t, free.Union(claimed).Equal(all))
+	assert.True(t, claimed.Complement(RangeFrom(0, math.MaxUint64)).Equal(free))
+	assert.True(t, all.Intersect(claimed).Equal(claimed))
+	assert.True(t, claimed.Contains(NewID(1<<40, 24)))
+}
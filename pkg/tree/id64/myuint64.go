@@ -1,136 +0,0 @@
-package id64
-
-import "math/bits"
-
-type myuint64 uint64
-
-func (u myuint64) isZero() bool { return u == 0 }
-
-// and returns the bitwise AND of u and m (u&m).
-func (u myuint64) and(m myuint64) myuint64 {
-	return myuint64(u & m)
-}
-
-// xor returns the bitwise XOR of u and m (u^m).
-func (u myuint64) xor(m myuint64) myuint64 {
-	return myuint64(u ^ m)
-}
-
-// or returns the bitwise OR of u and m (u|m).
-func (u myuint64) or(m myuint64) myuint64 {
-	return myuint64(u | m)
-}
-
-// not returns the bitwise NOT of u.
-func (u myuint64) not() myuint64 {
-	return myuint64(^u)
-}
-
-// subOne returns u - 1.
-func (u myuint64) subOne() myuint64 {
-	lo, borrow := bits.Sub32(uint32(u), 1, 0)
-	return myuint64(lo - borrow)
-}
-
-// addOne returns u + 1.
-func (u myuint64) addOne() myuint64 {
-	lo, carry := bits.Add64(uint64(u), 1, 0)
-	return myuint64(lo + carry)
-}
-
-// bitsSetFrom returns a copy of u with the given bit
-// and all subsequent ones set.
-func (u myuint64) bitsSetFrom(bit uint8) myuint64 {
-	return u.or(myuint64(mask6[bit]).not())
-}
-
-// bitsClearedFrom returns a copy of u with the given bit
-// and all subsequent ones cleared.
-func (u myuint64) bitsClearedFrom(bit uint8) myuint64 {
-	return u.and(myuint64(mask6[bit]))
-}
-
-var mask6 = [...]uint64{
-	0xffffffff00000000, //0
-	0x8000000000000000, //1
-	0xc000000000000000, //2
-	0xe000000000000000, //3
-	0xf000000000000000, //4
-	0xf800000000000000, //5
-	0xfc00000000000000, //6
-	0xfe00000000000000, //7
-	0xff00000000000000, //8
-	0xff80000000000000, //9
-	0xffc0000000000000, //10
-	0xffe0000000000000, //11
-	0xfff0000000000000, //12
-	0xfff8000000000000, //13
-	0xfffc000000000000, //14
-	0xfffe000000000000, //15
-	0xffff000000000000, //16
-	0xffff800000000000, //17
-	0xffffc00000000000, //18
-	0xffffe00000000000, //19
-	0xfffff00000000000, //20
-	0xfffff80000000000, //21
-	0xfffffc0000000000, //22
-	0xfffffe0000000000, //23
-	0xffffff0000000000, //24
-	0xffffff8000000000, //25
-	0xffffffc000000000, //26
-	0xffffffe000000000, //27
-	0xfffffff000000000, //28
-	0xfffffff800000000, //29
-	0xfffffffc00000000, //30
-	0xfffffffe00000000, //31
-	0xffffffff00000000, //32
-	0xffffffff80000000, //33
-	0xffffffffc0000000, //34
-	0xffffffffe0000000, //35
-	0xfffffffff0000000, //36
-	0xfffffffff8000000, //37
-	0xfffffffffc000000, //38
-	0xfffffffffe000000, //39
-	0xffffffffff000000, //40
-	0xffffffffff800000, //41
-	0xffffffffffc00000, //42
-	0xffffffffffe00000, //43
-	0xfffffffffff00000, //44
-	0xfffffffffff80000, //45
-	0xfffffffffffc0000, //46
-	0xfffffffffffe0000, //47
-	0xffffffffffff0000, //48
-	0xffffffffffff8000, //49
-	0xffffffffffffc000, //50
-	0xffffffffffffe000, //51
-	0xfffffffffffff000, //52
-	0xfffffffffffff800, //53
-	0xfffffffffffffc00, //54
-	0xfffffffffffffe00, //55
-	0xffffffffffffff00, //56
-	0xffffffffffffff80, //57
-	0xffffffffffffffc0, //58
-	0xffffffffffffffe0, //59
-	0xfffffffffffffff0, //60
-	0xfffffffffffffff8, //61
-	0xfffffffffffffffc, //62
-	0xfffffffffffffffe, //63
-	0xffffffffffffffff, //64
-}
-
-func bePutUint64(b []byte, v uint64) {
-	_ = b[7] // early bounds check to guarantee safety of writes below
-	b[0] = byte(v >> 56)
-	b[1] = byte(v >> 48)
-	b[2] = byte(v >> 40)
-	b[3] = byte(v >> 32)
-	b[4] = byte(v >> 24)
-	b[5] = byte(v >> 16)
-	b[6] = byte(v >> 8)
-	b[7] = byte(v)
-}
-
-func beUint64(b []byte) uint64 {
-	_ = b[7] // bounds check hint to compiler; see golang.org/issue/14808
-	return uint64(b[7]) | uint64(b[6])<<8 | uint64(b[5])<<16 | uint64(b[4])<<24 | uint64(b[3])<<32 | uint64(b[2])<<40 | uint64(b[1])<<48 | uint64(b[0])<<56
-}
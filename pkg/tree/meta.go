@@ -0,0 +1,58 @@
+package tree
+
+import "time"
+
+// Meta is the metadata of a stored entry
+type Meta struct {
+	// Created is the time the entry was claimed
+	Created time.Time `json:"created"`
+	// Updated is the time of the last change of the entry
+	Updated time.Time `json:"updated"`
+	// Generation counts the changes of the entry; it is 1 after the claim
+	Generation uint64 `json:"generation"`
+	// Annotations are kept with the entry across updates; unlike the labels
+	// they are not used by selectors and quotas
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// WithMeta returns the entry e with meta. A table or tree stores an entry
+// with a creation time as is, e.g. an entry that is moved; without one the
+// annotations of meta are set and the other fields are maintained.
+func WithMeta(e Entry, meta Meta) Entry {
+	return entry{
+		id:       e.ID(),
+		labels:   e.Labels(),
+		revision: e.Revision(),
+		meta:     meta,
+	}
+}
+
+// Clock returns the time of a change; the tables stamp the metadata and the
+// audit records of their changes with it. A nil Clock is time.Now.
+type Clock func() time.Time
+
+// Now returns the time of the clock
+func (c Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c()
+}
+
+// MetaSelector selects entries by their metadata
+type MetaSelector func(meta Meta) bool
+
+// CreatedBefore selects the entries claimed before t
+func CreatedBefore(t time.Time) MetaSelector {
+	return func(meta Meta) bool { return meta.Created.Before(t) }
+}
+
+// UpdatedBefore selects the entries that did not change since t
+func UpdatedBefore(t time.Time) MetaSelector {
+	return func(meta Meta) bool { return meta.Updated.Before(t) }
+}
+
+// OlderThan selects the entries claimed more than d ago
+func OlderThan(d time.Duration) MetaSelector {
+	return CreatedBefore(time.Now().Add(-d))
+}
@@ -1,11 +1,15 @@
 package tree
 
 type treeNode[T any] struct {
-	Left     uint // left node index: 0 for not set
-	Right    uint // right node index: 0 for not set
-	Id       uint64
-	Length   uint8
-	ValCount int
+	Left   *treeNode[T] // left node: nil for not set
+	Right  *treeNode[T] // right node: nil for not set
+	Id     uint64
+	Length uint8
+	// vals are the vals of the node; they are only changed in place by the
+	// tree that owns the node
+	vals []T
+	// gen is the generation of the tree that owns the node, see Tree.mutable
+	gen uint64
 }
 
 // See how many bits match the input address
@@ -32,17 +36,5 @@ func (n *treeNode[T]) ShiftLength(shiftCount uint8) {
 
 // MergeFromNodes updates the prefix and prefix length from the two input nodes
 func (n *treeNode[T]) MergeFromNodes(left *treeNode[T], right *treeNode[T], idLength uint8) {
-	switch idLength {
-	case 16:
-		id, l := MergeID16(uint16(left.Id), left.Length, uint16(right.Id), right.Length)
-		n.Id, n.Length = uint64(id), l
-	case 32:
-		id, l := MergeID32(uint32(left.Id), left.Length, uint32(right.Id), right.Length)
-		n.Id, n.Length = uint64(id), l
-	case 64:
-		id, l := MergeID64(left.Id, left.Length, right.Id, right.Length)
-		n.Id, n.Length = uint64(id), l
-	default:
-		panic("unsupported id length")
-	}
+	n.Id, n.Length = MergeID(left.Id, left.Length, right.Id, right.Length, idLength)
 }
@@ -0,0 +1,67 @@
+package tree
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PriorityLabelKey is the label key of the priority of a claim, an integer.
+// A claim without it has priority 0.
+const PriorityLabelKey = "idxtable.henderiw.io/priority"
+
+// PreemptibleLabelKey is the label key, with value "true", of the claims that
+// are evicted by a ClaimFree with a higher priority when no id is free
+const PreemptibleLabelKey = "idxtable.henderiw.io/preemptible"
+
+// Priority returns the priority of a claim with labels l
+func Priority(l labels.Set) (int, error) {
+	if !l.Has(PriorityLabelKey) {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(l.Get(PriorityLabelKey))
+	if err != nil {
+		return 0, fmt.Errorf("invalid priority %q, err: %s", l.Get(PriorityLabelKey), err.Error())
+	}
+	return priority, nil
+}
+
+// Preemptible returns true when a claim with labels l can be evicted
+func Preemptible(l labels.Set) bool {
+	return l.Get(PreemptibleLabelKey) == "true" &&
+		!l.Has(ReservedLabelKey) &&
+		!l.Has(DelegatedLabelKey)
+}
+
+// Eviction is an entry that is evicted by a claim with a higher priority
+type Eviction struct {
+	Evicted Entry
+	By      Entry
+}
+
+// EvictionFunc is called after every eviction
+type EvictionFunc func(Eviction)
+
+// Victim returns the preemptible entry with the lowest priority that is lower
+// than priority. Of the entries with that priority the smallest one is
+// returned, e.g. a single id rather than an aggregate prefix; the first one
+// when several have the same size.
+func Victim(entries Entries, priority int) (Entry, bool) {
+	var victim Entry
+	victimPriority := priority
+	for _, e := range entries {
+		if !Preemptible(e.Labels()) {
+			continue
+		}
+		p, err := Priority(e.Labels())
+		if err != nil || p > victimPriority || p == priority {
+			continue
+		}
+		if victim != nil && p == victimPriority && e.ID().Length() <= victim.ID().Length() {
+			continue
+		}
+		victim, victimPriority = e, p
+	}
+	return victim, victim != nil
+}
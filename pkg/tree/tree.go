@@ -2,12 +2,10 @@ package tree
 
 import (
 	"fmt"
+	"slices"
+	"sync/atomic"
 )
 
-func init() {
-	initBuildLeftMasks()
-}
-
 // MatchesFunc[T] is called to check if tag data matches the input value
 type MatchesFunc[T any] func(payload T, val T) bool
 
@@ -38,94 +36,100 @@ const (
 	deletedNodeJustRemoved
 )
 
+// generations hands out the generations of the trees, see Tree.mutable
+var generations atomic.Uint64
+
 type Tree[T any] struct {
-	name             string         // name of the tree
-	isLeftBitSetFn   IsLeftBitSetFn // input
-	length           uint8          // input
-	nodes            []treeNode[T]  // root is always at [1] - [0] is unused
-	availableIndexes []uint         // a place to store node indexes that we deleted, and are available
-	vals             map[uint64]T
+	name           string         // name of the tree
+	isLeftBitSetFn IsLeftBitSetFn // input
+	length         uint8          // input
+	root           *treeNode[T]   // the root has no id and is never deleted
+	// gen is the generation of the tree; the nodes of another generation are
+	// shared with a snapshot or a clone and are copied before a change
+	gen uint64
 }
 
 type IsLeftBitSetFn func(id uint64) bool
 
 func NewTree[T any](name string, isLeftBitSetFn IsLeftBitSetFn, length uint8) *Tree[T] {
-
+	gen := generations.Add(1)
 	return &Tree[T]{
-		name:             name,
-		isLeftBitSetFn:   isLeftBitSetFn,
-		length:           length,
-		nodes:            make([]treeNode[T], 2), // index 0 is skipped, 1 is root
-		availableIndexes: make([]uint, 0),
-		vals:             make(map[uint64]T),
+		name:           name,
+		isLeftBitSetFn: isLeftBitSetFn,
+		length:         length,
+		root:           &treeNode[T]{gen: gen},
+		gen:            gen,
 	}
 }
 
-// Clone creates an identical copy of the tree
+// Clone creates an identical copy of the tree in O(1)
+// - the copy shares the nodes with the tree; a change of either of them copies
+// the nodes on the path from the root to the changed node only
 // - Note: the items in the tree are not deep copied
 func (r *Tree[T]) Clone() *Tree[T] {
-	ret := &Tree[T]{
-		nodes:            make([]treeNode[T], len(r.nodes), cap(r.nodes)),
-		availableIndexes: make([]uint, len(r.availableIndexes), cap(r.availableIndexes)),
-		vals:             make(map[uint64]T, len(r.vals)),
+	// the shared nodes are no longer owned by the tree
+	r.gen = generations.Add(1)
+	return &Tree[T]{
+		name:           r.name,
+		isLeftBitSetFn: r.isLeftBitSetFn,
+		length:         r.length,
+		root:           r.root,
+		gen:            generations.Add(1),
 	}
+}
+
+// Snapshot returns a read-only view of the tree in O(1)
+// - the snapshot shares the nodes with the tree; a change of the tree
+// afterwards copies the nodes on the path from the root to the changed node,
+// so the snapshot stays consistent while the tree continues to be updated
+// - Note: like Clone, the items in the tree are not deep copied
+func (r *Tree[T]) Snapshot() *Tree[T] {
+	return r.Clone()
+}
 
-	copy(ret.nodes, r.nodes)
-	copy(ret.availableIndexes, r.availableIndexes)
-	for k, v := range r.vals {
-		ret.vals[k] = v
+// mutable returns the node at link, which the tree can change in place; a
+// node of another generation is shared and is replaced at link by a copy
+// first. The caller owns the node that holds link.
+func (r *Tree[T]) mutable(link **treeNode[T]) *treeNode[T] {
+	n := *link
+	if n.gen != r.gen {
+		c := *n
+		c.vals = slices.Clone(n.vals)
+		c.gen = r.gen
+		n = &c
+		*link = n
 	}
-	return ret
+	return n
 }
 
-// add a tag to the node at the input index
+// add a tag to the node
 // - if matchFunc is non-nil, it is used to determine equality (if nil, no existing tag match)
 // - if udpateFunc is non-nil, it is used to update the tag if it already exists (if nil, the provided tag is used)
 // - returns whether the tag count was increased
-func (r *Tree[T]) addVal(val T, nodeIndex uint, matchFunc MatchesFunc[T], updateFunc UpdatesFunc[T]) bool {
-	key := (uint64(nodeIndex) << uint64(r.length))
-	valCount := r.nodes[nodeIndex].ValCount
+func (n *treeNode[T]) addVal(val T, matchFunc MatchesFunc[T], updateFunc UpdatesFunc[T]) bool {
 	if matchFunc != nil {
 		// need to check if this value already exists
-		for i := 0; i < valCount; i++ {
-			if matchFunc(r.vals[key+uint64(i)], val) {
+		for i := range n.vals {
+			if matchFunc(n.vals[i], val) {
 				if updateFunc != nil {
-					r.vals[key+(uint64(i))] = updateFunc(r.vals[key+(uint64(i))])
+					n.vals[i] = updateFunc(n.vals[i])
 				}
 				return false
 			}
 		}
 	}
-	r.vals[key+(uint64(valCount))] = val
-	r.nodes[nodeIndex].ValCount++
+	n.vals = append(n.vals, val)
 	return true
 }
 
-func (r *Tree[T]) moveTags(fromIndex uint, toIndex uint) {
-	tagCount := r.nodes[fromIndex].ValCount
-	fromKey := uint64(fromIndex) << uint64(r.length)
-	toKey := uint64(toIndex) << uint64(r.length)
-	for i := 0; i < tagCount; i++ {
-		r.vals[toKey+uint64(i)] = r.vals[fromKey+uint64(i)]
-		delete(r.vals, fromKey+uint64(i))
-	}
-	r.nodes[toIndex].ValCount += r.nodes[fromIndex].ValCount
-	r.nodes[fromIndex].ValCount = 0
-}
-
-// return the tags at the input node index - appending to the input slice if they pass the optional filter func
+// return the tags of the node - appending to the input slice if they pass the optional filter func
 // - ret is only appended to
-func (r *Tree[T]) valsForNode(ret []T, nodeIndex uint, filterFunc FilterFunc[T]) []T {
-	if nodeIndex == 0 {
+func (n *treeNode[T]) valsForNode(ret []T, filterFunc FilterFunc[T]) []T {
+	if n == nil {
 		// useful for base cases where we haven't found anything
 		return ret
 	}
-
-	// TODO: clean up the typing in here, between uint, uint64
-	valCount := r.nodes[nodeIndex].ValCount
-	key := uint64(nodeIndex) << uint64(r.length)
-	for i := 0; i < valCount; i++ {
-		tag := r.vals[key+uint64(i)]
+	for _, tag := range n.vals {
 		if filterFunc == nil || filterFunc(tag) {
 			ret = append(ret, tag)
 		}
@@ -133,24 +137,17 @@ func (r *Tree[T]) valsForNode(ret []T, nodeIndex uint, filterFunc FilterFunc[T])
 	return ret
 }
 
-// delete tags at the input node, returning how many were deleted, and how many are left
+// delete tags at the node, returning how many were deleted, and how many are left
 // - uses input slice to reduce allocations
-func (r *Tree[T]) deleteVal(buf []T, nodeIndex uint, matchTag T, matchFunc MatchesFunc[T]) (int, int) {
+func (n *treeNode[T]) deleteVal(buf []T, matchTag T, matchFunc MatchesFunc[T]) (int, int) {
 	// get tags
-	buf = buf[:0]
-	buf = r.valsForNode(buf, nodeIndex, nil)
+	buf = n.valsForNode(buf[:0], nil)
 	if len(buf) == 0 {
 		return 0, 0
 	}
 
-	// delete tags
-	// TODO: this could be done smarter - delete in place?
-	for i := 0; i < r.nodes[nodeIndex].ValCount; i++ {
-		delete(r.vals, (uint64(nodeIndex)<<uint64(r.length))+uint64(i))
-	}
-	r.nodes[nodeIndex].ValCount = 0
-
-	// put them back
+	// delete tags and put the ones that do not match back
+	n.vals = n.vals[:0]
 	deleteCount := 0
 	keepCount := 0
 	for _, tag := range buf {
@@ -158,7 +155,7 @@ func (r *Tree[T]) deleteVal(buf []T, nodeIndex uint, matchTag T, matchFunc Match
 			deleteCount++
 		} else {
 			// doesn't match - get to keep it
-			r.addVal(tag, nodeIndex, matchFunc, nil)
+			n.addVal(tag, matchFunc, nil)
 			keepCount++
 		}
 	}
@@ -176,6 +173,27 @@ func (r *Tree[T]) Set(id ID, val T) (bool, int) {
 	return b, idx
 }
 
+// Replace sets the single value for a node like Set, and returns the value it
+// replaced, if any
+func (r *Tree[T]) Replace(id ID, val T) (T, bool) {
+	return r.ReplaceFunc(id, func(T, bool) T { return val })
+}
+
+// ReplaceFunc sets the single value for a node to the value returned by fn,
+// which is called with the value it replaces, if any. fn is called once
+// more with the replaced value when there is one.
+func (r *Tree[T]) ReplaceFunc(id ID, fn func(old T, replaced bool) T) (T, bool) {
+	var zero, old T
+	replaced := false
+	r.add(id, fn(zero, false),
+		func(T, T) bool { return true },
+		func(o T) T {
+			old, replaced = o, true
+			return fn(o, true)
+		})
+	return old, replaced
+}
+
 // Add adds a tag to the tree
 // - if matchFunc is non-nil, it will be used to ensure uniqueness at this node
 // - returns whether the val count at this address was increased, and how many vals at this address
@@ -184,48 +202,30 @@ func (r *Tree[T]) Add(id ID, val T, matchFunc MatchesFunc[T]) (bool, int) {
 }
 
 func (r *Tree[T]) add(id ID, val T, matchFunc MatchesFunc[T], updateFunc UpdatesFunc[T]) (bool, int) {
-
-	// make sure we have more than enough capacity before we start adding to the tree, which invalidates pointers into the array
-	if (len(r.availableIndexes) + cap(r.nodes)) < (len(r.nodes) + 10) {
-		temp := make([]treeNode[T], len(r.nodes), (cap(r.nodes)+1)*2)
-		copy(temp, r.nodes)
-		r.nodes = temp
-	}
-
-	root := &r.nodes[1]
+	root := r.mutable(&r.root)
 
 	// handle root tags
 	if id.Length() == 0 {
-		countIncreased := r.addVal(val, 1, matchFunc, updateFunc)
-		return countIncreased, r.nodes[1].ValCount
+		countIncreased := root.addVal(val, matchFunc, updateFunc)
+		return countIncreased, len(root.vals)
 	}
 
-	// root node doesn't have any id, so find the starting point
-	nodeIndex := uint(0)
-	parent := root
+	// root node doesn't have any id, so find the starting point; link is the
+	// child pointer of the owned parent that leads to the current node
+	link := &root.Right
 	if !id.IsLeftBitSet() {
-		if root.Left == 0 {
-			newNodeIndex := r.newNode(id, id.Length())
-			countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
-			root.Left = newNodeIndex
-			return countIncreased, r.nodes[newNodeIndex].ValCount
-		}
-		nodeIndex = root.Left
-	} else {
-		if root.Right == 0 {
-			newNodeIndex := r.newNode(id, id.Length())
-			countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
-			root.Right = newNodeIndex
-			return countIncreased, r.nodes[newNodeIndex].ValCount
-		}
-		nodeIndex = root.Right
+		link = &root.Left
 	}
 
 	for {
-		if nodeIndex == 0 {
-			panic("Trying to traverse nodeIndex=0")
+		if *link == nil {
+			// nowhere else to go - create a new node here
+			newNode := r.newNode(id, id.Length())
+			countIncreased := newNode.addVal(val, matchFunc, updateFunc)
+			*link = newNode
+			return countIncreased, len(newNode.vals)
 		}
-		node := &r.nodes[nodeIndex]
+		node := r.mutable(link)
 		if node.Length == 0 {
 			panic("Reached a node with no id")
 		}
@@ -239,14 +239,13 @@ func (r *Tree[T]) add(id ID, val T, matchFunc MatchesFunc[T], updateFunc Updates
 
 			if matchCount == node.Length {
 				// the whole prefix matched - we're done!
-				countIncreased := r.addVal(val, nodeIndex, matchFunc, updateFunc)
-				return countIncreased, r.nodes[nodeIndex].ValCount
+				countIncreased := node.addVal(val, matchFunc, updateFunc)
+				return countIncreased, len(node.vals)
 			}
 
 			// the input id is shorter than the match found - need to create a new, intermediate parent
-			newNodeIndex := r.newNode(id, id.Length())
-			newNode := &r.nodes[newNodeIndex]
-			countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
+			newNode := r.newNode(id, id.Length())
+			countIncreased := newNode.addVal(val, matchFunc, updateFunc)
 
 			// the existing node loses those matching bits, and becomes a child of the new node
 
@@ -254,22 +253,14 @@ func (r *Tree[T]) add(id ID, val T, matchFunc MatchesFunc[T], updateFunc Updates
 			node.ShiftLength(matchCount)
 
 			if !r.isLeftBitSetFn(node.Id) {
-				newNode.Left = nodeIndex
+				newNode.Left = node
 			} else {
-				newNode.Right = nodeIndex
+				newNode.Right = node
 			}
 
 			// now give this new node a home
-			if parent.Left == nodeIndex {
-				parent.Left = newNodeIndex
-			} else {
-				if parent.Right != nodeIndex {
-					panic("node isn't left or right parent - should be impossible! (1)")
-				}
-				parent.Right = newNodeIndex
-			}
-			return countIncreased, r.nodes[newNodeIndex].ValCount
-
+			*link = newNode
+			return countIncreased, len(newNode.vals)
 		}
 
 		if matchCount == node.Length {
@@ -279,66 +270,35 @@ func (r *Tree[T]) add(id ID, val T, matchFunc MatchesFunc[T], updateFunc Updates
 			id = id.ShiftLeft(matchCount)
 
 			if !id.IsLeftBitSet() {
-				if node.Left == 0 {
-					// nowhere else to go - create a new node here
-					newNodeIndex := r.newNode(id, id.Length())
-					countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
-					node.Left = newNodeIndex
-					return countIncreased, r.nodes[newNodeIndex].ValCount
-				}
-
-				// there's a node to the left - traverse it
-				parent = node
-				nodeIndex = node.Left
-				continue
-			}
-
-			// node didn't belong on the left, so it belongs on the right
-			if node.Right == 0 {
-				// nowhere else to go - create a new node here
-				newNodeIndex := r.newNode(id, id.Length())
-				countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
-				node.Right = newNodeIndex
-				return countIncreased, r.nodes[newNodeIndex].ValCount
+				link = &node.Left
+			} else {
+				link = &node.Right
 			}
-
-			// there's a node to the right - traverse it
-			parent = node
-			nodeIndex = node.Right
 			continue
 		}
 
 		// partial match with this node - need to split this node
-		newCommonParentNodeIndex := r.newNode(id, matchCount)
-		newCommonParentNode := &r.nodes[newCommonParentNodeIndex]
+		newCommonParentNode := r.newNode(id, matchCount)
 
 		// shift
 		id = id.ShiftLeft(matchCount)
 
-		newNodeIndex := r.newNode(id, id.Length())
-		countIncreased := r.addVal(val, newNodeIndex, matchFunc, updateFunc)
+		newNode := r.newNode(id, id.Length())
+		countIncreased := newNode.addVal(val, matchFunc, updateFunc)
 
 		// see where the existing node fits - left or right
 		node.ShiftLength(matchCount)
 		if !r.isLeftBitSetFn(node.Id) {
-			newCommonParentNode.Left = nodeIndex
-			newCommonParentNode.Right = newNodeIndex
+			newCommonParentNode.Left = node
+			newCommonParentNode.Right = newNode
 		} else {
-			newCommonParentNode.Right = nodeIndex
-			newCommonParentNode.Left = newNodeIndex
+			newCommonParentNode.Right = node
+			newCommonParentNode.Left = newNode
 		}
 
 		// now determine where the new node belongs
-		if parent.Left == nodeIndex {
-			parent.Left = newCommonParentNodeIndex
-		} else {
-			if parent.Right != nodeIndex {
-				panic("node isn't left or right parent - should be impossible! (2)")
-			}
-			parent.Right = newCommonParentNodeIndex
-		}
-		return countIncreased, r.nodes[newNodeIndex].ValCount
-
+		*link = newCommonParentNode
+		return countIncreased, len(newNode.vals)
 	}
 }
 
@@ -351,156 +311,175 @@ func (r *Tree[T]) Delete(id ID, matchFunc MatchesFunc[T], matchVal T) int {
 // DeleteWithBuffer a tag from the tree if it matches matchVal, as determined by matchFunc. Returns how many tags are removed
 // - uses input slice to reduce allocations
 func (r *Tree[T]) DeleteWithBuffer(buf []T, id ID, matchFunc MatchesFunc[T], matchVal T) int {
-	// traverse the tree, finding the node and its parent
-	root := &r.nodes[1]
-	var parentIndex uint
-	var parent *treeNode[T]
-	var targetNode *treeNode[T]
-	var targetNodeIndex uint
-
-	if id.Length() == 0 {
-		// caller just looking for root tags
-		targetNode = root
-		targetNodeIndex = 1
-	} else {
-		nodeIndex := uint(0)
+	if target := r.find(id); target == nil || len(target.vals) == 0 {
+		// no tags found, nothing is copied
+		return 0
+	}
 
-		parentIndex = 1
-		parent = root
+	// traverse the tree again, taking ownership of the path to the node
+	root := r.mutable(&r.root)
+	parent := root
+	targetLink := &r.root
+	if id.Length() != 0 {
+		targetLink = &root.Right
 		if !id.IsLeftBitSet() {
-			nodeIndex = root.Left
-		} else {
-			nodeIndex = root.Right
+			targetLink = &root.Left
 		}
-
-		// traverse the tree
 		for {
-			if nodeIndex == 0 {
-				return 0
-			}
-
-			node := &r.nodes[nodeIndex]
+			node := r.mutable(targetLink)
 			matchCount := node.MatchCount(id)
-			if matchCount < node.Length {
-				// didn't match the entire node - we're done
-				return 0
-			}
-
 			if matchCount == id.Length() {
 				// exact match - we're done
-				targetNode = node
-				targetNodeIndex = nodeIndex
 				break
 			}
-
 			// there's still more address - keep traversing
-			parentIndex = nodeIndex
 			parent = node
 			id = id.ShiftLeft(matchCount)
 			if !id.IsLeftBitSet() {
-				nodeIndex = node.Left
+				targetLink = &node.Left
 			} else {
-				nodeIndex = node.Right
+				targetLink = &node.Right
 			}
 		}
 	}
-
-	if targetNode == nil || targetNode.ValCount == 0 {
-		// no tags found
-		return 0
-	}
+	targetNode := *targetLink
 
 	// delete matching tags
-	deleteCount, remainingTagCount := r.deleteVal(buf, targetNodeIndex, matchVal, matchFunc)
+	deleteCount, remainingTagCount := targetNode.deleteVal(buf, matchVal, matchFunc)
 	if remainingTagCount > 0 {
 		// target node still has tags - we're not deleting it
 		return deleteCount
 	}
-	r.deleteNode(targetNodeIndex, targetNode, parentIndex, parent)
+	r.deleteNode(targetLink, parent)
 	return deleteCount
 }
 
-// deleteNode removes the provided node and compact the tree.
-func (r *Tree[T]) deleteNode(targetNodeIndex uint, targetNode *treeNode[T], parentIndex uint, parent *treeNode[T]) (result deleteNodeResult) {
+// find returns the node of exactly id, if any, without changing the tree
+func (r *Tree[T]) find(id ID) *treeNode[T] {
+	if id.Length() == 0 {
+		return r.root
+	}
+	node := r.root.Right
+	if !id.IsLeftBitSet() {
+		node = r.root.Left
+	}
+	for node != nil {
+		matchCount := node.MatchCount(id)
+		if matchCount < node.Length {
+			// didn't match the entire node - we're done
+			return nil
+		}
+		if matchCount == id.Length() {
+			return node
+		}
+		id = id.ShiftLeft(matchCount)
+		if !id.IsLeftBitSet() {
+			node = node.Left
+		} else {
+			node = node.Right
+		}
+	}
+	return nil
+}
+
+// Overlapping returns the vals of the ids that overlap id: the ones on the
+// path from the root to id, which cover id, and the ones in the subtree of
+// id, which id covers. Only these nodes are visited.
+func (r *Tree[T]) Overlapping(id ID) []T {
+	ret := append([]T{}, r.root.vals...)
+	if id.Length() == 0 {
+		return appendSubtree(ret, r.root.Left, r.root.Right)
+	}
+	node := r.root.Right
+	if !id.IsLeftBitSet() {
+		node = r.root.Left
+	}
+	for node != nil {
+		matchCount := node.MatchCount(id)
+		if matchCount == id.Length() {
+			// the node is id or more specific, so id covers its subtree
+			return appendSubtree(ret, node)
+		}
+		if matchCount < node.Length {
+			// the node and id diverge
+			return ret
+		}
+		// the node covers id
+		ret = append(ret, node.vals...)
+		id = id.ShiftLeft(matchCount)
+		if !id.IsLeftBitSet() {
+			node = node.Left
+		} else {
+			node = node.Right
+		}
+	}
+	return ret
+}
+
+// appendSubtree appends the vals of the nodes and their subtrees to ret
+func appendSubtree[T any](ret []T, nodes ...*treeNode[T]) []T {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		ret = append(ret, node.vals...)
+		ret = appendSubtree(ret, node.Left, node.Right)
+	}
+	return ret
+}
+
+// deleteNode removes the node at targetLink, a child of parent, and compacts
+// the tree; the tree owns the target node and its parent.
+func (r *Tree[T]) deleteNode(targetLink **treeNode[T], parent *treeNode[T]) (result deleteNodeResult) {
 	result = notDeleted
-	if targetNodeIndex == 1 {
+	targetNode := *targetLink
+	if targetLink == &r.root {
 		// can't delete the root node
 		return result
 	}
 
 	// compact the tree, if possible
-	if targetNode.Left != 0 && targetNode.Right != 0 {
+	if targetNode.Left != nil && targetNode.Right != nil {
 		// target has two children - nothing we can do - not deleting the node
 		return result
-	} else if targetNode.Left != 0 {
+	} else if targetNode.Left != nil {
 		// target node only has only left child
 		result = deletedNodeReplacedByChild
-		if parent.Left == targetNodeIndex {
-			parent.Left = targetNode.Left
-		} else {
-			parent.Right = targetNode.Left
-		}
 
 		// need to update the child node prefix to include target node's
-		tmpNode := &r.nodes[targetNode.Left]
+		tmpNode := r.mutable(&targetNode.Left)
 		tmpNode.MergeFromNodes(targetNode, tmpNode, r.length)
-	} else if targetNode.Right != 0 {
+		*targetLink = tmpNode
+	} else if targetNode.Right != nil {
 		// target node has only right child
 		result = deletedNodeReplacedByChild
-		if parent.Left == targetNodeIndex {
-			parent.Left = targetNode.Right
-		} else {
-			parent.Right = targetNode.Right
-		}
 
 		// need to update the child node prefix to include target node's
-		tmpNode := &r.nodes[targetNode.Right]
+		tmpNode := r.mutable(&targetNode.Right)
 		tmpNode.MergeFromNodes(targetNode, tmpNode, r.length)
+		*targetLink = tmpNode
 	} else {
 		// target node has no children - straight-up remove this node
 		result = deletedNodeJustRemoved
-		if parent.Left == targetNodeIndex {
-			parent.Left = 0
-			if parentIndex > 1 && parent.ValCount == 0 && parent.Right != 0 {
-				// parent isn't root, has no tags, and there's a sibling - merge sibling into parent
-				result = deletedNodeParentReplacedBySibling
-				siblingIndexToDelete := parent.Right
-				tmpNode := &r.nodes[siblingIndexToDelete]
-				parent.MergeFromNodes(parent, tmpNode, r.length)
-
-				// move tags
-				r.moveTags(siblingIndexToDelete, parentIndex)
-
-				// parent now gets target's sibling's children
-				parent.Left = r.nodes[siblingIndexToDelete].Left
-				parent.Right = r.nodes[siblingIndexToDelete].Right
-
-				r.availableIndexes = append(r.availableIndexes, siblingIndexToDelete)
-			}
-		} else {
-			parent.Right = 0
-			if parentIndex > 1 && parent.ValCount == 0 && parent.Left != 0 {
-				// parent isn't root, has no tags, and there's a sibling - merge sibling into parent
-				result = deletedNodeParentReplacedBySibling
-				siblingIndexToDelete := parent.Left
-				tmpNode := &r.nodes[siblingIndexToDelete]
-				parent.MergeFromNodes(parent, tmpNode, r.length)
-
-				// move tags
-				r.moveTags(siblingIndexToDelete, parentIndex)
-
-				// parent now gets target's sibling's children
-				parent.Right = r.nodes[parent.Left].Right
-				parent.Left = r.nodes[parent.Left].Left
-
-				r.availableIndexes = append(r.availableIndexes, siblingIndexToDelete)
-			}
+		*targetLink = nil
+
+		sibling := parent.Left
+		if sibling == nil {
+			sibling = parent.Right
 		}
-	}
+		if parent != r.root && len(parent.vals) == 0 && sibling != nil {
+			// parent isn't root, has no tags, and there's a sibling - merge sibling into parent
+			result = deletedNodeParentReplacedBySibling
+			parent.MergeFromNodes(parent, sibling, r.length)
 
-	targetNode.Left = 0
-	targetNode.Right = 0
-	r.availableIndexes = append(r.availableIndexes, targetNodeIndex)
+			// move tags
+			parent.vals = slices.Clone(sibling.vals)
+
+			// parent now gets target's sibling's children
+			parent.Left = sibling.Left
+			parent.Right = sibling.Right
+		}
+	}
 	return result
 }
 
@@ -508,8 +487,8 @@ func (r *Tree[T]) deleteNode(targetNodeIndex uint, targetNode *treeNode[T], pare
 type TreeIterator[T any] struct {
 	length      uint8 // input to determine which mask to apply
 	t           *Tree[T]
-	nodeIndex   uint
-	nodeHistory []uint
+	node        *treeNode[T]
+	nodeHistory []*treeNode[T]
 	next        treeIteratorNext
 }
 
@@ -519,36 +498,41 @@ func (r *Tree[T]) Iterate() *TreeIterator[T] {
 	return &TreeIterator[T]{
 		length:      r.length, // input to determine which mask to apply
 		t:           r,
-		nodeIndex:   1,
-		nodeHistory: []uint{},
+		node:        r.root,
+		nodeHistory: []*treeNode[T]{},
 		next:        nextSelf,
 	}
 }
 
+// Tree returns the tree the iterator walks
+func (iter *TreeIterator[T]) Tree() *Tree[T] {
+	return iter.t
+}
+
 // Next jumps to the next element of a tree. It returns false if there
 // is none.
 func (iter *TreeIterator[T]) Next() bool {
 	for {
-		node := &iter.t.nodes[iter.nodeIndex]
+		node := iter.node
 		if iter.next == nextSelf {
 			iter.next = nextLeft
-			if node.ValCount != 0 {
+			if len(node.vals) != 0 {
 				return true
 			}
 		}
 		if iter.next == nextLeft {
-			if node.Left != 0 {
-				iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
-				iter.nodeIndex = node.Left
+			if node.Left != nil {
+				iter.nodeHistory = append(iter.nodeHistory, iter.node)
+				iter.node = node.Left
 				iter.next = nextSelf
 			} else {
 				iter.next = nextRight
 			}
 		}
 		if iter.next == nextRight {
-			if node.Right != 0 {
-				iter.nodeHistory = append(iter.nodeHistory, iter.nodeIndex)
-				iter.nodeIndex = node.Right
+			if node.Right != nil {
+				iter.nodeHistory = append(iter.nodeHistory, iter.node)
+				iter.node = node.Right
 				iter.next = nextSelf
 			} else {
 				// We need to backtrack
@@ -560,14 +544,13 @@ func (iter *TreeIterator[T]) Next() bool {
 			if nodeHistoryLen == 0 {
 				return false
 			}
-			previousIndex := iter.nodeHistory[nodeHistoryLen-1]
-			previousNode := iter.t.nodes[previousIndex]
+			previousNode := iter.nodeHistory[nodeHistoryLen-1]
 			iter.nodeHistory = iter.nodeHistory[:nodeHistoryLen-1]
-			if previousNode.Left == iter.nodeIndex {
-				iter.nodeIndex = previousIndex
+			if previousNode.Left == iter.node {
+				iter.node = previousNode
 				iter.next = nextRight
-			} else if previousNode.Right == iter.nodeIndex {
-				iter.nodeIndex = previousIndex
+			} else if previousNode.Right == iter.node {
+				iter.node = previousNode
 				iter.next = nextUp
 			} else {
 				panic("unexpected state")
@@ -585,21 +568,26 @@ func (iter *TreeIterator[T]) Vals() []T {
 // TagsWithBuffer returns the current tags for the iterator. To avoid
 // allocation, it uses the provided buffer.
 func (iter *TreeIterator[T]) ValsWithBuffer(ret []T) []T {
-	return iter.t.valsForNode(ret, uint(iter.nodeIndex), nil)
+	return iter.node.valsForNode(ret, nil)
 }
 
 // note: this is only used for unit testing
 // nolint
 func (r *Tree[T]) PrintNodes(nodeIndex uint) {
-	for id, n := range r.nodes {
-		fmt.Println("node", id, n)
+	iter := r.Iterate()
+	for iter.Next() {
+		n := iter.node
+		fmt.Println("node", n.Id, n.Length, len(n.vals), n.Left != nil, n.Right != nil)
 	}
 }
 
 // note: this is only used for unit testing
 // nolint
 func (r *Tree[T]) PrintValues() {
-	for id, v := range r.vals {
-		fmt.Println("val", id, v)
+	iter := r.Iterate()
+	for iter.Next() {
+		for _, v := range iter.Vals() {
+			fmt.Println("val", v)
+		}
 	}
 }
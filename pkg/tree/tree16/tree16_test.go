@@ -34,13 +34,13 @@ func TestClaim(t *testing.T) {
 
 			for id, d := range tc.newSuccessEntries {
 				treeid := id16.NewID(id, id16.IDBitSize)
-				err := vt.ClaimID(treeid, d)
+				_, err := vt.ClaimID(treeid, d)
 				assert.NoError(t, err)
 
 			}
 			for id, d := range tc.newFailedEntries {
 				treeid := id16.NewID(id, id16.IDBitSize)
-				err := vt.ClaimID(treeid, d)
+				_, err := vt.ClaimID(treeid, d)
 				assert.Error(t, err)
 			}
 			// check table
@@ -84,14 +84,14 @@ func TestRandomClaim(t *testing.T) {
 				prange, err := id16.ParseRange(trange)
 				assert.NoError(t, err)
 				for _, id := range prange.IDs() {
-					err := vt.ClaimID(id, labels.Set{})
+					_, err := vt.ClaimID(id, labels.Set{})
 					assert.NoError(t, err)
 				}
 			}
 
 			for _, id := range tc.entries {
 				tid := id16.NewID(id, 16)
-				err := vt.ClaimID(tid, labels.Set{})
+				_, err := vt.ClaimID(tid, labels.Set{})
 				assert.NoError(t, err)
 			}
 
@@ -1,9 +1,19 @@
 package tree32
 
 import (
+	"errors"
 	"fmt"
+	"github.com/henderiw/idxtable/pkg/reconcile"
+	"math/rand"
 	"testing"
+	"time"
 
+	"github.com/henderiw/idxtable/pkg/audit"
+	"github.com/henderiw/idxtable/pkg/metrics"
+	"github.com/henderiw/idxtable/pkg/quota"
+	"github.com/henderiw/idxtable/pkg/storage"
+	"github.com/henderiw/idxtable/pkg/tree"
+	"github.com/henderiw/idxtable/pkg/tree/gtree"
 	"github.com/henderiw/idxtable/pkg/tree/id32"
 	"github.com/tj/assert"
 	"k8s.io/apimachinery/pkg/labels"
@@ -29,18 +39,18 @@ func TestClaim(t *testing.T) {
 	}
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			vt, err := New("dummy", id32.IDBitSize - 2)
+			vt, err := New("dummy", id32.IDBitSize-2)
 			assert.NoError(t, err)
 
 			for id, d := range tc.newSuccessEntries {
 				treeid := id32.NewID(id, id32.IDBitSize)
-				err := vt.ClaimID(treeid, d)
+				_, err := vt.ClaimID(treeid, d)
 				assert.NoError(t, err)
 
 			}
 			for id, d := range tc.newFailedEntries {
 				treeid := id32.NewID(id, id32.IDBitSize)
-				err := vt.ClaimID(treeid, d)
+				_, err := vt.ClaimID(treeid, d)
 				assert.Error(t, err)
 			}
 			// check table
@@ -83,7 +93,7 @@ func TestASClaim(t *testing.T) {
 			trange, err := id32.ParseRange(tc.trange)
 			assert.NoError(t, err)
 			for _, id := range trange.IDs() {
-				err := vt.ClaimID(id, labels.Set{})
+				_, err := vt.ClaimID(id, labels.Set{})
 				assert.NoError(t, err)
 			}
 
@@ -91,7 +101,7 @@ func TestASClaim(t *testing.T) {
 
 				tid := id32.NewID(id, 32)
 
-				err := vt.ClaimID(tid, labels.Set{})
+				_, err := vt.ClaimID(tid, labels.Set{})
 				assert.NoError(t, err)
 			}
 
@@ -104,3 +114,776 @@ func TestASClaim(t *testing.T) {
 		})
 	}
 }
+
+func TestClaimRangeAggregate(t *testing.T) {
+	cases := map[string]struct {
+		trange          string
+		release         uint32
+		expectedEntries int
+		claimed         []uint32
+		free            []uint32
+	}{
+		"Normal": {
+			trange:          "1024-2047",
+			release:         1500,
+			expectedEntries: 10,
+			claimed:         []uint32{1024, 1499, 1501, 2047},
+			free:            []uint32{1023, 1500, 2048},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			vt, err := New("dummy", id32.IDBitSize)
+			assert.NoError(t, err)
+
+			_, err = vt.ClaimRange(tc.trange, labels.Set{"owner": "a"})
+			assert.NoError(t, err)
+			// 1024-2047 is a single aggregate prefix
+			assert.Equal(t, 1, vt.Size())
+
+			e, err := vt.Get(id32.NewID(tc.release, id32.IDBitSize))
+			assert.NoError(t, err)
+			assert.Equal(t, uint8(22), e.ID().Length())
+			assert.False(t, vt.IsFree(id32.NewID(tc.release, id32.IDBitSize)))
+
+			_, err = vt.ReleaseID(id32.NewID(tc.release, id32.IDBitSize))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedEntries, vt.Size())
+
+			for _, id := range tc.claimed {
+				e, err := vt.Get(id32.NewID(id, id32.IDBitSize))
+				assert.NoError(t, err)
+				assert.Equal(t, "a", e.Labels()["owner"])
+				assert.False(t, vt.IsFree(id32.NewID(id, id32.IDBitSize)))
+			}
+			for _, id := range tc.free {
+				_, err := vt.Get(id32.NewID(id, id32.IDBitSize))
+				assert.Error(t, err)
+				assert.True(t, vt.IsFree(id32.NewID(id, id32.IDBitSize)))
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+
+	for _, id := range []uint32{10, 11} {
+		_, err := vt.ClaimID(id32.NewID(id, id32.IDBitSize), labels.Set{"id": fmt.Sprint(id)})
+		assert.NoError(t, err)
+	}
+
+	snap := vt.Snapshot()
+
+	_, err = vt.ReleaseID(id32.NewID(10, id32.IDBitSize))
+	assert.NoError(t, err)
+	_, err = vt.ClaimID(id32.NewID(12, id32.IDBitSize), labels.Set{"id": "12"})
+	assert.NoError(t, err)
+	_, err = vt.Update(id32.NewID(11, id32.IDBitSize), labels.Set{"id": "updated"})
+	assert.NoError(t, err)
+
+	// the snapshot is not affected by the mutations
+	assert.Equal(t, 2, snap.Size())
+	assert.False(t, snap.IsFree(id32.NewID(10, id32.IDBitSize)))
+	assert.True(t, snap.IsFree(id32.NewID(12, id32.IDBitSize)))
+	e, err := snap.Get(id32.NewID(11, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "11", e.Labels()["id"])
+
+	assert.Equal(t, 2, vt.Size())
+	assert.True(t, vt.IsFree(id32.NewID(10, id32.IDBitSize)))
+	e, err = vt.Get(id32.NewID(11, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", e.Labels()["id"])
+
+	// every snapshot keeps its own view while the tree and a clone of it
+	// continue to change their copied paths
+	for id := uint32(100); id < 300; id++ {
+		assert.NoError(t, errOf(vt.ClaimID(id32.NewID(id, id32.IDBitSize), labels.Set{"id": fmt.Sprint(id)})))
+	}
+	before := vt.GetAll()
+	snap = vt.Snapshot()
+	clone := vt.Clone()
+	for id := uint32(100); id < 300; id += 2 {
+		assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(id, id32.IDBitSize))))
+	}
+	assert.NoError(t, errOf(clone.ClaimID(id32.NewID(5000, id32.IDBitSize), labels.Set{"id": "5000"})))
+	assert.Equal(t, before, snap.GetAll())
+	assert.Equal(t, len(before)-100, vt.Size())
+	assert.Equal(t, len(before)+1, clone.Size())
+	assert.True(t, vt.IsFree(id32.NewID(5000, id32.IDBitSize)))
+	assert.False(t, clone.IsFree(id32.NewID(100, id32.IDBitSize)))
+}
+
+func TestDiffMerge(t *testing.T) {
+	a, err := New("a", id32.IDBitSize)
+	assert.NoError(t, err)
+	b, err := New("b", id32.IDBitSize)
+	assert.NoError(t, err)
+
+	assert.NoError(t, errOf(a.ClaimRange("1024-2047", labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(a.ClaimID(id32.NewID(10, id32.IDBitSize), labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(a.ClaimID(id32.NewID(11, id32.IDBitSize), labels.Set{"owner": "a"})))
+
+	assert.NoError(t, errOf(b.ClaimRange("1024-2047", labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(b.ClaimID(id32.NewID(1500, id32.IDBitSize), labels.Set{"owner": "b"})))
+	assert.NoError(t, errOf(b.ClaimID(id32.NewID(11, id32.IDBitSize), labels.Set{"owner": "b"})))
+	assert.NoError(t, errOf(b.ClaimID(id32.NewID(12, id32.IDBitSize), labels.Set{"owner": "b"})))
+
+	diff := gtree.Diff(a, b)
+	assert.Equal(t, []string{"12/32", "1500/32"}, idStrings(diff.Added))
+	assert.Equal(t, []string{"10/32"}, idStrings(diff.Removed))
+	assert.Equal(t, 1, len(diff.Changed))
+	assert.Equal(t, "11/32", diff.Changed[0].Old.ID().String())
+
+	assert.True(t, gtree.Diff(a, a.Snapshot()).IsEmpty())
+
+	// the snapshot no longer shares the storage once the tree is changed
+	snapshot := a.Snapshot()
+	assert.NoError(t, errOf(a.ClaimID(id32.NewID(13, id32.IDBitSize), labels.Set{"owner": "a"})))
+	assert.Equal(t, []string{"13/32"}, idStrings(gtree.Diff(snapshot, a).Added))
+	assert.NoError(t, errOf(a.ReleaseID(id32.NewID(13, id32.IDBitSize))))
+
+	assert.Error(t, gtree.Merge(a, b, gtree.ConflictPolicyFail))
+	assert.Equal(t, 3, a.Size())
+
+	// 1500 is in the aggregate of a, so it is not claimed twice
+	assert.NoError(t, gtree.Merge(a, b, gtree.ConflictPolicyKeep))
+	diff = gtree.Diff(a, b)
+	assert.Equal(t, []string{"1500/32"}, idStrings(diff.Added))
+	assert.Equal(t, 1, len(diff.Changed))
+	assert.Equal(t, []string{"10/32"}, idStrings(diff.Removed))
+	assert.Equal(t, 0, len(a.Children(id32.NewID(1024, 22))))
+
+	// only 1500 is released from the aggregate of a, its other ids stay
+	// claimed in the prefixes that remain of the split
+	assert.NoError(t, gtree.Merge(a, b, gtree.ConflictPolicyOverwrite))
+	diff = gtree.Diff(a, b)
+	assert.Equal(t, []string{"1024/22"}, idStrings(diff.Added))
+	assert.Equal(t, 0, len(diff.Changed))
+	assert.Contains(t, idStrings(diff.Removed), "1536/23")
+	assert.NotContains(t, idStrings(diff.Removed), "1500/32")
+	for _, id := range []uint32{1024, 1499, 1501, 2047} {
+		e, err := a.Get(id32.NewID(id, id32.IDBitSize))
+		assert.NoError(t, err)
+		assert.Equal(t, "a", e.Labels()["owner"])
+	}
+	e, err := a.Get(id32.NewID(1500, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", e.Labels()["owner"])
+	e, err = a.Get(id32.NewID(11, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", e.Labels()["owner"])
+
+	// the merge is reverted when a claim fails
+	c, err := New("c", 20)
+	assert.NoError(t, err)
+	d, err := New("d", 24)
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(d.ClaimID(id32.NewID(12, id32.IDBitSize), labels.Set{"owner": "d"})))
+	assert.NoError(t, errOf(d.ClaimID(id32.NewID(1<<21, id32.IDBitSize), labels.Set{"owner": "d"})))
+	assert.Error(t, gtree.Merge(c, d, gtree.ConflictPolicyOverwrite))
+	assert.Equal(t, 0, c.Size())
+}
+
+func idStrings(entries tree.Entries) []string {
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID().String())
+	}
+	return ids
+}
+
+func TestReconcile(t *testing.T) {
+	claim := func(name string, created int64, id tree.ID) gtree.ClaimSpec {
+		return gtree.ClaimSpec{
+			Meta:   reconcile.Meta{Name: name, CreationTimestamp: time.Unix(created, 0)},
+			ID:     id,
+			Labels: labels.Set{"owner": name},
+		}
+	}
+
+	vt, err := New("dummy", 20)
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(4096, 24), labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(8192, 32), labels.Set{"owner": "gone"})))
+
+	report, err := vt.Reconcile([]gtree.ClaimSpec{
+		// block /24 of a, which overlaps the id of b
+		claim("a", 1, id32.NewID(4096, 24)),
+		claim("b", 2, id32.NewID(4100, 32)),
+		// overlaps the stale entry
+		claim("c", 1, id32.NewID(8192, 30)),
+		claim("d", 1, id32.NewID(100, 32)),
+		claim("e", 1, id32.NewID(1<<20, 32)),
+	})
+	assert.NoError(t, err)
+
+	names := func(claims []gtree.ClaimSpec) []string {
+		s := []string{}
+		for _, c := range claims {
+			s = append(s, c.Name)
+		}
+		return s
+	}
+	assert.Equal(t, []string{"a", "d"}, names(report.Loaded))
+	assert.Equal(t, []string{"c"}, names(report.Pending))
+	assert.Equal(t, []string{"e"}, names(report.OutOfRange))
+	assert.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "a", report.Conflicts[0].Winner.Name)
+	assert.Equal(t, "b", report.Conflicts[0].Claim.Name)
+	assert.Equal(t, []string{"8192/32"}, idStrings(report.Stale))
+
+	// once the stale entry is released the pending claim is loaded
+	assert.NoError(t, errOf(vt.ReleaseID(report.Stale[0].ID())))
+	report, err = vt.Reconcile([]gtree.ClaimSpec{
+		claim("a", 1, id32.NewID(4096, 24)),
+		claim("c", 1, id32.NewID(8192, 30)),
+		claim("d", 1, id32.NewID(100, 32)),
+	})
+	assert.NoError(t, err)
+	assert.False(t, report.HasIssues())
+	assert.Equal(t, 3, vt.Size())
+
+	// an entry that holds the labels of its claim is not changed
+	e, err := vt.Get(id32.NewID(100, 32))
+	assert.NoError(t, err)
+	report, err = vt.Reconcile([]gtree.ClaimSpec{claim("d", 1, id32.NewID(100, 32))})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, names(report.Loaded))
+	got, err := vt.Get(id32.NewID(100, 32))
+	assert.NoError(t, err)
+	assert.Equal(t, e.Revision(), got.Revision())
+	assert.Equal(t, e.Meta(), got.Meta())
+}
+
+func TestStats(t *testing.T) {
+	vt, err := New("dummy", 20)
+	assert.NoError(t, err)
+
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(0, id32.IDBitSize), labels.Set{"owner": "a"})))
+	assert.NoError(t, errOf(vt.ClaimRange("1024-2047", labels.Set{"owner": "b"})))
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(1500, id32.IDBitSize))))
+
+	// free are 1-1023, 1500 and 2048-1048575
+	freePrefixes := map[uint8]uint64{32: 2}
+	for l := uint8(13); l <= 21; l++ {
+		freePrefixes[l] = 1
+	}
+	for l := uint8(23); l <= 31; l++ {
+		freePrefixes[l] = 1
+	}
+	runSizes := make([]uint64, 20)
+	runSizes[0], runSizes[9], runSizes[19] = 1, 1, 1
+
+	stats := vt.Stats()
+	assert.Equal(t, metrics.Stats{
+		Capacity:       1 << 20,
+		Claimed:        1024,
+		Free:           1<<20 - 1024,
+		FreeRuns:       3,
+		LargestFreeRun: 1<<20 - 2048,
+		FreePrefixes:   freePrefixes,
+		RunSizes:       runSizes,
+	}, stats)
+	assert.Equal(t, 11, vt.Size())
+
+	// the stats of a snapshot do not change with the tree
+	snapshot := vt.Snapshot()
+	assert.NoError(t, errOf(vt.ReleaseByLabel(labels.SelectorFromSet(labels.Set{"owner": "b"}))))
+	assert.Equal(t, stats, snapshot.Stats())
+	assert.Equal(t, uint64(1), vt.Stats().Claimed)
+	assert.Equal(t, uint64(1), vt.Stats().FreeRuns)
+	assert.Equal(t, 1, vt.Size())
+}
+
+// TestStatsRandom checks the stats, which are maintained on every change,
+// against the stats of the free ids of the entries
+func TestStatsRandom(t *testing.T) {
+	const length = 12
+	vt, err := New("dummy", length)
+	assert.NoError(t, err)
+	rnd := rand.New(rand.NewSource(1))
+
+	expected := func(entries tree.Entries) metrics.Stats {
+		var bldr id32.IDSetBuilder
+		bldr.AddRange(id32.RangeFrom(0, 1<<length-1))
+		for _, e := range entries {
+			bldr.RemoveId(e.ID())
+		}
+		free, err := bldr.IPSet()
+		assert.NoError(t, err)
+		stats := metrics.Stats{
+			Capacity:     1 << length,
+			Free:         free.Size(),
+			FreePrefixes: map[uint8]uint64{},
+			RunSizes:     []uint64{},
+		}
+		stats.Claimed = stats.Capacity - stats.Free
+		for _, r := range free.Ranges() {
+			size := r.To().ID() - r.From().ID() + 1
+			stats.FreeRuns++
+			stats.LargestFreeRun = max(stats.LargestFreeRun, size)
+			for len(stats.RunSizes) <= metrics.RunSizeBucket(size) {
+				stats.RunSizes = append(stats.RunSizes, 0)
+			}
+			stats.RunSizes[metrics.RunSizeBucket(size)]++
+		}
+		for _, id := range free.IDs() {
+			stats.FreePrefixes[id.Length()]++
+		}
+		return stats
+	}
+
+	var snapshot gtree.Reader
+	var snapshotStats metrics.Stats
+	for i := 0; i < 500; i++ {
+		id := uint32(rnd.Intn(1 << length))
+		switch rnd.Intn(4) {
+		case 0:
+			_, _ = vt.ClaimID(id32.NewID(id, id32.IDBitSize), labels.Set{})
+		case 1:
+			_, _ = vt.ClaimID(id32.NewID(id, uint8(32-rnd.Intn(6))), labels.Set{})
+		case 2:
+			_, _ = vt.ClaimRange(fmt.Sprintf("%d-%d", id, min(id+uint32(rnd.Intn(64)), 1<<length-1)), labels.Set{})
+		default:
+			_, _ = vt.ReleaseID(id32.NewID(id, uint8(32-rnd.Intn(3))))
+		}
+		assert.Equal(t, expected(vt.GetAll()), vt.Stats())
+		if i == 250 {
+			snapshot, snapshotStats = vt.Snapshot(), vt.Stats()
+		}
+	}
+	assert.Equal(t, snapshotStats, snapshot.Stats())
+}
+
+func TestDefrag(t *testing.T) {
+	vt, err := New("dummy", 4)
+	assert.NoError(t, err)
+
+	for id, l := range map[uint32]labels.Set{
+		2:  {"owner": "a"},
+		5:  {"owner": "b"},
+		9:  {"owner": "c"},
+		13: {tree.ReservedLabelKey: "pool"},
+	} {
+		assert.NoError(t, errOf(vt.ClaimID(id32.NewID(id, id32.IDBitSize), l)))
+	}
+
+	_, err = vt.PlanDefrag(14, "owner")
+	assert.Error(t, err)
+
+	// 6-13 has the reserved id 13, so 0-7 is freed
+	plan, err := vt.PlanDefrag(8, "owner")
+	assert.NoError(t, err)
+	assert.Equal(t, "0-7", plan.Range.String())
+	moves := map[string]string{}
+	for owner, ownerMoves := range plan.ByOwner() {
+		assert.Len(t, ownerMoves, 1)
+		moves[owner] = ownerMoves[0].From.String() + " -> " + ownerMoves[0].To.String()
+	}
+	assert.Equal(t, map[string]string{
+		"a": id32.NewID(2, id32.IDBitSize).String() + " -> " + id32.NewID(8, id32.IDBitSize).String(),
+		"b": id32.NewID(5, id32.IDBitSize).String() + " -> " + id32.NewID(12, id32.IDBitSize).String(),
+	}, moves)
+
+	// a stale plan is not applied
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(12, id32.IDBitSize), labels.Set{"owner": "d"})))
+	assert.Error(t, errOf(vt.ApplyPlan(plan, nil)))
+	assert.False(t, vt.IsFree(id32.NewID(2, id32.IDBitSize)))
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(12, id32.IDBitSize))))
+
+	applied := []gtree.Move{}
+	rev, err := vt.ApplyPlan(plan, func(move gtree.Move) {
+		applied = append(applied, move)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, vt.Revision(), rev)
+	assert.Equal(t, plan.Moves, applied)
+	assert.True(t, vt.IsFree(id32.NewID(0, 29)))
+	e, err := vt.Get(id32.NewID(8, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", e.Labels()["owner"])
+	assert.Equal(t, 4, vt.Size())
+	assert.Equal(t, uint64(8), vt.Stats().LargestFreeRun)
+}
+
+func TestDelegation(t *testing.T) {
+	vt, err := New("labels", 20)
+	assert.NoError(t, err)
+
+	region := id32.NewID(4096, 20)
+	site := id32.NewID(4096, 24)
+	assert.NoError(t, errOf(vt.ClaimID(region, labels.Set{"owner": "region"})))
+	regionScope, err := vt.Delegate(region)
+	assert.NoError(t, err)
+
+	// the ids of the region are only claimed through its scope
+	free, _, err := vt.ClaimFree(labels.Set{"owner": "a"})
+	assert.NoError(t, err)
+	assert.False(t, region.Overlaps(free.ID()))
+
+	assert.NoError(t, regionScope.ClaimID(site, labels.Set{"owner": "site"}))
+	siteScope, err := regionScope.Delegate(site)
+	assert.NoError(t, err)
+	device, err := siteScope.ClaimFree(labels.Set{"owner": "device"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4096), device.ID().ID())
+
+	assert.Error(t, siteScope.ClaimID(id32.NewID(8192, id32.IDBitSize), labels.Set{}))
+	assert.Error(t, regionScope.ClaimID(id32.NewID(4100, id32.IDBitSize), labels.Set{}))
+	assert.Error(t, regionScope.ClaimID(region, labels.Set{}))
+	assert.Equal(t, 2, len(regionScope.GetAll()))
+
+	// the tree does not claim or update the ids of a delegated prefix, nor
+	// the prefixes that cover it
+	for _, id := range []tree.ID{id32.NewID(4200, id32.IDBitSize), site, region, id32.NewID(0, 16)} {
+		assert.Equal(t, metrics.ReasonClaimed, metrics.ReasonOf(errOf(vt.ClaimID(id, labels.Set{"owner": "x"}))))
+		assert.Equal(t, metrics.ReasonClaimed, metrics.ReasonOf(errOf(vt.Update(id, labels.Set{"owner": "x"}))))
+	}
+	assert.Equal(t, metrics.ReasonClaimed, metrics.ReasonOf(errOf(vt.ClaimRange("4200-4300", labels.Set{"owner": "x"}))))
+	assert.Equal(t, metrics.ReasonClaimed, metrics.ReasonOf(errOf(vt.UpdateIf(device.ID(), device.Revision(), labels.Set{"owner": "x"}))))
+	assert.Equal(t, 4, vt.Size())
+	e, err := vt.Get(site)
+	assert.NoError(t, err)
+	assert.Equal(t, "site", e.Labels()["owner"])
+
+	scope, err := vt.Scope(site)
+	assert.NoError(t, err)
+	assert.False(t, scope.IsFree(device.ID()))
+	_, err = vt.Scope(free.ID())
+	assert.Error(t, err)
+
+	// the delegation chain of the device
+	owners := []string{}
+	for _, p := range vt.Parents(device.ID()) {
+		owners = append(owners, p.Labels()["owner"])
+	}
+	assert.Equal(t, []string{"region", "site"}, owners)
+	assert.Equal(t, 2, len(vt.Children(region)))
+
+	// the parents are not released while ids are claimed in them
+	assert.Error(t, errOf(vt.ReleaseID(region)))
+	assert.Error(t, errOf(vt.ReleaseID(id32.NewID(4097, id32.IDBitSize))))
+	assert.Error(t, errOf(vt.ReleaseByLabel(labels.SelectorFromSet(labels.Set{"owner": "region"}))))
+	assert.Equal(t, 4, vt.Size())
+
+	assert.NoError(t, regionScope.ReleaseID(device.ID()))
+	assert.NoError(t, siteScope.ReleaseID(device.ID()))
+	_, err = siteScope.ClaimFree(labels.Set{"owner": "device"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, errOf(vt.ReleaseCascade(region)))
+	assert.Equal(t, 1, vt.Size())
+	assert.True(t, vt.IsFree(region))
+	_, err = regionScope.ClaimFree(labels.Set{"owner": "site"})
+	assert.Error(t, err)
+}
+
+func TestQuota(t *testing.T) {
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	assert.NoError(t, vt.SetQuotas([]quota.Rule{
+		{Name: "tenant-a", Selector: labels.SelectorFromSet(labels.Set{"tenant": "a"}), MaxIDs: 16, MaxEntries: 1},
+	}))
+
+	// at most one /28
+	assert.Error(t, errOf(vt.ClaimID(id32.NewID(0, 27), labels.Set{"tenant": "a"})))
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(16, 28), labels.Set{"tenant": "a"})))
+	assert.Error(t, errOf(vt.ClaimID(id32.NewID(64, 28), labels.Set{"tenant": "a"})))
+	_, _, err = vt.ClaimFree(labels.Set{"tenant": "a"})
+	var e *quota.ExceededError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, quota.UnitIDs, e.Unit)
+	assert.Equal(t, 1, vt.Size())
+
+	// releasing an id of the /28 splits it without being limited
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(20, id32.IDBitSize))))
+	assert.Equal(t, 4, vt.Size())
+	usage := vt.QuotaUsage()
+	assert.Equal(t, uint64(15), usage[0].IDs)
+	assert.Equal(t, uint64(4), usage[0].Entries)
+	assert.Error(t, errOf(vt.ClaimID(id32.NewID(20, id32.IDBitSize), labels.Set{"tenant": "a"})))
+
+	assert.NoError(t, errOf(vt.ReleaseByLabel(labels.SelectorFromSet(labels.Set{"tenant": "a"}))))
+	assert.Equal(t, uint64(0), vt.QuotaUsage()[0].IDs)
+	assert.NoError(t, errOf(vt.ClaimRange("32-47", labels.Set{"tenant": "a"})))
+}
+
+func TestPreempt(t *testing.T) {
+	vt, err := New("dummy", 4)
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(0, 29), labels.Set{"owner": "a", tree.PriorityLabelKey: "1", tree.PreemptibleLabelKey: "true"})))
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(8, 30), labels.Set{"owner": "b", tree.PreemptibleLabelKey: "true", tree.ReservedLabelKey: "true"})))
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(12, 30), labels.Set{"owner": "c"})))
+	evictions := []tree.Eviction{}
+	vt.SetEvictionFunc(func(e tree.Eviction) { evictions = append(evictions, e) })
+
+	// reserved entries, claims with the same priority and claims without a
+	// priority do not evict
+	_, _, err = vt.ClaimFree(labels.Set{"owner": "c", tree.PriorityLabelKey: "1"})
+	assert.Error(t, err)
+	_, _, err = vt.ClaimFree(labels.Set{"owner": "c"})
+	assert.Error(t, err)
+
+	// the victim is restored as a whole when the claim exceeds its quota
+	assert.NoError(t, vt.SetQuotas([]quota.Rule{
+		{Name: "owner-c", Selector: labels.SelectorFromSet(labels.Set{"owner": "c"}), MaxIDs: 4},
+	}))
+	_, _, err = vt.ClaimFree(labels.Set{"owner": "c", tree.PriorityLabelKey: "5"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(evictions))
+	assert.Equal(t, 3, vt.Size())
+	e, err := vt.Get(id32.NewID(1, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, "0/29", e.ID().String())
+
+	// only the first id of the victim is evicted and claimed
+	assert.NoError(t, vt.SetQuotas([]quota.Rule{
+		{Name: "owner-c", Selector: labels.SelectorFromSet(labels.Set{"owner": "c"}), MaxIDs: 5},
+	}))
+	e, _, err = vt.ClaimFree(labels.Set{"owner": "c", tree.PriorityLabelKey: "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), e.ID().ID())
+	assert.Equal(t, uint8(id32.IDBitSize), e.ID().Length())
+	assert.Equal(t, 1, len(evictions))
+	assert.Equal(t, "0/32", evictions[0].Evicted.ID().String())
+	assert.Equal(t, "a", evictions[0].Evicted.Labels()["owner"])
+	assert.Equal(t, 6, vt.Size())
+	assert.Equal(t, 3, len(vt.GetByLabel(labels.SelectorFromSet(labels.Set{"owner": "a"}))))
+	assert.Equal(t, uint64(5), vt.QuotaUsage()[0].IDs)
+
+	// the smallest victim is evicted next
+	e, _, err = vt.ClaimFree(labels.Set{"owner": "d", tree.PriorityLabelKey: "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1/32", e.ID().String())
+	assert.Equal(t, "1/32", evictions[1].Evicted.ID().String())
+}
+
+// failingStorage fails every write
+type failingStorage struct {
+	*storage.Memory
+}
+
+func (r failingStorage) Put(storage.Record) error {
+	return errors.New("storage unavailable")
+}
+
+func TestStorage(t *testing.T) {
+	s := storage.NewMemory()
+	assert.NoError(t, s.Put(storage.Record{Key: "4096/20", Labels: labels.Set{"owner": "a"}}))
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(vt.ClaimID(id32.NewID(10, id32.IDBitSize), labels.Set{"owner": "b"})))
+
+	// the records are loaded and the entries of the tree are stored
+	assert.NoError(t, vt.SetStorage(s))
+	assert.False(t, vt.IsFree(id32.NewID(5000, id32.IDBitSize)))
+	assert.Equal(t, 2, s.Len())
+
+	// releasing an id of a prefix stores the remaining prefixes
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(4096, id32.IDBitSize))))
+	assert.Equal(t, 1+12, s.Len())
+	assert.NoError(t, errOf(vt.ClaimRange("100-103", labels.Set{"owner": "c"})))
+	assert.NoError(t, errOf(vt.Update(id32.NewID(10, id32.IDBitSize), labels.Set{"owner": "d"})))
+
+	// a new tree loads the same entries
+	restored, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.SetStorage(s))
+	assert.Equal(t, unstored(vt.GetAll()), unstored(restored.GetAll()))
+
+	// a failing write fails the mutation and leaves the tree unchanged
+	assert.NoError(t, vt.SetStorage(failingStorage{Memory: s}))
+	_, _, err = vt.ClaimFree(labels.Set{"owner": "e"})
+	assert.Error(t, err)
+	assert.Error(t, errOf(vt.Update(id32.NewID(10, id32.IDBitSize), labels.Set{"owner": "e"})))
+	assert.Equal(t, unstored(restored.GetAll()), unstored(vt.GetAll()))
+	assert.Equal(t, restored.Stats(), vt.Stats())
+}
+
+// keyFailingStorage fails the writes of the record key
+type keyFailingStorage struct {
+	*storage.Memory
+	key string
+}
+
+func (r keyFailingStorage) Put(rec storage.Record) error {
+	if rec.Key == r.key {
+		return errors.New("storage unavailable")
+	}
+	return r.Memory.Put(rec)
+}
+
+func TestApplyPlanStorage(t *testing.T) {
+	vt, err := New("dummy", 4)
+	assert.NoError(t, err)
+	for id, l := range map[uint32]labels.Set{
+		2:  {"owner": "a"},
+		5:  {"owner": "b"},
+		9:  {"owner": "c"},
+		13: {tree.ReservedLabelKey: "pool"},
+	} {
+		assert.NoError(t, errOf(vt.ClaimID(id32.NewID(id, id32.IDBitSize), l)))
+	}
+	plan, err := vt.PlanDefrag(8, "owner")
+	assert.NoError(t, err)
+	assert.Len(t, plan.Moves, 2)
+	before := unstored(vt.GetAll())
+
+	// the write of the last move fails after its entry is released, the
+	// entry is claimed again and the first move is reverted
+	s := storage.NewMemory()
+	assert.NoError(t, vt.SetStorage(keyFailingStorage{Memory: s, key: plan.Moves[1].To.String()}))
+	_, err = vt.ApplyPlan(plan, nil)
+	assert.Error(t, err)
+	assert.Equal(t, before, unstored(vt.GetAll()))
+	assert.Equal(t, 4, s.Len())
+	for _, move := range plan.Moves {
+		assert.False(t, vt.IsFree(move.From))
+		assert.True(t, vt.IsFree(move.To))
+	}
+}
+
+func TestRevision(t *testing.T) {
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	id := id32.NewID(10, id32.IDBitSize)
+	assert.NoError(t, errOf(vt.ClaimID(id, labels.Set{"owner": "a"})))
+	e, _, err := vt.ClaimFree(labels.Set{"owner": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), e.Revision())
+
+	// an update with a stale revision does not overwrite the previous one
+	rev, err := vt.UpdateIf(id, 1, labels.Set{"owner": "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), rev)
+	rev, err = vt.UpdateIf(id, 1, labels.Set{"owner": "d"})
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	assert.Equal(t, uint64(3), rev)
+	got, err := vt.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", got.Labels()["owner"])
+	assert.Equal(t, uint64(3), got.Revision())
+
+	// an id of an aggregate prefix is released at the revision of the prefix;
+	// the remaining prefixes are stored at new revisions
+	assert.NoError(t, errOf(vt.ClaimRange("100-103", labels.Set{"owner": "e"})))
+	_, err = vt.ReleaseIf(id32.NewID(101, id32.IDBitSize), 3)
+	assert.Equal(t, metrics.ReasonRevisionMismatch, metrics.ReasonOf(err))
+	rev, err = vt.ReleaseIf(id32.NewID(101, id32.IDBitSize), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), rev)
+	assert.True(t, vt.IsFree(id32.NewID(101, id32.IDBitSize)))
+	_, err = vt.ReleaseIf(id32.NewID(101, id32.IDBitSize), 7)
+	assert.Equal(t, metrics.ReasonNotFound, metrics.ReasonOf(err))
+	assert.Equal(t, uint64(7), vt.Revision())
+
+	// every mutation returns the revision of the tree, also when it fails
+	rev, err = vt.ClaimID(id32.NewID(20, id32.IDBitSize), labels.Set{"owner": "f"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8), rev)
+	rev, err = vt.Update(id32.NewID(20, id32.IDBitSize), labels.Set{"owner": "g"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(9), rev)
+	rev, err = vt.ClaimRange("200-201", labels.Set{"owner": "h"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), rev)
+	rev, err = vt.ReleaseID(id32.NewID(20, id32.IDBitSize))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(11), rev)
+	rev, err = vt.ClaimRange("invalid", labels.Set{"owner": "f"})
+	assert.Error(t, err)
+	assert.Equal(t, uint64(11), rev)
+}
+
+func TestMeta(t *testing.T) {
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	assert.NoError(t, errOf(vt.ClaimRange("100-103", labels.Set{"owner": "a"})))
+	prefix := id32.NewID(100, 30)
+	assert.NoError(t, errOf(vt.Annotate(prefix, map[string]string{"ticket": "1234"})))
+	time.Sleep(time.Millisecond)
+	cutoff := time.Now()
+	id := id32.NewID(10, id32.IDBitSize)
+	assert.NoError(t, errOf(vt.ClaimID(id, labels.Set{"owner": "b"})))
+	assert.NoError(t, errOf(vt.Update(id, labels.Set{"owner": "c"})))
+	e, err := vt.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), e.Meta().Generation)
+	assert.False(t, e.Meta().Created.Before(cutoff))
+
+	// the prefixes remaining of a released aggregate keep its metadata
+	aggregate, err := vt.Get(prefix)
+	assert.NoError(t, err)
+	snapshot := vt.Snapshot()
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(101, id32.IDBitSize))))
+	entries := vt.GetByMeta(tree.CreatedBefore(cutoff))
+	assert.Equal(t, 2, len(entries))
+	for _, e := range entries {
+		assert.Equal(t, aggregate.Meta(), e.Meta())
+	}
+	assert.Equal(t, 0, len(vt.GetByMeta(tree.OlderThan(time.Hour))))
+
+	// the snapshot keeps the metadata of the aggregate
+	entries = snapshot.GetByMeta(tree.CreatedBefore(cutoff))
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "1234", entries[0].Meta().Annotations["ticket"])
+
+	// the metadata is stored and restored with the entries
+	s := storage.NewMemory()
+	assert.NoError(t, vt.SetStorage(s))
+	assert.NoError(t, errOf(vt.Annotate(id, map[string]string{"ticket": "5678"})))
+	e, err = vt.Get(id)
+	assert.NoError(t, err)
+	restored, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.SetStorage(s))
+	got, err := restored.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, e.Meta(), got.Meta())
+	assert.Equal(t, "5678", got.Meta().Annotations["ticket"])
+}
+
+func TestAudit(t *testing.T) {
+	log, err := audit.New(0)
+	assert.NoError(t, err)
+	vt, err := New("dummy", id32.IDBitSize)
+	assert.NoError(t, err)
+	vt.SetAuditLog(log, "controller-a")
+	assert.NoError(t, errOf(vt.ClaimRange("100-103", labels.Set{"owner": "a"})))
+	start := time.Now()
+	assert.NoError(t, errOf(vt.ReleaseID(id32.NewID(101, id32.IDBitSize))))
+
+	// the release of an id of an aggregate is recorded once for the id, in
+	// the format of the tables
+	records := log.Query(audit.Query{From: start})
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, metrics.OperationRelease, records[0].Op)
+	assert.Equal(t, "101", records[0].ID)
+	assert.Equal(t, labels.Set{"owner": "a"}, records[0].Before)
+	assert.Equal(t, 1, len(log.Query(audit.Query{ID: "100/30"})))
+
+	// the changes of a clone are not recorded
+	clone := vt.Clone()
+	assert.NoError(t, errOf(clone.ReleaseID(id32.NewID(100, id32.IDBitSize))))
+	assert.Equal(t, 2, log.Len())
+}
+
+// unstored returns the entries without the revision and metadata of the
+// table or tree that stores them, to compare them with the ones restored in
+// another
+func unstored(entries tree.Entries) tree.Entries {
+	out := make(tree.Entries, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, tree.NewEntry(e.ID(), e.Labels()))
+	}
+	return out
+}
+
+// errOf returns the error of a mutation without the revision of the tree
+func errOf(_ uint64, err error) error {
+	return err
+}
@@ -35,7 +35,7 @@ func TestClaim(t *testing.T) {
 				id := id
 				d := d
 				treeid := id64.NewID(id, id64.IDBitSize)
-				err := vt.ClaimID(treeid.Copy(), d)
+				_, err := vt.ClaimID(treeid.Copy(), d)
 				assert.NoError(t, err)
 
 			}
@@ -43,7 +43,7 @@ func TestClaim(t *testing.T) {
 				id := id
 				d := d
 				treeid := id64.NewID(id, id64.IDBitSize)
-				err := vt.ClaimID(treeid, d)
+				_, err := vt.ClaimID(treeid, d)
 				assert.Error(t, err)
 			}
 			// check table
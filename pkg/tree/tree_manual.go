@@ -1,38 +1,8 @@
 package tree
 
-var _leftMasks16 []uint16
-var _leftMasks32 []uint32
-var _leftMasks64 []uint64
-
-func initBuildLeftMasks() {
-	_leftMasks16 = make([]uint16, 17)
-	for i := uint8(1); i < 17; i++ {
-		_leftMasks16[i] = uint16(_leftMasks16[i-1] | 1<<(16-i))
-	}
-
-	_leftMasks32 = make([]uint32, 33)
-	for i := uint8(1); i < 33; i++ {
-		_leftMasks32[i] = uint32(_leftMasks32[i-1] | 1<<(32-i))
-	}
-
-	_leftMasks64 = make([]uint64, 65)
-	for i := uint8(1); i < 65; i++ {
-		_leftMasks64[i] = uint64(_leftMasks64[i-1] | 1<<(64-i))
-	}
-}
-
-// create a new node in the tree, return its index
-func (t *Tree[T]) newNode(id ID, length uint8) uint {
-	availCount := len(t.availableIndexes)
-	if availCount > 0 {
-		index := t.availableIndexes[availCount-1]
-		t.availableIndexes = t.availableIndexes[:availCount-1]
-		t.nodes[index] = treeNode[T]{Id: id.ID(), Length: length}
-		return index
-	}
-
-	t.nodes = append(t.nodes, treeNode[T]{Id: id.ID(), Length: length})
-	return uint(len(t.nodes) - 1)
+// create a new node in the tree, owned by the tree
+func (t *Tree[T]) newNode(id ID, length uint8) *treeNode[T] {
+	return &treeNode[T]{Id: id.ID(), Length: length, gen: t.gen}
 }
 
 /*
@@ -72,14 +42,27 @@ func (iter *TreeIterator[T]) ID() {
 }
 */
 
+// leftMask returns the mask with the leftmost length bits set of an id of idLength bits
+func leftMask(length, idLength uint8) uint64 {
+	return (^uint64(0) >> (64 - idLength)) &^ (^uint64(0) >> (64 - idLength + length))
+}
+
+// MergeID merges the leftLength bits of left with the rightLength bits of right,
+// for ids of idLength bits
+func MergeID(left uint64, leftLength uint8, right uint64, rightLength uint8, idLength uint8) (uint64, uint8) {
+	return (left & leftMask(leftLength, idLength)) | ((right & leftMask(rightLength, idLength)) >> leftLength), (leftLength + rightLength)
+}
+
 func MergeID64(left uint64, leftLength uint8, right uint64, rightLength uint8) (uint64, uint8) {
-	return (left & _leftMasks64[leftLength]) | ((right & _leftMasks64[rightLength]) >> leftLength), (leftLength + rightLength)
+	return MergeID(left, leftLength, right, rightLength, 64)
 }
 
 func MergeID32(left uint32, leftLength uint8, right uint32, rightLength uint8) (uint32, uint8) {
-	return (left & _leftMasks32[leftLength]) | ((right & _leftMasks32[rightLength]) >> leftLength), (leftLength + rightLength)
+	id, l := MergeID(uint64(left), leftLength, uint64(right), rightLength, 32)
+	return uint32(id), l
 }
 
 func MergeID16(left uint16, leftLength uint8, right uint16, rightLength uint8) (uint16, uint8) {
-	return (left & _leftMasks16[leftLength]) | ((right & _leftMasks16[rightLength]) >> leftLength), (leftLength + rightLength)
+	id, l := MergeID(uint64(left), leftLength, uint64(right), rightLength, 16)
+	return uint16(id), l
 }